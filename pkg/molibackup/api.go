@@ -0,0 +1,151 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gookit/slog"
+)
+
+// Functions in this file allow platforms which import this package to define jobs from their own
+// configuration system instead of the yaml configuration file. Configurations are maps which
+// contain the same entries as the corresponding sections of the configuration file.
+
+// Initialise the configuration with the options of the global section and without any job
+func EmbeddedInitialise(globalconfig map[string]any) error {
+
+	if runId == "" {
+		runId = NewRunId()
+	}
+
+	kconfig.Delete("")
+	if err := kconfig.Set("global", embeddedCopyConfig(globalconfig)); err != nil {
+		return fmt.Errorf("failed to set the global configuration: %v", err)
+	}
+	// The global section is validated by the same rules as the global section of the configuration file
+	if err := configProcessGlobal(); err != nil {
+		return fmt.Errorf("failed to process the global configuration: %w", err)
+	}
+	// Subprocesses read the configuration from the command line which the platform does not use
+	if kconfig.Bool("global.isolate_jobs") == true {
+		return fmt.Errorf("global option \"isolate_jobs\" is not supported when jobs are defined programmatically")
+	}
+	configApplyGlobal()
+
+	jobmetadefs = make(map[string]JobMetaConfig)
+
+	return nil
+}
+
+// Register a job and validate its configuration using the rules of its module
+func EmbeddedRegisterJob(jobname string, jobconfig map[string]any) error {
+
+	if jobmetadefs == nil {
+		return fmt.Errorf("EmbeddedInitialise() must be called before jobs are registered")
+	}
+	if jobname == "" || strings.Contains(jobname, ".") {
+		return fmt.Errorf("invalid job name \"%s\" which must not be empty or contain dots", jobname)
+	}
+	if _, found := jobmetadefs[jobname]; found == true {
+		return fmt.Errorf("job \"%s\" is already registered", jobname)
+	}
+
+	module, _ := jobconfig["module"].(string)
+	if err := moduleUnavailableError(module); err != nil {
+		return fmt.Errorf("the configuration for job \"%s\" cannot be used: %w", jobname, err)
+	}
+	moddef, ok := findModuleDefinition(module)
+	if ok == false {
+		return fmt.Errorf("the configuration for job \"%s\" has an invalid value \"%s\" for \"module\"", jobname, module)
+	}
+
+	cfgpath := fmt.Sprintf("jobs.%s", jobname)
+	if err := kconfig.Set(cfgpath, embeddedCopyConfig(jobconfig)); err != nil {
+		return fmt.Errorf("failed to set the configuration of job \"%s\": %v", jobname, err)
+	}
+
+	// Jobs are checked by the same rules as the jobs of the configuration file before their module checks them
+	var jobconf JobMetaConfig
+	if err := kconfig.Unmarshal(cfgpath, &jobconf); err != nil {
+		kconfig.Delete(cfgpath)
+		return fmt.Errorf("failed to unmarshal path %s: %v", cfgpath, err)
+	}
+	if err := configValidateJobEntries(jobname, jobconf); err != nil {
+		kconfig.Delete(cfgpath)
+		return fmt.Errorf("failed to validate job \"%s\": %w", jobname, err)
+	}
+
+	// Errors are reported when jobs are registered rather than when they are executed
+	err := moddef.create().LoadConfiguration(jobname)
+	kconfig.Delete(cfgpath)
+	if err != nil {
+		return fmt.Errorf("invalid configuration for job \"%s\": %w", jobname, err)
+	}
+
+	// Default values set by the validation are discarded as the job is validated again when it runs
+	if err := kconfig.Set(cfgpath, embeddedCopyConfig(jobconfig)); err != nil {
+		return fmt.Errorf("failed to set the configuration of job \"%s\": %v", jobname, err)
+	}
+
+	jobmetadefs[jobname] = jobconf
+	slog.Debugf("Registered job \"%s\" using module \"%s\"", jobname, module)
+
+	return nil
+}
+
+// Execute all registered jobs and return a report about their execution
+func EmbeddedRunJobs() (*RunReport, error) {
+
+	report := NewRunReport(strings.TrimSpace(progversion))
+	jobcount, errcount := runAllJobs(report)
+
+	if err := reportUpload(report); err != nil {
+		slog.Errorf("Failed to upload run report: %v", err)
+	}
+
+	if errcount > 0 {
+		return report, fmt.Errorf("have finished running jobs with %d failures out of %d jobs", errcount, jobcount)
+	}
+
+	return report, nil
+}
+
+// The configuration keeps references to the maps it is given and default values are written to them, so
+// the maps and the lists nested in the configuration are copied as well
+func embeddedCopyConfig(config map[string]any) map[string]any {
+	result := make(map[string]any, len(config))
+	for key, val := range config {
+		result[key] = embeddedCopyValue(val)
+	}
+	return result
+}
+
+// Copy a value of the configuration with the maps and the lists it contains
+func embeddedCopyValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return embeddedCopyConfig(v)
+	case map[string]string:
+		result := make(map[string]string, len(v))
+		for key, val := range v {
+			result[key] = val
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, val := range v {
+			result[i] = embeddedCopyValue(val)
+		}
+		return result
+	case []string:
+		return append([]string(nil), v...)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,126 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedRegisterJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		jobname string
+		config  map[string]any
+		wantErr string
+	}{
+		{
+			name:    "valid job",
+			jobname: "canary01",
+			config:  map[string]any{"module": "canary"},
+		},
+		{
+			name:    "valid snooze",
+			jobname: "canary01",
+			config:  map[string]any{"module": "canary", "snooze_until": "2024-09-01"},
+		},
+		{
+			name:    "invalid job name",
+			jobname: "canary.01",
+			config:  map[string]any{"module": "canary"},
+			wantErr: "must not be empty or contain dots",
+		},
+		{
+			name:    "invalid module",
+			jobname: "job01",
+			config:  map[string]any{"module": "unknown"},
+			wantErr: "invalid value \"unknown\" for \"module\"",
+		},
+		{
+			name:    "metadata not supported by the module",
+			jobname: "canary01",
+			config:  map[string]any{"module": "canary", "metadata": map[string]any{"owner": "team"}},
+			wantErr: "\"metadata\" entry which is not supported by module \"canary\"",
+		},
+		{
+			name:    "invalid snooze",
+			jobname: "canary01",
+			config:  map[string]any{"module": "canary", "snooze_until": "tomorrow"},
+			wantErr: "invalid value for \"snooze_until\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := EmbeddedInitialise(map[string]any{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err := EmbeddedRegisterJob(tt.jobname, tt.config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if _, found := jobmetadefs[tt.jobname]; found == false {
+					t.Fatalf("job \"%s\" has not been registered", tt.jobname)
+				}
+				return
+			}
+			if err == nil || strings.Contains(err.Error(), tt.wantErr) == false {
+				t.Fatalf("got error %v, want an error containing %q", err, tt.wantErr)
+			}
+			if _, found := jobmetadefs[tt.jobname]; found == true {
+				t.Fatalf("job \"%s\" has been registered despite the error", tt.jobname)
+			}
+			if kconfig.Exists("jobs."+tt.jobname) == true {
+				t.Fatalf("configuration of job \"%s\" has been kept despite the error", tt.jobname)
+			}
+		})
+	}
+}
+
+func TestEmbeddedRegisterJobTwice(t *testing.T) {
+	if err := EmbeddedInitialise(map[string]any{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := EmbeddedRegisterJob("canary01", map[string]any{"module": "canary"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := EmbeddedRegisterJob("canary01", map[string]any{"module": "canary"}); err == nil {
+		t.Fatalf("job registered twice without error")
+	}
+}
+
+func TestEmbeddedCopyConfig(t *testing.T) {
+	config := map[string]any{
+		"module":   "ebs-snapshot",
+		"metadata": map[string]any{"owner": "team"},
+		"tags":     map[string]string{"Env": "prod"},
+		"volumes":  []any{"vol-1", map[string]any{"id": "vol-2"}},
+		"regions":  []string{"eu-west-1"},
+	}
+	result := embeddedCopyConfig(config)
+	if reflect.DeepEqual(result, config) == false {
+		t.Fatalf("got %v, want %v", result, config)
+	}
+
+	// Changes made by the configuration to its copy must not reach the maps and the lists of the caller
+	result["metadata"].(map[string]any)["owner"] = "other"
+	result["tags"].(map[string]string)["Env"] = "dev"
+	result["volumes"].([]any)[1].(map[string]any)["id"] = "vol-3"
+	result["regions"].([]string)[0] = "us-east-1"
+
+	want := map[string]any{
+		"module":   "ebs-snapshot",
+		"metadata": map[string]any{"owner": "team"},
+		"tags":     map[string]string{"Env": "prod"},
+		"volumes":  []any{"vol-1", map[string]any{"id": "vol-2"}},
+		"regions":  []string{"eu-west-1"},
+	}
+	if reflect.DeepEqual(config, want) == false {
+		t.Fatalf("original configuration has been modified: got %v, want %v", config, want)
+	}
+}
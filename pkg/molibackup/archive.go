@@ -0,0 +1,557 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Header written at the beginning of encrypted archives to identify their format. Archives in the second
+// format have the identifier of their key after the header so they can be re-encrypted when keys are rotated.
+// Archives in the third format are encrypted in chunks so they can be written and read as streams.
+const (
+	archiveMagic   = "MOLIBKP1"
+	archiveMagicV2 = "MOLIBKP2"
+	archiveMagicV3 = "MOLIBKP3"
+)
+
+// Size of the chunks of archives in the third format before they are encrypted, and size of the random
+// part of their nonces. The rest of the nonce of a chunk is its index and a flag set on the last chunk,
+// so chunks cannot be reordered, and an archive which has been truncated cannot be decrypted.
+const (
+	archiveChunkSize   = 64 * 1024
+	archiveNoncePrefix = 7
+)
+
+// Length in bytes of the identifiers of keys written in the header of archives
+const archiveKeyIdSize = 8
+
+// Return the identifier of a key which is derived from its hash so it does not reveal the key
+func archiveKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:archiveKeyIdSize])
+}
+
+// Read a base64 encoded 256 bits key from a file such as one generated with "openssl rand -base64 32"
+func archiveReadKeyFile(keyfile string) ([]byte, error) {
+	data, err := os.ReadFile(keyfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key file %s: %v", keyfile, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key file %s does not contain a valid base64 value: %v", keyfile, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key file %s must contain a 256 bits key but it has %d bits", keyfile, len(key)*8)
+	}
+	return key, nil
+}
+
+// Compress data with gzip so it can be read with the usual tools
+func archiveCompress(data []byte) ([]byte, error) {
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress archive: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress archive: %v", err)
+	}
+
+	return compressed.Bytes(), nil
+}
+
+// Compress data read from a reader with gzip and write the result as it is compressed
+func archiveCompressStream(output io.Writer, input io.Reader) error {
+
+	writer := gzip.NewWriter(output)
+	if _, err := io.Copy(writer, input); err != nil {
+		return fmt.Errorf("failed to compress archive: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to compress archive: %v", err)
+	}
+
+	return nil
+}
+
+// Return true if the path relative to the archived directory or the base name matches a pattern
+func archiveMatchPatterns(relpath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, relpath); matched == true {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, filepath.Base(relpath)); matched == true {
+			return true
+		}
+	}
+	return false
+}
+
+// Write a tar archive of directories where entries are named after their absolute path without the leading slash
+func archiveTarDirectories(output io.Writer, directories []string, includes []string, excludes []string, compression string) (int, error) {
+	skip := func(relpath string, isdir bool) bool {
+		if archiveMatchPatterns(relpath, excludes) == true {
+			return true
+		}
+		// Include patterns only apply to files so all directories are traversed
+		return isdir == false && len(includes) > 0 && archiveMatchPatterns(relpath, includes) == false
+	}
+	return archiveTarDirectoriesFunc(output, directories, skip, nil, compression)
+}
+
+// Write a tar archive of directories where entries are skipped when the function returns true for their path
+// relative to the archived directory, and where extra files are added with the names and contents specified.
+// The archive is written as the directories are walked so it is never held in memory.
+func archiveTarDirectoriesFunc(output io.Writer, directories []string, skip func(relpath string, isdir bool) bool, extra map[string][]byte, compression string) (int, error) {
+
+	var writer io.WriteCloser
+	var err error
+	filecount := 0
+
+	switch compression {
+	case "gzip":
+		writer = gzip.NewWriter(output)
+	case "zstd":
+		if writer, err = zstd.NewWriter(output); err != nil {
+			return 0, fmt.Errorf("failed to create zstd compressor: %v", err)
+		}
+	default:
+		writer = archiveNopCloser{output}
+	}
+	tarwriter := tar.NewWriter(writer)
+
+	for _, directory := range directories {
+		err := filepath.WalkDir(directory, func(fullpath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", fullpath, err)
+			}
+			relpath, _ := filepath.Rel(directory, fullpath)
+			if relpath != "." && skip(filepath.ToSlash(relpath), entry.IsDir()) == true {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", fullpath, err)
+			}
+			var linkname string
+			if info.Mode()&fs.ModeSymlink != 0 {
+				if linkname, err = os.Readlink(fullpath); err != nil {
+					return fmt.Errorf("failed to read link %s: %v", fullpath, err)
+				}
+			} else if info.Mode().IsRegular() == false && info.IsDir() == false {
+				return nil // Sockets, pipes and devices are not archived
+			}
+			header, err := tar.FileInfoHeader(info, linkname)
+			if err != nil {
+				return fmt.Errorf("failed to create archive header for %s: %v", fullpath, err)
+			}
+			header.Name = strings.TrimPrefix(filepath.ToSlash(fullpath), "/")
+			if info.IsDir() {
+				header.Name += "/"
+			}
+			if err := tarwriter.WriteHeader(header); err != nil {
+				return fmt.Errorf("failed to write archive header for %s: %v", fullpath, err)
+			}
+			if info.Mode().IsRegular() {
+				file, err := os.Open(fullpath)
+				if err != nil {
+					return fmt.Errorf("failed to open %s: %v", fullpath, err)
+				}
+				defer file.Close()
+				// Files which grow while they are archived, such as logs, are archived with the size they had when found
+				if _, err := io.CopyN(tarwriter, file, header.Size); err != nil {
+					return fmt.Errorf("failed to archive %s: %v", fullpath, err)
+				}
+				filecount++
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Extra files are generated by modules so they are added in the same order at each run
+	var names []string
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(extra[name])), ModTime: clockNow(), Typeflag: tar.TypeReg}
+		if err := tarwriter.WriteHeader(header); err != nil {
+			return 0, fmt.Errorf("failed to write archive header for %s: %v", name, err)
+		}
+		if _, err := tarwriter.Write(extra[name]); err != nil {
+			return 0, fmt.Errorf("failed to archive %s: %v", name, err)
+		}
+		filecount++
+	}
+
+	if err := tarwriter.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress archive: %v", err)
+	}
+
+	return filecount, nil
+}
+
+// Writer used when archives are not compressed
+type archiveNopCloser struct {
+	io.Writer
+}
+
+func (w archiveNopCloser) Close() error {
+	return nil
+}
+
+// Compress data with gzip and encrypt the result with AES-256-GCM
+func archiveEncrypt(key []byte, plaintext []byte) ([]byte, error) {
+
+	compressed, err := archiveCompress(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return archiveSeal(key, compressed)
+}
+
+// Encrypt compressed data in the third format with the header which has the identifier of the key
+func archiveSeal(key []byte, compressed []byte) ([]byte, error) {
+
+	var buffer bytes.Buffer
+	writer, err := archiveNewSealWriter(&buffer, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(compressed); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Writer which compresses data with gzip and encrypts the result like archiveEncrypt()
+type archiveEncryptWriter struct {
+	compressor *gzip.Writer
+	sealer     io.WriteCloser
+}
+
+// Return a writer which compresses and encrypts data as it is written, so archives of any size can be
+// encrypted while they are uploaded. The archive is only complete once the writer has been closed.
+func archiveNewEncryptWriter(output io.Writer, key []byte) (io.WriteCloser, error) {
+	sealer, err := archiveNewSealWriter(output, key)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveEncryptWriter{compressor: gzip.NewWriter(sealer), sealer: sealer}, nil
+}
+
+func (w *archiveEncryptWriter) Write(data []byte) (int, error) {
+	return w.compressor.Write(data)
+}
+
+func (w *archiveEncryptWriter) Close() error {
+	if err := w.compressor.Close(); err != nil {
+		return fmt.Errorf("failed to compress archive: %v", err)
+	}
+	return w.sealer.Close()
+}
+
+// Writer which encrypts data in chunks in the third format, where each chunk is sealed once the next one
+// has started so the last chunk is only sealed with the final flag when the writer is closed
+type archiveSealWriter struct {
+	gcm     cipher.AEAD
+	output  io.Writer
+	header  []byte
+	counter uint32
+	buffer  []byte
+	failed  error
+}
+
+func archiveNewSealWriter(output io.Writer, key []byte) (io.WriteCloser, error) {
+
+	gcm, err := archiveNewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, archiveNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	// The header is authenticated with each chunk so it cannot be altered without the decryption failing
+	keyid, _ := hex.DecodeString(archiveKeyId(key))
+	header := append(append([]byte(archiveMagicV3), keyid...), prefix...)
+	if _, err := output.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %v", err)
+	}
+
+	return &archiveSealWriter{gcm: gcm, output: output, header: header}, nil
+}
+
+func (w *archiveSealWriter) Write(data []byte) (int, error) {
+	if w.failed != nil {
+		return 0, w.failed
+	}
+	w.buffer = append(w.buffer, data...)
+	for len(w.buffer) > archiveChunkSize {
+		if err := w.seal(w.buffer[:archiveChunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buffer = append(w.buffer[:0], w.buffer[archiveChunkSize:]...)
+	}
+	return len(data), nil
+}
+
+func (w *archiveSealWriter) Close() error {
+	if w.failed != nil {
+		return w.failed
+	}
+	return w.seal(w.buffer, true)
+}
+
+func (w *archiveSealWriter) seal(chunk []byte, last bool) error {
+	if w.counter == ^uint32(0) {
+		w.failed = fmt.Errorf("archive is too large to be encrypted")
+		return w.failed
+	}
+	nonce := archiveChunkNonce(w.header, w.counter, last)
+	if _, err := w.output.Write(w.gcm.Seal(nil, nonce, chunk, w.header)); err != nil {
+		w.failed = fmt.Errorf("failed to write archive: %v", err)
+		return w.failed
+	}
+	w.counter++
+	return nil
+}
+
+// Return the nonce of a chunk which is made of the random prefix in the header, the index of the chunk, and the final flag
+func archiveChunkNonce(header []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, archiveNoncePrefix+5)
+	nonce = append(nonce, header[len(header)-archiveNoncePrefix:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last == true {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// Reader which decrypts the chunks of an archive in the third format
+type archiveOpenReader struct {
+	gcm     cipher.AEAD
+	input   *bufio.Reader
+	header  []byte
+	counter uint32
+	chunk   []byte
+	done    bool
+}
+
+func (r *archiveOpenReader) Read(data []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done == true {
+			return 0, io.EOF
+		}
+		sealed := make([]byte, archiveChunkSize+r.gcm.Overhead())
+		count, err := io.ReadFull(r.input, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				err = fmt.Errorf("archive is truncated")
+			}
+			return 0, err
+		}
+		// The last chunk is either shorter than the others or it is followed by the end of the archive
+		last := count < len(sealed)
+		if last == false {
+			if _, err := r.input.Peek(1); err == io.EOF {
+				last = true
+			}
+		}
+		r.chunk, err = r.gcm.Open(sealed[:0], archiveChunkNonce(r.header, r.counter, last), sealed[:count], r.header)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt archive, the key may be wrong or the archive may be truncated: %v", err)
+		}
+		r.counter++
+		r.done = last
+	}
+	count := copy(data, r.chunk)
+	r.chunk = r.chunk[count:]
+	return count, nil
+}
+
+// Return a reader of the compressed data of an encrypted archive which decrypts archives in the third format
+// as they are read, while archives in previous formats are decrypted at once as they are not made of chunks
+func archiveNewOpenReader(input io.Reader, key []byte) (io.Reader, error) {
+
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(len(archiveMagicV3))
+	if err != nil || string(magic) != archiveMagicV3 {
+		data, err := io.ReadAll(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %v", err)
+		}
+		compressed, err := archiveOpen(key, data)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(compressed), nil
+	}
+
+	header := make([]byte, len(archiveMagicV3)+archiveKeyIdSize+archiveNoncePrefix)
+	if _, err := io.ReadFull(buffered, header); err != nil {
+		return nil, fmt.Errorf("data is not an archive created by this program")
+	}
+	if keyid := hex.EncodeToString(header[len(archiveMagicV3) : len(archiveMagicV3)+archiveKeyIdSize]); keyid != archiveKeyId(key) {
+		return nil, fmt.Errorf("archive was encrypted with key %s but the key provided is %s", keyid, archiveKeyId(key))
+	}
+	gcm, err := archiveNewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &archiveOpenReader{gcm: gcm, input: buffered, header: header}, nil
+}
+
+// Return the identifier of the key used to encrypt an archive, which is empty for archives in the first format
+func archiveGetKeyId(data []byte) (string, error) {
+	switch {
+	case len(data) >= len(archiveMagicV3)+archiveKeyIdSize && string(data[:len(archiveMagicV3)]) == archiveMagicV3:
+		return hex.EncodeToString(data[len(archiveMagicV3) : len(archiveMagicV3)+archiveKeyIdSize]), nil
+	case len(data) >= len(archiveMagicV2)+archiveKeyIdSize && string(data[:len(archiveMagicV2)]) == archiveMagicV2:
+		return hex.EncodeToString(data[len(archiveMagicV2) : len(archiveMagicV2)+archiveKeyIdSize]), nil
+	case len(data) >= len(archiveMagic) && string(data[:len(archiveMagic)]) == archiveMagic:
+		return "", nil
+	default:
+		return "", fmt.Errorf("data is not an archive created by this program")
+	}
+}
+
+// Decrypt data produced by archiveSeal() or by previous versions and return the compressed data
+func archiveOpen(key []byte, data []byte) ([]byte, error) {
+
+	keyid, err := archiveGetKeyId(data)
+	if err != nil {
+		return nil, err
+	}
+	if keyid != "" && keyid != archiveKeyId(key) {
+		return nil, fmt.Errorf("archive was encrypted with key %s but the key provided is %s", keyid, archiveKeyId(key))
+	}
+	if string(data[:len(archiveMagicV3)]) == archiveMagicV3 {
+		reader, err := archiveNewOpenReader(bytes.NewReader(data), key)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	}
+	header := []byte(archiveMagic)
+	if keyid != "" {
+		header = data[:len(archiveMagicV2)+archiveKeyIdSize]
+	}
+
+	gcm, err := archiveNewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(header)+gcm.NonceSize() {
+		return nil, fmt.Errorf("data is not an archive created by this program")
+	}
+	nonce := data[len(header) : len(header)+gcm.NonceSize()]
+	ciphertext := data[len(header)+gcm.NonceSize():]
+
+	compressed, err := gcm.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive, the key may be wrong: %v", err)
+	}
+
+	return compressed, nil
+}
+
+// Decrypt and decompress data produced by archiveEncrypt()
+func archiveDecrypt(key []byte, data []byte) ([]byte, error) {
+
+	compressed, err := archiveOpen(key, data)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %v", err)
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %v", err)
+	}
+
+	return plaintext, nil
+}
+
+func archiveNewCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise cipher: %v", err)
+	}
+	return gcm, nil
+}
+
+// Decrypt an archive file and write its contents to the standard output as it is decrypted
+func archiveDecryptFile(keyfile string, archivefile string) error {
+	key, err := archiveReadKeyFile(keyfile)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(archivefile)
+	if err != nil {
+		return fmt.Errorf("failed to read archive %s: %v", archivefile, err)
+	}
+	defer file.Close()
+	compressed, err := archiveNewOpenReader(file, key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", archivefile, err)
+	}
+	reader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return fmt.Errorf("%s: failed to decompress archive: %v", archivefile, err)
+	}
+	if _, err := io.Copy(os.Stdout, reader); err != nil {
+		return fmt.Errorf("%s: failed to write decrypted archive: %v", archivefile, err)
+	}
+	return nil
+}
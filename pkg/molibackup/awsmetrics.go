@@ -0,0 +1,163 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gookit/slog"
+)
+
+// The metrics of the calls to the AWS API are collected for each operation of the job which is running,
+// so slow or throttled runs can be diagnosed from the run report. A call is measured once the metadata
+// of its operation has been registered until its result is returned, so its latency includes the delays
+// between its attempts, and each attempt made by the retry middleware of the SDK is counted by another
+// middleware placed after it.
+
+// Metrics of the calls made to an operation of the AWS API by a job
+type RunReportApiCall struct {
+	Operation      string `json:"operation"`
+	Calls          int    `json:"calls"`
+	Attempts       int    `json:"attempts"`
+	Throttled      int    `json:"throttled,omitempty"`
+	Errors         int    `json:"errors,omitempty"`
+	TotalLatencyMs int64  `json:"total_latency_ms"`
+	MaxLatencyMs   int64  `json:"max_latency_ms"`
+}
+
+// Metrics of the job which is running, indexed by operation
+var awsMetricsMutex sync.Mutex
+var awsMetricsCalls = make(map[string]*RunReportApiCall)
+
+// Key of the context value where the attempts of a call are counted
+type awsMetricsAttemptsKey struct{}
+
+// Forget the metrics of the previous job before a job starts
+func awsMetricsReset() {
+	awsMetricsMutex.Lock()
+	defer awsMetricsMutex.Unlock()
+	awsMetricsCalls = make(map[string]*RunReportApiCall)
+}
+
+// Add metrics to those of the job which is running, such as the metrics of a job executed in a subprocess
+func awsMetricsMerge(calls []RunReportApiCall) {
+	awsMetricsMutex.Lock()
+	defer awsMetricsMutex.Unlock()
+	for _, call := range calls {
+		metrics, found := awsMetricsCalls[call.Operation]
+		if found == false {
+			metrics = &RunReportApiCall{Operation: call.Operation}
+			awsMetricsCalls[call.Operation] = metrics
+		}
+		metrics.Calls += call.Calls
+		metrics.Attempts += call.Attempts
+		metrics.Throttled += call.Throttled
+		metrics.Errors += call.Errors
+		metrics.TotalLatencyMs += call.TotalLatencyMs
+		if call.MaxLatencyMs > metrics.MaxLatencyMs {
+			metrics.MaxLatencyMs = call.MaxLatencyMs
+		}
+	}
+}
+
+// Return the metrics of the job which is running sorted by operation
+func awsMetricsSummary() []RunReportApiCall {
+	awsMetricsMutex.Lock()
+	defer awsMetricsMutex.Unlock()
+	var results []RunReportApiCall
+	for _, metrics := range awsMetricsCalls {
+		results = append(results, *metrics)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Operation < results[j].Operation })
+	return results
+}
+
+// Record the result of a call once all its attempts have been made
+func awsMetricsRecord(operation string, attempts int, throttled int, latency time.Duration, err error) {
+	awsMetricsMutex.Lock()
+	defer awsMetricsMutex.Unlock()
+	metrics, found := awsMetricsCalls[operation]
+	if found == false {
+		metrics = &RunReportApiCall{Operation: operation}
+		awsMetricsCalls[operation] = metrics
+	}
+	metrics.Calls++
+	metrics.Attempts += attempts
+	metrics.Throttled += throttled
+	if err != nil {
+		metrics.Errors++
+	}
+	metrics.TotalLatencyMs += latency.Milliseconds()
+	if latency.Milliseconds() > metrics.MaxLatencyMs {
+		metrics.MaxLatencyMs = latency.Milliseconds()
+	}
+}
+
+// Check if an attempt has been rejected by the rate limits of the API
+func awsMetricsThrottled(err error) bool {
+	var apierr smithy.APIError
+	if errors.As(err, &apierr) == false {
+		return false
+	}
+	_, found := retry.DefaultThrottleErrorCodes[apierr.ErrorCode()]
+	return found
+}
+
+// Attempts and throttled attempts of a call which are counted by the finalize middleware
+type awsMetricsAttempts struct {
+	attempts  int
+	throttled int
+}
+
+// Add the middlewares which measure the calls and count their attempts to the stack of each operation
+func awsMetricsAwsMiddleware(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ApiMetrics",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			counts := &awsMetricsAttempts{}
+			starttime := time.Now()
+			out, metadata, err := next.HandleInitialize(context.WithValue(ctx, awsMetricsAttemptsKey{}, counts), in)
+			operation := fmt.Sprintf("%s:%s", awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx))
+			awsMetricsRecord(operation, counts.attempts, counts.throttled, time.Since(starttime), err)
+			return out, metadata, err
+		}), middleware.After)
+	if err != nil {
+		return err
+	}
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("ApiMetricsAttempts",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			if counts, ok := ctx.Value(awsMetricsAttemptsKey{}).(*awsMetricsAttempts); ok == true {
+				counts.attempts++
+				if awsMetricsThrottled(err) == true {
+					counts.throttled++
+				}
+			}
+			return out, metadata, err
+		}), middleware.After)
+}
+
+// Log the calls of a job which have been retried or throttled, and the others at the debug level
+func awsMetricsLogUsage(jobname string, calls []RunReportApiCall) {
+	for _, call := range calls {
+		logf := slog.Debugf
+		if call.Attempts > call.Calls {
+			logf = slog.Infof
+		}
+		logf("AWS API calls of job \"%s\" to %s: calls=%d attempts=%d throttled=%d errors=%d latency=%v max=%v", jobname,
+			call.Operation, call.Calls, call.Attempts, call.Throttled, call.Errors,
+			time.Duration(call.TotalLatencyMs)*time.Millisecond, time.Duration(call.MaxLatencyMs)*time.Millisecond)
+	}
+}
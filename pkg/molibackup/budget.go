@@ -0,0 +1,119 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// The API budget limits the number of calls per second which create and delete resources across the
+// whole run, so jobs covering hundreds of volumes do not get throttled by the provider. When calls of
+// both classes are waiting, the budget is shared according to the share reserved for creations, so
+// deletions do not starve creations when both phases are interleaved, and creations do not starve
+// deletions either. Each class has a virtual time which advances more slowly for the class with the
+// larger share, and the waiting class with the earliest virtual time is served first.
+
+// Classes of calls which share the API budget
+var apiBudgetClasses = []string{"create", "delete"}
+
+// State of the scheduler
+var apiBudgetMutex sync.Mutex
+var apiBudgetRate float64
+var apiBudgetTokens float64
+var apiBudgetLastRefill time.Time
+var apiBudgetShares = make(map[string]float64)
+var apiBudgetVirtual = make(map[string]float64)
+var apiBudgetWaiting = make(map[string]int)
+var apiBudgetCalls = make(map[string]int)
+var apiBudgetWaited = make(map[string]time.Duration)
+
+// Set the number of calls per second shared by all classes, where zero disables the budget,
+// and the percentage of the budget reserved for creations when both classes are waiting
+func apiBudgetSet(rate int64, createShare int64) {
+	apiBudgetMutex.Lock()
+	defer apiBudgetMutex.Unlock()
+	apiBudgetRate = float64(rate)
+	apiBudgetTokens = 1
+	apiBudgetLastRefill = time.Now()
+	apiBudgetShares["create"] = float64(createShare) / 100
+	apiBudgetShares["delete"] = 1 - apiBudgetShares["create"]
+}
+
+// Block until a call of the class specified can be made within the budget
+func apiBudgetWait(class string) {
+
+	apiBudgetMutex.Lock()
+	if apiBudgetRate <= 0 {
+		apiBudgetMutex.Unlock()
+		return
+	}
+
+	// A class which has been idle starts at the virtual time of the other classes so it does not get a burst
+	if apiBudgetWaiting[class] == 0 {
+		for _, other := range apiBudgetClasses {
+			if other != class && apiBudgetWaiting[other] > 0 && apiBudgetVirtual[other] > apiBudgetVirtual[class] {
+				apiBudgetVirtual[class] = apiBudgetVirtual[other]
+			}
+		}
+	}
+	apiBudgetWaiting[class]++
+	starttime := time.Now()
+
+	for {
+		now := time.Now()
+		apiBudgetTokens += now.Sub(apiBudgetLastRefill).Seconds() * apiBudgetRate
+		if apiBudgetTokens > apiBudgetRate {
+			apiBudgetTokens = apiBudgetRate
+		}
+		apiBudgetLastRefill = now
+		if apiBudgetTokens >= 1 && apiBudgetTurn(class) == true {
+			apiBudgetTokens--
+			apiBudgetVirtual[class] += 1 / apiBudgetShares[class]
+			apiBudgetWaiting[class]--
+			apiBudgetCalls[class]++
+			apiBudgetWaited[class] += time.Since(starttime)
+			apiBudgetMutex.Unlock()
+			return
+		}
+		// Wait for the next token or for the other class to take its turn
+		delay := time.Duration((1 - apiBudgetTokens) / apiBudgetRate * float64(time.Second))
+		if delay < 10*time.Millisecond {
+			delay = 10 * time.Millisecond
+		}
+		apiBudgetMutex.Unlock()
+		time.Sleep(delay)
+		apiBudgetMutex.Lock()
+	}
+}
+
+// Check if a class is the waiting class with the earliest virtual time, must be called with the mutex locked
+func apiBudgetTurn(class string) bool {
+	for _, other := range apiBudgetClasses {
+		if other != class && apiBudgetWaiting[other] > 0 && apiBudgetVirtual[other] < apiBudgetVirtual[class] {
+			return false
+		}
+	}
+	return true
+}
+
+// Log the number of calls made within the budget and how long they have waited so the budget can be tuned
+func apiBudgetLogUsage() {
+	apiBudgetMutex.Lock()
+	defer apiBudgetMutex.Unlock()
+	if apiBudgetRate <= 0 {
+		return
+	}
+	for _, class := range apiBudgetClasses {
+		if apiBudgetCalls[class] > 0 {
+			slog.Infof("API budget: %d calls to %s resources have waited %v in total", apiBudgetCalls[class], class,
+				apiBudgetWaited[class].Truncate(time.Millisecond))
+		}
+	}
+}
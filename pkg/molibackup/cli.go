@@ -0,0 +1,343 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+	"golang.org/x/exp/slices"
+)
+
+//go:embed VERSION
+var progversion string
+
+const (
+	ExitStatusSuccessfulExecution  = 0
+	ExitStatusInvalidConfiguration = 1
+	ExitStatusFailedToExecuteJobs  = 2
+)
+
+// Run the program with the options specified on the command line and return its exit status
+func Main() int {
+
+	version := strings.TrimSpace(progversion)
+
+	// Process options specified on the command line
+	configfile := flag.String("c", "", "path to the yaml configuration file")
+	profile := flag.String("profile", "", "name of the configuration profile to activate")
+	showversion := flag.Bool("v", false, "show program version and exit")
+	region := flag.String("region", "", "AWS region of the instance to restore")
+	launch := flag.Bool("launch", false, "launch a new instance with the restored volumes")
+	volumeType := flag.String("volume-type", "gp3", "type of the restored volumes")
+	iops := flag.Int("iops", 0, "provisioned IOPS of the restored volumes (default of the volume type when not specified)")
+	throughput := flag.Int("throughput", 0, "throughput of the restored gp3 volumes in MiB/s (default of the volume type when not specified)")
+	kmsKey := flag.String("kms-key", "", "KMS key used to encrypt the restored volumes (key of the snapshots when not specified)")
+	copyTags := flag.String("copy-tags", "", "comma separated list of tags of the snapshots to copy to the restored volumes, or * for all tags")
+	tagRestored := flag.Bool("tag-restored", true, "tag the restored resources with RestoredBy and the identifier of their source")
+	initialize := flag.String("initialize", "", "initialize the restored volumes with fast snapshot restore (fsr) or by reading them on the new instance (read)")
+	initTimeout := flag.Duration("initialize-timeout", 6*time.Hour, "maximum time to wait for the initialization of the restored volumes")
+	offline := flag.Bool("offline", false, "disable all network destinations other than the providers used by jobs")
+	jobsFromTags := flag.Bool("jobs-from-tags", false, "read the configuration from the tags of the local EC2 instance instead of a file")
+	phases := flag.String("phases", strings.Join(jobPhaseNames, ","), "comma separated list of the phases of jobs to run among create, list and delete")
+	simnow := flag.String("now", "", "simulate a run at the date and time specified in the RFC3339 format (implies dryrun)")
+	eventsFd := flag.Int("events-fd", -1, "write events about the progress of jobs as JSON lines to this file descriptor")
+	eventsSocket := flag.String("events-socket", "", "write events about the progress of jobs as JSON lines to this unix socket")
+	internalRunJob := flag.String("internal-run-job", "", "run a single job, reserved for subprocesses started when jobs are isolated")
+	gcDelete := flag.Bool("gc-delete", false, "delete the orphans found by the gc command instead of only listing them")
+	faultInjection := flag.String("fault-injection", "", "inject errors and timeouts in calls to the AWS API such as error=0.1,timeout=0.05")
+	hideFlags("fault-injection")
+	flag.Parse()
+
+	// Show version number if requested
+	if *showversion {
+		fmt.Printf("molibackup version %s built with %s for %s/%s\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		return ExitStatusSuccessfulExecution
+	}
+
+	// Decrypt an archive created by a module without reading the configuration
+	if flag.Arg(0) == "decrypt" {
+		if flag.NArg() != 3 {
+			fmt.Fprintf(os.Stderr, "usage: molibackup decrypt <keyfile> <archive>\n")
+			return ExitStatusInvalidConfiguration
+		}
+		if err := archiveDecryptFile(flag.Arg(1), flag.Arg(2)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to decrypt archive: %v\n", err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	}
+
+	// Show the identifier of a key so it can be compared with the identifiers recorded in archives
+	if flag.Arg(0) == "keyid" {
+		if flag.NArg() != 2 {
+			fmt.Fprintf(os.Stderr, "usage: molibackup keyid <keyfile>\n")
+			return ExitStatusInvalidConfiguration
+		}
+		key, err := archiveReadKeyFile(flag.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read key: %v\n", err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		fmt.Println(archiveKeyId(key))
+		return ExitStatusSuccessfulExecution
+	}
+
+	// Describe the modules and their options without reading the configuration
+	if flag.Arg(0) == "modules" {
+		printModules(os.Stdout)
+		return ExitStatusSuccessfulExecution
+	}
+
+	// Faults are injected from the start so the configuration loaded from the tags of the instance is affected too
+	if *faultInjection != "" {
+		if err := faultInjectionSet(*faultInjection); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid value for -fault-injection: %v\n", err)
+			return ExitStatusInvalidConfiguration
+		}
+	}
+
+	// Initialise the logging library
+	logfmtTemplateShort := "[{{datetime}}] [{{level}}] [{{runid}}] {{message}} {{data}} {{extra}}\n"
+	logfmtTemplateDebug := "[{{datetime}}] [{{level}}] [{{runid}}] [{{caller}}] {{message}} {{data}} {{extra}}\n"
+	logfmt := slog.NewTextFormatter()
+	logfmt.SetTemplate(logfmtTemplateShort)
+	logfmt.EnableColor = true
+	slog.SetFormatter(logfmt)
+	slog.SetLogLevel(slog.InfoLevel)
+
+	// Include the identifier of the run or of the current job in all log lines
+	runId = NewRunId()
+	if *internalRunJob != "" && os.Getenv(isolationEnvRunId) != "" {
+		runId = os.Getenv(isolationEnvRunId)
+	}
+	slog.AddProcessor(slog.ProcessorFunc(func(record *slog.Record) {
+		record.AddField("runid", currentCorrelationId())
+	}))
+
+	// Print version number
+	if *internalRunJob == "" {
+		slog.Infof("molibackup version %s built with %s for %s/%s starting ...", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	}
+
+	if faultInjectionEnabled() == true && *internalRunJob == "" {
+		slog.Warnf("Fault injection enabled: calls to the AWS API fail with the rates requested by -fault-injection")
+	}
+
+	// Read the configuration file or the tags of the local instance
+	var err error
+	if *jobsFromTags == true && (*configfile != "" || *profile != "") {
+		slog.Errorf("Options -c and -profile cannot be used together with -jobs-from-tags")
+		return ExitStatusInvalidConfiguration
+	}
+	if *jobsFromTags == true {
+		err = readConfigurationFromTags()
+	} else {
+		err = readConfiguration(*configfile, *profile)
+	}
+	if err != nil {
+		slog.Errorf("Failed to read configuration: %v", err)
+		return ExitStatusInvalidConfiguration
+	}
+
+	// The command line option enables the offline mode whatever the configuration says
+	if *offline == true {
+		kconfig.Set("global.offline", true)
+	}
+	if kconfig.Bool("global.offline") == true {
+		slog.Infof("Running in offline mode: outbound integrations other than the providers used by jobs are disabled")
+	}
+
+	// Run only some phases of jobs so they can be orchestrated separately
+	if err := selectJobPhases(*phases); err != nil {
+		slog.Errorf("Invalid value for -phases: %v", err)
+		return ExitStatusInvalidConfiguration
+	}
+
+	// Simulate a run at a different time to see which backups the retention settings would delete
+	if *simnow != "" {
+		now, err := time.Parse(time.RFC3339, *simnow)
+		if err != nil {
+			slog.Errorf("Invalid value for -now which must be in the RFC3339 format such as \"2024-01-31T04:00:00Z\": %v", err)
+			return ExitStatusInvalidConfiguration
+		}
+		// Commands which modify backups outside of jobs cannot be simulated so they are not allowed
+		if flag.Arg(0) == "gc" || flag.Arg(0) == "retag" || flag.Arg(0) == "rekey" {
+			slog.Errorf("Option -now cannot be used with the %s command", flag.Arg(0))
+			return ExitStatusInvalidConfiguration
+		}
+		clock = fixedClock{now: now}
+		for jobname := range jobmetadefs {
+			for _, option := range []string{"dryrun", "dryrun_create", "dryrun_delete"} {
+				kconfig.Set(fmt.Sprintf("jobs.%s.%s", jobname, option), true)
+			}
+		}
+		slog.Warnf("Simulating a run at %s: all jobs run in dryrun mode", now.Format(time.RFC3339))
+	}
+
+	// Configure the logging library
+	loglevel := progconfig.Global["loglevel"]
+	switch loglevel {
+	case "error":
+		slog.SetLogLevel(slog.ErrorLevel)
+		logfmt.SetTemplate(logfmtTemplateShort)
+	case "warn":
+		slog.SetLogLevel(slog.WarnLevel)
+		logfmt.SetTemplate(logfmtTemplateShort)
+	case "info":
+		slog.SetLogLevel(slog.InfoLevel)
+		logfmt.SetTemplate(logfmtTemplateShort)
+	case "debug":
+		slog.SetLogLevel(slog.DebugLevel)
+		logfmt.SetTemplate(logfmtTemplateDebug)
+	default:
+		slog.Errorf("Invalid loglevel in configuration: \"%s\"", loglevel)
+		return ExitStatusInvalidConfiguration
+	}
+
+	// Stream events to an orchestrator which wraps the program so it can show the progress of jobs
+	if *eventsFd >= 0 && *eventsSocket != "" {
+		slog.Errorf("Options -events-fd and -events-socket cannot be used together")
+		return ExitStatusInvalidConfiguration
+	}
+	if *eventsFd >= 0 {
+		err = eventsOpenFd(*eventsFd)
+	} else if *eventsSocket != "" {
+		err = eventsOpenSocket(*eventsSocket)
+	}
+	if err != nil {
+		slog.Errorf("Failed to open the stream of events: %v", err)
+		return ExitStatusInvalidConfiguration
+	}
+	configApplyGlobal()
+	slog.AddProcessor(slog.ProcessorFunc(eventsLogProcessor))
+
+	// Execute a single job when the program runs as the subprocess of an isolated job
+	if *internalRunJob != "" {
+		return runIsolatedChild(*internalRunJob)
+	}
+
+	// Merge the reports uploaded by all hosts when the digest command is requested
+	switch flag.Arg(0) {
+	case "":
+	case "digest":
+		if err := reportDigest(); err != nil {
+			slog.Errorf("Failed to produce digest of run reports: %v", err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	case "docs":
+		if flag.NArg() != 2 {
+			slog.Errorf("usage: molibackup docs <output-file>")
+			return ExitStatusInvalidConfiguration
+		}
+		if err := docsGenerate(flag.Arg(1), version); err != nil {
+			slog.Errorf("Failed to generate the documentation: %v", err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	case "gc":
+		if err := gcDestinations(*gcDelete); err != nil {
+			slog.Errorf("Failed to collect orphans in destinations: %v", err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	case "restore":
+		if flag.NArg() != 4 || flag.Arg(1) != "instance" {
+			slog.Errorf("usage: molibackup [-region <region>] [-launch] [-volume-type <type>] [-iops <iops>] [-throughput <mibps>] [-kms-key <key>] [-copy-tags <tags>] [-tag-restored=false] [-initialize fsr|read] restore instance <instance-id> <YYYY-MM-DD>")
+			return ExitStatusInvalidConfiguration
+		}
+		settings := ProviderAwsRestoreVolumeSettings{volumeType: *volumeType, iops: int32(*iops), throughput: int32(*throughput),
+			kmsKeyId: *kmsKey, tagRestored: *tagRestored, initialize: *initialize, initTimeout: *initTimeout}
+		if *copyTags != "" {
+			for _, tagkey := range strings.Split(*copyTags, ",") {
+				settings.copyTags = append(settings.copyTags, strings.TrimSpace(tagkey))
+			}
+		}
+		if err := restoreInstance(*region, flag.Arg(2), flag.Arg(3), *launch, settings); err != nil {
+			slog.Errorf("Failed to restore instance: %v", err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	case "retag":
+		if flag.NArg() < 3 {
+			slog.Errorf("usage: molibackup retag <job> <key=value> [<key=value> ...]")
+			return ExitStatusInvalidConfiguration
+		}
+		if err := retagJob(flag.Arg(1), flag.Args()[2:]); err != nil {
+			slog.Errorf("Failed to update tags of job \"%s\": %v", flag.Arg(1), err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	case "rekey":
+		if flag.NArg() < 3 {
+			slog.Errorf("usage: molibackup rekey <job> <old-keyfile> [<old-keyfile> ...]")
+			return ExitStatusInvalidConfiguration
+		}
+		if err := rekeyJob(flag.Arg(1), flag.Args()[2:]); err != nil {
+			slog.Errorf("Failed to encrypt archives of job \"%s\" with the new key: %v", flag.Arg(1), err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	case "snooze":
+		if flag.NArg() != 3 {
+			slog.Errorf("usage: molibackup snooze <job> <duration>")
+			return ExitStatusInvalidConfiguration
+		}
+		if err := snoozeJob(flag.Arg(1), flag.Arg(2)); err != nil {
+			slog.Errorf("Failed to snooze job \"%s\": %v", flag.Arg(1), err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		return ExitStatusSuccessfulExecution
+	default:
+		slog.Errorf("Invalid command on the command line: \"%s\"", flag.Arg(0))
+		return ExitStatusInvalidConfiguration
+	}
+
+	// Execute all jobs defined in the configuration
+	report := NewRunReport(version)
+	jobcount, errcount := runAllJobs(report)
+
+	// Report volumes which are not covered by any job so they do not get forgotten
+	if kconfig.Bool("global.warn_uncovered_volumes") == true {
+		ebsWarnUncoveredVolumes()
+	}
+
+	// Share the result of this execution so it can be included in a digest for the whole fleet
+	if err := reportUpload(report); err != nil {
+		slog.Errorf("Failed to upload run report: %v", err)
+	}
+
+	if errcount > 0 {
+		slog.Errorf("Have finished running jobs with %d failures out of %d jobs", errcount, jobcount)
+		return ExitStatusFailedToExecuteJobs
+	}
+
+	slog.Infof("Have successfully executed %d jobs", jobcount)
+	return ExitStatusSuccessfulExecution
+}
+
+// Options which are accepted but not described in the usage message as they are only meant for testing
+func hideFlags(names ...string) {
+	flag.Usage = func() {
+		visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		visible.SetOutput(flag.CommandLine.Output())
+		flag.VisitAll(func(f *flag.Flag) {
+			if slices.Contains(names, f.Name) == false {
+				visible.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		visible.PrintDefaults()
+	}
+}
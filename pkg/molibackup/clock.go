@@ -0,0 +1,42 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"crypto/rand"
+	"io"
+	"time"
+)
+
+// Source of the current time used to name backups and to calculate their age
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Clock which always returns the same time so retention boundaries can be checked deterministically
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// Clock and source of randomness which can be replaced for simulations and tests
+var clock Clock = systemClock{}
+var randReader io.Reader = rand.Reader
+
+// Return the current time according to the clock in use
+func clockNow() time.Time {
+	return clock.Now()
+}
@@ -0,0 +1,162 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Time of the fixed clock used by the tests of the boundaries of the rotation
+var testClockNow = time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// Use a fixed clock for the duration of a test
+func testFixedClock(t *testing.T) {
+	clock = fixedClock{now: testClockNow}
+	t.Cleanup(func() { clock = systemClock{} })
+}
+
+// Backup created the duration specified before the time of the fixed clock
+func testBackupAged(identifier string, age time.Duration) BackupItem {
+	return BackupItem{identifier: identifier, description: identifier, timestamp: testClockNow.Add(-age).Unix()}
+}
+
+// Snapshot provider which records the snapshots deleted by the rotation
+type testSnapshotProvider struct {
+	deleted []string
+}
+
+func (p *testSnapshotProvider) CreateSnapshot(source snapshotSource, snapname string, snapdate string, snaptime string) (string, error) {
+	return snapname, nil
+}
+
+func (p *testSnapshotProvider) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	return nil, nil
+}
+
+func (p *testSnapshotProvider) DeleteSnapshot(item BackupItem) error {
+	p.deleted = append(p.deleted, item.identifier)
+	return nil
+}
+
+func TestClockMinAgeBeforeDelete(t *testing.T) {
+	testFixedClock(t)
+	tests := []struct {
+		name      string
+		age       time.Duration
+		minage    int64
+		protected bool
+	}{
+		{"exactly the minimum age", 24 * time.Hour, 24, false},
+		{"one second before the minimum age", 24*time.Hour - time.Second, 24, true},
+		{"one hour after the minimum age", 25 * time.Hour, 24, false},
+		{"no minimum age", 0, 0, false},
+		{"timestamp in the future", -time.Hour, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := testBackupAged("backup-1", tt.age)
+			if got := recentBackups([]BackupItem{item}, tt.minage)[item.identifier]; got != tt.protected {
+				t.Fatalf("got protected=%v, want %v", got, tt.protected)
+			}
+		})
+	}
+}
+
+func TestClockRetention(t *testing.T) {
+	testFixedClock(t)
+	tests := []struct {
+		name    string
+		age     time.Duration
+		deleted bool
+	}{
+		{"exactly the retention", 7 * 24 * time.Hour, false},
+		{"one second before the next day", 8*24*time.Hour - time.Second, false},
+		{"one day after the retention", 8 * 24 * time.Hour, true},
+		{"created now", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &testSnapshotProvider{}
+			rotation := &snapshotRotation{noun: "snapshot", retention: 7, provider: provider}
+			if err := rotation.DeleteOldBackups([]BackupItem{testBackupAged("snap-1", tt.age)}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := len(provider.deleted) == 1; got != tt.deleted {
+				t.Fatalf("got deleted=%v, want %v", got, tt.deleted)
+			}
+		})
+	}
+}
+
+func TestClockKeepMinimum(t *testing.T) {
+	testFixedClock(t)
+	day := 24 * time.Hour
+	tests := []struct {
+		name        string
+		keepMinimum int
+		ages        []time.Duration
+		recent      map[string]bool
+		want        []string
+	}{
+		{
+			name:        "snapshots at the retention boundary are kept",
+			keepMinimum: 0,
+			ages:        []time.Duration{7 * day, 8*day - time.Second},
+			want:        nil,
+		},
+		{
+			name:        "snapshots past the retention are deleted",
+			keepMinimum: 0,
+			ages:        []time.Duration{1 * day, 8 * day, 9 * day},
+			want:        []string{"snap-1", "snap-2"},
+		},
+		{
+			name:        "minimum kept when all snapshots are past the retention",
+			keepMinimum: 2,
+			ages:        []time.Duration{8 * day, 9 * day, 10 * day},
+			want:        []string{"snap-2"},
+		},
+		{
+			name:        "minimum equal to the number of snapshots",
+			keepMinimum: 3,
+			ages:        []time.Duration{8 * day, 9 * day, 10 * day},
+			want:        nil,
+		},
+		{
+			name:        "recent snapshots count in the minimum",
+			keepMinimum: 1,
+			ages:        []time.Duration{0, 8 * day, 9 * day},
+			recent:      map[string]bool{"snap-0": true},
+			want:        []string{"snap-1", "snap-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &backup_ebs_snapshot{snapshots: make(map[string]ProviderAwsEbsSnapshot)}
+			b.config.Retention = 7
+			b.config.KeepMinimum = tt.keepMinimum
+			var bkpitems []BackupItem
+			for i, age := range tt.ages {
+				item := testBackupAged(fmt.Sprintf("snap-%d", i), age)
+				b.snapshots[item.identifier] = ProviderAwsEbsSnapshot{snapshotId: item.identifier, volumeId: "vol-1"}
+				bkpitems = append(bkpitems, item)
+			}
+			var got []string
+			for identifier := range b.selectSnapshotsToDelete(bkpitems, tt.recent) {
+				got = append(got, identifier)
+			}
+			sort.Strings(got)
+			if reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
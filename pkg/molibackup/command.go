@@ -0,0 +1,112 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gookit/slog"
+)
+
+// Error returned when an external program has failed, which carries what the program has written on stderr
+type CommandError struct {
+	program string
+	args    []string
+	stderr  string
+	err     error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command \"%s %s\" has failed: %v: %s", e.program, strings.Join(e.args, " "), e.err, e.stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.err
+}
+
+// Run an external program with additional environment variables and return what it has written on stdout
+func runCommand(program string, args []string, env map[string]string) ([]byte, error) {
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = &watchdogWriter{writer: &stdout}
+	cmd.Stderr = &watchdogWriter{writer: &stderr}
+	cmd.Env = os.Environ()
+	for key, val := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, val))
+	}
+
+	slog.Debugf("Running command: %s %s", program, strings.Join(args, " "))
+	err := cmd.Run()
+	watchdogActivity()
+	if err != nil {
+		return nil, &CommandError{program: program, args: args, stderr: strings.TrimSpace(stderr.String()), err: err}
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Run an external program with additional environment variables and stream what it writes on stdout to
+// the writer specified, so large outputs such as dumps are never held in memory
+func runCommandStream(program string, args []string, env map[string]string, output io.Writer) error {
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = &watchdogWriter{writer: output}
+	cmd.Stderr = &watchdogWriter{writer: &stderr}
+	cmd.Env = os.Environ()
+	for key, val := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, val))
+	}
+
+	slog.Debugf("Running command: %s %s", program, strings.Join(args, " "))
+	err := cmd.Run()
+	watchdogActivity()
+	if err != nil {
+		return &CommandError{program: program, args: args, stderr: strings.TrimSpace(stderr.String()), err: err}
+	}
+
+	return nil
+}
+
+// File written by an external program which is read by the caller, and which is removed with the temporary
+// directory or file which contains it when it is closed
+type commandOutputFile struct {
+	*os.File
+	cleanup string
+}
+
+// Open a file written by an external program so it can be streamed rather than read in memory, and return
+// it with its size. The path specified by cleanup is removed when the file is closed or when it fails to open.
+func openCommandOutput(path string, cleanup string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		os.RemoveAll(cleanup)
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		os.RemoveAll(cleanup)
+		return nil, 0, err
+	}
+	return &commandOutputFile{File: file, cleanup: cleanup}, info.Size(), nil
+}
+
+func (f *commandOutputFile) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.cleanup)
+	return err
+}
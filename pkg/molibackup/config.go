@@ -0,0 +1,799 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gookit/slog"
+
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+)
+
+// Structure of the whole configuration file
+type ProgramConfig struct {
+	Global  map[string]interface{} `koanf:"global"`
+	Jobsdef map[string]interface{} `koanf:"jobs"`
+}
+
+// Job attributes which are common to all job configs
+type JobMetaConfig struct {
+	Module      string `koanf:"module"`
+	Enabled     any    `koanf:"enabled"`
+	DryRun      bool   `koanf:"dryrun"`
+	Retention   int    `koanf:"retention"`
+	SnoozeUntil any    `koanf:"snooze_until"`
+}
+
+// Structures for rules to validate config entries
+type ConfigEntryValidation struct {
+	entryname  string
+	entrytype  string // Type of the value as printed by %T, or "list" for a list of any type
+	mandatory  bool
+	defaultval string
+	allowedval []string
+	replacedby string                 // Name of the entry replacing this deprecated entry
+	migrate    func(any) (any, error) // Conversion of the value of the deprecated entry (optional)
+}
+
+// Rules to validate the global section of the config file
+var validateConfigGlobal = []ConfigEntryValidation{
+	{
+		entryname:  "loglevel",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "info",
+		allowedval: []string{"error", "warn", "info", "debug"},
+	},
+	{
+		entryname:  "log_items_threshold",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "50",
+		allowedval: nil,
+	},
+	{
+		entryname:  "warn_uncovered_volumes",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "report_s3_bucket",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "report_s3_prefix",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "report_aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "report_max_age",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "48",
+		allowedval: nil,
+	},
+	{
+		entryname:  "allowed_accounts",
+		entrytype:  "list",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "allowed_regions",
+		entrytype:  "list",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "state_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "offline",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "config_strict",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "events_max_rate",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "20",
+		allowedval: nil,
+	},
+	{
+		entryname:  "emf_namespace",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "isolate_jobs",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "job_timeout",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "0",
+		allowedval: nil,
+	},
+	{
+		entryname:  "job_max_memory_mb",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "0",
+		allowedval: nil,
+	},
+	{
+		entryname:  "stall_timeout",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "0",
+		allowedval: nil,
+	},
+	{
+		entryname:  "api_budget",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "0",
+		allowedval: nil,
+	},
+	{
+		entryname:  "api_budget_create_share",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "50",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_retry_mode",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"standard", "adaptive"},
+	},
+	{
+		entryname:  "aws_max_attempts",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "0",
+		allowedval: nil,
+	},
+	{
+		entryname:  "interleave_phases",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+}
+
+// Prefix of the instance tags used to build the configuration when jobs are read from tags
+const configTagsPrefix = "molibackup:"
+const configTagsDefaultJob = "instance"
+
+var kconfig = koanf.New(".")
+var kparser = yaml.Parser()
+var progconfig ProgramConfig
+var jobmetadefs map[string]JobMetaConfig
+
+// Names of the jobs of all profiles which are kept once a profile has been applied so the garbage collection
+// does not mistake the files of the jobs of other profiles for orphans
+var configProfileJobs []string
+
+func readConfiguration(configfile string, profile string) error {
+
+	var configPaths []string
+	var err error
+
+	// Use the configuration file specified on the command line if requested
+	if configfile != "" {
+		configPaths = append(configPaths, configfile)
+	} else { // Search for the configuration file in the default locations
+		homedir, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get the user home directory: %w", err)
+		}
+		dircwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current working directory: %w", err)
+		}
+		pathexe, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to find path to executable: %w", err)
+		}
+		direxe := path.Dir(pathexe)
+
+		// List of paths where to try to find the configuration file
+		dirname := "molibackup"
+		filename := "molibackup.yaml"
+		configPaths = append(configPaths, path.Join(homedir, dirname, filename))
+		configPaths = append(configPaths, path.Join(dircwd, dirname, filename))
+		configPaths = append(configPaths, path.Join(direxe, dirname, filename))
+		if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+			configPaths = append(configPaths, path.Join("/etc", dirname, filename))
+		}
+	}
+
+	// Try to find a configuration file in the paths from the list
+	configPath := ""
+	for _, fullpath := range configPaths {
+		slog.Debugf("Attempting to find configuration in \"%s\"", fullpath)
+		_, err := os.Stat(fullpath)
+		if err == nil {
+			configPath = fullpath
+			break
+		}
+	}
+	if configPath == "" {
+		return fmt.Errorf("could not find the configuration file in any of the following locations: %s", strings.Join(configPaths, ","))
+	}
+	slog.Infof("Found configuration file in %s", configPath)
+
+	// Load configuration file together with the files it includes
+	fileconfig, err := configLoadFile(configPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration file %s: %w", configPath, err)
+	}
+	if err := kconfig.Merge(fileconfig); err != nil {
+		return fmt.Errorf("failed to merge configuration file %s: %v", configPath, err)
+	}
+
+	// Merge the profile requested on the command line on top of the rest of the configuration
+	if err := configApplyProfile(profile); err != nil {
+		return fmt.Errorf("failed to apply profile: %w", err)
+	}
+
+	return configProcess()
+}
+
+// Build the configuration from the tags of the EC2 instance running the program instead of a file
+func readConfigurationFromTags() error {
+
+	cfg, err := ProviderAwsLoadConfig("", "", "")
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration of the instance: %w", err)
+	}
+	region, err := ProviderAwsGetCurrentRegion(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to find the region of the instance: %w", err)
+	}
+	cfg.Region = region
+	instanceId, err := ProviderAwsGetCurrentInstance(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to find the identifier of the instance: %w", err)
+	}
+	instances, err := ProviderAwsGetEc2Instances(ProviderAwsNewEc2Client(cfg), instanceId, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read the tags of instance %s: %w", instanceId, err)
+	}
+	if len(instances) != 1 {
+		return fmt.Errorf("failed to find the tags of the local instance %s", instanceId)
+	}
+	slog.Infof("Reading configuration from the tags of instance %s in region %s", instanceId, region)
+
+	// Tags are either "molibackup:<option>" for the default job or "molibackup:<job>:<option>"
+	tagcount := 0
+	for tagkey, tagval := range instances[0].instanceTags {
+		if strings.HasPrefix(tagkey, configTagsPrefix) == false {
+			continue
+		}
+		name := strings.TrimPrefix(tagkey, configTagsPrefix)
+		section, option, found := strings.Cut(name, ":")
+		if found == false {
+			section, option = configTagsDefaultJob, name
+		}
+		if section == "" || option == "" || strings.Contains(section, ".") || strings.Contains(option, ".") {
+			return fmt.Errorf("tag \"%s\" of instance %s is not a valid configuration tag", tagkey, instanceId)
+		}
+		value := configTagValue(tagval)
+		keypath := fmt.Sprintf("jobs.%s.%s", section, option)
+		if section == "global" {
+			keypath = fmt.Sprintf("global.%s", option)
+		}
+		slog.Debugf("Setting config key with path=\"%s\" value=\"%v\" from tag \"%s\"", keypath, value, tagkey)
+		if err := kconfig.Set(keypath, value); err != nil {
+			return fmt.Errorf("failed to set config key at path %s: %v", keypath, err)
+		}
+		tagcount++
+	}
+	if tagcount == 0 {
+		return fmt.Errorf("instance %s has no tag starting with \"%s\"", instanceId, configTagsPrefix)
+	}
+
+	// Jobs back up the volumes of the local instance with snapshots unless the tags say otherwise
+	for _, jobname := range kconfig.MapKeys("jobs") {
+		jobpath := fmt.Sprintf("jobs.%s", jobname)
+		if kconfig.Exists(jobpath+".module") == false {
+			kconfig.Set(jobpath+".module", "ebs-snapshot")
+		}
+		if kconfig.String(jobpath+".module") == "ebs-snapshot" {
+			if kconfig.Exists(jobpath+".instance_id") == false {
+				kconfig.Set(jobpath+".instance_id", "local")
+			}
+			if kconfig.Exists(jobpath+".aws_region") == false {
+				kconfig.Set(jobpath+".aws_region", region)
+			}
+		}
+	}
+
+	return configProcess()
+}
+
+// Convert the value of a tag to the type it would have in yaml, where lists are separated with spaces
+// as the characters used for lists in yaml are not allowed in the values of EC2 tags
+func configTagValue(tagval string) any {
+	if number, err := strconv.Atoi(tagval); err == nil {
+		return number
+	}
+	if tagval == "true" || tagval == "false" {
+		return tagval == "true"
+	}
+	if fields := strings.Fields(tagval); len(fields) > 1 {
+		var values []any
+		for _, field := range fields {
+			values = append(values, field)
+		}
+		return values
+	}
+	return tagval
+}
+
+// Validate the configuration once it has been loaded and parse the sections of all jobs
+func configProcess() error {
+
+	var err error
+
+	if err := configProcessGlobal(); err != nil {
+		return fmt.Errorf("failed to process the global configuration: %w", err)
+	}
+
+	// Parse job specific sections of the config
+	jobmetadefs = make(map[string]JobMetaConfig)
+	for jobname := range progconfig.Jobsdef {
+		var jobconf JobMetaConfig
+		cfgpath := fmt.Sprintf("jobs.%s", jobname)
+		if err = kconfig.Unmarshal(cfgpath, &jobconf); err != nil {
+			return fmt.Errorf("failed to unmarshal path %s: %v", cfgpath, err)
+		}
+		jobmetadefs[jobname] = jobconf
+	}
+
+	// Make sure all job configuration sections have a "module" entry
+	validmods := moduleNames()
+	for jobname, jobconf := range jobmetadefs {
+		if jobconf.Module == "" {
+			return fmt.Errorf("the configuration section for job \"%s\" has no \"module\" entry", jobname)
+		}
+		if err := moduleUnavailableError(jobconf.Module); err != nil {
+			return fmt.Errorf("the configuration section for job \"%s\" cannot be used: %w", jobname, err)
+		}
+		if slices.Contains(validmods, jobconf.Module) == false {
+			// Jobs using modules from newer versions are ignored in permissive mode
+			if kconfig.Bool("global.config_strict") == false {
+				slog.Warnf("Ignoring job \"%s\" as module \"%s\" is not supported by this version", jobname, jobconf.Module)
+				delete(jobmetadefs, jobname)
+				continue
+			}
+			return fmt.Errorf("the configuration section for job \"%s\" has an invalid value \"%s\" for \"module\"", jobname, jobconf.Module)
+		}
+		if err := configValidateJobEntries(jobname, jobconf); err != nil {
+			return fmt.Errorf("failed to validate job \"%s\": %w", jobname, err)
+		}
+	}
+
+	if len(jobmetadefs) == 0 {
+		slog.Warnf("Have not found any job definition in the configuration, there is nothing to do")
+	}
+
+	return nil
+}
+
+// Validate the entries of a job which are handled by the program rather than by its module, which is shared
+// by the jobs of the configuration file and the jobs which are defined programmatically
+func configValidateJobEntries(jobname string, jobconf JobMetaConfig) error {
+
+	// Custom metadata would otherwise be silently ignored by modules which cannot store it with their backups
+	metamods := moduleNamesWithEntry("metadata")
+	if kconfig.Exists(fmt.Sprintf("jobs.%s.metadata", jobname)) == true && slices.Contains(metamods, jobconf.Module) == false {
+		return fmt.Errorf("the configuration section for job \"%s\" has a \"metadata\" entry which is not supported by module \"%s\" as it is only supported by modules %s",
+			jobname, jobconf.Module, strings.Join(metamods, ", "))
+	}
+	if _, err := configSnoozeUntil(jobconf.SnoozeUntil); err != nil {
+		return fmt.Errorf("the configuration section for job \"%s\" has an invalid value for \"snooze_until\": %v", jobname, err)
+	}
+
+	return nil
+}
+
+// Validate the global section of the configuration and parse the whole configuration, which is shared
+// by the configuration file, the tags of the instance, and the jobs which are defined programmatically
+func configProcessGlobal() error {
+
+	err := configValidateAndSetDefaults("global", validateConfigGlobal)
+	if err != nil {
+		return fmt.Errorf("failed to validate the global config section: %w", err)
+	}
+
+	// Account identifiers must be quoted as leading zeros are lost when they are parsed as numbers
+	for _, account := range configListToStrings(kconfig.Get("global.allowed_accounts")) {
+		matched, _ := regexp.MatchString("^[0-9]{12}$", account)
+		if matched == false {
+			return fmt.Errorf("global option \"allowed_accounts\" contains an invalid account \"%s\" which must be a quoted 12 digits number", account)
+		}
+	}
+
+	if kconfig.Int64("global.job_timeout") < 0 || kconfig.Int64("global.job_max_memory_mb") < 0 {
+		return fmt.Errorf("global options \"job_timeout\" and \"job_max_memory_mb\" must be valid numbers greater than or equal to 0")
+	}
+
+	if kconfig.Int64("global.stall_timeout") < 0 {
+		return fmt.Errorf("global option \"stall_timeout\" must be a valid number of minutes greater than or equal to 0")
+	}
+
+	if kconfig.Int64("global.api_budget") < 0 {
+		return fmt.Errorf("global option \"api_budget\" must be a valid number of calls per second greater than or equal to 0")
+	}
+	if share := kconfig.Int64("global.api_budget_create_share"); share < 1 || share > 99 {
+		return fmt.Errorf("global option \"api_budget_create_share\" must be a valid percentage between 1 and 99")
+	}
+
+	if kconfig.Int64("global.aws_max_attempts") < 0 {
+		return fmt.Errorf("global option \"aws_max_attempts\" must be a valid number of attempts greater than or equal to 0")
+	}
+
+	// Parse the whole configuration file, where maps of a configuration read previously must not be merged
+	progconfig = ProgramConfig{}
+	if err := kconfig.Unmarshal("", &progconfig); err != nil {
+		return fmt.Errorf("failed to unmarshal the configuration file: %v", err)
+	}
+
+	return nil
+}
+
+// Apply the global options which configure the state of the program shared by all jobs
+func configApplyGlobal() {
+	eventsSetMaxRate(kconfig.Int64("global.events_max_rate"))
+	apiBudgetSet(kconfig.Int64("global.api_budget"), kconfig.Int64("global.api_budget_create_share"))
+}
+
+// Load a configuration file after the files listed in its "include" entry so it can override them
+func configLoadFile(configPath string, parents []string) (*koanf.Koanf, error) {
+
+	abspath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path of configuration file %s: %v", configPath, err)
+	}
+	if slices.Contains(parents, abspath) == true {
+		return nil, fmt.Errorf("configuration file %s includes itself through %s", abspath, strings.Join(parents, " -> "))
+	}
+	parents = append(parents, abspath)
+
+	fileconfig := koanf.New(".")
+	if err := fileconfig.Load(file.Provider(abspath), kparser); err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %s: %v", abspath, err)
+	}
+
+	// Relative patterns are relative to the directory of the file which includes them
+	var includes []string
+	for _, pattern := range configListToStrings(fileconfig.Get("include")) {
+		if filepath.IsAbs(pattern) == false {
+			pattern = filepath.Join(filepath.Dir(abspath), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern \"%s\" in the include entry of %s: %v", pattern, abspath, err)
+		}
+		if len(matches) == 0 && strings.ContainsAny(pattern, "*?[") == false {
+			return nil, fmt.Errorf("file \"%s\" included by %s does not exist", pattern, abspath)
+		}
+		includes = append(includes, matches...)
+	}
+	fileconfig.Delete("include")
+
+	result := koanf.New(".")
+	jobfiles := make(map[string]string)
+	for _, incpath := range includes {
+		slog.Debugf("Including configuration file %s from %s", incpath, abspath)
+		incconfig, err := configLoadFile(incpath, parents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration file %s included by %s: %w", incpath, abspath, err)
+		}
+		// Jobs with the same name in two included files would be silently merged into a single job
+		for _, jobname := range incconfig.MapKeys("jobs") {
+			if other, found := jobfiles[jobname]; found == true {
+				return nil, fmt.Errorf("job \"%s\" is defined in both %s and %s", jobname, other, incpath)
+			}
+			jobfiles[jobname] = incpath
+		}
+		if err := result.Merge(incconfig); err != nil {
+			return nil, fmt.Errorf("failed to merge configuration file %s: %v", incpath, err)
+		}
+	}
+
+	if err := result.Merge(fileconfig); err != nil {
+		return nil, fmt.Errorf("failed to merge configuration file %s: %v", abspath, err)
+	}
+
+	return result, nil
+}
+
+// Merge the sections of a profile into the root of the configuration and discard all profiles
+func configApplyProfile(profile string) error {
+
+	if profile != "" {
+		profpath := fmt.Sprintf("profiles.%s", profile)
+		if kconfig.Exists(profpath) == false {
+			return fmt.Errorf("profile \"%s\" is not defined in the configuration", profile)
+		}
+		profconfig := kconfig.Cut(profpath)
+		for _, section := range profconfig.MapKeys("") {
+			if section != "global" && section != "jobs" {
+				return fmt.Errorf("profile \"%s\" has an invalid section \"%s\" as it must be either \"global\" or \"jobs\"", profile, section)
+			}
+		}
+		slog.Infof("Using configuration profile \"%s\"", profile)
+		if err := kconfig.Merge(profconfig); err != nil {
+			return fmt.Errorf("failed to merge profile \"%s\": %v", profile, err)
+		}
+	}
+
+	configProfileJobs = nil
+	for _, name := range kconfig.MapKeys("profiles") {
+		configProfileJobs = append(configProfileJobs, kconfig.MapKeys(fmt.Sprintf("profiles.%s.jobs", name))...)
+	}
+	kconfig.Delete("profiles")
+
+	return nil
+}
+
+// Process validation rules on a section of the configuration and use default values on entries having no value
+func configValidateAndSetDefaults(configpath string, validation []ConfigEntryValidation) error {
+
+	var knownEntries []string
+	var configmap = make(map[string]interface{})
+
+	err := kconfig.Unmarshal(configpath, &configmap)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", configpath, err)
+	}
+
+	// Replace deprecated entries with their replacement before the other rules are processed
+	if err := configMigrateDeprecated(configpath, validation, configmap); err != nil {
+		return fmt.Errorf("failed to migrate the deprecated entries of %s: %w", configpath, err)
+	}
+
+	// Make sure all validation rules are met for all entries in the map
+	for _, entry := range validation {
+		knownEntries = append(knownEntries, entry.entryname)
+		if entry.replacedby != "" {
+			continue
+		}
+		// Make sure the entry does not have invalid values and meets all conditions
+		entryval, hasentry := configmap[entry.entryname]
+		if hasentry == true {
+			// Make sure the entry has the right type, where lists can be decoded from yaml or set programmatically
+			typefound := fmt.Sprintf("%T", entryval)
+			if entryval != nil && reflect.TypeOf(entryval).Kind() == reflect.Slice {
+				typefound = "list"
+			}
+			if entry.entrytype != "" && typefound != entry.entrytype {
+				return fmt.Errorf("entry \"%s\" in path \"%s\" has the wrong type: found=%s expected=%s",
+					entry.entryname, configpath, typefound, entry.entrytype)
+			}
+			// Make sure the entry is set to a value which is allowed if there are restrictions
+			entryvalstr := fmt.Sprintf("%v", entryval)
+			if (entry.allowedval != nil) && (slices.Contains(entry.allowedval, entryvalstr) == false) {
+				return fmt.Errorf("value \"%v\" is invalid for configuration entry \"%s\" as it must be one of %v", entryval, entry.entryname, entry.allowedval)
+			}
+		} else {
+			if entry.mandatory == true {
+				return fmt.Errorf("configuration entry \"%s\" must be specified", entry.entryname)
+			} else {
+				if entry.defaultval != "" {
+					keypath := fmt.Sprintf("%s.%s", configpath, entry.entryname)
+					slog.Debugf("Setting default value for config key with path=\"%s\" value=\"%v\"", keypath, entry.defaultval)
+					err := kconfig.Set(keypath, entry.defaultval)
+					if err != nil {
+						return fmt.Errorf("failed to set default value for key at path %s: %v", keypath, err)
+					}
+				}
+			}
+		}
+	}
+
+	// Options which restrict the dry run to a single phase inherit the value of the dryrun option
+	if err := configSetDryRunDefaults(configpath, validation, configmap); err != nil {
+		return fmt.Errorf("failed to set the dryrun entries of %s: %w", configpath, err)
+	}
+
+	// Make sure all entries in the map are known entries unless the configuration is permissive
+	for key := range configmap {
+		if slices.Contains(knownEntries, key) == false {
+			if kconfig.Bool("global.config_strict") == false {
+				slog.Warnf("Ignoring entry \"%v\" in path \"%s\" as it is not a valid entry in this section of the configuration", key, configpath)
+				continue
+			}
+			return fmt.Errorf("entry \"%v\" is not a valid entry in this section of the configuration", key)
+		}
+	}
+
+	return nil
+}
+
+// Set the value of the "dryrun_create" and "dryrun_delete" entries to the value of "dryrun" when they are not specified
+func configSetDryRunDefaults(configpath string, validation []ConfigEntryValidation, configmap map[string]interface{}) error {
+
+	dryrun := kconfig.Bool(fmt.Sprintf("%s.dryrun", configpath))
+
+	for _, entry := range validation {
+		if entry.entryname != "dryrun_create" && entry.entryname != "dryrun_delete" {
+			continue
+		}
+		if _, hasentry := configmap[entry.entryname]; hasentry == true {
+			continue
+		}
+		keypath := fmt.Sprintf("%s.%s", configpath, entry.entryname)
+		slog.Debugf("Setting default value for config key with path=\"%s\" value=\"%v\"", keypath, dryrun)
+		if err := kconfig.Set(keypath, dryrun); err != nil {
+			return fmt.Errorf("failed to set default value for key at path %s: %v", keypath, err)
+		}
+	}
+
+	return nil
+}
+
+// Move the value of deprecated entries to the entries which replace them and warn about it
+func configMigrateDeprecated(configpath string, validation []ConfigEntryValidation, configmap map[string]interface{}) error {
+
+	for _, entry := range validation {
+		if entry.replacedby == "" {
+			continue
+		}
+		oldval, hasentry := configmap[entry.entryname]
+		if hasentry == false {
+			continue
+		}
+		slog.Warnf("Entry \"%s\" in path \"%s\" is deprecated and must be replaced with \"%s\"", entry.entryname, configpath, entry.replacedby)
+		if _, hasnew := configmap[entry.replacedby]; hasnew == true {
+			return fmt.Errorf("entries \"%s\" and \"%s\" in path \"%s\" cannot be used together as the former is deprecated", entry.entryname, entry.replacedby, configpath)
+		}
+		newval := oldval
+		if entry.migrate != nil {
+			var err error
+			if newval, err = entry.migrate(oldval); err != nil {
+				return fmt.Errorf("invalid value for deprecated entry \"%s\" in path \"%s\": %w", entry.entryname, configpath, err)
+			}
+		}
+		if err := kconfig.Set(fmt.Sprintf("%s.%s", configpath, entry.replacedby), newval); err != nil {
+			return fmt.Errorf("failed to set value for key at path %s.%s: %v", configpath, entry.replacedby, err)
+		}
+		kconfig.Delete(fmt.Sprintf("%s.%s", configpath, entry.entryname))
+		delete(configmap, entry.entryname)
+		configmap[entry.replacedby] = newval
+	}
+
+	return nil
+}
+
+// Convert a deprecated option made of a single "key=value" tag into a map of tags
+func configMigrateTagString(option any) (any, error) {
+
+	tagstr, ok := option.(string)
+	if ok == false {
+		return nil, fmt.Errorf("the value must be a string in the \"key=value\" format")
+	}
+
+	tagkey, tagval, found := strings.Cut(tagstr, "=")
+	if found == false || tagkey == "" {
+		return nil, fmt.Errorf("the value \"%s\" must be in the \"key=value\" format", tagstr)
+	}
+
+	return map[string]any{tagkey: tagval}, nil
+}
+
+// Convert an option made of tag names and values into a map of strings
+func configTagsToMap(option any) map[string]string {
+
+	results := make(map[string]string)
+
+	tags, ok := option.(map[string]any)
+	if ok == true {
+		for key, val := range tags {
+			results[key] = fmt.Sprintf("%v", val)
+		}
+	}
+
+	return results
+}
+
+// Convert the "metadata" option into a map where environment variables in values have been expanded
+// so information such as the version of an application can be captured at the time of the backup
+func configMetadataToMap(option any) (map[string]string, error) {
+
+	results := configTagsToMap(option)
+
+	for key, val := range results {
+		if metadataKeyValid(key) == false {
+			return nil, fmt.Errorf("Option \"metadata\" contains an invalid key: \"%s\"", key)
+		}
+		results[key] = os.ExpandEnv(val)
+		if len(results[key]) > 256 {
+			return nil, fmt.Errorf("Option \"metadata\" contains a value longer than 256 characters for key \"%s\"", key)
+		}
+	}
+
+	return results, nil
+}
+
+// Check that a key of custom metadata can be stored in the tags or the labels of all providers
+func metadataKeyValid(key string) bool {
+	matched, _ := regexp.MatchString("^[A-Za-z0-9_.:/=+@-]{1,100}$", key)
+	return matched
+}
+
+// Convert an option made of a list of values into a slice of strings
+func configListToStrings(option any) []string {
+
+	var results []string
+
+	switch values := option.(type) {
+	case []any:
+		for _, val := range values {
+			results = append(results, fmt.Sprintf("%v", val))
+		}
+	case []string:
+		results = append(results, values...)
+	}
+
+	return results
+}
@@ -0,0 +1,278 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Write the configuration files of a test in a temporary directory and return the path of the first one
+func testWriteConfig(t *testing.T, files map[string]string, mainfile string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write configuration file %s: %v", name, err)
+		}
+	}
+	return filepath.Join(dir, mainfile)
+}
+
+// Read a configuration file with a profile as the program does from an empty configuration
+func testReadConfig(t *testing.T, configfile string, profile string) error {
+	kconfig.Delete("")
+	t.Cleanup(func() { kconfig.Delete("") })
+	return readConfiguration(configfile, profile)
+}
+
+// Check that an error contains the message expected or that there is no error when none is expected
+func testCheckError(t *testing.T, err error, wantErr string) {
+	if wantErr == "" && err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wantErr != "" && (err == nil || strings.Contains(err.Error(), wantErr) == false) {
+		t.Fatalf("got error %v, want an error containing %q", err, wantErr)
+	}
+}
+
+func TestConfigMigrateDeprecated(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries string
+		want    any
+		wantErr string
+	}{
+		{
+			name:    "deprecated entry is migrated",
+			entries: "instance_tag: \"Env=prod\"",
+			want:    map[string]any{"Env": "prod"},
+		},
+		{
+			name:    "replacement entry is used as it is",
+			entries: "instance_tags:\n        Env: prod",
+			want:    map[string]any{"Env": "prod"},
+		},
+		{
+			name:    "deprecated entry with an invalid value",
+			entries: "instance_tag: \"prod\"",
+			wantErr: "invalid value for deprecated entry \"instance_tag\"",
+		},
+		{
+			name:    "deprecated entry used with its replacement",
+			entries: "instance_tag: \"Env=prod\"\n      instance_tags:\n        Env: dev",
+			wantErr: "cannot be used together",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configfile := testWriteConfig(t, map[string]string{"molibackup.yaml": `
+jobs:
+    job01:
+      module: ebs-snapshot
+      aws_region: eu-west-1
+      ` + tt.entries + `
+`}, "molibackup.yaml")
+			if err := testReadConfig(t, configfile, ""); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err := configValidateAndSetDefaults("jobs.job01", validateConfigEbsSnapshot)
+			testCheckError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := kconfig.Get("jobs.job01.instance_tags"); reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got instance_tags=%v, want %v", got, tt.want)
+			}
+			if kconfig.Exists("jobs.job01.instance_tag") == true {
+				t.Fatalf("deprecated entry has been kept")
+			}
+		})
+	}
+}
+
+func TestConfigStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  string
+		module  string
+		wantJob bool
+		wantErr string
+	}{
+		{"unknown entry in strict mode", "true", "ebs-snapshot", true, "entry \"unknown_entry\" is not a valid entry"},
+		{"unknown entry in permissive mode", "false", "ebs-snapshot", true, ""},
+		{"unknown module in strict mode", "true", "unknown-module", false, "invalid value \"unknown-module\" for \"module\""},
+		{"unknown module in permissive mode", "false", "unknown-module", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configfile := testWriteConfig(t, map[string]string{"molibackup.yaml": `
+global:
+  config_strict: ` + tt.strict + `
+jobs:
+    job01:
+      module: ` + tt.module + `
+      aws_region: eu-west-1
+      unknown_entry: value
+`}, "molibackup.yaml")
+			err := testReadConfig(t, configfile, "")
+			if tt.wantJob == true {
+				// Entries are checked by the module of the job when it loads its configuration
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				err = configValidateAndSetDefaults("jobs.job01", validateConfigEbsSnapshot)
+			}
+			testCheckError(t, err, tt.wantErr)
+			if _, found := jobmetadefs["job01"]; err == nil && found != tt.wantJob {
+				t.Fatalf("got job defined=%v, want %v", found, tt.wantJob)
+			}
+		})
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	configfile := testWriteConfig(t, map[string]string{"molibackup.yaml": `
+global:
+  loglevel: info
+  api_budget: 10
+jobs:
+    job01:
+      module: canary
+      aws_region: eu-west-1
+profiles:
+    staging:
+      global:
+        api_budget: 5
+      jobs:
+        job01:
+          aws_region: eu-central-1
+        job02:
+          module: canary
+    invalid:
+      defaults:
+        retention: 7
+`}, "molibackup.yaml")
+	tests := []struct {
+		name       string
+		profile    string
+		wantBudget int64
+		wantRegion string
+		wantJobs   []string
+		wantErr    string
+	}{
+		{"no profile", "", 10, "eu-west-1", []string{"job01"}, ""},
+		{"profile overrides global options and jobs and adds jobs", "staging", 5, "eu-central-1", []string{"job01", "job02"}, ""},
+		{"undefined profile", "production", 0, "", nil, "profile \"production\" is not defined"},
+		{"profile with an invalid section", "invalid", 0, "", nil, "invalid section \"defaults\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testReadConfig(t, configfile, tt.profile)
+			testCheckError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if got := kconfig.Int64("global.api_budget"); got != tt.wantBudget {
+				t.Fatalf("got api_budget=%d, want %d", got, tt.wantBudget)
+			}
+			if got := kconfig.String("jobs.job01.aws_region"); got != tt.wantRegion {
+				t.Fatalf("got aws_region=%q, want %q", got, tt.wantRegion)
+			}
+			// Entries which are not overridden by the profile are kept
+			if got := kconfig.String("global.loglevel"); got != "info" {
+				t.Fatalf("got loglevel=%q, want %q", got, "info")
+			}
+			var jobs []string
+			for jobname := range jobmetadefs {
+				jobs = append(jobs, jobname)
+			}
+			sort.Strings(jobs)
+			if reflect.DeepEqual(jobs, tt.wantJobs) == false {
+				t.Fatalf("got jobs %v, want %v", jobs, tt.wantJobs)
+			}
+			// Profiles are discarded once applied but the jobs of all profiles are known to the garbage collection
+			if kconfig.Exists("profiles") == true {
+				t.Fatalf("profiles have not been discarded")
+			}
+			if reflect.DeepEqual(configProfileJobs, []string{"job01", "job02"}) == false {
+				t.Fatalf("got jobs of profiles %v, want %v", configProfileJobs, []string{"job01", "job02"})
+			}
+		})
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		wantJobs []string
+		wantErr  string
+	}{
+		{
+			name: "jobs from included files and pattern matching no file",
+			files: map[string]string{
+				"molibackup.yaml": "include:\n  - \"conf.d/*.yaml\"\n  - other.yaml\njobs:\n  job01:\n    module: canary\n",
+				"other.yaml":      "jobs:\n  job02:\n    module: canary\n",
+			},
+			wantJobs: []string{"job01", "job02"},
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"molibackup.yaml": "include:\n  - missing.yaml\njobs:\n  job01:\n    module: canary\n",
+			},
+			wantErr: "missing.yaml\" included by",
+		},
+		{
+			name: "file including itself",
+			files: map[string]string{
+				"molibackup.yaml": "include:\n  - molibackup.yaml\n",
+			},
+			wantErr: "includes itself",
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"molibackup.yaml": "include:\n  - first.yaml\n",
+				"first.yaml":      "include:\n  - second.yaml\n",
+				"second.yaml":     "include:\n  - first.yaml\n",
+			},
+			wantErr: "includes itself",
+		},
+		{
+			name: "job defined in two included files",
+			files: map[string]string{
+				"molibackup.yaml": "include:\n  - first.yaml\n  - second.yaml\n",
+				"first.yaml":      "jobs:\n  job01:\n    module: canary\n",
+				"second.yaml":     "jobs:\n  job01:\n    module: canary\n",
+			},
+			wantErr: "job \"job01\" is defined in both",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configfile := testWriteConfig(t, tt.files, "molibackup.yaml")
+			err := testReadConfig(t, configfile, "")
+			testCheckError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			var jobs []string
+			for jobname := range jobmetadefs {
+				jobs = append(jobs, jobname)
+			}
+			sort.Strings(jobs)
+			if reflect.DeepEqual(jobs, tt.wantJobs) == false {
+				t.Fatalf("got jobs %v, want %v", jobs, tt.wantJobs)
+			}
+		})
+	}
+}
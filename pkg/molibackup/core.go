@@ -0,0 +1,472 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gookit/slog"
+)
+
+// Identifiers used to correlate logs, reports and resources created by an execution of the program
+var runId string
+var jobExecutionId string
+
+// Phases of jobs which are executed, all phases run unless a selection is specified on the command line
+var jobPhaseNames = []string{"create", "list", "delete"}
+var jobPhases = map[string]bool{"create": true, "list": true, "delete": true}
+
+// Custom metadata of the most recent backup of each job which has run so it can be reported
+var jobLatestMetadata = make(map[string]map[string]string)
+
+// Generate a random identifier for an execution of the program
+func NewRunId() string {
+	data := make([]byte, 6)
+	if _, err := io.ReadFull(randReader, data); err != nil {
+		return fmt.Sprintf("%x", clockNow().UnixNano())
+	}
+	return hex.EncodeToString(data)
+}
+
+// Return the most specific identifier of what is currently running so it can be included in logs
+func currentCorrelationId() string {
+	if jobExecutionId != "" {
+		return fmt.Sprintf("job=%s", jobExecutionId)
+	}
+	return fmt.Sprintf("run=%s", runId)
+}
+
+// Outbound integrations are connections to destinations other than the providers used by jobs
+// and they must all check this function so they can be disabled in air-gapped environments
+func outboundAllowed(integration string) bool {
+	if kconfig.Bool("global.offline") == true {
+		slog.Infof("Outbound integration \"%s\" is disabled as the program runs in offline mode", integration)
+		return false
+	}
+	return true
+}
+
+type BackupModule interface {
+	LoadConfiguration(jobname string) error
+	InitialiseModule() error
+	CreateBackup() error
+	ListBackups() ([]BackupItem, error)
+	DeleteOldBackups([]BackupItem) error
+}
+
+// Modules whose rotation depends on the number or the size of all their backups, such as keep_minimum or
+// max_storage_gb, implement this interface so they are given all backups instead of only those which are
+// old enough to be deleted. Backups in the protected set must be counted but they must never be deleted.
+type BackupProtectedDeleter interface {
+	DeleteOldBackupsExcept(bkpitems []BackupItem, protected map[string]bool) error
+}
+
+// Modules which can update the tags of the backups they manage implement this interface
+type BackupRetagger interface {
+	RetagBackups(bkpitems []BackupItem, tags map[string]string) error
+}
+
+// Modules which write archives encrypted with a key of their configuration implement this interface so
+// the archives can be encrypted again with a new key when the previous keys are compromised
+type BackupRekeyer interface {
+	RekeyBackups(bkpitems []BackupItem, oldkeys [][]byte) error
+}
+
+// Modules which can list their backups in independent batches implement this interface so the
+// backups of very large jobs are listed and rotated batch by batch rather than all at once
+type BackupStreamer interface {
+	StreamBackups(process func(bkpitems []BackupItem) error) error
+}
+
+// Modules whose backups can be listed and deleted while new backups are being created implement this
+// interface, so both phases of large jobs are interleaved rather than one waiting for the other.
+// CreateBackup then runs in its own goroutine concurrently with StreamBackups and with the calls to
+// DeleteOldBackups and RetagBackups made while backups are streamed. The fields set by LoadConfiguration and
+// InitialiseModule must only be read from then on, and any other state used by both sides must be protected.
+// InterleavePhases is called before both phases start and returns false when they must run one after the other.
+type BackupInterleaver interface {
+	BackupStreamer
+	InterleavePhases() bool
+}
+
+type BackupItem struct {
+	identifier  string
+	description string
+	timestamp   int64
+	size        int64             // Size in bytes when the module knows it, zero otherwise
+	metadata    map[string]string // Custom metadata defined in the job when the backup was created
+}
+
+// Summary of the actions performed on the items processed during a phase of a job
+type ProgressSummary struct {
+	verbose  bool
+	actions  []string
+	counters map[string]int
+}
+
+// Details about each item are only logged at the info level if there are not too many items
+func NewProgressSummary(itemcount int) *ProgressSummary {
+	return &ProgressSummary{
+		verbose:  itemcount <= kconfig.Int("global.log_items_threshold"),
+		counters: make(map[string]int),
+	}
+}
+
+// Count an action performed on an item and log the details about this item
+func (p *ProgressSummary) Itemf(action string, format string, args ...any) {
+	if _, ok := p.counters[action]; ok == false {
+		p.actions = append(p.actions, action)
+	}
+	p.counters[action]++
+	eventItemf(action, format, args...)
+	if p.verbose == true {
+		slog.Infof(format, args...)
+	} else {
+		slog.Debugf(format, args...)
+	}
+}
+
+// Log the number of items for each action which has been performed
+func (p *ProgressSummary) Logf(itemtype string) {
+	var parts []string
+	for _, action := range p.actions {
+		parts = append(parts, fmt.Sprintf("%s %d", action, p.counters[action]))
+	}
+	if len(parts) > 0 {
+		slog.Infof("Summary of %s: %s", itemtype, strings.Join(parts, ", "))
+	}
+}
+
+// Execute all enabled jobs in alphabetical order and return the number of jobs executed and failed
+func runAllJobs(report *RunReport) (jobcount int, errcount int) {
+
+	var jobnames []string
+
+	// Create list of jobs sorted alphabetically
+	for jobname := range jobmetadefs {
+		jobnames = append(jobnames, jobname)
+	}
+	sort.Strings(jobnames)
+
+	// Canary jobs run first so the other jobs which rely on AWS can be skipped when they have failed
+	sort.SliceStable(jobnames, func(i, j int) bool {
+		return jobmetadefs[jobnames[i]].Module == "canary" && jobmetadefs[jobnames[j]].Module != "canary"
+	})
+	var canaryJob string
+	var canaryError error
+
+	eventEmit(Event{Type: "run_started", JobCount: len(jobnames)}, false)
+
+	if err := stateLoad(); err != nil {
+		slog.Errorf("Failed to load the state file: %v", err)
+	}
+
+	for _, jobname := range jobnames {
+		jobconfig := jobmetadefs[jobname]
+		jobenabled := fmt.Sprintf("%v", jobconfig.Enabled)
+		if snoozeuntil, snoozed := jobSnoozedUntil(jobname); snoozed == true && jobenabled != "false" {
+			slog.Infof("Skipping job \"%s\" as it is snoozed until %v", jobname, snoozeuntil.Format(time.RFC3339))
+			report.AddJob(jobname, jobconfig.Module, "snoozed", nil)
+			eventEmit(Event{Type: "job_finished", Job: jobname, Module: jobconfig.Module, Status: "snoozed"}, false)
+		} else if jobenabled != "false" {
+			slog.Infof("Running job \"%s\" ...", jobname)
+			jobExecutionId = fmt.Sprintf("%s-%02d", runId, jobcount+1)
+			eventEmit(Event{Type: "job_started", Job: jobname, Module: jobconfig.Module}, false)
+			awsMetricsReset()
+			var err error
+			if canaryError != nil && jobUsesAws(jobname, jobconfig.Module) == true {
+				err = &EnvironmentError{canary: canaryJob, err: canaryError}
+			} else if kconfig.Bool("global.isolate_jobs") == true {
+				err = runJobIsolated(jobname)
+			} else {
+				err = watchdogRun(jobname, false, func() error { return runJob(jobname) })
+			}
+			if err != nil && jobconfig.Module == "canary" && canaryError == nil {
+				canaryJob, canaryError = jobname, err
+			}
+			if err != nil {
+				errcount++
+				slog.Errorf("Failed to execute job \"%s\": %v", jobname, err)
+				report.AddJob(jobname, jobconfig.Module, "failed", err)
+				code, resource := errorDetails(err)
+				eventEmit(Event{Type: "job_finished", Job: jobname, Module: jobconfig.Module, Status: "failed", Message: err.Error(),
+					ErrorCode: code, ErrorResource: resource}, false)
+			} else {
+				report.AddJob(jobname, jobconfig.Module, "success", nil)
+				eventEmit(Event{Type: "job_finished", Job: jobname, Module: jobconfig.Module, Status: "success"}, false)
+			}
+			report.AddJobUsage(jobname, stateJobUsage(jobname))
+			report.AddJobMetadata(jobname, jobLatestMetadata[jobname])
+			apicalls := awsMetricsSummary()
+			awsMetricsLogUsage(jobname, apicalls)
+			report.AddJobApiCalls(jobname, apicalls)
+			jobExecutionId = ""
+			jobcount++
+		} else {
+			slog.Infof("Skipping job \"%s\" as it is disabled in the configuration", jobname)
+			report.AddJob(jobname, jobconfig.Module, "disabled", nil)
+			eventEmit(Event{Type: "job_finished", Job: jobname, Module: jobconfig.Module, Status: "disabled"}, false)
+		}
+	}
+
+	for _, job := range report.Jobs {
+		stateRecordResult(job)
+	}
+	if err := stateSave(); err != nil {
+		slog.Errorf("Failed to save the state file: %v", err)
+	}
+
+	apiBudgetLogUsage()
+	faultInjectionLogUsage()
+
+	// Jobs which have failed for the same reason are reported together so a shared cause is obvious
+	failures := reportGroupFailures(report.Jobs)
+	reportLogFailureGroups(failures)
+
+	eventEmit(Event{Type: "run_finished", JobCount: jobcount, ErrCount: errcount, Failures: failures}, false)
+
+	return jobcount, errcount
+}
+
+func runJob(jobname string) (err error) {
+
+	var module BackupModule
+
+	// Recover from a panic in a backup module so it only causes this job to fail
+	defer func() {
+		if r := recover(); r != nil {
+			stack := string(debug.Stack())
+			slog.Errorf("Job \"%s\" has panicked: %v\n%s", jobname, r, stack)
+			err = &PanicError{value: r, stack: stack}
+		}
+	}()
+
+	jobconf, ok := jobmetadefs[jobname]
+	if ok == false {
+		return fmt.Errorf("configuration for job \"%s\" not found in the map", jobname)
+	}
+
+	moddef, ok := findModuleDefinition(jobconf.Module)
+	if ok == false {
+		return fmt.Errorf("invalid type of backup module: \"%s\"", jobconf.Module)
+	}
+	module = moddef.create()
+
+	// Load backup job configuration
+	err = module.LoadConfiguration(jobname)
+	if err != nil {
+		return fmt.Errorf("failed to load the job configuration: %w", err)
+	}
+
+	// Initialise the backup job
+	err = module.InitialiseModule()
+	if err != nil {
+		return fmt.Errorf("failed to initialise module \"%s\": %w", jobconf.Module, err)
+	}
+
+	// Creation and rotation share the API budget when they are interleaved
+	if interleaver, ok := module.(BackupInterleaver); ok == true && kconfig.Bool("global.interleave_phases") == true &&
+		jobPhases["create"] == true && jobPhases["delete"] == true && interleaver.InterleavePhases() == true {
+		return runJobInterleaved(jobname, module, interleaver)
+	}
+
+	// Create a new backup
+	if jobPhases["create"] == true {
+		err = module.CreateBackup()
+		if err != nil {
+			return fmt.Errorf("failed to create backup: %w", err)
+		}
+	} else {
+		slog.Infof("Skipping the creation of a new backup as phase \"create\" has not been selected")
+	}
+
+	// Backups must be listed to be deleted so the list phase is implied by the delete phase
+	if jobPhases["list"] == false && jobPhases["delete"] == false {
+		return nil
+	}
+
+	// Backups created recently are never deleted whatever their timestamp says about their age
+	minage := kconfig.Int64(fmt.Sprintf("jobs.%s.min_age_before_delete", jobname))
+	if minage < 0 {
+		return fmt.Errorf("Option \"min_age_before_delete\" must be a valid number of hours greater than or equal to 0")
+	}
+
+	if streamer, ok := module.(BackupStreamer); ok == true {
+		return runJobStreamed(jobname, module, streamer, minage)
+	}
+
+	// List existing backups
+	bkpitems, err := module.ListBackups()
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	// Keep track of the backups managed by the job to report their growth over time
+	stateRecordUsage(jobname, bkpitems)
+	jobLatestMetadata[jobname] = latestBackupMetadata(bkpitems)
+
+	if jobPhases["delete"] == false {
+		slog.Infof("Found %d backups and skipping their deletion as phase \"delete\" has not been selected", len(bkpitems))
+		return nil
+	}
+
+	// Delete backups older than retention period
+	err = deleteOldBackups(module, bkpitems, minage)
+	if err != nil {
+		return fmt.Errorf("failed to delete old backups: %w", err)
+	}
+
+	return nil
+}
+
+// Create a new backup in the background while the old backups are listed and deleted one batch at a time
+func runJobInterleaved(jobname string, module BackupModule, streamer BackupStreamer) error {
+
+	minage := kconfig.Int64(fmt.Sprintf("jobs.%s.min_age_before_delete", jobname))
+	if minage < 0 {
+		return fmt.Errorf("Option \"min_age_before_delete\" must be a valid number of hours greater than or equal to 0")
+	}
+
+	slog.Infof("Creating new backups while old backups are rotated as phases are interleaved")
+
+	created := make(chan error, 1)
+	go func() {
+		// A panic in this goroutine cannot be recovered by the caller so it is recovered here
+		defer func() {
+			if r := recover(); r != nil {
+				stack := string(debug.Stack())
+				slog.Errorf("Job \"%s\" has panicked: %v\n%s", jobname, r, stack)
+				created <- &PanicError{value: r, stack: stack}
+			}
+		}()
+		created <- module.CreateBackup()
+	}()
+
+	// Both phases must have finished before the job is considered as finished
+	rotateErr := runJobStreamed(jobname, module, streamer, minage)
+	if err := <-created; err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	if rotateErr != nil {
+		return fmt.Errorf("%w", rotateErr)
+	}
+
+	return nil
+}
+
+// Rotate the backups of a job one batch at a time so only the backups of the current batch are in memory
+func runJobStreamed(jobname string, module BackupModule, streamer BackupStreamer, minage int64) error {
+
+	var latest BackupItem
+
+	sample := StateUsageSample{Time: clockNow().UTC()}
+	if jobPhases["delete"] == false {
+		slog.Infof("Skipping the deletion of backups as phase \"delete\" has not been selected")
+	}
+
+	err := streamer.StreamBackups(func(bkpitems []BackupItem) error {
+		// Keep track of the usage and of the most recent metadata across all batches
+		sample.Count += len(bkpitems)
+		for _, item := range bkpitems {
+			sample.Size += item.size
+			if len(item.metadata) > 0 && (latest.metadata == nil || item.timestamp > latest.timestamp) {
+				latest = item
+			}
+		}
+		if jobPhases["delete"] == false {
+			return nil
+		}
+		return deleteOldBackups(module, bkpitems, minage)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list and delete backups: %w", err)
+	}
+
+	// Keep track of the backups managed by the job to report their growth over time
+	stateRecordSample(jobname, sample)
+	jobLatestMetadata[jobname] = latest.metadata
+
+	return nil
+}
+
+// Return the custom metadata of the most recent backup which has some
+func latestBackupMetadata(bkpitems []BackupItem) map[string]string {
+	var latest *BackupItem
+	for i := range bkpitems {
+		if len(bkpitems[i].metadata) > 0 && (latest == nil || bkpitems[i].timestamp > latest.timestamp) {
+			latest = &bkpitems[i]
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return latest.metadata
+}
+
+// Return the set of backups younger than the minimum age which must not be deleted
+func recentBackups(bkpitems []BackupItem, minage int64) map[string]bool {
+	results := make(map[string]bool)
+
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		// Timestamps in the future are also protected as they are caused by clock skew or invalid tags
+		backupAge := (curtime - item.timestamp) / 3600
+		if item.timestamp > curtime || backupAge < minage {
+			slog.Debugf("Protecting backup: id=\"%s\" age=%vh min_age_before_delete=%vh", item.identifier, backupAge, minage)
+			results[item.identifier] = true
+		}
+	}
+
+	return results
+}
+
+// Delete the old backups of a module without deleting recent backups. Modules which count their backups are
+// given all of them with the set of recent backups, and other modules are only given the backups they may delete.
+func deleteOldBackups(module BackupModule, bkpitems []BackupItem, minage int64) error {
+
+	protected := recentBackups(bkpitems, minage)
+	if deleter, ok := module.(BackupProtectedDeleter); ok == true {
+		return deleter.DeleteOldBackupsExcept(bkpitems, protected)
+	}
+
+	var deletable []BackupItem
+	for _, item := range bkpitems {
+		if protected[item.identifier] == false {
+			deletable = append(deletable, item)
+		}
+	}
+
+	return module.DeleteOldBackups(deletable)
+}
+
+// Select the phases of jobs which are executed from a comma separated list such as "create,list"
+func selectJobPhases(selection string) error {
+
+	phases := make(map[string]bool)
+	for _, phase := range strings.Split(selection, ",") {
+		phase = strings.TrimSpace(phase)
+		if slices.Contains(jobPhaseNames, phase) == false {
+			return fmt.Errorf("phase \"%s\" is invalid and it must be one of %v", phase, jobPhaseNames)
+		}
+		phases[phase] = true
+	}
+
+	for _, phase := range jobPhaseNames {
+		jobPhases[phase] = phases[phase]
+	}
+
+	return nil
+}
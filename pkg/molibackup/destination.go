@@ -0,0 +1,287 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/pkg/sftp"
+
+	"google.golang.org/api/storage/v1"
+)
+
+// Location where modules which export data to files store these files
+type BackupDestination interface {
+	WriteFile(name string, reader io.Reader) error
+	ReadFile(name string) ([]byte, error)
+	ListFiles() ([]string, error)
+	DeleteFile(name string) error
+	String() string
+}
+
+// Files stored in a directory of the local file system
+type destinationLocal struct {
+	directory string
+}
+
+// Objects stored in an S3 bucket under a prefix
+type destinationS3 struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// Objects stored in a Google Cloud Storage bucket under a prefix
+type destinationGcs struct {
+	service *storage.Service
+	bucket  string
+	prefix  string
+}
+
+// Files stored in a directory of a remote host accessed with SFTP
+type destinationSftp struct {
+	host      string
+	user      string
+	directory string
+	client    *sftp.Client
+}
+
+// Split an URL such as "s3://bucket/prefix" into a bucket name and a prefix ending with a slash
+func destinationBucketAndPrefix(location string, scheme string) (string, string, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, scheme), "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("destination \"%s\" has no bucket name", location)
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix = prefix + "/"
+	}
+	return bucket, prefix, nil
+}
+
+// Create a destination from either a local path or an URL such as "s3://bucket/prefix", "gs://bucket/prefix" or "sftp://user@host/path"
+func NewBackupDestination(location string, cfg aws.Config) (BackupDestination, error) {
+
+	if strings.HasPrefix(location, "s3://") {
+		bucket, prefix, err := destinationBucketAndPrefix(location, "s3://")
+		if err != nil {
+			return nil, err
+		}
+		return &destinationS3{client: ProviderAwsNewS3Client(cfg), bucket: bucket, prefix: prefix}, nil
+	}
+
+	// Google Cloud Storage always uses the application default credentials
+	if strings.HasPrefix(location, "gs://") {
+		bucket, prefix, err := destinationBucketAndPrefix(location, "gs://")
+		if err != nil {
+			return nil, err
+		}
+		service, err := ProviderGcpNewStorageService("")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the Cloud Storage client for destination \"%s\": %w", location, err)
+		}
+		return &destinationGcs{service: service, bucket: bucket, prefix: prefix}, nil
+	}
+
+	// SFTP uses the keys of the SSH agent or of the user, and the known hosts of the user
+	if strings.HasPrefix(location, "sftp://") {
+		return newDestinationSftp(location)
+	}
+
+	if strings.Contains(location, "://") {
+		return nil, fmt.Errorf("destination \"%s\" uses an unsupported scheme", location)
+	}
+	if filepath.IsAbs(location) == false {
+		return nil, fmt.Errorf("destination \"%s\" must be an absolute path", location)
+	}
+
+	return &destinationLocal{directory: filepath.Clean(location)}, nil
+}
+
+// Writer which counts the bytes written and remembers if the destination has stopped reading them
+type destinationCountingWriter struct {
+	writer io.Writer
+	size   int64
+	failed bool
+}
+
+func (w *destinationCountingWriter) Write(data []byte) (int, error) {
+	count, err := w.writer.Write(data)
+	w.size += int64(count)
+	if err != nil {
+		w.failed = true
+	}
+	return count, err
+}
+
+// Write a file with the data written by a function while the file is being written to the destination, so
+// files are never held in memory, and return the size of the file. The error of the function is returned
+// unless it was caused by the destination which stopped reading the data, in which case its error is returned.
+func destinationStreamFile(destination BackupDestination, name string, produce func(output io.Writer) error) (int64, error) {
+
+	reader, writer := io.Pipe()
+	counter := &destinationCountingWriter{writer: writer}
+	result := make(chan error, 1)
+
+	go func() {
+		err := produce(counter)
+		if err == nil {
+			writer.Close()
+		} else {
+			writer.CloseWithError(err)
+		}
+		result <- err
+	}()
+
+	writeerr := destination.WriteFile(name, reader)
+	// Unblock the function if the destination has failed before reading all the data
+	reader.Close()
+	produceerr := <-result
+
+	if produceerr != nil && (counter.failed == false || writeerr == nil) {
+		return 0, produceerr
+	}
+	if writeerr != nil {
+		return 0, writeerr
+	}
+	return counter.size, nil
+}
+
+func (d *destinationLocal) WriteFile(name string, reader io.Reader) error {
+	if err := os.MkdirAll(d.directory, 0700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", d.directory, err)
+	}
+	// Write to a temporary file first so an interrupted export does not leave a truncated file
+	fullpath := filepath.Join(d.directory, name)
+	tmppath := fullpath + ".tmp"
+	file, err := os.OpenFile(tmppath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %v", tmppath, err)
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		os.Remove(tmppath)
+		return fmt.Errorf("failed to write file %s: %v", tmppath, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmppath)
+		return fmt.Errorf("failed to write file %s: %v", tmppath, err)
+	}
+	if err := os.Rename(tmppath, fullpath); err != nil {
+		return fmt.Errorf("failed to rename file %s: %v", tmppath, err)
+	}
+	return nil
+}
+
+func (d *destinationLocal) ReadFile(name string) ([]byte, error) {
+	fullpath := filepath.Join(d.directory, name)
+	data, err := os.ReadFile(fullpath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %v", fullpath, err)
+	}
+	return data, nil
+}
+
+func (d *destinationLocal) ListFiles() ([]string, error) {
+	var results []string
+	entries, err := os.ReadDir(d.directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory %s: %v", d.directory, err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			results = append(results, entry.Name())
+		}
+	}
+	return results, nil
+}
+
+func (d *destinationLocal) DeleteFile(name string) error {
+	fullpath := filepath.Join(d.directory, name)
+	if err := os.Remove(fullpath); err != nil {
+		return fmt.Errorf("failed to delete file %s: %v", fullpath, err)
+	}
+	return nil
+}
+
+func (d *destinationLocal) String() string {
+	return d.directory
+}
+
+func (d *destinationS3) WriteFile(name string, reader io.Reader) error {
+	return ProviderAwsUploadS3Object(d.client, d.bucket, d.prefix+name, reader)
+}
+
+func (d *destinationS3) ReadFile(name string) ([]byte, error) {
+	return ProviderAwsGetS3Object(d.client, d.bucket, d.prefix+name)
+}
+
+func (d *destinationS3) ListFiles() ([]string, error) {
+	var results []string
+	objects, err := ProviderAwsListS3Objects(d.client, d.bucket, d.prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		// Objects stored under a deeper prefix are not part of this destination
+		name := strings.TrimPrefix(object.key, d.prefix)
+		if name != "" && strings.Contains(name, "/") == false {
+			results = append(results, name)
+		}
+	}
+	return results, nil
+}
+
+func (d *destinationS3) DeleteFile(name string) error {
+	return ProviderAwsDeleteS3Objects(d.client, d.bucket, []string{d.prefix + name})
+}
+
+func (d *destinationS3) String() string {
+	return fmt.Sprintf("s3://%s/%s", d.bucket, d.prefix)
+}
+
+func (d *destinationGcs) WriteFile(name string, reader io.Reader) error {
+	return ProviderGcpPutObject(d.service, d.bucket, d.prefix+name, reader)
+}
+
+func (d *destinationGcs) ReadFile(name string) ([]byte, error) {
+	return ProviderGcpGetObject(d.service, d.bucket, d.prefix+name)
+}
+
+func (d *destinationGcs) ListFiles() ([]string, error) {
+	var results []string
+	objects, err := ProviderGcpListObjects(d.service, d.bucket, d.prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		// Objects stored under a deeper prefix are not part of this destination
+		name := strings.TrimPrefix(object, d.prefix)
+		if name != "" && strings.Contains(name, "/") == false {
+			results = append(results, name)
+		}
+	}
+	return results, nil
+}
+
+func (d *destinationGcs) DeleteFile(name string) error {
+	return ProviderGcpDeleteObject(d.service, d.bucket, d.prefix+name)
+}
+
+func (d *destinationGcs) String() string {
+	return fmt.Sprintf("gs://%s/%s", d.bucket, d.prefix)
+}
@@ -0,0 +1,192 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Parse an URL such as "sftp://user@host:port/path" where the path is absolute on the remote host
+func newDestinationSftp(location string) (BackupDestination, error) {
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("destination \"%s\" is not a valid URL: %v", location, err)
+	}
+	if parsed.Hostname() == "" || parsed.Path == "" || parsed.Path == "/" {
+		return nil, fmt.Errorf("destination \"%s\" must include a host and a path such as \"sftp://user@host/path\"", location)
+	}
+
+	username := parsed.User.Username()
+	if username == "" {
+		curuser, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the current user: %v", err)
+		}
+		username = curuser.Username
+	}
+
+	host := parsed.Host
+	if parsed.Port() == "" {
+		host = net.JoinHostPort(parsed.Hostname(), "22")
+	}
+
+	return &destinationSftp{host: host, user: username, directory: path.Clean(parsed.Path)}, nil
+}
+
+// Connect to the remote host the first time the destination is used
+func (d *destinationSftp) connect() (*sftp.Client, error) {
+
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the user home directory: %v", err)
+	}
+	hostkeys, err := knownhosts.New(filepath.Join(homedir, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %v", err)
+	}
+
+	var signers []ssh.Signer
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			if agentsigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentsigners...)
+			}
+		}
+	}
+	for _, keyname := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(homedir, ".ssh", keyname))
+		if err != nil {
+			continue
+		}
+		// Keys protected by a passphrase can only be used through the agent
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no SSH key found in the agent or in %s", filepath.Join(homedir, ".ssh"))
+	}
+
+	sshconfig := &ssh.ClientConfig{
+		User:            d.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostkeys,
+	}
+	conn, err := ssh.Dial("tcp", d.host, sshconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", d.host, err)
+	}
+	d.client, err = sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP session on %s: %v", d.host, err)
+	}
+
+	return d.client, nil
+}
+
+func (d *destinationSftp) WriteFile(name string, reader io.Reader) error {
+	client, err := d.connect()
+	if err != nil {
+		return err
+	}
+	if err := client.MkdirAll(d.directory); err != nil {
+		return fmt.Errorf("failed to create directory %s on %s: %v", d.directory, d.host, err)
+	}
+	// Write to a temporary file first so an interrupted transfer does not leave a truncated file
+	fullpath := path.Join(d.directory, name)
+	tmppath := fullpath + ".tmp"
+	file, err := client.OpenFile(tmppath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s on %s: %v", tmppath, d.host, err)
+	}
+	if _, err := file.ReadFrom(reader); err != nil {
+		file.Close()
+		client.Remove(tmppath)
+		return fmt.Errorf("failed to write file %s on %s: %v", tmppath, d.host, err)
+	}
+	if err := file.Close(); err != nil {
+		client.Remove(tmppath)
+		return fmt.Errorf("failed to write file %s on %s: %v", tmppath, d.host, err)
+	}
+	if err := client.PosixRename(tmppath, fullpath); err != nil {
+		return fmt.Errorf("failed to rename file %s on %s: %v", tmppath, d.host, err)
+	}
+	return nil
+}
+
+func (d *destinationSftp) ReadFile(name string) ([]byte, error) {
+	client, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	fullpath := path.Join(d.directory, name)
+	file, err := client.Open(fullpath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s on %s: %v", fullpath, d.host, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s on %s: %v", fullpath, d.host, err)
+	}
+	return data, nil
+}
+
+func (d *destinationSftp) ListFiles() ([]string, error) {
+	var results []string
+	client, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := client.ReadDir(d.directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory %s on %s: %v", d.directory, d.host, err)
+	}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() {
+			results = append(results, entry.Name())
+		}
+	}
+	return results, nil
+}
+
+func (d *destinationSftp) DeleteFile(name string) error {
+	client, err := d.connect()
+	if err != nil {
+		return err
+	}
+	fullpath := path.Join(d.directory, name)
+	if err := client.Remove(fullpath); err != nil {
+		return fmt.Errorf("failed to delete file %s on %s: %v", fullpath, d.host, err)
+	}
+	return nil
+}
+
+func (d *destinationSftp) String() string {
+	return fmt.Sprintf("sftp://%s@%s%s", d.user, d.host, d.directory)
+}
@@ -0,0 +1,281 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// The documentation describes the effective configuration of each job, with the defaults of the options
+// which are not specified, and the results of its recent runs from the state file. It is generated as a
+// static page for auditors and on-call engineers who do not have access to the configuration file.
+// Values of options which hold secrets are redacted.
+
+// Description of a job as shown in the documentation
+type DocsJob struct {
+	Name        string           `json:"name"`
+	Module      string           `json:"module"`
+	Description string           `json:"description"`
+	Status      string           `json:"status"`
+	Retention   string           `json:"retention,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	Options     []DocsOption     `json:"options"`
+	Results     []StateJobResult `json:"results,omitempty"`
+}
+
+type DocsOption struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Default bool   `json:"default"`
+}
+
+// Options which hold secrets have one of these words in their name, unless they are the path to a file
+var docsSecretWords = []string{"secret", "password", "passphrase", "token"}
+
+const docsTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>molibackup on {{.Hostname}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; vertical-align: top; }
+.default { color: #888; }
+.failed { color: #b00; }
+.success { color: #070; }
+</style>
+</head>
+<body>
+<h1>molibackup on {{.Hostname}}</h1>
+<p>Version {{.Version}}, page generated on {{.Now}}.
+Jobs run each time the program is executed by the scheduler of the host.</p>
+<table>
+<tr><th>Job</th><th>Module</th><th>Status</th><th>Retention (days)</th><th>Latest result</th></tr>
+{{range .Jobs}}<tr><td><a href="#job-{{.Name}}">{{.Name}}</a></td><td>{{.Module}}</td><td>{{.Status}}</td><td>{{.Retention}}</td>
+<td>{{with latest .Results}}<span class="{{.Status}}">{{.Status}}</span> on {{.Time.Format "2006-01-02 15:04 MST"}}{{end}}</td></tr>
+{{end}}</table>
+{{range .Jobs}}
+<h2 id="job-{{.Name}}">{{.Name}}</h2>
+<p>{{.Description}}</p>
+{{if .Error}}<p class="failed">The configuration of this job is invalid: {{.Error}}</p>{{end}}
+<table>
+<tr><th>Option</th><th>Value</th></tr>
+{{range .Options}}<tr{{if .Default}} class="default"{{end}}><td>{{.Name}}</td><td>{{.Value}}{{if .Default}} (default){{end}}</td></tr>
+{{end}}</table>
+{{if .Results}}<table>
+<tr><th>Run</th><th>Job id</th><th>Status</th><th>Error</th></tr>
+{{range .Results}}<tr><td>{{.Time.Format "2006-01-02 15:04 MST"}}</td><td>{{.JobId}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{else}}<p>No result has been recorded for this job{{if not $.StateFile}} as the state file is not configured{{end}}.</p>{{end}}
+{{end}}
+</body>
+</html>
+`
+
+// Write the documentation of the jobs of the configuration to a file, as JSON when its name ends with
+// ".json" and as an HTML page otherwise. The file is only replaced once the documentation is complete.
+func docsGenerate(outpath string, version string) error {
+
+	var output bytes.Buffer
+	if err := docsRender(&output, strings.HasSuffix(outpath, ".json"), version); err != nil {
+		return fmt.Errorf("failed to render the documentation: %w", err)
+	}
+
+	tmppath := outpath + ".tmp"
+	if err := os.WriteFile(tmppath, output.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write the documentation to %s: %v", tmppath, err)
+	}
+	if err := os.Rename(tmppath, outpath); err != nil {
+		os.Remove(tmppath)
+		return fmt.Errorf("failed to rename %s to %s: %v", tmppath, outpath, err)
+	}
+
+	slog.Infof("Have written the documentation of the jobs to %s", outpath)
+
+	return nil
+}
+
+// Render the documentation of all jobs with the recent results of the state file
+func docsRender(output io.Writer, asjson bool, version string) error {
+
+	// Defaults are set in the configuration when jobs are validated
+	jobs := docsWithResults(docsDescribeJobs())
+
+	if asjson == true {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(jobs); err != nil {
+			return fmt.Errorf("failed to encode the documentation: %v", err)
+		}
+		return nil
+	}
+
+	page, err := template.New("docs").Funcs(template.FuncMap{
+		"latest": func(results []StateJobResult) *StateJobResult {
+			if len(results) == 0 {
+				return nil
+			}
+			return &results[len(results)-1]
+		},
+	}).Parse(docsTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse the template of the documentation: %v", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	data := struct {
+		Hostname  string
+		Version   string
+		Now       string
+		StateFile bool
+		Jobs      []DocsJob
+	}{hostname, version, time.Now().Format(time.RFC3339), kconfig.String("global.state_file") != "", jobs}
+	if err := page.Execute(output, data); err != nil {
+		return fmt.Errorf("failed to render the documentation: %v", err)
+	}
+
+	return nil
+}
+
+// Describe the effective configuration of all jobs sorted by name
+func docsDescribeJobs() []DocsJob {
+
+	var jobnames []string
+	for jobname := range jobmetadefs {
+		jobnames = append(jobnames, jobname)
+	}
+	sort.Strings(jobnames)
+
+	var results []DocsJob
+	for _, jobname := range jobnames {
+		results = append(results, docsDescribeJob(jobname))
+	}
+
+	return results
+}
+
+func docsDescribeJob(jobname string) DocsJob {
+
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+	job := DocsJob{Name: jobname, Module: jobmetadefs[jobname].Module}
+
+	moddef, found := findModuleDefinition(job.Module)
+	if found == false {
+		job.Error = fmt.Sprintf("module \"%s\" is not supported", job.Module)
+		return job
+	}
+	job.Description = moddef.description
+
+	// Options which are not in the original configuration get their default value during the validation
+	specified := kconfig.Cut(jobpath).Raw()
+	if err := configValidateAndSetDefaults(jobpath, moddef.validation); err != nil {
+		job.Error = err.Error()
+	}
+
+	for _, entry := range moddef.validation {
+		if entry.replacedby != "" || kconfig.Exists(fmt.Sprintf("%s.%s", jobpath, entry.entryname)) == false {
+			continue
+		}
+		value := kconfig.Get(fmt.Sprintf("%s.%s", jobpath, entry.entryname))
+		text := docsFormatValue(value)
+		if text == "" {
+			continue
+		}
+		_, isset := specified[entry.entryname]
+		job.Options = append(job.Options, DocsOption{Name: entry.entryname, Value: docsRedact(entry.entryname, text), Default: isset == false})
+	}
+
+	job.Status = "enabled"
+	if fmt.Sprintf("%v", kconfig.Get(jobpath+".enabled")) == "false" {
+		job.Status = "disabled"
+	}
+	if kconfig.Bool(jobpath+".dryrun") == true {
+		job.Status += ", dryrun"
+	}
+	if kconfig.Exists(jobpath + ".retention") {
+		job.Retention = docsFormatValue(kconfig.Get(jobpath + ".retention"))
+	}
+
+	return job
+}
+
+// Attach the recent results and the snoozes of the state file
+func docsWithResults(jobs []DocsJob) []DocsJob {
+
+	statefile := kconfig.String("global.state_file")
+	if statefile == "" {
+		return jobs
+	}
+	state, err := stateRead(statefile)
+	if err != nil {
+		slog.Warnf("Failed to read the state file for the documentation: %v", err)
+		return jobs
+	}
+
+	results := make([]DocsJob, len(jobs))
+	for i, job := range jobs {
+		results[i] = job
+		results[i].Results = state.Results[job.Name]
+		if snooze, found := state.Snoozes[job.Name]; found == true && clockNow().Before(snooze) == true {
+			results[i].Status += fmt.Sprintf(", snoozed until %s", snooze.Format(time.RFC3339))
+		}
+	}
+
+	return results
+}
+
+func docsFormatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []any, map[string]any:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Hide the values of options which hold secrets and the passwords included in URLs
+func docsRedact(name string, value string) string {
+
+	if strings.HasSuffix(name, "_file") == false && strings.HasSuffix(name, "_path") == false {
+		for _, word := range docsSecretWords {
+			if strings.Contains(name, word) == true {
+				return "(redacted)"
+			}
+		}
+	}
+
+	if parsed, err := url.Parse(value); err == nil && parsed.User != nil {
+		if _, hasPassword := parsed.User.Password(); hasPassword == true {
+			parsed.User = url.UserPassword(parsed.User.Username(), "redacted")
+			return parsed.String()
+		}
+	}
+
+	return value
+}
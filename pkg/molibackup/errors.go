@@ -0,0 +1,167 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/smithy-go"
+	"github.com/gophercloud/gophercloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/linode/linodego"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"google.golang.org/api/googleapi"
+)
+
+// Error returned when a request to the API of a provider has failed. It carries the code of the
+// error returned by the provider and the resource concerned, so the callers, the reports and the
+// events can act on the type of error, while errors.Is and errors.As still reach the original error.
+type ProviderError struct {
+	operation string
+	kind      string
+	resource  string
+	code      string
+	err       error
+}
+
+// Errors which carry a code and a resource implement this interface, including errors of jobs
+// which have been executed in a subprocess and which have been sent back to the parent process
+type codedError interface {
+	ErrorCode() string
+	ErrorResource() string
+}
+
+// Wrap the error returned by an operation on a resource of the kind specified such as "snapshot"
+func newProviderError(operation string, kind string, resource string, err error) error {
+	return &ProviderError{operation: operation, kind: kind, resource: resource, code: providerErrorCode(err), err: err}
+}
+
+func (e *ProviderError) Error() string {
+	if e.resource == "" {
+		return fmt.Sprintf("%s() has failed: %v", e.operation, e.err)
+	}
+	return fmt.Sprintf("%s() has failed for %s %s: %v", e.operation, e.kind, e.resource, e.err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.err
+}
+
+func (e *ProviderError) ErrorCode() string {
+	return e.code
+}
+
+func (e *ProviderError) ErrorResource() string {
+	return e.resource
+}
+
+// Return the code of the error returned by the API of a provider, or an empty string if it is unknown.
+// Providers which only return an HTTP status have this status as the code.
+func providerErrorCode(err error) string {
+
+	var awserr smithy.APIError
+	if errors.As(err, &awserr) == true {
+		return awserr.ErrorCode()
+	}
+	var gcperr *googleapi.Error
+	if errors.As(err, &gcperr) == true {
+		if len(gcperr.Errors) > 0 && gcperr.Errors[0].Reason != "" {
+			return gcperr.Errors[0].Reason
+		}
+		return strconv.Itoa(gcperr.Code)
+	}
+	var azerr *azcore.ResponseError
+	if errors.As(err, &azerr) == true {
+		return azerr.ErrorCode
+	}
+	var hcerr hcloud.Error
+	if errors.As(err, &hcerr) == true {
+		return string(hcerr.Code)
+	}
+	var lnerr *linodego.Error
+	if errors.As(err, &lnerr) == true {
+		return strconv.Itoa(lnerr.Code)
+	}
+	var ocierr common.ServiceError
+	if errors.As(err, &ocierr) == true {
+		return ocierr.GetCode()
+	}
+	var oserr gophercloud.StatusCodeError
+	if errors.As(err, &oserr) == true {
+		return strconv.Itoa(oserr.GetStatusCode())
+	}
+
+	return ""
+}
+
+// Error returned when a backup module has panicked, with the stack of the goroutine at the time of the panic
+type PanicError struct {
+	value any
+	stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("unexpected panic in module: %v", e.value)
+}
+
+func (e *PanicError) ErrorStack() string {
+	return e.stack
+}
+
+// Return the stack of the first error in the chain which carries one, or an empty string otherwise
+func errorStack(err error) string {
+	var stacked interface{ ErrorStack() string }
+	if errors.As(err, &stacked) == true {
+		return stacked.ErrorStack()
+	}
+	return ""
+}
+
+// Return the code and the resource of the first error in the chain which carries them
+func errorDetails(err error) (string, string) {
+	var coded codedError
+	if errors.As(err, &coded) == true {
+		return coded.ErrorCode(), coded.ErrorResource()
+	}
+	return "", ""
+}
+
+// Return a short description of the cause of an error so jobs which have failed for the same reason can be
+// grouped. It is the code returned by the provider when it is known, what an external program has written
+// on stderr as command lines are specific to each job, or the message of the innermost error.
+func errorCause(err error) string {
+	// Errors of jobs executed in subprocesses carry the cause of the original error
+	var caused interface{ ErrorCause() string }
+	if errors.As(err, &caused) == true && caused.ErrorCause() != "" {
+		return caused.ErrorCause()
+	}
+	if code, _ := errorDetails(err); code != "" {
+		return code
+	}
+	var cmderr *CommandError
+	if errors.As(err, &cmderr) == true && cmderr.stderr != "" {
+		return cmderr.stderr
+	}
+	for errors.Unwrap(err) != nil {
+		err = errors.Unwrap(err)
+	}
+	return err.Error()
+}
+
+// Check if an error has been returned by a provider with any of the codes specified
+func errorHasCode(err error, codes ...string) bool {
+	code, _ := errorDetails(err)
+	for _, curcode := range codes {
+		if code != "" && code == curcode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Events are written as newline-delimited JSON to a file descriptor or a unix socket provided by
+// an orchestrator which wraps the program, so it can show the progress of jobs in real time.
+// Events about items are dropped when they exceed the rate limit, but events about runs, jobs
+// and errors are always written.
+
+// Structure of an event written to the stream
+type Event struct {
+	Time          time.Time               `json:"time"`
+	Type          string                  `json:"type"`
+	RunId         string                  `json:"run_id"`
+	JobId         string                  `json:"job_id,omitempty"`
+	Job           string                  `json:"job,omitempty"`
+	Module        string                  `json:"module,omitempty"`
+	Action        string                  `json:"action,omitempty"`
+	Status        string                  `json:"status,omitempty"`
+	Message       string                  `json:"message,omitempty"`
+	ErrorCode     string                  `json:"error_code,omitempty"`
+	ErrorResource string                  `json:"error_resource,omitempty"`
+	JobCount      int                     `json:"job_count,omitempty"`
+	ErrCount      int                     `json:"error_count,omitempty"`
+	Dropped       int                     `json:"dropped,omitempty"`
+	Failures      []RunReportFailureGroup `json:"failures,omitempty"`
+}
+
+// Destination of the stream and state of the rate limiter
+var eventsWriter io.WriteCloser
+var eventsFile *os.File // Set when the stream is a file descriptor so it can be passed to subprocesses
+var eventsMutex sync.Mutex
+var eventsMaxRate float64
+var eventsTokens float64
+var eventsLastRefill time.Time
+var eventsDropped int
+
+// Write events to a file descriptor which has been opened by the parent process
+func eventsOpenFd(fd int) error {
+	file := os.NewFile(uintptr(fd), fmt.Sprintf("events-fd-%d", fd))
+	if file == nil {
+		return fmt.Errorf("file descriptor %d is not valid", fd)
+	}
+	if _, err := file.Stat(); err != nil {
+		return fmt.Errorf("file descriptor %d is not open: %v", fd, err)
+	}
+	eventsWriter = file
+	eventsFile = file
+	return nil
+}
+
+// Write events to a unix socket on which the orchestrator is listening
+func eventsOpenSocket(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to connect to socket %s: %v", path, err)
+	}
+	eventsWriter = conn
+	return nil
+}
+
+// Set the maximum number of events about items which can be written per second
+func eventsSetMaxRate(rate int64) {
+	eventsMaxRate = float64(rate)
+	eventsTokens = eventsMaxRate
+	eventsLastRefill = time.Now()
+}
+
+// Write an event to the stream if one has been opened, events which can be dropped are subject to the rate limit
+func eventEmit(event Event, droppable bool) {
+
+	// The stream has been disabled when the error is logged so it is not written to the stream again
+	if err := eventWrite(event, droppable); err != nil {
+		slog.Warnf("Have stopped writing events as the stream has failed: %v", err)
+	}
+}
+
+func eventWrite(event Event, droppable bool) error {
+
+	eventsMutex.Lock()
+	defer eventsMutex.Unlock()
+
+	if eventsWriter == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if droppable == true && eventsMaxRate > 0 {
+		eventsTokens += now.Sub(eventsLastRefill).Seconds() * eventsMaxRate
+		if eventsTokens > eventsMaxRate {
+			eventsTokens = eventsMaxRate
+		}
+		eventsLastRefill = now
+		if eventsTokens < 1 {
+			eventsDropped++
+			return nil
+		}
+		eventsTokens--
+	}
+
+	// The orchestrator is told how many events it has missed before it gets the next one
+	events := []Event{}
+	if eventsDropped > 0 {
+		events = append(events, Event{Type: "events_dropped", Dropped: eventsDropped})
+		eventsDropped = 0
+	}
+	events = append(events, event)
+
+	for _, curevent := range events {
+		curevent.Time = now.UTC()
+		curevent.RunId = runId
+		if curevent.JobId == "" {
+			curevent.JobId = jobExecutionId
+		}
+		data, err := json.Marshal(curevent)
+		if err != nil {
+			return err
+		}
+		if _, err := eventsWriter.Write(append(data, '\n')); err != nil {
+			eventsWriter.Close()
+			eventsWriter = nil
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write an event about an action performed on an item such as the creation or the deletion of a backup
+func eventItemf(action string, format string, args ...any) {
+	watchdogActivity()
+	eventEmit(Event{Type: "item", Action: action, Message: fmt.Sprintf(format, args...)}, true)
+}
+
+// Log records which are warnings or errors are also written to the stream. Processors are called
+// while the logger is locked so a failure of the stream is reported without using the logger.
+func eventsLogProcessor(record *slog.Record) {
+	var err error
+	switch record.Level {
+	case slog.ErrorLevel:
+		err = eventWrite(Event{Type: "error", Message: record.Message}, false)
+	case slog.WarnLevel:
+		err = eventWrite(Event{Type: "warning", Message: record.Message}, false)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Have stopped writing events as the stream has failed: %v\n", err)
+	}
+}
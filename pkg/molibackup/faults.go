@@ -0,0 +1,171 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gookit/slog"
+)
+
+// Fault injection makes a share of the calls to the AWS API fail with errors or timeouts which have not
+// been returned by AWS, so users can rehearse how their alerting and the retries behave when AWS has an
+// outage. Faults are injected after the retry middleware of the SDK, so each attempt can fail and the
+// calls only fail when all attempts have failed, as it happens when the API is actually degraded.
+
+// Settings of the faults to inject, which are disabled when both rates are zero
+var faultInjectionMutex sync.Mutex
+var faultInjectionErrorRate float64
+var faultInjectionTimeoutRate float64
+var faultInjectionDelay = 5 * time.Second
+var faultInjectionRandom *rand.Rand
+var faultInjectionCounts = make(map[string]int)
+
+// Error returned instead of the response of AWS when a fault is injected
+type faultInjectionError struct {
+	code      string
+	operation string
+}
+
+func (e *faultInjectionError) Error() string {
+	return fmt.Sprintf("%s: fault injected in %s", e.code, e.operation)
+}
+
+func (e *faultInjectionError) ErrorCode() string {
+	return e.code
+}
+
+func (e *faultInjectionError) ErrorMessage() string {
+	return fmt.Sprintf("fault injected in %s", e.operation)
+}
+
+func (e *faultInjectionError) ErrorFault() smithy.ErrorFault {
+	return smithy.FaultServer
+}
+
+// Injected faults are retried by the SDK like the transient errors they simulate
+func (e *faultInjectionError) RetryableError() bool {
+	return true
+}
+
+func (e *faultInjectionError) Timeout() bool {
+	return e.code == "InjectedTimeout"
+}
+
+// Parse the specification of the faults to inject such as "error=0.1,timeout=0.05,delay=10s,seed=42"
+// where the rates are the probabilities of each attempt to fail with an error or with a timeout
+func faultInjectionSet(spec string) error {
+
+	faultInjectionMutex.Lock()
+	defer faultInjectionMutex.Unlock()
+
+	// The default seed comes from the source of randomness so tests and simulations can replace it
+	var random [8]byte
+	if _, err := io.ReadFull(randReader, random[:]); err != nil {
+		return fmt.Errorf("failed to generate the seed of the faults: %v", err)
+	}
+	seed := int64(binary.LittleEndian.Uint64(random[:]))
+	for _, item := range strings.Split(spec, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(item), "=")
+		if found == false {
+			return fmt.Errorf("invalid item \"%s\" which must be in the key=value format", item)
+		}
+		var err error
+		switch key {
+		case "error":
+			faultInjectionErrorRate, err = strconv.ParseFloat(value, 64)
+		case "timeout":
+			faultInjectionTimeoutRate, err = strconv.ParseFloat(value, 64)
+		case "delay":
+			faultInjectionDelay, err = time.ParseDuration(value)
+		case "seed":
+			seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return fmt.Errorf("invalid key \"%s\" which must be one of error, timeout, delay and seed", key)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value \"%s\" for \"%s\": %v", value, key, err)
+		}
+	}
+
+	if faultInjectionErrorRate < 0 || faultInjectionTimeoutRate < 0 || faultInjectionErrorRate+faultInjectionTimeoutRate > 1 {
+		return fmt.Errorf("rates must be positive and their sum must not be greater than 1")
+	}
+	if faultInjectionDelay < 0 {
+		return fmt.Errorf("delay must be positive")
+	}
+	faultInjectionRandom = rand.New(rand.NewSource(seed))
+
+	return nil
+}
+
+// Check if faults have to be injected in the calls to the AWS API
+func faultInjectionEnabled() bool {
+	faultInjectionMutex.Lock()
+	defer faultInjectionMutex.Unlock()
+	return faultInjectionErrorRate > 0 || faultInjectionTimeoutRate > 0
+}
+
+// Return the code of the fault to inject in the next attempt, or an empty string to let it reach AWS
+func faultInjectionNext() string {
+	faultInjectionMutex.Lock()
+	defer faultInjectionMutex.Unlock()
+	draw := faultInjectionRandom.Float64()
+	if draw < faultInjectionErrorRate {
+		faultInjectionCounts["InjectedError"]++
+		return "InjectedError"
+	}
+	if draw < faultInjectionErrorRate+faultInjectionTimeoutRate {
+		faultInjectionCounts["InjectedTimeout"]++
+		return "InjectedTimeout"
+	}
+	return ""
+}
+
+// Add the middleware which injects faults to the stack of each operation of the AWS clients
+func faultInjectionAwsMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("FaultInjection",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			code := faultInjectionNext()
+			if code == "" {
+				return next.HandleFinalize(ctx, in)
+			}
+			operation := fmt.Sprintf("%s:%s", awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx))
+			slog.Warnf("Fault injection: failing attempt of %s with %s", operation, code)
+			// Timeouts hold the attempt for the delay as a request which does not get any response would do
+			if code == "InjectedTimeout" {
+				select {
+				case <-time.After(faultInjectionDelay):
+				case <-ctx.Done():
+					return middleware.FinalizeOutput{}, middleware.Metadata{}, ctx.Err()
+				}
+			}
+			return middleware.FinalizeOutput{}, middleware.Metadata{}, &faultInjectionError{code: code, operation: operation}
+		}), middleware.After)
+}
+
+// Log the number of faults injected so they can be compared with the alerts which have been raised
+func faultInjectionLogUsage() {
+	faultInjectionMutex.Lock()
+	defer faultInjectionMutex.Unlock()
+	if faultInjectionErrorRate <= 0 && faultInjectionTimeoutRate <= 0 {
+		return
+	}
+	slog.Warnf("Fault injection: %d errors and %d timeouts have been injected in calls to the AWS API",
+		faultInjectionCounts["InjectedError"], faultInjectionCounts["InjectedTimeout"])
+}
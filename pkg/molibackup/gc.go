@@ -0,0 +1,281 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gookit/slog"
+)
+
+// Jobs only delete the files they recognise, so files of jobs which have been renamed or removed and
+// temporary files left by interrupted uploads stay in destinations forever. The garbage collection finds
+// these orphans in the destinations of all jobs of the configuration. Only files which have been written
+// by this program are considered, based on the prefix of their name, and they must contain a date which
+// is older than the minimum age so files which are being written are never deleted. Destinations can be
+// shared with other profiles and other hosts, so a file is only deleted when the state file proves that
+// it belongs to a job of this host which has been removed, and nothing is deleted in a destination which
+// has files that cannot be attributed to a job of this host.
+
+// Minimum age of the date in the name of a file before it can be deleted as an orphan
+const gcMinAge = 7 * 24 * time.Hour
+
+// Dates included in the names of the files written by modules
+var gcDateRegexp = regexp.MustCompile(`[0-9]{8}-[0-9]{6}`)
+
+// Destination shared by one or several jobs with the prefixes of the files of these jobs
+type gcDestination struct {
+	destination BackupDestination
+	jobnames    []string
+	prefixes    []string
+}
+
+// Names of the jobs defined in any profile of the configuration, and of the jobs which have run on this
+// host according to the state file but which are no longer defined, whose files are orphans
+type gcOwnership struct {
+	configured []string
+	removed    []string
+}
+
+// Find the files of all destinations which do not belong to any job of the configuration and delete them
+// when requested. Orphans are only listed by default so they can be reviewed before they get deleted.
+func gcDestinations(deleteOrphans bool) error {
+
+	destinations, err := gcFindDestinations()
+	if err != nil {
+		return err
+	}
+	ownership, err := gcFindOwnership()
+	if err != nil {
+		return err
+	}
+
+	// Prefixes of all modules so files which have not been written by this program are ignored
+	var kinds []string
+	for _, moddef := range moduleDefinitions {
+		if moddef.fileprefix != "" {
+			kinds = append(kinds, moddef.fileprefix+"-")
+		}
+	}
+
+	var locations []string
+	for location := range destinations {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+
+	errcount := 0
+	for _, location := range locations {
+		if err := gcDestinationOrphans(destinations[location], kinds, ownership, deleteOrphans); err != nil {
+			slog.Errorf("Failed to collect orphans in \"%s\": %v", location, err)
+			errcount++
+		}
+	}
+	if errcount > 0 {
+		return fmt.Errorf("failed to collect orphans in %d destinations out of %d", errcount, len(locations))
+	}
+
+	return nil
+}
+
+// Return the destinations of all jobs whose module writes files, including disabled jobs whose files must be
+// kept. The garbage collection stops if the destination of a job cannot be determined as its files could be
+// mistaken for orphans.
+func gcFindDestinations() (map[string]*gcDestination, error) {
+
+	var jobnames []string
+	for jobname := range jobmetadefs {
+		jobnames = append(jobnames, jobname)
+	}
+	sort.Strings(jobnames)
+
+	results := make(map[string]*gcDestination)
+	for _, jobname := range jobnames {
+		moddef, found := findModuleDefinition(jobmetadefs[jobname].Module)
+		if found == false {
+			return nil, fmt.Errorf("module \"%s\" of job \"%s\" is not supported", jobmetadefs[jobname].Module, jobname)
+		}
+		if moddef.fileprefix == "" {
+			continue
+		}
+		jobpath := fmt.Sprintf("jobs.%s", jobname)
+		if err := configValidateAndSetDefaults(jobpath, moddef.validation); err != nil {
+			return nil, fmt.Errorf("configuration of job \"%s\" is invalid: %w", jobname, err)
+		}
+		location := kconfig.String(jobpath + ".destination")
+		if location == "" {
+			continue
+		}
+
+		// The AWS configuration is only used when the destination is an S3 bucket
+		var cfg aws.Config
+		if strings.HasPrefix(location, "s3://") {
+			var err error
+			cfg, err = ProviderAwsLoadConfig(kconfig.String(jobpath+".aws_region"), kconfig.String(jobpath+".accesskey_id"), kconfig.String(jobpath+".accesskey_secret"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to load the AWS configuration of job \"%s\": %w", jobname, err)
+			}
+		}
+		destination, err := NewBackupDestination(location, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination for job \"%s\": %w", jobname, err)
+		}
+
+		// Jobs which write to the same location share the destination even if it is written differently
+		key := destination.String()
+		if _, found := results[key]; found == false {
+			results[key] = &gcDestination{destination: destination}
+		}
+		results[key].jobnames = append(results[key].jobnames, jobname)
+		results[key].prefixes = append(results[key].prefixes, fmt.Sprintf("%s-%s-", moddef.fileprefix, jobname))
+	}
+
+	return results, nil
+}
+
+// Return the jobs of all profiles and the jobs which have been removed according to the state file. Without
+// a state file no job can be proven to have been removed so no file can be deleted.
+func gcFindOwnership() (gcOwnership, error) {
+
+	var result gcOwnership
+	configured := make(map[string]bool)
+	for jobname := range jobmetadefs {
+		configured[jobname] = true
+	}
+	for _, jobname := range configProfileJobs {
+		configured[jobname] = true
+	}
+	for jobname := range configured {
+		result.configured = append(result.configured, jobname)
+	}
+	sort.Strings(result.configured)
+
+	statefile := kconfig.String("global.state_file")
+	if statefile == "" {
+		slog.Warnf("No orphan can be deleted as the state file which records the jobs of this host is not configured")
+		return result, nil
+	}
+	state, err := stateRead(statefile)
+	if err != nil {
+		return result, fmt.Errorf("failed to read the state file %s which records the jobs of this host: %w", statefile, err)
+	}
+	removed := make(map[string]bool)
+	for jobname := range state.Jobs {
+		removed[jobname] = true
+	}
+	for jobname := range state.Results {
+		removed[jobname] = true
+	}
+	for jobname := range removed {
+		if configured[jobname] == false {
+			result.removed = append(result.removed, jobname)
+		}
+	}
+	sort.Strings(result.removed)
+
+	return result, nil
+}
+
+// Check if the name of a file starts with the prefix of a module followed by the name of one of the jobs
+func gcMatchJobs(filename string, kinds []string, jobnames []string) bool {
+	for _, kind := range kinds {
+		for _, jobname := range jobnames {
+			if strings.HasPrefix(filename, fmt.Sprintf("%s%s-", kind, jobname)) == true {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func gcDestinationOrphans(dest *gcDestination, kinds []string, ownership gcOwnership, deleteOrphans bool) error {
+
+	slog.Infof("Looking for orphans in \"%s\" used by jobs %s ...", dest.destination, strings.Join(dest.jobnames, ", "))
+	filenames, err := dest.destination.ListFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list the files of %s: %w", dest.destination, err)
+	}
+	sort.Strings(filenames)
+
+	// Files are aged with the real time even when a run at another time is simulated
+	curtime := time.Now()
+	progress := NewProgressSummary(len(filenames))
+	var orphans []string
+	var unattributed []string
+	for _, filename := range filenames {
+		// Temporary files are renamed once they have been written so they are orphans if their job is known
+		owned := false
+		for _, prefix := range dest.prefixes {
+			if strings.HasPrefix(filename, prefix) == true && strings.HasSuffix(filename, ".tmp") == false {
+				owned = true
+			}
+		}
+		known := false
+		for _, kind := range kinds {
+			if strings.HasPrefix(filename, kind) == true {
+				known = true
+			}
+		}
+		if owned == true || known == false {
+			continue
+		}
+
+		// Files of other jobs of any profile are kept, and only the files of jobs which have been removed are orphans
+		tmpowned := false
+		for _, prefix := range dest.prefixes {
+			if strings.HasPrefix(filename, prefix) == true {
+				tmpowned = true
+			}
+		}
+		if tmpowned == false && gcMatchJobs(filename, kinds, ownership.configured) == true {
+			progress.Itemf("kept", "Keeping \"%s\" as it belongs to another job of the configuration", filename)
+			continue
+		}
+		if tmpowned == false && gcMatchJobs(filename, kinds, ownership.removed) == false {
+			progress.Itemf("unattributed", "Cannot attribute \"%s\" to a job of this host", filename)
+			unattributed = append(unattributed, filename)
+			continue
+		}
+
+		datetime := gcDateRegexp.FindString(filename)
+		filetime, err := time.Parse("20060102-150405", datetime)
+		if datetime == "" || err != nil {
+			progress.Itemf("kept", "Keeping orphan \"%s\" as its name does not contain a valid date", filename)
+			continue
+		}
+		if age := curtime.Sub(filetime); age < gcMinAge {
+			progress.Itemf("kept", "Keeping orphan \"%s\" as it is only %s old", filename, age.Truncate(time.Minute))
+			continue
+		}
+		if deleteOrphans == false {
+			progress.Itemf("skipped", "Not deleting orphan \"%s\" created on %s without -gc-delete", filename, filetime.Format(time.RFC3339))
+			continue
+		}
+		orphans = append(orphans, filename)
+	}
+
+	// Files which cannot be attributed may belong to another host so this destination is not collected
+	if deleteOrphans == true && len(unattributed) > 0 {
+		progress.Logf("orphans")
+		return fmt.Errorf("refusing to delete %d orphans as %d files such as \"%s\" cannot be attributed to a job of this host",
+			len(orphans), len(unattributed), unattributed[0])
+	}
+	for _, filename := range orphans {
+		if err := dest.destination.DeleteFile(filename); err != nil {
+			return fmt.Errorf("failed to delete orphan %s: %w", filename, err)
+		}
+		progress.Itemf("deleted", "Deleted orphan \"%s\"", filename)
+	}
+	progress.Logf("orphans")
+
+	return nil
+}
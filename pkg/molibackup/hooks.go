@@ -0,0 +1,76 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gookit/slog"
+)
+
+// Prefix of the lines written by a pre-hook on stdout which contain metadata
+const hookMetadataPrefix = "MOLIBACKUP_META"
+
+// Run the command of a pre-hook with the shell right before backups are created and return the metadata
+// it has written on stdout as lines in the "MOLIBACKUP_META key=value" format, such as the position of the
+// log of a database, so backups can be correlated precisely with the state of the application at restore
+// time. Other lines are ignored so hooks and the tools they run can write messages without adding
+// metadata by accident, and a failure of the hook fails the job.
+func hookRunPre(command string, jobname string) (map[string]string, error) {
+
+	results := make(map[string]string)
+
+	env := map[string]string{
+		"MOLIBACKUP_JOB":    jobname,
+		"MOLIBACKUP_RUN_ID": runId,
+	}
+
+	slog.Infof("Running pre-hook of job \"%s\" ...", jobname)
+	output, err := runCommand("/bin/sh", []string{"-c", command}, env)
+	if err != nil {
+		return nil, fmt.Errorf("pre-hook has failed: %w", err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, hookMetadataPrefix+" ") == false && strings.HasPrefix(line, hookMetadataPrefix+"\t") == false {
+			continue
+		}
+		key, val, found := strings.Cut(strings.TrimSpace(line[len(hookMetadataPrefix):]), "=")
+		if found == false {
+			return nil, fmt.Errorf("pre-hook has written a metadata line which is not in the \"%s key=value\" format: \"%s\"", hookMetadataPrefix, line)
+		}
+		if metadataKeyValid(key) == false {
+			return nil, fmt.Errorf("pre-hook has written an invalid metadata key: \"%s\"", key)
+		}
+		if len(val) > 256 {
+			return nil, fmt.Errorf("pre-hook has written a value longer than 256 characters for key \"%s\"", key)
+		}
+		results[key] = val
+	}
+
+	slog.Infof("Pre-hook has returned metadata %v", results)
+
+	return results, nil
+}
+
+// Merge the metadata returned by a pre-hook into the metadata of the job, where values returned by
+// the hook take precedence as they describe the state of the application at the time of the backup
+func hookMergeMetadata(metadata map[string]string, hookmeta map[string]string) map[string]string {
+	results := make(map[string]string)
+	for key, val := range metadata {
+		results[key] = val
+	}
+	for key, val := range hookmeta {
+		if oldval, found := results[key]; found == true && oldval != val {
+			slog.Debugf("Metadata \"%s\" returned by the pre-hook replaces the value \"%s\" of the configuration", key, oldval)
+		}
+		results[key] = val
+	}
+	return results
+}
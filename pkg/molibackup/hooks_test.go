@@ -0,0 +1,64 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHookRunPre(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "metadata lines",
+			command: "echo 'MOLIBACKUP_META lsn=0/16B3748'; echo 'MOLIBACKUP_META schema=42'",
+			want:    map[string]string{"lsn": "0/16B3748", "schema": "42"},
+		},
+		{
+			name:    "lines without the prefix are ignored",
+			command: "echo 'starting backup'; echo 'timeout=30'; echo 'MOLIBACKUP_META lsn=0/16B3748'",
+			want:    map[string]string{"lsn": "0/16B3748"},
+		},
+		{
+			name:    "no metadata",
+			command: "echo 'nothing to report'",
+			want:    map[string]string{},
+		},
+		{
+			name:    "metadata line without a value",
+			command: "echo 'MOLIBACKUP_META lsn'",
+			wantErr: "not in the \"MOLIBACKUP_META key=value\" format",
+		},
+		{
+			name:    "invalid metadata key",
+			command: "echo 'MOLIBACKUP_META log position=0/16B3748'",
+			wantErr: "invalid metadata key",
+		},
+		{
+			name:    "failed command",
+			command: "echo 'MOLIBACKUP_META lsn=0/16B3748'; exit 1",
+			wantErr: "pre-hook has failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hookRunPre(tt.command, "job01")
+			testCheckError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			if reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
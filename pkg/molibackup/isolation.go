@@ -0,0 +1,231 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Jobs can be executed in a subprocess running the same binary so a crash, a leak or a hang in
+// a module only causes this job to fail. The subprocess reads the same configuration as the
+// parent process as it gets the same command line, and it writes its result to a temporary file.
+
+// Environment variables used to pass the context of a job from the parent to the subprocess
+const isolationEnvRunId = "MOLIBACKUP_RUN_ID"
+const isolationEnvJobExecutionId = "MOLIBACKUP_JOB_EXECUTION_ID"
+const isolationEnvResultFile = "MOLIBACKUP_JOB_RESULT_FILE"
+
+// Result of a job executed in a subprocess which is read by the parent process
+type isolationJobResult struct {
+	Error         string             `json:"error,omitempty"`
+	ErrorCode     string             `json:"error_code,omitempty"`
+	ErrorResource string             `json:"error_resource,omitempty"`
+	ErrorCause    string             `json:"error_cause,omitempty"`
+	ErrorStack    string             `json:"error_stack,omitempty"`
+	Usage         *StateUsageSample  `json:"usage,omitempty"`
+	Metadata      map[string]string  `json:"metadata,omitempty"`
+	ApiCalls      []RunReportApiCall `json:"api_calls,omitempty"`
+	// Volumes found by the job which are needed by the parent to report the volumes not covered by any job
+	VolumesAttached map[string]string `json:"volumes_attached,omitempty"`
+	VolumesCovered  []string          `json:"volumes_covered,omitempty"`
+}
+
+// Error of a job executed in a subprocess with the code and the resource of the original error
+type isolationJobError struct {
+	message  string
+	code     string
+	resource string
+	cause    string
+	stack    string
+}
+
+func (e *isolationJobError) Error() string {
+	return e.message
+}
+
+func (e *isolationJobError) ErrorCode() string {
+	return e.code
+}
+
+func (e *isolationJobError) ErrorResource() string {
+	return e.resource
+}
+
+func (e *isolationJobError) ErrorCause() string {
+	return e.cause
+}
+
+func (e *isolationJobError) ErrorStack() string {
+	return e.stack
+}
+
+// Execute a job in a subprocess with the resource limits and the timeout of the global configuration
+func runJobIsolated(jobname string) error {
+
+	program, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the path of the program to run the job in a subprocess: %v", err)
+	}
+
+	resultfile, err := os.CreateTemp("", "molibackup-job-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create the result file of the subprocess: %v", err)
+	}
+	resultfile.Close()
+	defer os.Remove(resultfile.Name())
+
+	ctx := context.Background()
+	timeout := time.Duration(kconfig.Int64("global.job_timeout")) * time.Minute
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	args := append([]string{"-internal-run-job", jobname}, os.Args[1:]...)
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", isolationEnvRunId, runId),
+		fmt.Sprintf("%s=%s", isolationEnvJobExecutionId, jobExecutionId),
+		fmt.Sprintf("%s=%s", isolationEnvResultFile, resultfile.Name()))
+
+	// The stream of events is passed as the first extra file which the subprocess gets as descriptor 3
+	if eventsFile != nil {
+		cmd.ExtraFiles = []*os.File{eventsFile}
+		cmd.Args = append(cmd.Args, "-events-fd", "3")
+	}
+
+	isolationPrepareCommand(cmd)
+
+	slog.Debugf("Running job \"%s\" in a subprocess with timeout=%v ...", jobname, timeout)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the subprocess: %v", err)
+	}
+
+	// The whole process group is killed on timeout, as killing only the subprocess would leave the
+	// programs started by the job running and holding the pipes of the standard output and error
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := isolationKillCommand(cmd); err != nil {
+				slog.Errorf("Failed to kill the subprocess of job \"%s\": %v", jobname, err)
+			}
+		case <-exited:
+		}
+	}()
+	runerr := cmd.Wait()
+	close(exited)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("the subprocess has been killed as the job has not finished within %v", timeout)
+	}
+
+	// The subprocess of a job aborted by the watchdog or which has crashed exits without waiting for the
+	// programs it has started, which are killed with its process group as on timeout
+	if err := isolationKillOrphans(cmd); err != nil {
+		slog.Errorf("Failed to kill the programs started by the subprocess of job \"%s\": %v", jobname, err)
+	}
+
+	// A subprocess which has crashed or which has been killed has not written any result
+	data, err := os.ReadFile(resultfile.Name())
+	if err != nil || len(data) == 0 {
+		if runerr == nil {
+			runerr = fmt.Errorf("no result has been written")
+		}
+		return fmt.Errorf("the subprocess has failed: %v", runerr)
+	}
+	result := isolationJobResult{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to decode the result of the subprocess: %v", err)
+	}
+
+	return isolationApplyResult(jobname, result)
+}
+
+// Record the result of a job executed in a subprocess in the parent process as if the job had run in it
+func isolationApplyResult(jobname string, result isolationJobResult) error {
+
+	if result.Usage != nil {
+		stateRecordSample(jobname, *result.Usage)
+	}
+	jobLatestMetadata[jobname] = result.Metadata
+	awsMetricsMerge(result.ApiCalls)
+	ebsVolumesMerge(result.VolumesAttached, result.VolumesCovered)
+	if result.Error != "" {
+		return &isolationJobError{message: result.Error, code: result.ErrorCode, resource: result.ErrorResource, cause: result.ErrorCause, stack: result.ErrorStack}
+	}
+
+	return nil
+}
+
+// Execute a single job in the subprocess and write its result for the parent process
+func runIsolatedChild(jobname string) int {
+
+	resultpath := os.Getenv(isolationEnvResultFile)
+	if resultpath == "" {
+		slog.Errorf("Option -internal-run-job is reserved for subprocesses started by the program")
+		return ExitStatusInvalidConfiguration
+	}
+	jobExecutionId = os.Getenv(isolationEnvJobExecutionId)
+
+	if _, ok := jobmetadefs[jobname]; ok == false {
+		slog.Errorf("Configuration for job \"%s\" not found in the subprocess", jobname)
+		return ExitStatusInvalidConfiguration
+	}
+
+	// The limit enforced by the operating system makes the subprocess crash when it is exceeded, and the
+	// Go runtime collects garbage more aggressively as the memory used approaches it to avoid this
+	if maxmem := kconfig.Int64("global.job_max_memory_mb"); maxmem > 0 {
+		if err := isolationSetMemoryLimit(uint64(maxmem) * 1024 * 1024); err != nil {
+			slog.Errorf("Failed to limit the memory of job \"%s\": %v", jobname, err)
+			return ExitStatusFailedToExecuteJobs
+		}
+		debug.SetMemoryLimit(maxmem * 1024 * 1024)
+	}
+
+	// The usage of the job is recorded in memory and sent to the parent which owns the state file
+	statedata = &StateFile{Jobs: make(map[string][]StateUsageSample)}
+	stateUpdated = make(map[string]bool)
+
+	result := isolationJobResult{}
+	if err := watchdogRun(jobname, true, func() error { return runJob(jobname) }); err != nil {
+		result.Error = err.Error()
+		result.ErrorCode, result.ErrorResource = errorDetails(err)
+		result.ErrorCause = errorCause(err)
+		result.ErrorStack = errorStack(err)
+	}
+	faultInjectionLogUsage()
+	if history := statedata.Jobs[jobname]; len(history) > 0 {
+		result.Usage = &history[len(history)-1]
+	}
+	result.Metadata = jobLatestMetadata[jobname]
+	result.ApiCalls = awsMetricsSummary()
+	result.VolumesAttached, result.VolumesCovered = ebsVolumesSummary()
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		slog.Errorf("Failed to encode the result of job \"%s\": %v", jobname, err)
+		return ExitStatusFailedToExecuteJobs
+	}
+	if err := os.WriteFile(resultpath, data, 0600); err != nil {
+		slog.Errorf("Failed to write the result of job \"%s\": %v", jobname, err)
+		return ExitStatusFailedToExecuteJobs
+	}
+
+	return ExitStatusSuccessfulExecution
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// Run the subprocess in its own process group so the programs started by the job can be killed with it,
+// and kill it if the parent process dies as it does not receive the signals sent to the terminal anymore
+func isolationPrepareCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
+
+// Kill the subprocess and all the programs it has started which are still in its process group
+func isolationKillCommand(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// Kill the programs started by the subprocess which are still running in its process group after it has exited
+func isolationKillOrphans(cmd *exec.Cmd) error {
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err == syscall.ESRCH {
+		return nil
+	}
+	return err
+}
+
+// Limit the size of the data segment of the subprocess, which includes the heap of the Go runtime, so
+// allocations beyond the limit fail and the subprocess crashes. The limit is inherited by the programs
+// started by the job, and it cannot be raised again as both the soft and the hard limits are set.
+func isolationSetMemoryLimit(limit uint64) error {
+	rlimit := &syscall.Rlimit{Cur: limit, Max: limit}
+	if err := syscall.Setrlimit(syscall.RLIMIT_DATA, rlimit); err != nil {
+		return fmt.Errorf("failed to set the limit of the data segment to %d bytes: %v", limit, err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Process groups are only used on Linux where the program is supported
+func isolationPrepareCommand(cmd *exec.Cmd) {
+}
+
+// Kill the subprocess only as the programs it has started cannot be found without a process group
+func isolationKillCommand(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// Programs started by the subprocess cannot be found without a process group once it has exited
+func isolationKillOrphans(cmd *exec.Cmd) error {
+	return nil
+}
+
+// Memory limits enforced by the operating system are only supported on Linux
+func isolationSetMemoryLimit(limit uint64) error {
+	return fmt.Errorf("memory limits of isolated jobs are only supported on Linux")
+}
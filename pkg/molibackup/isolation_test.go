@@ -0,0 +1,102 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Volumes found by a job executed in a subprocess
+type testIsolatedVolumes struct {
+	attached map[string]string
+	covered  []string
+}
+
+// Volumes of isolated jobs must reach the parent process through their results to be reported as uncovered
+func TestIsolationUncoveredVolumes(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []testIsolatedVolumes
+		want []string
+	}{
+		{
+			name: "no volume",
+			jobs: []testIsolatedVolumes{{}},
+			want: nil,
+		},
+		{
+			name: "volume excluded by the only job",
+			jobs: []testIsolatedVolumes{
+				{attached: map[string]string{"vol-1": "i-1", "vol-2": "i-1"}, covered: []string{"vol-1"}},
+			},
+			want: []string{"vol-2"},
+		},
+		{
+			name: "volume excluded by a job and covered by another job",
+			jobs: []testIsolatedVolumes{
+				{attached: map[string]string{"vol-1": "i-1", "vol-2": "i-1"}, covered: []string{"vol-1"}},
+				{covered: []string{"vol-2"}},
+			},
+			want: nil,
+		},
+		{
+			name: "volumes excluded by several jobs",
+			jobs: []testIsolatedVolumes{
+				{attached: map[string]string{"vol-1": "i-1", "vol-2": "i-1"}, covered: []string{"vol-1"}},
+				{attached: map[string]string{"vol-3": "i-2", "vol-4": "i-2"}, covered: []string{"vol-4"}},
+			},
+			want: []string{"vol-2", "vol-3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				ebsVolumesAttached = make(map[string]string)
+				ebsVolumesCovered = make(map[string]bool)
+			}()
+
+			// Each subprocess starts with empty globals and sends what its job has found in its result file
+			var results [][]byte
+			for _, job := range tt.jobs {
+				ebsVolumesAttached = make(map[string]string)
+				ebsVolumesCovered = make(map[string]bool)
+				for volumeId, instanceId := range job.attached {
+					ebsVolumesAttached[volumeId] = instanceId
+				}
+				for _, volumeId := range job.covered {
+					ebsVolumesCovered[volumeId] = true
+				}
+				result := isolationJobResult{}
+				result.VolumesAttached, result.VolumesCovered = ebsVolumesSummary()
+				data, err := json.Marshal(result)
+				if err != nil {
+					t.Fatalf("failed to encode result: %v", err)
+				}
+				results = append(results, data)
+			}
+
+			// The parent process has not found any volume itself
+			ebsVolumesAttached = make(map[string]string)
+			ebsVolumesCovered = make(map[string]bool)
+			for _, data := range results {
+				result := isolationJobResult{}
+				if err := json.Unmarshal(data, &result); err != nil {
+					t.Fatalf("failed to decode result: %v", err)
+				}
+				if err := isolationApplyResult("job", result); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if got := ebsUncoveredVolumes(); reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
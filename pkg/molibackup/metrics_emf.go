@@ -0,0 +1,88 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Metrics about snapshot operations are written as log lines in the CloudWatch Embedded Metric
+// Format (EMF) when the "emf_namespace" global option is set. CloudWatch Logs extracts metrics from
+// these lines when the output of the program is sent there, so no call to the metrics API is needed.
+
+// Lines are written to the standard output, which subprocesses of isolated jobs share with the parent
+var emfWriter io.Writer = os.Stdout
+var emfMutex sync.Mutex
+
+// Metadata which tells CloudWatch Logs how to extract metrics from the other fields of the line
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// Write the duration and the result of an operation such as "CreateSnapshot" on a snapshot of a volume
+func emfSnapshotOperation(jobname string, operation string, volumeId string, snapshotId string, duration time.Duration, err error) {
+
+	namespace := kconfig.String("global.emf_namespace")
+	if namespace == "" {
+		return
+	}
+
+	failures := 0
+	if err != nil {
+		failures = 1
+	}
+
+	// Metrics are aggregated per job and operation and they can also be looked at for each volume
+	line := map[string]any{
+		"_aws": emfMetadata{
+			Timestamp: clockNow().UnixMilli(),
+			CloudWatchMetrics: []emfDirective{
+				{
+					Namespace:  namespace,
+					Dimensions: [][]string{{"Job", "Operation"}, {"Job", "Operation", "VolumeId"}},
+					Metrics:    []emfMetric{{Name: "Duration", Unit: "Milliseconds"}, {Name: "Failures", Unit: "Count"}},
+				},
+			},
+		},
+		"Job":       jobname,
+		"Operation": operation,
+		"VolumeId":  volumeId,
+		"Duration":  duration.Milliseconds(),
+		"Failures":  failures,
+		"RunId":     runId,
+		"JobId":     jobExecutionId,
+	}
+	if snapshotId != "" {
+		line["SnapshotId"] = snapshotId
+	}
+
+	data, jsonerr := json.Marshal(line)
+	if jsonerr != nil {
+		return
+	}
+
+	emfMutex.Lock()
+	defer emfMutex.Unlock()
+	fmt.Fprintf(emfWriter, "%s\n", data)
+}
@@ -0,0 +1,405 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigAmiImage struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	InstanceId      string `koanf:"instance_id"`
+	InstanceTags    any    `koanf:"instance_tags"`
+	ExclInstTags    any    `koanf:"exclude_instance_tags"`
+	NoReboot        bool   `koanf:"no_reboot"`
+	Metadata        any    `koanf:"metadata"`
+	PreHook         string `koanf:"pre_hook"`
+}
+
+type backup_ami_image struct {
+	config    JobConfigAmiImage
+	cfg       aws.Config
+	client    *ec2.Client
+	instances []ProviderAwsEc2Instance
+	images    map[string]ProviderAwsAmiImage
+	metadata  map[string]string
+	jobname   string
+}
+
+var validateConfigAmiImage = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"ami-image"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude_instance_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "no_reboot",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "metadata",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "pre_hook",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_ami_image) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+	b.jobname = jobname
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigAmiImage); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.InstanceId != "" {
+		matched, _ := regexp.MatchString("^(local|i-[a-z0-9]{17})$", b.config.InstanceId)
+		if matched == false {
+			return fmt.Errorf("Option \"instance_id\" must be either \"local\" or in the \"i-0123456789abcdef0\" format")
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	var err error
+	if b.metadata, err = configMetadataToMap(b.config.Metadata); err != nil {
+		return err
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- InstanceId=\"%v\"", b.config.InstanceId)
+	slog.Debugf("- InstanceTags=\"%v\"", b.config.InstanceTags)
+	slog.Debugf("- ExclInstTags=\"%v\"", b.config.ExclInstTags)
+	slog.Debugf("- NoReboot=%v", b.config.NoReboot)
+	slog.Debugf("- Metadata=\"%v\"", b.metadata)
+	slog.Debugf("- PreHook=\"%v\"", b.config.PreHook)
+
+	return nil
+}
+
+func (b *backup_ami_image) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Dynamically determine the EC2 Instance ID if requested in the configuration
+	if b.config.InstanceId == "local" {
+		slog.Debugf("Trying to detect the instance ID of the local instance ...")
+		b.config.InstanceId, err = ProviderAwsGetCurrentInstance(b.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to detect the instance ID of the local instance: %w", err)
+		}
+		slog.Debugf("Have detected the instance ID of the local instance as %s", b.config.InstanceId)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewEc2Client(b.cfg)
+	b.images = make(map[string]ProviderAwsAmiImage)
+
+	// Find list of all instances that match the conditions specified in the configuration
+	instags := configTagsToMap(b.config.InstanceTags)
+	instexcl := configTagsToMap(b.config.ExclInstTags)
+	slog.Debugf("Listing instances based on instance_id=\"%s\" instance_tags=\"%v\" and exclude_instance_tags=\"%v\" ...", b.config.InstanceId, instags, instexcl)
+	b.instances, err = ProviderAwsGetEc2Instances(b.client, b.config.InstanceId, instags, instexcl)
+	if err != nil {
+		return fmt.Errorf("failed to find the instances to back up: %w", err)
+	}
+	for _, instance := range b.instances {
+		slog.Debugf("Found instance: instanceId=\"%s\" instanceName=\"%s\"", instance.instanceId, instance.instanceName)
+	}
+	if len(b.instances) == 0 {
+		slog.Warnf("Have not found any instance matching the conditions")
+	}
+
+	return nil
+}
+
+func (b *backup_ami_image) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.instances))
+
+	// The pre-hook runs right before the images so the metadata it returns matches their point in time,
+	// and it only applies to the images of this run so the metadata of the job is left unchanged
+	imagetags := awsMetadataToTags(b.metadata)
+	if b.config.PreHook != "" && b.config.DryRunCreate == false {
+		hookmeta, err := hookRunPre(b.config.PreHook, b.jobname)
+		if err != nil {
+			return fmt.Errorf("failed to run the pre-hook of job \"%s\": %w", b.jobname, err)
+		}
+		imagetags = awsMetadataToTags(hookMergeMetadata(b.metadata, hookmeta))
+		for tagkey, tagval := range imagetags {
+			if err := awsValidateTag(tagkey, tagval); err != nil {
+				return fmt.Errorf("invalid metadata returned by the pre-hook: %w", err)
+			}
+		}
+	}
+
+	for _, instance := range b.instances {
+		slog.Debugf("Considering image for instance: instanceId=\"%s\" ...", instance.instanceId)
+		curtime := clockNow()
+		basename := instance.instanceId
+		if instance.instanceName != "" {
+			basename = instance.instanceName
+		}
+		// Image names must be unique in the account and region so the instance ID is always included
+		imagename := fmt.Sprintf("%s-%s-%s", basename, instance.instanceId, curtime.UTC().Format("20060102-150405"))
+		snapdate := fmt.Sprintf("%04d%02d%02d", curtime.Year(), curtime.Month(), curtime.Day())
+		snaptime := fmt.Sprintf("%v", curtime.Unix())
+		if b.config.DryRunCreate == false {
+			imageId, err := ProviderAwsCreateAmiImage(b.client, instance.instanceId, imagename, snapdate, snaptime, b.config.NoReboot, imagetags)
+			if err != nil {
+				return fmt.Errorf("failed to create image of instance \"%s\": %w", instance.instanceId, err)
+			}
+			progress.Itemf("created", "Successfully created image \"%s\" of instance \"%s\"", imageId, instance.instanceId)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating image of instance \"%s\"", instance.instanceId)
+		}
+	}
+
+	progress.Logf("images")
+
+	return nil
+}
+
+func (b *backup_ami_image) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	// Enumerate instances and their images to get a list of relevant images
+	for _, instance := range b.instances {
+		slog.Debugf("Listing images from instance: instanceId=\"%s\" ...", instance.instanceId)
+
+		images, err := ProviderAwsGetAmiImages(b.client, instance.instanceId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range images {
+			item := BackupItem{}
+			item.identifier = image.imageId
+			item.description = image.imageName
+			item.timestamp = image.imageTime
+			item.metadata = image.metadata
+			results = append(results, item)
+			b.images[image.imageId] = image
+			imgtime := time.Unix(image.imageTime, 0)
+			slog.Debugf("Found image: id=\"%s\" name=\"%s\" created=\"%v\" snapshots=%v metadata=%v",
+				image.imageId, image.imageName, imgtime.Format(time.RFC3339), image.snapshotIds, image.metadata)
+		}
+	}
+
+	// Image names start with the instance name followed by the date
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].description < results[j].description
+	})
+
+	return results, nil
+}
+
+func (b *backup_ami_image) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		imageAge := (curtime - item.timestamp) / 86400
+		imageDelete := imageAge > retention
+		slog.Debugf("Considering deletion of image: id=\"%s\" age=%v retention=%v ...",
+			item.identifier, imageAge, retention)
+		if imageDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderAwsDeleteAmiImage(b.client, b.images[item.identifier])
+				if err != nil {
+					return fmt.Errorf("failed to delete image \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted image: id=\"%s\" age=%v retention=%v", item.identifier, imageAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting image: id=\"%s\" age=%d retention=%v", item.identifier, imageAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping image: id=\"%s\" age=%d retention=%d", item.identifier, imageAge, retention)
+		}
+	}
+
+	progress.Logf("images")
+
+	return nil
+}
+
+func (b *backup_ami_image) RetagBackups(bkpitems []BackupItem, tags map[string]string) error {
+
+	// Snapshots backing the images are tagged together with the images
+	var resourceIds []string
+	for _, item := range bkpitems {
+		resourceIds = append(resourceIds, item.identifier)
+		resourceIds = append(resourceIds, b.images[item.identifier].snapshotIds...)
+	}
+
+	if b.config.DryRunCreate == true || b.config.DryRunDelete == true {
+		slog.Infof("Dryrun: Not tagging %d images and their snapshots with %v", len(bkpitems), tags)
+		return nil
+	}
+	if err := ProviderAwsTagEc2Resources(b.client, resourceIds, tags); err != nil {
+		return fmt.Errorf("failed to tag %d images and their snapshots: %w", len(bkpitems), err)
+	}
+	slog.Infof("Successfully tagged %d images and their snapshots with %v", len(bkpitems), tags)
+
+	return nil
+}
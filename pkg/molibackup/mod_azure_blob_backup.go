@@ -0,0 +1,391 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigAzureBlobBackup struct {
+	Module               string `koanf:"module"`
+	Enabled              any    `koanf:"enabled"`
+	DryRun               bool   `koanf:"dryrun"`
+	DryRunCreate         bool   `koanf:"dryrun_create"`
+	DryRunDelete         bool   `koanf:"dryrun_delete"`
+	Retention            int64  `koanf:"retention"`
+	TenantId             string `koanf:"tenant_id"`
+	ClientId             string `koanf:"client_id"`
+	ClientSecret         string `koanf:"client_secret"`
+	SourceAccount        string `koanf:"source_account"`
+	SourceContainer      string `koanf:"source_container"`
+	SourcePrefix         string `koanf:"source_prefix"`
+	DestinationAccount   string `koanf:"destination_account"`
+	DestinationContainer string `koanf:"destination_container"`
+	DestinationPrefix    string `koanf:"destination_prefix"`
+}
+
+type backup_azure_blob_backup struct {
+	config    JobConfigAzureBlobBackup
+	srcclient *azblob.Client
+	dstclient *azblob.Client
+	basepath  string
+}
+
+// Manifest stored with each backup in the destination container
+type AzureBlobManifest struct {
+	SourceAccount   string                   `json:"source_account"`
+	SourceContainer string                   `json:"source_container"`
+	SourcePrefix    string                   `json:"source_prefix"`
+	Timestamp       int64                    `json:"timestamp"`
+	JobId           string                   `json:"job_id"`
+	Blobs           []AzureBlobManifestEntry `json:"blobs"`
+}
+
+type AzureBlobManifestEntry struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+var validateConfigAzureBlobBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"azure-blob-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "tenant_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "client_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "client_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "source_account",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "source_container",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "source_prefix",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination_account",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination_container",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination_prefix",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "molibackup",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_azure_blob_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigAzureBlobBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	// The destination is in the same storage account as the source unless specified otherwise
+	if b.config.DestinationAccount == "" {
+		b.config.DestinationAccount = b.config.SourceAccount
+	}
+
+	for _, account := range []string{b.config.SourceAccount, b.config.DestinationAccount} {
+		matched, _ := regexp.MatchString("^[a-z0-9]{3,24}$", account)
+		if matched == false {
+			return fmt.Errorf("Storage account name \"%s\" is not a valid storage account name", account)
+		}
+	}
+	for _, containerName := range []string{b.config.SourceContainer, b.config.DestinationContainer} {
+		matched, _ := regexp.MatchString("^[a-z0-9]([a-z0-9-]{1,61}[a-z0-9])?$", containerName)
+		if matched == false {
+			return fmt.Errorf("Container name \"%s\" is not a valid container name", containerName)
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// Backups are stored in a dated folder under a folder named after the source account and container
+	b.basepath = path.Join(b.config.DestinationPrefix, b.config.SourceAccount, b.config.SourceContainer) + "/"
+	if b.config.SourceAccount == b.config.DestinationAccount && b.config.SourceContainer == b.config.DestinationContainer &&
+		strings.HasPrefix(b.basepath, b.config.SourcePrefix) {
+		return fmt.Errorf("Option \"destination_prefix\" must not be inside the source prefix when using the same container")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- TenantId=\"%v\"", b.config.TenantId)
+	slog.Debugf("- ClientId=\"%v\"", b.config.ClientId)
+	slog.Debugf("- ClientSecret=\"%v\"", b.config.ClientSecret)
+	slog.Debugf("- SourceAccount=\"%v\"", b.config.SourceAccount)
+	slog.Debugf("- SourceContainer=\"%v\"", b.config.SourceContainer)
+	slog.Debugf("- SourcePrefix=\"%v\"", b.config.SourcePrefix)
+	slog.Debugf("- DestinationAccount=\"%v\"", b.config.DestinationAccount)
+	slog.Debugf("- DestinationContainer=\"%v\"", b.config.DestinationContainer)
+	slog.Debugf("- DestinationPrefix=\"%v\"", b.config.DestinationPrefix)
+
+	return nil
+}
+
+func (b *backup_azure_blob_backup) InitialiseModule() error {
+
+	var err error
+
+	// Create a client for each storage account using the service principal if it has been provided
+	b.srcclient, err = ProviderAzureNewBlobClient(b.config.SourceAccount, b.config.TenantId, b.config.ClientId, b.config.ClientSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create the client of storage account \"%s\": %w", b.config.SourceAccount, err)
+	}
+	b.dstclient, err = ProviderAzureNewBlobClient(b.config.DestinationAccount, b.config.TenantId, b.config.ClientId, b.config.ClientSecret)
+	if err != nil {
+		return fmt.Errorf("failed to create the client of storage account \"%s\": %w", b.config.DestinationAccount, err)
+	}
+
+	return nil
+}
+
+func (b *backup_azure_blob_backup) CreateBackup() error {
+
+	curtime := clockNow()
+	backuppath := b.basepath + curtime.UTC().Format("20060102-150405") + "/"
+	manifest := AzureBlobManifest{
+		SourceAccount:   b.config.SourceAccount,
+		SourceContainer: b.config.SourceContainer,
+		SourcePrefix:    b.config.SourcePrefix,
+		Timestamp:       curtime.Unix(),
+		JobId:           jobExecutionId,
+	}
+
+	slog.Debugf("Listing blobs in container \"%s\" with prefix \"%s\" ...", b.config.SourceContainer, b.config.SourcePrefix)
+	blobs, err := ProviderAzureListBlobs(b.srcclient, b.config.SourceContainer, b.config.SourcePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list the blobs of container \"%s\": %w", b.config.SourceContainer, err)
+	}
+	if len(blobs) == 0 {
+		slog.Warnf("Have not found any blob in container \"%s\" with prefix \"%s\"", b.config.SourceContainer, b.config.SourcePrefix)
+	}
+
+	progress := NewProgressSummary(len(blobs))
+
+	for _, blob := range blobs {
+		dstname := backuppath + strings.TrimPrefix(blob.name, b.config.SourcePrefix)
+		if b.config.DryRunCreate == false {
+			err := ProviderAzureCopyBlob(b.srcclient, b.config.SourceContainer, blob.name, b.dstclient, b.config.DestinationContainer, dstname)
+			if err != nil {
+				return fmt.Errorf("failed to copy blob \"%s\" to container \"%s\": %w", blob.name, b.config.DestinationContainer, err)
+			}
+			progress.Itemf("copied", "Copied blob \"%s\" to \"%s\"", blob.name, dstname)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not copying blob \"%s\" to \"%s\"", blob.name, dstname)
+		}
+		manifest.Blobs = append(manifest.Blobs, AzureBlobManifestEntry{Name: blob.name, Size: blob.size})
+	}
+
+	progress.Logf("blobs")
+
+	// The manifest is written last so its presence indicates that the backup is complete
+	if b.config.DryRunCreate == false {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode the manifest: %v", err)
+		}
+		if err := ProviderAzureUploadBlob(b.dstclient, b.config.DestinationContainer, backuppath+s3SyncManifestName, data); err != nil {
+			return fmt.Errorf("failed to upload the manifest of backup \"%s\": %w", backuppath, err)
+		}
+		slog.Infof("Successfully created backup \"%s/%s\" with %d blobs", b.config.DestinationContainer, backuppath, len(manifest.Blobs))
+		eventItemf("created", "Created backup \"%s/%s\"", b.config.DestinationContainer, backuppath)
+	} else {
+		slog.Infof("Dryrun: Not creating backup \"%s/%s\"", b.config.DestinationContainer, backuppath)
+	}
+
+	return nil
+}
+
+func (b *backup_azure_blob_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing backups in container \"%s\" with prefix \"%s\" ...", b.config.DestinationContainer, b.basepath)
+	prefixes, err := ProviderAzureListBlobPrefixes(b.dstclient, b.config.DestinationContainer, b.basepath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prefix := range prefixes {
+		// Only consider folders named after the date and time of a backup created by this program
+		name := strings.TrimSuffix(strings.TrimPrefix(prefix, b.basepath), "/")
+		backuptime, err := time.Parse("20060102-150405", name)
+		if err != nil {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = prefix
+		item.description = prefix
+		item.timestamp = backuptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found backup: prefix=\"%s\" created=\"%v\"", prefix, backuptime.Format(time.RFC3339))
+	}
+
+	// Backup folders are named after the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_azure_blob_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		backupAge := (curtime - item.timestamp) / 86400
+		backupDelete := backupAge > retention
+		slog.Debugf("Considering deletion of backup: prefix=\"%s\" age=%v retention=%v ...",
+			item.identifier, backupAge, retention)
+		if backupDelete == true {
+			if b.config.DryRunDelete == false {
+				blobs, err := ProviderAzureListBlobs(b.dstclient, b.config.DestinationContainer, item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to list the blobs of backup \"%s\": %w", item.identifier, err)
+				}
+				for _, blob := range blobs {
+					if err := ProviderAzureDeleteBlob(b.dstclient, b.config.DestinationContainer, blob.name); err != nil {
+						return fmt.Errorf("failed to delete blob \"%s\" of backup \"%s\": %w", blob.name, item.identifier, err)
+					}
+				}
+				progress.Itemf("deleted", "Deleted backup: prefix=\"%s\" blobs=%d age=%v retention=%v", item.identifier, len(blobs), backupAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting backup: prefix=\"%s\" age=%d retention=%v", item.identifier, backupAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping backup: prefix=\"%s\" age=%d retention=%d", item.identifier, backupAge, retention)
+		}
+	}
+
+	progress.Logf("backups")
+
+	return nil
+}
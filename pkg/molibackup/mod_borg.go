@@ -0,0 +1,341 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigBorg struct {
+	Module         string `koanf:"module"`
+	Enabled        any    `koanf:"enabled"`
+	DryRun         bool   `koanf:"dryrun"`
+	DryRunCreate   bool   `koanf:"dryrun_create"`
+	DryRunDelete   bool   `koanf:"dryrun_delete"`
+	Retention      int64  `koanf:"retention"`
+	BorgPath       string `koanf:"borg_path"`
+	Repository     string `koanf:"repository"`
+	PassphraseFile string `koanf:"passphrase_file"`
+	Environment    any    `koanf:"environment"`
+	Directories    any    `koanf:"directories"`
+	Exclude        any    `koanf:"exclude"`
+	Compression    string `koanf:"compression"`
+	Compact        bool   `koanf:"compact"`
+}
+
+type backup_borg struct {
+	config      JobConfigBorg
+	borg        *ProviderBorg
+	directories []string
+	exclude     []string
+	prefix      string
+}
+
+var validateConfigBorg = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"borg"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "borg_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "borg",
+		allowedval: nil,
+	},
+	{
+		entryname:  "repository",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "passphrase_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "environment",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "directories",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "compression",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "lz4",
+		allowedval: nil,
+	},
+	{
+		entryname:  "compact",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+}
+
+func (b *backup_borg) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigBorg); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// The repository can be provided in the environment in the same way as for borg itself
+	if b.config.Repository == "" && os.Getenv("BORG_REPO") == "" {
+		return fmt.Errorf("Option \"repository\" or the BORG_REPO environment variable must be specified")
+	}
+
+	b.directories = configListToStrings(b.config.Directories)
+	if len(b.directories) == 0 {
+		return fmt.Errorf("Option \"directories\" must contain at least one directory")
+	}
+	for _, directory := range b.directories {
+		if filepath.IsAbs(directory) == false {
+			return fmt.Errorf("Option \"directories\" must contain absolute paths but \"%s\" is not", directory)
+		}
+	}
+	b.exclude = configListToStrings(b.config.Exclude)
+
+	// Archives are named after the job so multiple jobs can share the same repository
+	b.prefix = fmt.Sprintf("molibackup-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- BorgPath=\"%v\"", b.config.BorgPath)
+	slog.Debugf("- Repository=\"%v\"", b.config.Repository)
+	slog.Debugf("- PassphraseFile=\"%v\"", b.config.PassphraseFile)
+	slog.Debugf("- Environment=\"%v\"", b.config.Environment)
+	slog.Debugf("- Directories=\"%v\"", b.directories)
+	slog.Debugf("- Exclude=\"%v\"", b.exclude)
+	slog.Debugf("- Compression=\"%v\"", b.config.Compression)
+	slog.Debugf("- Compact=%v", b.config.Compact)
+
+	return nil
+}
+
+func (b *backup_borg) InitialiseModule() error {
+
+	// Options of the job take precedence over the environment
+	env := configTagsToMap(b.config.Environment)
+	if b.config.Repository != "" {
+		env["BORG_REPO"] = b.config.Repository
+	}
+	if b.config.PassphraseFile != "" {
+		env["BORG_PASSCOMMAND"] = fmt.Sprintf("cat %s", rsyncShellQuote(b.config.PassphraseFile))
+	}
+
+	b.borg = &ProviderBorg{program: b.config.BorgPath, env: env}
+
+	return nil
+}
+
+// Return the time of an archive from its name
+func (b *backup_borg) parseArchiveName(archive string) (time.Time, bool) {
+	if strings.HasPrefix(archive, b.prefix) == false {
+		return time.Time{}, false
+	}
+	archtime, err := time.Parse("20060102-150405", strings.TrimPrefix(archive, b.prefix))
+	return archtime, err == nil
+}
+
+func (b *backup_borg) CreateBackup() error {
+
+	curtime := clockNow()
+	archive := fmt.Sprintf("%s%s", b.prefix, curtime.UTC().Format("20060102-150405"))
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating archive \"%s\" of %v", archive, b.directories)
+		return nil
+	}
+
+	slog.Infof("Creating archive \"%s\" of %v ...", archive, b.directories)
+	stats, err := ProviderBorgCreateArchive(b.borg, archive, b.directories, b.exclude, b.config.Compression)
+	if err != nil {
+		return fmt.Errorf("failed to create archive \"%s\": %w", archive, err)
+	}
+	slog.Infof("Successfully created archive \"%s\": files=%d original=%s compressed=%s deduplicated=%s",
+		archive, stats.Files, formatBytes(stats.OriginalSize), formatBytes(stats.CompressedSize), formatBytes(stats.DeduplicatedSize))
+	eventItemf("created", "Created archive \"%s\"", archive)
+
+	return nil
+}
+
+func (b *backup_borg) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing archives with prefix \"%s\" ...", b.prefix)
+	archives, err := ProviderBorgListArchives(b.borg, b.prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, archive := range archives {
+		archtime, ok := b.parseArchiveName(archive)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = archive
+		item.description = archive
+		item.timestamp = archtime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found archive: name=\"%s\" created=\"%v\"", archive, archtime.Format(time.RFC3339))
+	}
+
+	// Archive names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_borg) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	var expired []BackupItem
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		archAge := (curtime - item.timestamp) / 86400
+		archDelete := archAge > retention
+		slog.Debugf("Considering deletion of archive: name=\"%s\" age=%v retention=%v ...",
+			item.identifier, archAge, retention)
+		if archDelete == true {
+			if b.config.DryRunDelete == false {
+				expired = append(expired, item)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting archive: name=\"%s\" age=%d retention=%v", item.identifier, archAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping archive: name=\"%s\" age=%d retention=%d", item.identifier, archAge, retention)
+		}
+	}
+
+	// Archives are older than the retention when their age in days is at least one more than the retention
+	if len(expired) > 0 {
+		if err := ProviderBorgPrune(b.borg, b.prefix, retention+1); err != nil {
+			return fmt.Errorf("failed to prune the archives with prefix \"%s\": %w", b.prefix, err)
+		}
+		for _, item := range expired {
+			archAge := (curtime - item.timestamp) / 86400
+			progress.Itemf("deleted", "Deleted archive: name=\"%s\" age=%v retention=%v", item.identifier, archAge, retention)
+		}
+		if b.config.Compact == true {
+			if err := ProviderBorgCompact(b.borg); err != nil {
+				return fmt.Errorf("failed to compact the repository: %w", err)
+			}
+		}
+	}
+
+	progress.Logf("archives")
+
+	return nil
+}
@@ -0,0 +1,174 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigCanary struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+}
+
+type backup_canary struct {
+	config JobConfigCanary
+	cfg    aws.Config
+}
+
+// Error of a canary job which has found that the credentials or the connectivity do not work, and
+// of the jobs which have been skipped as a consequence, so they are all reported with the same cause
+type EnvironmentError struct {
+	canary string
+	err    error
+}
+
+var validateConfigCanary = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"canary"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (e *EnvironmentError) Error() string {
+	if e.canary == "" {
+		return fmt.Sprintf("environment problem: %v", e.err)
+	}
+	return fmt.Sprintf("skipped as canary job \"%s\" has failed: %v", e.canary, e.err)
+}
+
+func (e *EnvironmentError) Unwrap() error {
+	return e.err
+}
+
+func (e *EnvironmentError) ErrorCode() string {
+	return "EnvironmentProblem"
+}
+
+func (e *EnvironmentError) ErrorResource() string {
+	return ""
+}
+
+// Skipped jobs have the cause of the error of the canary job so they are grouped with it
+func (e *EnvironmentError) ErrorCause() string {
+	if e.canary == "" {
+		return fmt.Sprintf("environment problem: %s", errorCause(e.err))
+	}
+	return errorCause(e.err)
+}
+
+func (b *backup_canary) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigCanary); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+
+	return nil
+}
+
+// The checks are done here so they are performed whatever phases have been selected
+func (b *backup_canary) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return &EnvironmentError{err: err}
+	}
+
+	slog.Infof("Checking the credentials with GetCallerIdentity ...")
+	account, err := ProviderAwsGetCurrentAccount(b.cfg)
+	if err != nil {
+		return &EnvironmentError{err: err}
+	}
+
+	slog.Infof("Checking the access to EC2 in region \"%s\" with DescribeRegions ...", b.cfg.Region)
+	regions, err := ProviderAwsGetEnabledRegions(ProviderAwsNewEc2Client(b.cfg))
+	if err != nil {
+		return &EnvironmentError{err: err}
+	}
+
+	slog.Infof("Successfully checked the environment: account=\"%s\" region=\"%s\" enabled_regions=%d", account, b.cfg.Region, len(regions))
+
+	return nil
+}
+
+func (b *backup_canary) CreateBackup() error {
+	return nil
+}
+
+func (b *backup_canary) ListBackups() ([]BackupItem, error) {
+	return nil, nil
+}
+
+func (b *backup_canary) DeleteOldBackups(bkpitems []BackupItem) error {
+	return nil
+}
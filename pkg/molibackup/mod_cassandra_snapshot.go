@@ -0,0 +1,505 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigCassandraSnapshot struct {
+	Module           string `koanf:"module"`
+	Enabled          any    `koanf:"enabled"`
+	DryRun           bool   `koanf:"dryrun"`
+	DryRunCreate     bool   `koanf:"dryrun_create"`
+	DryRunDelete     bool   `koanf:"dryrun_delete"`
+	Retention        int64  `koanf:"retention"`
+	AwsRegion        string `koanf:"aws_region"`
+	AccessKeyId      string `koanf:"accesskey_id"`
+	AccessKeySecret  string `koanf:"accesskey_secret"`
+	NodetoolPath     string `koanf:"nodetool_path"`
+	JmxHost          string `koanf:"jmx_host"`
+	JmxPort          int64  `koanf:"jmx_port"`
+	JmxUsername      string `koanf:"jmx_username"`
+	JmxPasswordFile  string `koanf:"jmx_password_file"`
+	Keyspaces        any    `koanf:"keyspaces"`
+	DataDir          string `koanf:"data_dir"`
+	Archive          bool   `koanf:"archive"`
+	ArchiveRetention int64  `koanf:"archive_retention"`
+	Compression      string `koanf:"compression"`
+	Destination      string `koanf:"destination"`
+}
+
+type backup_cassandra_snapshot struct {
+	config      JobConfigCassandraSnapshot
+	cfg         aws.Config
+	cassandra   *ProviderCassandra
+	destination BackupDestination
+	keyspaces   []string
+	snapprefix  string
+	fileprefix  string
+	archives    map[string]bool
+}
+
+var validateConfigCassandraSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"cassandra-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "nodetool_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "nodetool",
+		allowedval: nil,
+	},
+	{
+		entryname:  "jmx_host",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "jmx_port",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "0",
+		allowedval: nil,
+	},
+	{
+		entryname:  "jmx_username",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "jmx_password_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "keyspaces",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "data_dir",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "/var/lib/cassandra/data",
+		allowedval: nil,
+	},
+	{
+		entryname:  "archive",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "archive_retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "compression",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "gzip",
+		allowedval: []string{"none", "gzip", "zstd"},
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_cassandra_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigCassandraSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	b.keyspaces = configListToStrings(b.config.Keyspaces)
+	if len(b.keyspaces) == 0 {
+		return fmt.Errorf("Option \"keyspaces\" must contain at least one keyspace")
+	}
+
+	// Archives are usually kept longer than snapshots which use the disk space of the node
+	if b.config.ArchiveRetention < 0 {
+		return fmt.Errorf("Option \"archive_retention\" must be a valid number greater than 0")
+	}
+	if b.config.ArchiveRetention == 0 {
+		b.config.ArchiveRetention = b.config.Retention
+	}
+	if b.config.Archive == true && b.config.Destination == "" {
+		return fmt.Errorf("Option \"destination\" must be specified when option \"archive\" is enabled")
+	}
+	if b.config.Archive == false && b.config.Destination != "" {
+		return fmt.Errorf("Option \"destination\" can only be used when option \"archive\" is enabled")
+	}
+	if filepath.IsAbs(b.config.DataDir) == false {
+		return fmt.Errorf("Option \"data_dir\" must be an absolute path")
+	}
+
+	// Snapshots are named after the job so they are not mixed with snapshots created by other tools
+	b.snapprefix = fmt.Sprintf("molibackup-%s-", jobname)
+	b.fileprefix = fmt.Sprintf("cassandra-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- NodetoolPath=\"%v\"", b.config.NodetoolPath)
+	slog.Debugf("- JmxHost=\"%v\"", b.config.JmxHost)
+	slog.Debugf("- JmxPort=%v", b.config.JmxPort)
+	slog.Debugf("- JmxUsername=\"%v\"", b.config.JmxUsername)
+	slog.Debugf("- JmxPasswordFile=\"%v\"", b.config.JmxPasswordFile)
+	slog.Debugf("- Keyspaces=\"%v\"", b.keyspaces)
+	slog.Debugf("- DataDir=\"%v\"", b.config.DataDir)
+	slog.Debugf("- Archive=%v", b.config.Archive)
+	slog.Debugf("- ArchiveRetention=%v", b.config.ArchiveRetention)
+	slog.Debugf("- Compression=\"%v\"", b.config.Compression)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_cassandra_snapshot) InitialiseModule() error {
+
+	b.cassandra = &ProviderCassandra{program: b.config.NodetoolPath, host: b.config.JmxHost, port: b.config.JmxPort,
+		username: b.config.JmxUsername, passwordFile: b.config.JmxPasswordFile}
+	b.archives = make(map[string]bool)
+
+	if b.config.Archive == false {
+		return nil
+	}
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// Each node archives its own snapshots so archives include the name of the node in a shared destination
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get the hostname: %v", err)
+	}
+	b.fileprefix = fmt.Sprintf("%s%s-", b.fileprefix, hostname)
+
+	return nil
+}
+
+// Return the time of a snapshot from its name
+func (b *backup_cassandra_snapshot) parseSnapshotName(snapname string) (time.Time, bool) {
+	if strings.HasPrefix(snapname, b.snapprefix) == false {
+		return time.Time{}, false
+	}
+	snaptime, err := time.Parse("20060102-150405", strings.TrimPrefix(snapname, b.snapprefix))
+	return snaptime, err == nil
+}
+
+// Return the time of an archive from its file name
+func (b *backup_cassandra_snapshot) parseArchiveName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false {
+		return time.Time{}, false
+	}
+	datetime := strings.TrimPrefix(filename, b.fileprefix)
+	for _, extension := range tarArchiveExtensions {
+		if strings.HasSuffix(datetime, extension) {
+			archivetime, err := time.Parse("20060102-150405", strings.TrimSuffix(datetime, extension))
+			return archivetime, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+func (b *backup_cassandra_snapshot) CreateBackup() error {
+
+	curtime := clockNow().UTC().Format("20060102-150405")
+	snapname := fmt.Sprintf("%s%s", b.snapprefix, curtime)
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating snapshot \"%s\" of keyspaces %v", snapname, b.keyspaces)
+		return nil
+	}
+
+	slog.Infof("Creating snapshot \"%s\" of keyspaces %v ...", snapname, b.keyspaces)
+	if err := ProviderCassandraCreateSnapshot(b.cassandra, snapname, b.keyspaces); err != nil {
+		return fmt.Errorf("failed to create snapshot \"%s\": %w", snapname, err)
+	}
+	slog.Infof("Successfully created snapshot \"%s\" of keyspaces %v", snapname, b.keyspaces)
+	eventItemf("created", "Created snapshot \"%s\"", snapname)
+
+	if b.config.Archive == true {
+		filename := fmt.Sprintf("%s%s%s", b.fileprefix, curtime, tarArchiveExtensions[b.config.Compression])
+		if err := b.archiveSnapshot(snapname, filename); err != nil {
+			return fmt.Errorf("failed to archive snapshot \"%s\": %w", snapname, err)
+		}
+	}
+
+	return nil
+}
+
+// Write the directories of a snapshot in all tables of the keyspaces to an archive in the destination
+func (b *backup_cassandra_snapshot) archiveSnapshot(snapname string, filename string) error {
+
+	// Snapshots are stored in <data_dir>/<keyspace>/<table>-<id>/snapshots/<name> on the node
+	var directories []string
+	for _, keyspace := range b.keyspaces {
+		matches, err := filepath.Glob(filepath.Join(b.config.DataDir, keyspace, "*", "snapshots", snapname))
+		if err != nil {
+			return fmt.Errorf("failed to find the directories of snapshot \"%s\": %v", snapname, err)
+		}
+		directories = append(directories, matches...)
+	}
+	if len(directories) == 0 {
+		return fmt.Errorf("failed to find the directories of snapshot \"%s\" in \"%s\"", snapname, b.config.DataDir)
+	}
+	sort.Strings(directories)
+
+	slog.Infof("Creating archive of snapshot \"%s\" with %d tables ...", snapname, len(directories))
+	filecount := 0
+	size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+		var err error
+		filecount, err = archiveTarDirectories(output, directories, nil, nil, b.config.Compression)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive \"%s\" to the destination: %w", filename, err)
+	}
+	slog.Infof("Successfully created archive \"%s\" with %d files (%s) in \"%s\"", filename, filecount, formatBytes(size), b.destination)
+	eventItemf("created", "Created archive \"%s\" in \"%s\"", filename, b.destination)
+
+	return nil
+}
+
+func (b *backup_cassandra_snapshot) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing snapshots of the node ...")
+	snapshots, err := ProviderCassandraListSnapshots(b.cassandra)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshots are listed once per table so tables of the same snapshot are merged
+	found := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		snaptime, ok := b.parseSnapshotName(snapshot.snapshotName)
+		if ok == false || found[snapshot.snapshotName] == true {
+			continue
+		}
+		found[snapshot.snapshotName] = true
+		item := BackupItem{}
+		item.identifier = snapshot.snapshotName
+		item.description = "snapshot"
+		item.timestamp = snaptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found snapshot: name=\"%s\" created=\"%v\"", snapshot.snapshotName, snaptime.Format(time.RFC3339))
+	}
+
+	if b.config.Archive == true {
+		slog.Debugf("Listing archives in \"%s\" ...", b.destination)
+		filenames, err := b.destination.ListFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, filename := range filenames {
+			archivetime, ok := b.parseArchiveName(filename)
+			if ok == false {
+				continue
+			}
+			item := BackupItem{}
+			item.identifier = filename
+			item.description = "archive"
+			item.timestamp = archivetime.Unix()
+			results = append(results, item)
+			b.archives[filename] = true
+			slog.Debugf("Found archive: file=\"%s\" created=\"%v\"", filename, archivetime.Format(time.RFC3339))
+		}
+	}
+
+	// Snapshots are listed before archives and both are sorted chronologically
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].description != results[j].description {
+			return results[i].description > results[j].description
+		}
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (b *backup_cassandra_snapshot) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		// Archives in the destination have their own retention
+		retention := b.config.Retention
+		if b.archives[item.identifier] == true {
+			retention = b.config.ArchiveRetention
+		}
+		itemAge := (curtime - item.timestamp) / 86400
+		itemDelete := itemAge > retention
+		slog.Debugf("Considering deletion of %s: name=\"%s\" age=%v retention=%v ...",
+			item.description, item.identifier, itemAge, retention)
+		if itemDelete == true {
+			if b.config.DryRunDelete == false {
+				var err error
+				if b.archives[item.identifier] == true {
+					err = b.destination.DeleteFile(item.identifier)
+				} else {
+					err = ProviderCassandraClearSnapshot(b.cassandra, item.identifier)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to delete %s \"%s\": %w", item.description, item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted %s: name=\"%s\" age=%v retention=%v", item.description, item.identifier, itemAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting %s: name=\"%s\" age=%d retention=%v", item.description, item.identifier, itemAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping %s: name=\"%s\" age=%d retention=%d", item.description, item.identifier, itemAge, retention)
+		}
+	}
+
+	progress.Logf("snapshots and archives")
+
+	return nil
+}
@@ -0,0 +1,394 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigClickhouseBackup struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	ClientPath      string `koanf:"clickhouse_client_path"`
+	Host            string `koanf:"host"`
+	Port            int64  `koanf:"port"`
+	ConfigFile      string `koanf:"config_file"`
+	Databases       any    `koanf:"databases"`
+	Tables          any    `koanf:"tables"`
+	BackupDisk      string `koanf:"backup_disk"`
+	BackupDiskPath  string `koanf:"backup_disk_path"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_clickhouse_backup struct {
+	config      JobConfigClickhouseBackup
+	cfg         aws.Config
+	clickhouse  *ProviderClickhouse
+	destination BackupDestination
+	databases   []string
+	tables      []string
+	fileprefix  string
+}
+
+// Extension of the archives created by the BACKUP statement
+const clickhouseBackupExtension = ".zip"
+
+var validateConfigClickhouseBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"clickhouse-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "clickhouse_client_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "clickhouse-client",
+		allowedval: nil,
+	},
+	{
+		entryname:  "host",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "port",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "0",
+		allowedval: nil,
+	},
+	{
+		entryname:  "config_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "databases",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "tables",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "backup_disk",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "backups",
+		allowedval: nil,
+	},
+	{
+		entryname:  "backup_disk_path",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_clickhouse_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigClickhouseBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	b.databases = configListToStrings(b.config.Databases)
+	b.tables = configListToStrings(b.config.Tables)
+	if len(b.databases) == 0 && len(b.tables) == 0 {
+		return fmt.Errorf("Option \"databases\" or option \"tables\" must contain at least one entry")
+	}
+	for _, table := range b.tables {
+		if strings.Count(table, ".") != 1 {
+			return fmt.Errorf("Option \"tables\" must contain tables in the \"database.table\" format but \"%s\" is not", table)
+		}
+	}
+
+	// The server writes backups to its backup disk and the program reads them from the path of this disk
+	if filepath.IsAbs(b.config.BackupDiskPath) == false {
+		return fmt.Errorf("Option \"backup_disk_path\" must be an absolute path")
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("clickhouse-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- ClientPath=\"%v\"", b.config.ClientPath)
+	slog.Debugf("- Host=\"%v\"", b.config.Host)
+	slog.Debugf("- Port=%v", b.config.Port)
+	slog.Debugf("- ConfigFile=\"%v\"", b.config.ConfigFile)
+	slog.Debugf("- Databases=\"%v\"", b.databases)
+	slog.Debugf("- Tables=\"%v\"", b.tables)
+	slog.Debugf("- BackupDisk=\"%v\"", b.config.BackupDisk)
+	slog.Debugf("- BackupDiskPath=\"%v\"", b.config.BackupDiskPath)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_clickhouse_backup) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	b.clickhouse = &ProviderClickhouse{program: b.config.ClientPath, host: b.config.Host, port: b.config.Port, configFile: b.config.ConfigFile}
+
+	return nil
+}
+
+// Return the time of a backup from its file name
+func (b *backup_clickhouse_backup) parseBackupName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false || strings.HasSuffix(filename, clickhouseBackupExtension) == false {
+		return time.Time{}, false
+	}
+	datetime := strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), clickhouseBackupExtension)
+	backuptime, err := time.Parse("20060102-150405", datetime)
+	return backuptime, err == nil
+}
+
+func (b *backup_clickhouse_backup) CreateBackup() error {
+
+	curtime := clockNow()
+	filename := fmt.Sprintf("%s%s%s", b.fileprefix, curtime.UTC().Format("20060102-150405"), clickhouseBackupExtension)
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating backup \"%s\" in \"%s\"", filename, b.destination)
+		return nil
+	}
+
+	slog.Infof("Creating backup of databases %v and tables %v ...", b.databases, b.tables)
+
+	// The archive is only needed on the backup disk until it has been written to the destination
+	localpath := filepath.Join(b.config.BackupDiskPath, filename)
+	defer os.Remove(localpath)
+
+	if err := ProviderClickhouseBackup(b.clickhouse, b.databases, b.tables, b.config.BackupDisk, filename); err != nil {
+		return fmt.Errorf("failed to create backup \"%s\" on the backup disk: %w", filename, err)
+	}
+	file, err := os.Open(localpath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup written by the server on the backup disk: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read backup written by the server on the backup disk: %v", err)
+	}
+	if err := b.destination.WriteFile(filename, file); err != nil {
+		return fmt.Errorf("failed to write backup \"%s\" to the destination: %w", filename, err)
+	}
+	slog.Infof("Successfully created backup \"%s\" (%s) in \"%s\"", filename, formatBytes(info.Size()), b.destination)
+	eventItemf("created", "Created backup \"%s\" in \"%s\"", filename, b.destination)
+
+	return nil
+}
+
+func (b *backup_clickhouse_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing backups in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		backuptime, ok := b.parseBackupName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = backuptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found backup: file=\"%s\" created=\"%v\"", filename, backuptime.Format(time.RFC3339))
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_clickhouse_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		backupAge := (curtime - item.timestamp) / 86400
+		backupDelete := backupAge > retention
+		slog.Debugf("Considering deletion of backup: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, backupAge, retention)
+		if backupDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted backup: file=\"%s\" age=%v retention=%v", item.identifier, backupAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting backup: file=\"%s\" age=%d retention=%v", item.identifier, backupAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping backup: file=\"%s\" age=%d retention=%d", item.identifier, backupAge, retention)
+		}
+	}
+
+	progress.Logf("backups")
+
+	return nil
+}
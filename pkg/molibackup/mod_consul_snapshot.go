@@ -0,0 +1,381 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigConsulSnapshot struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	ConsulAddr      string `koanf:"consul_addr"`
+	AclToken        string `koanf:"acl_token"`
+	AclTokenFile    string `koanf:"acl_token_file"`
+	Datacenter      string `koanf:"datacenter"`
+	Stale           bool   `koanf:"stale"`
+	TlsInsecure     bool   `koanf:"tls_insecure"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_consul_snapshot struct {
+	config      JobConfigConsulSnapshot
+	cfg         aws.Config
+	consul      *ProviderConsul
+	destination BackupDestination
+	fileprefix  string
+}
+
+// Extension of the snapshots saved by Consul which are gzipped archives
+const consulSnapshotExtension = ".snap"
+
+var validateConfigConsulSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"consul-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "consul_addr",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "acl_token",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "acl_token_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "datacenter",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "stale",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "tls_insecure",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_consul_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigConsulSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// Defaults are the environment variables used by the consul command so both find the same agent
+	if b.config.ConsulAddr == "" {
+		b.config.ConsulAddr = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if b.config.ConsulAddr == "" {
+		b.config.ConsulAddr = "http://127.0.0.1:8500"
+	}
+	if strings.Contains(b.config.ConsulAddr, "://") == false {
+		b.config.ConsulAddr = "http://" + b.config.ConsulAddr
+	}
+	if b.config.AclToken == "" && b.config.AclTokenFile == "" {
+		b.config.AclToken = os.Getenv("CONSUL_HTTP_TOKEN")
+		b.config.AclTokenFile = os.Getenv("CONSUL_HTTP_TOKEN_FILE")
+	}
+	if b.config.AclToken != "" && b.config.AclTokenFile != "" {
+		return fmt.Errorf("Options \"acl_token\" and \"acl_token_file\" cannot be used together")
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("consul-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- ConsulAddr=\"%v\"", b.config.ConsulAddr)
+	slog.Debugf("- AclTokenFile=\"%v\"", b.config.AclTokenFile)
+	slog.Debugf("- Datacenter=\"%v\"", b.config.Datacenter)
+	slog.Debugf("- Stale=%v", b.config.Stale)
+	slog.Debugf("- TlsInsecure=%v", b.config.TlsInsecure)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_consul_snapshot) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// Tokens stored in a file can be rotated without changing the configuration
+	token := b.config.AclToken
+	if b.config.AclTokenFile != "" {
+		data, err := os.ReadFile(b.config.AclTokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the ACL token file: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	b.consul = ProviderConsulNewClient(b.config.ConsulAddr, token, b.config.TlsInsecure)
+
+	return nil
+}
+
+// Return the time of a snapshot from its file name
+func (b *backup_consul_snapshot) parseSnapshotName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false || strings.HasSuffix(filename, consulSnapshotExtension) == false {
+		return time.Time{}, false
+	}
+	datetime := strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), consulSnapshotExtension)
+	snaptime, err := time.Parse("20060102-150405", datetime)
+	return snaptime, err == nil
+}
+
+func (b *backup_consul_snapshot) CreateBackup() error {
+
+	curtime := clockNow()
+	filename := fmt.Sprintf("%s%s%s", b.fileprefix, curtime.UTC().Format("20060102-150405"), consulSnapshotExtension)
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating snapshot \"%s\" in \"%s\"", filename, b.destination)
+		return nil
+	}
+
+	slog.Infof("Creating snapshot of Consul servers at \"%s\" ...", b.config.ConsulAddr)
+
+	snapshot, index, err := ProviderConsulSaveSnapshot(b.consul, b.config.Datacenter, b.config.Stale)
+	if err != nil {
+		return fmt.Errorf("failed to save the snapshot of the Consul servers: %w", err)
+	}
+	defer snapshot.Close()
+	size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+		if _, err := io.Copy(output, snapshot); err != nil {
+			return fmt.Errorf("failed to read snapshot: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot \"%s\" to the destination: %w", filename, err)
+	}
+	slog.Infof("Successfully created snapshot \"%s\" (%s) at index %s in \"%s\"", filename, formatBytes(size), index, b.destination)
+	eventItemf("created", "Created snapshot \"%s\" in \"%s\"", filename, b.destination)
+
+	return nil
+}
+
+func (b *backup_consul_snapshot) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing snapshots in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		snaptime, ok := b.parseSnapshotName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = snaptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found snapshot: file=\"%s\" created=\"%v\"", filename, snaptime.Format(time.RFC3339))
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_consul_snapshot) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		snapshotAge := (curtime - item.timestamp) / 86400
+		snapshotDelete := snapshotAge > retention
+		slog.Debugf("Considering deletion of snapshot: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, snapshotAge, retention)
+		if snapshotDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted snapshot: file=\"%s\" age=%v retention=%v", item.identifier, snapshotAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting snapshot: file=\"%s\" age=%d retention=%v", item.identifier, snapshotAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping snapshot: file=\"%s\" age=%d retention=%d", item.identifier, snapshotAge, retention)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
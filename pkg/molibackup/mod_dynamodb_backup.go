@@ -0,0 +1,245 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigDynamodbBackup struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	TablePattern    string `koanf:"table_pattern"`
+	TableTags       any    `koanf:"table_tags"`
+}
+
+type backup_dynamodb_backup struct {
+	snapshotRotation
+	config JobConfigDynamodbBackup
+	cfg    aws.Config
+	client *dynamodb.Client
+}
+
+var validateConfigDynamodbBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"dynamodb-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "table_pattern",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "table_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_dynamodb_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigDynamodbBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if _, err := path.Match(b.config.TablePattern, ""); err != nil {
+		return fmt.Errorf("Option \"table_pattern\" must be a valid pattern: %v", err)
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- TablePattern=\"%v\"", b.config.TablePattern)
+	slog.Debugf("- TableTags=\"%v\"", b.config.TableTags)
+
+	return nil
+}
+
+func (b *backup_dynamodb_backup) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewDynamodbClient(b.cfg)
+	b.snapshotRotation = snapshotRotation{noun: "backup", timeformat: "20060102-150405", retention: b.config.Retention,
+		dryruncreate: b.config.DryRunCreate, dryrundelete: b.config.DryRunDelete, provider: b}
+
+	// Find list of all DynamoDB tables that match the conditions specified in the configuration
+	tabtags := configTagsToMap(b.config.TableTags)
+	slog.Debugf("Listing DynamoDB tables based on table_pattern=\"%s\" and table_tags=\"%v\" ...", b.config.TablePattern, tabtags)
+	tables, err := ProviderAwsGetDynamodbTables(b.client, b.config.TablePattern, tabtags)
+	if err != nil {
+		return fmt.Errorf("failed to find the tables to back up: %w", err)
+	}
+	for _, table := range tables {
+		slog.Debugf("Found table: tableName=\"%s\" tableArn=\"%s\"", table.tableName, table.tableArn)
+		// Backup names are limited to 255 characters
+		basename := table.tableName
+		if len(basename) > 200 {
+			basename = basename[:200]
+		}
+		b.sources = append(b.sources, snapshotSource{id: table.tableName, kind: "table", prefix: awsDynamodbBackupPrefix + basename})
+	}
+	if len(tables) == 0 {
+		slog.Warnf("Have not found any DynamoDB table matching the conditions")
+	}
+
+	return nil
+}
+
+func (b *backup_dynamodb_backup) CreateSnapshot(source snapshotSource, bkpname string, bkpdate string, bkptime string) (string, error) {
+	return ProviderAwsCreateDynamodbBackup(b.client, source.id, bkpname)
+}
+
+func (b *backup_dynamodb_backup) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	var results []BackupItem
+
+	backups, err := ProviderAwsGetDynamodbBackups(b.client, source.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Backup names start with the table name followed by the date
+	for _, backup := range backups {
+		item := BackupItem{}
+		item.identifier = backup.backupArn
+		item.description = backup.backupName
+		item.timestamp = backup.backupTime
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+func (b *backup_dynamodb_backup) DeleteSnapshot(item BackupItem) error {
+	return ProviderAwsDeleteDynamodbBackup(b.client, item.identifier)
+}
@@ -0,0 +1,1550 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigEbsSnapshot struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	InstanceId      string `koanf:"instance_id"`
+	InstanceTags    any    `koanf:"instance_tags"`
+	VolumeTags      any    `koanf:"volume_tags"`
+	ExclInstTags    any    `koanf:"exclude_instance_tags"`
+	ExclVolTags     any    `koanf:"exclude_volume_tags"`
+	TargetsFile     string `koanf:"targets_file"`
+	LockMode        string `koanf:"lock_mode"`
+	LockDuration    int32  `koanf:"lock_duration"`
+	CopyRegions     any    `koanf:"copy_regions"`
+	CopyRetention   int64  `koanf:"copy_retention"`
+	CopyConcurrency int    `koanf:"copy_concurrency"`
+	MaxStorageGb    int64  `koanf:"max_storage_gb"`
+	KeepMinimum     int    `koanf:"keep_minimum"`
+	StreamSnapshots bool   `koanf:"stream_snapshots"`
+	RpoMaxHours     int64  `koanf:"rpo_max_hours"`
+	RestoreMbps     int64  `koanf:"restore_throughput_mbps"`
+	InheritTags     any    `koanf:"inherit_tags"`
+	GroupName       string `koanf:"consistency_group"`
+	FreezeMounts    any    `koanf:"freeze_mountpoints"`
+	FreezeTimeout   int64  `koanf:"freeze_timeout"`
+	Metadata        any    `koanf:"metadata"`
+	PreHook         string `koanf:"pre_hook"`
+	Intervals       any    `koanf:"snapshot_intervals"`
+}
+
+// Minimum interval between snapshots of the volumes which have a particular tag
+type ebsSnapshotInterval struct {
+	tagkey    string
+	condition string
+	hours     int64
+}
+
+// Runs which start a bit earlier than the previous one must still create snapshots when they are due
+const ebsSnapshotIntervalTolerance = 10 * time.Minute
+
+// Creating snapshots may run concurrently with listing and deleting them when phases are interleaved, so
+// the creation phase never modifies the fields of the module and the maps of snapshots belong to the rotation
+type backup_ebs_snapshot struct {
+	jobname     string
+	config      JobConfigEbsSnapshot
+	cfg         aws.Config
+	client      *ec2.Client
+	volumes     []ProviderAwsEbsVolume
+	copyregions []string
+	copyclients map[string]*ec2.Client
+	copies      map[string]string
+	snapshots   map[string]ProviderAwsEbsSnapshot
+	inherittags []string
+	inherited   map[string]map[string]string
+	freezedirs  []string
+	ssmclient   *ssm.Client
+	volinstance map[string]string
+	metadata    map[string]string
+	intervals   []ebsSnapshotInterval
+	retentions  map[string]int64
+	copysources map[string]string
+	copyretset  bool
+}
+
+// Volumes attached to instances in the scope of all jobs and volumes covered by at least one job. Jobs executed
+// in a subprocess send the volumes they have found in their result which are merged in the parent process.
+var ebsVolumesAttached = make(map[string]string)
+var ebsVolumesCovered = make(map[string]bool)
+
+var validateConfigEbsSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"ebs-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_tag",
+		replacedby: "instance_tags",
+		migrate:    configMigrateTagString,
+	},
+	{
+		entryname:  "volume_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude_instance_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude_volume_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "targets_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "lock_mode",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"compliance", "governance"},
+	},
+	{
+		entryname:  "lock_duration",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "7",
+		allowedval: nil,
+	},
+	{
+		entryname:  "copy_regions",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "copy_retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "copy_concurrency",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "20",
+		allowedval: nil,
+	},
+	{
+		entryname:  "max_storage_gb",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "keep_minimum",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "stream_snapshots",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "rpo_max_hours",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "restore_throughput_mbps",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "inherit_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "consistency_group",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "freeze_mountpoints",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "freeze_timeout",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "60",
+		allowedval: nil,
+	},
+	{
+		entryname:  "metadata",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "pre_hook",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "snapshot_intervals",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_ebs_snapshot) LoadConfiguration(jobname string) error {
+
+	b.jobname = jobname
+
+	// Original job config before validation and defaults
+	var origconf JobConfigEbsSnapshot
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Getting original job configuration (before validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &origconf); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Dump of the initial configuration:")
+	slog.Debugf("- Module=\"%v\"", origconf.Module)
+	slog.Debugf("- Enabled=%v", origconf.Enabled)
+	slog.Debugf("- DryRun=%v", origconf.DryRun)
+	slog.Debugf("- Retention=%v", origconf.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", origconf.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", origconf.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", origconf.AccessKeySecret)
+	slog.Debugf("- InstanceId=\"%v\"", origconf.InstanceId)
+	slog.Debugf("- InstanceTags=\"%v\"", origconf.InstanceTags)
+	slog.Debugf("- VolumeTags=\"%v\"", origconf.VolumeTags)
+	slog.Debugf("- ExclInstTags=\"%v\"", origconf.ExclInstTags)
+	slog.Debugf("- ExclVolTags=\"%v\"", origconf.ExclVolTags)
+	slog.Debugf("- TargetsFile=\"%v\"", origconf.TargetsFile)
+	slog.Debugf("- LockMode=\"%v\"", origconf.LockMode)
+	slog.Debugf("- LockDuration=\"%v\"", origconf.LockDuration)
+	slog.Debugf("- CopyRegions=\"%v\"", origconf.CopyRegions)
+	slog.Debugf("- CopyRetention=%v", origconf.CopyRetention)
+	slog.Debugf("- MaxStorageGb=%v", origconf.MaxStorageGb)
+	slog.Debugf("- KeepMinimum=%v", origconf.KeepMinimum)
+	slog.Debugf("- StreamSnapshots=%v", origconf.StreamSnapshots)
+	slog.Debugf("- RpoMaxHours=%v", origconf.RpoMaxHours)
+	slog.Debugf("- RestoreMbps=%v", origconf.RestoreMbps)
+	slog.Debugf("- InheritTags=\"%v\"", origconf.InheritTags)
+	slog.Debugf("- GroupName=\"%v\"", origconf.GroupName)
+	slog.Debugf("- FreezeMounts=\"%v\"", origconf.FreezeMounts)
+	slog.Debugf("- FreezeTimeout=%v", origconf.FreezeTimeout)
+	slog.Debugf("- Metadata=\"%v\"", origconf.Metadata)
+	slog.Debugf("- PreHook=\"%v\"", origconf.PreHook)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigEbsSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.InstanceId != "" {
+		matched, _ := regexp.MatchString("^(local|i-[a-z0-9]{17})$", b.config.InstanceId)
+		if matched == false {
+			return fmt.Errorf("Option \"instance_id\" must be either \"local\" or in the \"i-0123456789abcdef0\" format")
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// The targets file replaces the selection of instances while volumes can still be excluded by their tags
+	if b.config.TargetsFile != "" && (b.config.InstanceId != "" || len(configTagsToMap(b.config.InstanceTags)) > 0) {
+		return fmt.Errorf("Option \"targets_file\" cannot be used with options \"instance_id\" and \"instance_tags\"")
+	}
+
+	b.copyregions = configListToStrings(b.config.CopyRegions)
+	for _, region := range b.copyregions {
+		matched, _ := regexp.MatchString("^[a-z]{2}(-[a-z]+)+-[0-9]$", region)
+		if matched == false {
+			return fmt.Errorf("Option \"copy_regions\" contains an invalid region: \"%s\"", region)
+		}
+		if region == b.config.AwsRegion {
+			return fmt.Errorf("Option \"copy_regions\" must not contain the region of the job: \"%s\"", region)
+		}
+	}
+
+	// Copies are kept as long as the original snapshots unless a specific retention is specified
+	if b.config.CopyRetention < 0 {
+		return fmt.Errorf("Option \"copy_retention\" must be a valid number greater than 0")
+	}
+	b.copyretset = b.config.CopyRetention > 0
+	if b.config.CopyRetention == 0 {
+		b.config.CopyRetention = b.config.Retention
+	}
+	if b.config.CopyConcurrency <= 0 {
+		return fmt.Errorf("Option \"copy_concurrency\" must be a valid number greater than 0")
+	}
+
+	// A budget of zero means the storage used by snapshots is not limited
+	if b.config.MaxStorageGb < 0 {
+		return fmt.Errorf("Option \"max_storage_gb\" must be a valid number greater than 0")
+	}
+	if b.config.KeepMinimum < 0 {
+		return fmt.Errorf("Option \"keep_minimum\" must be a valid number greater than or equal to 0")
+	}
+
+	// The storage budget applies to all snapshots of the job which cannot be processed one volume at a time
+	if b.config.StreamSnapshots == true && b.config.MaxStorageGb > 0 {
+		return fmt.Errorf("Option \"stream_snapshots\" cannot be used with option \"max_storage_gb\"")
+	}
+	b.inherittags = configListToStrings(b.config.InheritTags)
+	for _, tagkey := range b.inherittags {
+		if slices.Contains(awsReservedTags, tagkey) == true || strings.HasPrefix(tagkey, "aws:") == true {
+			return fmt.Errorf("Option \"inherit_tags\" contains a tag which cannot be inherited: \"%s\"", tagkey)
+		}
+	}
+
+	if b.config.RpoMaxHours < 0 || b.config.RestoreMbps < 0 {
+		return fmt.Errorf("Options \"rpo_max_hours\" and \"restore_throughput_mbps\" must be valid numbers greater than 0")
+	}
+
+	if b.config.GroupName != "" {
+		matched, _ := regexp.MatchString("^[a-zA-Z0-9_.-]{1,64}$", b.config.GroupName)
+		if matched == false {
+			return fmt.Errorf("Option \"consistency_group\" must only contain letters, digits, dots, dashes and underscores")
+		}
+	}
+	b.freezedirs = configListToStrings(b.config.FreezeMounts)
+	// Mount points are inserted in shell commands run as root on the instances so only safe characters are allowed
+	for _, mountpoint := range b.freezedirs {
+		matched, _ := regexp.MatchString("^/[A-Za-z0-9_./-]+$", mountpoint)
+		if matched == false || path.Clean(mountpoint) != mountpoint {
+			return fmt.Errorf("Option \"freeze_mountpoints\" contains an invalid mount point: \"%s\" which must be an absolute path made of letters, digits, dots, dashes, underscores and slashes", mountpoint)
+		}
+	}
+	if len(b.freezedirs) > 0 && b.config.GroupName == "" {
+		return fmt.Errorf("Option \"freeze_mountpoints\" requires option \"consistency_group\" to be set")
+	}
+	// AWS does not accept commands with a timeout shorter than 30 seconds
+	if b.config.FreezeTimeout < 30 {
+		return fmt.Errorf("Option \"freeze_timeout\" must be a valid number of seconds greater than or equal to 30")
+	}
+	var err error
+	if b.metadata, err = configMetadataToMap(b.config.Metadata); err != nil {
+		return err
+	}
+
+	// Volumes of different classes can be snapshotted at different frequencies by a single job
+	for class, hours := range configTagsToMap(b.config.Intervals) {
+		tagkey, condition, found := strings.Cut(class, "=")
+		if found == false || tagkey == "" {
+			return fmt.Errorf("Option \"snapshot_intervals\" must have keys in the \"key=value\" format but \"%s\" is not", class)
+		}
+		interval, err := strconv.ParseInt(hours, 10, 64)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("Option \"snapshot_intervals\" must have a valid number of hours greater than 0 for \"%s\"", class)
+		}
+		b.intervals = append(b.intervals, ebsSnapshotInterval{tagkey: tagkey, condition: condition, hours: interval})
+	}
+	if err := b.validateSnapshotTags(b.metadata); err != nil {
+		return err
+	}
+	if len(b.intervals) > 0 && b.config.GroupName != "" {
+		return fmt.Errorf("Option \"snapshot_intervals\" cannot be used with option \"consistency_group\"")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- InstanceId=\"%v\"", b.config.InstanceId)
+	slog.Debugf("- InstanceTags=\"%v\"", origconf.InstanceTags)
+	slog.Debugf("- VolumeTags=\"%v\"", b.config.VolumeTags)
+	slog.Debugf("- ExclInstTags=\"%v\"", b.config.ExclInstTags)
+	slog.Debugf("- ExclVolTags=\"%v\"", b.config.ExclVolTags)
+	slog.Debugf("- TargetsFile=\"%v\"", b.config.TargetsFile)
+	slog.Debugf("- LockMode=\"%v\"", origconf.LockMode)
+	slog.Debugf("- LockDuration=\"%v\"", origconf.LockDuration)
+	slog.Debugf("- CopyRegions=\"%v\"", b.copyregions)
+	slog.Debugf("- CopyRetention=%v", b.config.CopyRetention)
+	slog.Debugf("- CopyConcurrency=%v", b.config.CopyConcurrency)
+	slog.Debugf("- MaxStorageGb=%v", b.config.MaxStorageGb)
+	slog.Debugf("- KeepMinimum=%v", b.config.KeepMinimum)
+	slog.Debugf("- StreamSnapshots=%v", b.config.StreamSnapshots)
+	slog.Debugf("- RpoMaxHours=%v", b.config.RpoMaxHours)
+	slog.Debugf("- RestoreMbps=%v", b.config.RestoreMbps)
+	slog.Debugf("- InheritTags=\"%v\"", b.inherittags)
+	slog.Debugf("- GroupName=\"%v\"", b.config.GroupName)
+	slog.Debugf("- FreezeMounts=\"%v\"", b.freezedirs)
+	slog.Debugf("- FreezeTimeout=%v", b.config.FreezeTimeout)
+	slog.Debugf("- Metadata=\"%v\"", b.metadata)
+	slog.Debugf("- PreHook=\"%v\"", b.config.PreHook)
+	slog.Debugf("- Intervals=\"%v\"", b.intervals)
+
+	return nil
+}
+
+func (b *backup_ebs_snapshot) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, append([]string{b.config.AwsRegion}, b.copyregions...)...); err != nil {
+		return fmt.Errorf("failed to check the guardrails for regions %v: %w", append([]string{b.config.AwsRegion}, b.copyregions...), err)
+	}
+
+	// Dynamically determine the EC2 Instance ID if requested in the configuration
+	if b.config.InstanceId == "local" {
+		slog.Debugf("Trying to detect the instance ID of the local instance ...")
+		b.config.InstanceId, err = ProviderAwsGetCurrentInstance(b.cfg)
+		if err != nil {
+			return fmt.Errorf("failed to detect the instance ID of the local instance: %w", err)
+		}
+		slog.Debugf("Have detected the instance ID of the local instance as %s", b.config.InstanceId)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewEc2Client(b.cfg)
+	if len(b.freezedirs) > 0 {
+		b.ssmclient = ProviderAwsNewSsmClient(b.cfg)
+	}
+
+	// Create a client for each region where snapshots must be copied
+	b.copyclients = make(map[string]*ec2.Client)
+	b.copies = make(map[string]string)
+	b.snapshots = make(map[string]ProviderAwsEbsSnapshot)
+	b.inherited = make(map[string]map[string]string)
+	b.volinstance = make(map[string]string)
+	b.retentions = make(map[string]int64)
+	b.copysources = make(map[string]string)
+	for _, region := range b.copyregions {
+		b.copyclients[region] = ProviderAwsNewEc2ClientForRegion(b.cfg, region)
+	}
+
+	// Find list of all EBS volumes that match the conditions specific in the configuration
+	err = b.findRelevantVolumes()
+	if err != nil {
+		return fmt.Errorf("failed to find the volumes to snapshot: %w", err)
+	}
+
+	return nil
+}
+
+func (b *backup_ebs_snapshot) findRelevantVolumes() error {
+	var results []ProviderAwsEbsVolume
+
+	// Parse "instance_tags" and "volume_tags" options and the tags to exclude
+	instags := configTagsToMap(b.config.InstanceTags)
+	voltags := configTagsToMap(b.config.VolumeTags)
+	instexcl := configTagsToMap(b.config.ExclInstTags)
+	volexcl := configTagsToMap(b.config.ExclVolTags)
+
+	// Get list of instances that match the conditions specified or which are listed in the targets file
+	var instances []ProviderAwsEc2Instance
+	var targets []TargetRow
+	var err error
+	if b.config.TargetsFile != "" {
+		slog.Debugf("Reading targets from \"%s\" ...", b.config.TargetsFile)
+		if targets, err = readTargetsFile(b.config.TargetsFile, b.cfg); err != nil {
+			return fmt.Errorf("failed to read targets file \"%s\": %w", b.config.TargetsFile, err)
+		}
+		if instances, err = b.findTargetInstances(targets, instexcl); err != nil {
+			return fmt.Errorf("failed to find the instances of the targets: %w", err)
+		}
+	} else {
+		slog.Debugf("Listing instances based on instance_id=\"%s\" instance_tags=\"%v\" and exclude_instance_tags=\"%v\" ...", b.config.InstanceId, instags, instexcl)
+		instances, err = ProviderAwsGetEc2Instances(b.client, b.config.InstanceId, instags, instexcl)
+		if err != nil {
+			return fmt.Errorf("failed to find the instances to back up: %w", err)
+		}
+		if len(instances) == 0 {
+			slog.Warnf("Have not found any instance matching the conditions")
+		}
+	}
+
+	// Go through each instance
+	for _, instance := range instances {
+		slog.Debugf("Listing volumes attached to instance \"%s\" with volume_tags=\"%v\" and exclude_volume_tags=\"%v\" ...", instance.instanceId, voltags, volexcl)
+		volumes, err := ProviderAwsGetEbsVolumes(b.client, instance.instanceId, voltags, volexcl)
+		if err != nil {
+			return fmt.Errorf("failed to find the volumes of instance \"%s\": %w", instance.instanceId, err)
+		}
+
+		// Go through each volume
+		for _, curvol := range volumes {
+			slog.Debugf("Found volume: volumeId=\"%s\" volumeName=\"%s\" instanceId=\"%s\"",
+				curvol.volumeId, curvol.volumeName, instance.instanceId)
+			results = append(results, curvol)
+			b.addVolume(curvol, instance)
+		}
+
+		// Keep track of volumes attached to the instance which have been excluded by volume_tags
+		if len(voltags) > 0 || len(volexcl) > 0 {
+			allvolumes, err := ProviderAwsGetEbsVolumes(b.client, instance.instanceId, nil, nil)
+			if err != nil {
+				return fmt.Errorf("failed to find the volumes of instance \"%s\": %w", instance.instanceId, err)
+			}
+			for _, curvol := range allvolumes {
+				ebsVolumesAttached[curvol.volumeId] = instance.instanceId
+			}
+		}
+	}
+
+	// Volumes listed in the targets file are backed up whether they are attached or not
+	if len(targets) > 0 {
+		volumes, err := b.findTargetVolumes(targets, volexcl)
+		if err != nil {
+			return fmt.Errorf("failed to find the volumes of the targets: %w", err)
+		}
+		results = append(results, volumes...)
+		b.setTargetRetentions(targets)
+	}
+
+	if len(results) == 0 {
+		slog.Warnf("Have not found any volume matching the conditions")
+	}
+
+	b.volumes = results
+	return nil
+}
+
+// Record a volume which is covered by the job and the tags to set on its snapshots
+func (b *backup_ebs_snapshot) addVolume(curvol ProviderAwsEbsVolume, instance ProviderAwsEc2Instance) {
+
+	ebsVolumesCovered[curvol.volumeId] = true
+	b.volinstance[curvol.volumeId] = instance.instanceId
+
+	// Tags of the volume take precedence over tags of the instance it is attached to
+	b.inherited[curvol.volumeId] = make(map[string]string)
+	for _, tagkey := range b.inherittags {
+		if tagval, ok := curvol.volumeTags[tagkey]; ok == true {
+			b.inherited[curvol.volumeId][tagkey] = tagval
+		} else if tagval, ok := instance.instanceTags[tagkey]; ok == true {
+			b.inherited[curvol.volumeId][tagkey] = tagval
+		}
+	}
+	// Custom metadata of the job is stored in tags which are set with the inherited tags
+	for tagkey, tagval := range awsMetadataToTags(b.metadata) {
+		b.inherited[curvol.volumeId][tagkey] = tagval
+	}
+}
+
+// Return the instances listed in the targets file
+func (b *backup_ebs_snapshot) findTargetInstances(targets []TargetRow, instexcl map[string]string) ([]ProviderAwsEc2Instance, error) {
+
+	var results []ProviderAwsEc2Instance
+
+	for _, target := range targets {
+		if strings.HasPrefix(target.id, "vol-") == true {
+			continue
+		}
+		if matched, _ := regexp.MatchString("^i-[a-z0-9]{8,17}$", target.id); matched == false {
+			return nil, fmt.Errorf("targets file contains an invalid identifier \"%s\" which must be an instance or a volume", target.id)
+		}
+		instances, err := ProviderAwsGetEc2Instances(b.client, target.id, nil, instexcl)
+		if err != nil {
+			return nil, err
+		}
+		if len(instances) == 0 {
+			slog.Warnf("Have not found instance \"%s\" listed in the targets file or it is excluded", target.id)
+		}
+		results = append(results, instances...)
+	}
+
+	return results, nil
+}
+
+// Record the retention of the volumes which have one in the targets file, either directly or through the
+// instance they are attached to, where the retention of the volume takes precedence
+func (b *backup_ebs_snapshot) setTargetRetentions(targets []TargetRow) {
+
+	retentions := make(map[string]int64)
+	for _, target := range targets {
+		if target.retention > 0 {
+			retentions[target.id] = target.retention
+		}
+	}
+	for volumeId := range b.inherited {
+		if retention, found := retentions[volumeId]; found == true {
+			b.retentions[volumeId] = retention
+		} else if retention, found := retentions[b.volinstance[volumeId]]; found == true {
+			b.retentions[volumeId] = retention
+		}
+	}
+}
+
+// Return the retention of a snapshot which is the retention of its volume in the targets file if it has one.
+// Copies in other regions have their own retention unless it has not been specified.
+func (b *backup_ebs_snapshot) snapshotRetention(item BackupItem) int64 {
+	_, iscopy := b.copies[item.identifier]
+	if iscopy == true && b.copyretset == true {
+		return b.config.CopyRetention
+	}
+	volumeId := b.snapshots[item.identifier].volumeId
+	if iscopy == true {
+		volumeId = b.copysources[item.identifier]
+	}
+	if retention, found := b.retentions[volumeId]; found == true {
+		return retention
+	}
+	if iscopy == true {
+		return b.config.CopyRetention
+	}
+	return b.config.Retention
+}
+
+// Return the volumes listed in the targets file which are not attached to an instance which is already listed
+func (b *backup_ebs_snapshot) findTargetVolumes(targets []TargetRow, volexcl map[string]string) ([]ProviderAwsEbsVolume, error) {
+
+	var volumeIds []string
+	for _, target := range targets {
+		if strings.HasPrefix(target.id, "vol-") == true {
+			volumeIds = append(volumeIds, target.id)
+		}
+	}
+	if len(volumeIds) == 0 {
+		return nil, nil
+	}
+
+	volumes, attachments, err := ProviderAwsGetEbsVolumesByIds(b.client, volumeIds, volexcl)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ProviderAwsEbsVolume
+	found := make(map[string]bool)
+	for _, curvol := range volumes {
+		found[curvol.volumeId] = true
+		slog.Debugf("Found volume: volumeId=\"%s\" volumeName=\"%s\" instanceId=\"%s\"",
+			curvol.volumeId, curvol.volumeName, attachments[curvol.volumeId])
+		if _, covered := b.inherited[curvol.volumeId]; covered == false {
+			b.addVolume(curvol, ProviderAwsEc2Instance{instanceId: attachments[curvol.volumeId]})
+			results = append(results, curvol)
+		}
+	}
+	for _, volumeId := range volumeIds {
+		if found[volumeId] == false {
+			slog.Warnf("Have not found volume \"%s\" listed in the targets file or it is excluded", volumeId)
+		}
+	}
+
+	return results, nil
+}
+
+func (b *backup_ebs_snapshot) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.volumes))
+
+	// The pre-hook runs right before the snapshots so the metadata it returns matches their point in time
+	inherited := b.inherited
+	if b.config.PreHook != "" && b.config.DryRunCreate == false {
+		var err error
+		if inherited, err = b.runPreHook(); err != nil {
+			return fmt.Errorf("failed to prepare the tags of the new snapshots: %w", err)
+		}
+	}
+
+	// Volumes of a consistency group are all snapshotted together rather than one after the other
+	if b.config.GroupName != "" {
+		err := b.createGroupSnapshots(progress, inherited)
+		if err != nil {
+			return fmt.Errorf("failed to create the snapshots of consistency group \"%s\": %w", b.config.GroupName, err)
+		}
+	} else {
+		err := b.createVolumeSnapshots(progress, inherited)
+		if err != nil {
+			return fmt.Errorf("failed to create the snapshots of the volumes: %w", err)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	// Copy snapshots to other regions if this has been requested
+	if len(b.copyregions) > 0 {
+		err := b.copySnapshots()
+		if err != nil {
+			return fmt.Errorf("failed to copy the snapshots to regions %v: %w", b.copyregions, err)
+		}
+	}
+
+	return nil
+}
+
+// Run the pre-hook and return the tags of the snapshots of each volume including the metadata it returns
+func (b *backup_ebs_snapshot) runPreHook() (map[string]map[string]string, error) {
+
+	hookmeta, err := hookRunPre(b.config.PreHook, b.jobname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run the pre-hook of job \"%s\": %w", b.jobname, err)
+	}
+
+	if err := b.validateSnapshotTags(hookMergeMetadata(b.metadata, hookmeta)); err != nil {
+		return nil, fmt.Errorf("invalid metadata returned by the pre-hook: %w", err)
+	}
+	results := make(map[string]map[string]string)
+	for volumeId, tags := range b.inherited {
+		results[volumeId] = make(map[string]string)
+		for tagkey, tagval := range tags {
+			results[volumeId][tagkey] = tagval
+		}
+		for tagkey, tagval := range awsMetadataToTags(hookmeta) {
+			results[volumeId][tagkey] = tagval
+		}
+	}
+
+	return results, nil
+}
+
+// Return the name of the snapshots of a volume created at a particular time
+func (b *backup_ebs_snapshot) snapshotName(curvol ProviderAwsEbsVolume, curtime time.Time) string {
+	basename := curvol.volumeId
+	if curvol.volumeName != "" {
+		basename = curvol.volumeName
+	}
+	// Long volume names are truncated so the name fits in the description and the Name tag of the snapshot
+	return awsResourceName(basename, fmt.Sprintf("-%s", curtime.Format(time.RFC3339)))
+}
+
+func (b *backup_ebs_snapshot) createVolumeSnapshots(progress *ProgressSummary, inherited map[string]map[string]string) error {
+
+	for _, curvol := range b.volumes {
+		slog.Debugf("Considering backup for volume: volumeId=\"%s\" volumeName=\"%s\" ...", curvol.volumeId, curvol.volumeName)
+		curtime := clockNow()
+		snapname := b.snapshotName(curvol, curtime)
+		snapdate := fmt.Sprintf("%04d%02d%02d", curtime.Year(), curtime.Month(), curtime.Day())
+		snaptime := fmt.Sprintf("%v", curtime.Unix())
+		due, err := b.snapshotDue(curvol)
+		if err != nil {
+			return fmt.Errorf("failed to check if a snapshot of volume \"%s\" is due: %w", curvol.volumeId, err)
+		}
+		if due == false {
+			progress.Itemf("skipped", "Not creating snapshot of volume \"%s\" as its latest snapshot is more recent than its interval", curvol.volumeId)
+			continue
+		}
+		if b.config.DryRunCreate == false {
+			apiBudgetWait("create")
+			starttime := time.Now()
+			snapshotId, err := ProviderAwsCreateEbsSnapshot(b.client, curvol.volumeId, snapname, snapdate, snaptime, b.config.LockMode, b.config.LockDuration, inherited[curvol.volumeId])
+			emfSnapshotOperation(b.jobname, "CreateSnapshot", curvol.volumeId, snapshotId, time.Since(starttime), err)
+			if err != nil {
+				return fmt.Errorf("failed to create snapshot of volume \"%s\": %w", curvol.volumeId, err)
+			}
+			progress.Itemf("created", "Successfully created snapshot \"%s\" of volume \"%s\"", snapshotId, curvol.volumeId)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating snapshot of volume \"%s\"", curvol.volumeId)
+		}
+	}
+
+	return nil
+}
+
+// Check that the tags set on new snapshots are within the limits of EC2 so CreateSnapshot does not fail at runtime.
+// Values of inherited tags are already valid as they are copied from the tags of volumes and instances.
+func (b *backup_ebs_snapshot) validateSnapshotTags(metadata map[string]string) error {
+
+	tagcount := len(awsReservedTags) + len(b.inherittags) + len(metadata)
+	if b.config.GroupName != "" {
+		tagcount += 2 // ConsistencyGroup and ConsistencyGroupId
+	}
+	if tagcount > awsMaxTagsPerResource {
+		return fmt.Errorf("Snapshots would have %d tags including %d inherited tags and %d metadata tags but EC2 accepts at most %d tags",
+			tagcount, len(b.inherittags), len(metadata), awsMaxTagsPerResource)
+	}
+
+	for _, tagkey := range b.inherittags {
+		if err := awsValidateTag(tagkey, ""); err != nil {
+			return fmt.Errorf("Option \"inherit_tags\" is invalid: %v", err)
+		}
+	}
+	for tagkey, tagval := range awsMetadataToTags(metadata) {
+		if err := awsValidateTag(tagkey, tagval); err != nil {
+			return fmt.Errorf("Option \"metadata\" is invalid: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Return the minimum number of hours between two snapshots of a volume, or zero when a snapshot is created at
+// each run. When the volume matches several classes the shortest interval applies so it is never under-protected.
+func (b *backup_ebs_snapshot) snapshotInterval(curvol ProviderAwsEbsVolume) int64 {
+	var result int64
+	for _, interval := range b.intervals {
+		value, found := curvol.volumeTags[interval.tagkey]
+		if found == true && awsTagValueMatch(value, interval.condition) == true && (result == 0 || interval.hours < result) {
+			result = interval.hours
+		}
+	}
+	return result
+}
+
+// Check if a new snapshot of a volume must be created based on the interval of its class and its latest snapshot
+func (b *backup_ebs_snapshot) snapshotDue(curvol ProviderAwsEbsVolume) (bool, error) {
+
+	interval := b.snapshotInterval(curvol)
+	if interval == 0 {
+		return true, nil
+	}
+
+	snapshots, err := ProviderAwsGetEbsSnapshots(b.client, curvol.volumeId)
+	if err != nil {
+		return false, err
+	}
+	var latest int64
+	for _, snapshot := range snapshots {
+		if snapshot.snapshotTime > latest {
+			latest = snapshot.snapshotTime
+		}
+	}
+
+	age := clockNow().Sub(time.Unix(latest, 0))
+	slog.Debugf("Latest snapshot of volume \"%s\" has been created %v ago and its interval is %d hours",
+		curvol.volumeId, age.Truncate(time.Second), interval)
+
+	return age+ebsSnapshotIntervalTolerance >= time.Duration(interval)*time.Hour, nil
+}
+
+// Create snapshots of all volumes of the consistency group as close to simultaneously as possible.
+// Filesystems can be frozen on all instances first so the snapshots are consistent across instances.
+// All snapshots of the group share the same timestamp so they are also rotated together.
+func (b *backup_ebs_snapshot) createGroupSnapshots(progress *ProgressSummary, inherited map[string]map[string]string) error {
+
+	curtime := clockNow()
+	snapdate := fmt.Sprintf("%04d%02d%02d", curtime.Year(), curtime.Month(), curtime.Day())
+	snaptime := fmt.Sprintf("%v", curtime.Unix())
+	groupid := fmt.Sprintf("%s-%s", b.config.GroupName, curtime.UTC().Format("20060102-150405"))
+
+	if b.config.DryRunCreate == true {
+		for _, curvol := range b.volumes {
+			progress.Itemf("skipped", "Dryrun: Not creating snapshot of volume \"%s\" in consistency group \"%s\"", curvol.volumeId, b.config.GroupName)
+		}
+		return nil
+	}
+
+	// Instances to which the volumes of the group are attached
+	var instances []string
+	for _, curvol := range b.volumes {
+		instanceId := b.volinstance[curvol.volumeId]
+		if instanceId != "" && slices.Contains(instances, instanceId) == false {
+			instances = append(instances, instanceId)
+		}
+	}
+
+	if len(b.freezedirs) > 0 && len(instances) > 0 {
+		if err := b.freezeFilesystems(instances); err != nil {
+			b.thawFilesystems(instances)
+			return fmt.Errorf("failed to freeze the file systems of the consistency group: %w", err)
+		}
+	}
+
+	type snapresult struct {
+		snapshotId string
+		err        error
+	}
+	results := make([]snapresult, len(b.volumes))
+
+	// All requests wait on the barrier so they are sent at the same time once all goroutines are running,
+	// and they are not subject to the API budget which would spread them over time
+	barrier := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, curvol := range b.volumes {
+		extratags := map[string]string{
+			"ConsistencyGroup":   b.config.GroupName,
+			"ConsistencyGroupId": groupid,
+		}
+		for tagkey, tagval := range inherited[curvol.volumeId] {
+			if _, ok := extratags[tagkey]; ok == false {
+				extratags[tagkey] = tagval
+			}
+		}
+		wg.Add(1)
+		go func(i int, curvol ProviderAwsEbsVolume, extratags map[string]string) {
+			defer wg.Done()
+			<-barrier
+			snapname := b.snapshotName(curvol, curtime)
+			starttime := time.Now()
+			results[i].snapshotId, results[i].err = ProviderAwsCreateEbsSnapshot(b.client, curvol.volumeId, snapname, snapdate, snaptime, b.config.LockMode, b.config.LockDuration, extratags)
+			emfSnapshotOperation(b.jobname, "CreateSnapshot", curvol.volumeId, results[i].snapshotId, time.Since(starttime), results[i].err)
+		}(i, curvol, extratags)
+	}
+	close(barrier)
+	wg.Wait()
+
+	// The point in time of the snapshots is set when CreateSnapshot() returns so filesystems can be thawed
+	if len(b.freezedirs) > 0 && len(instances) > 0 {
+		b.thawFilesystems(instances)
+	}
+
+	var errs []string
+	for i, curvol := range b.volumes {
+		if results[i].err != nil {
+			errs = append(errs, results[i].err.Error())
+			continue
+		}
+		progress.Itemf("created", "Successfully created snapshot \"%s\" of volume \"%s\" in consistency group \"%s\"", results[i].snapshotId, curvol.volumeId, groupid)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("consistency group %s is incomplete: %s", groupid, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Freeze the filesystems of the consistency group on all instances using SSM. Each instance also
+// schedules a thaw after the timeout so filesystems do not remain frozen if the program is interrupted.
+func (b *backup_ebs_snapshot) freezeFilesystems(instances []string) error {
+	var commands []string
+	for _, mountpoint := range b.freezedirs {
+		commands = append(commands, fmt.Sprintf("nohup sh -c 'sleep %d; fsfreeze --unfreeze \"%s\"' >/dev/null 2>&1 &", b.config.FreezeTimeout, mountpoint))
+	}
+	for _, mountpoint := range b.freezedirs {
+		commands = append(commands, fmt.Sprintf("sync -f '%s' && fsfreeze --freeze '%s'", mountpoint, mountpoint))
+	}
+	slog.Infof("Freezing filesystems %v on instances %v ...", b.freezedirs, instances)
+	timeout := time.Duration(b.config.FreezeTimeout) * time.Second
+	if err := ProviderAwsRunShellCommands(b.ssmclient, instances, commands, timeout); err != nil {
+		return fmt.Errorf("failed to freeze filesystems: %w", err)
+	}
+	return nil
+}
+
+// Thaw the filesystems of the consistency group on all instances. Failures are only reported as
+// warnings as filesystems are thawed anyway when the timeout scheduled on each instance expires.
+func (b *backup_ebs_snapshot) thawFilesystems(instances []string) {
+	var commands []string
+	for _, mountpoint := range b.freezedirs {
+		commands = append(commands, fmt.Sprintf("fsfreeze --unfreeze '%s' || true", mountpoint))
+	}
+	slog.Infof("Thawing filesystems %v on instances %v ...", b.freezedirs, instances)
+	timeout := time.Duration(b.config.FreezeTimeout) * time.Second
+	if err := ProviderAwsRunShellCommands(b.ssmclient, instances, commands, timeout); err != nil {
+		slog.Warnf("Failed to thaw filesystems, they will be thawed after %d seconds: %v", b.config.FreezeTimeout, err)
+	}
+}
+
+// Copy the most recent completed snapshot of each volume to other regions unless it has already been copied.
+// Snapshots can only be copied once completed, so new snapshots get copied during the next execution.
+// The number of copies in progress in each region is limited, and copies which cannot be started because
+// of this limit are deferred. They are started by a later execution as the snapshot is still not copied.
+func (b *backup_ebs_snapshot) copySnapshots() error {
+
+	progress := NewProgressSummary(len(b.volumes) * len(b.copyregions))
+
+	// Number of copies which can still be started in each destination region
+	slots := make(map[string]int)
+	for _, region := range b.copyregions {
+		pending, err := ProviderAwsCountPendingEbsSnapshotCopies(b.copyclients[region])
+		if err != nil {
+			return fmt.Errorf("failed to count the pending copies in region \"%s\": %w", region, err)
+		}
+		slots[region] = b.config.CopyConcurrency - pending
+		slog.Debugf("There are %d copies of snapshots in progress in region \"%s\"", pending, region)
+	}
+
+	for _, curvol := range b.volumes {
+		snapshots, err := ProviderAwsGetEbsSnapshots(b.client, curvol.volumeId)
+		if err != nil {
+			return fmt.Errorf("failed to list the snapshots of volume \"%s\": %w", curvol.volumeId, err)
+		}
+
+		// Find the most recent snapshot of the volume which is completed
+		var latest *ProviderAwsEbsSnapshot
+		for i, snapshot := range snapshots {
+			if snapshot.snapshotState != "completed" {
+				continue
+			}
+			if latest == nil || snapshot.snapshotTime > latest.snapshotTime {
+				latest = &snapshots[i]
+			}
+		}
+		if latest == nil {
+			slog.Debugf("There is no completed snapshot to copy for volume \"%s\"", curvol.volumeId)
+			continue
+		}
+
+		for _, region := range b.copyregions {
+			copies, err := ProviderAwsGetEbsSnapshotCopies(b.copyclients[region], curvol.volumeId)
+			if err != nil {
+				return fmt.Errorf("failed to list the copies of the snapshots of volume \"%s\" in region \"%s\": %w", curvol.volumeId, region, err)
+			}
+			copied := false
+			for _, snapcopy := range copies {
+				if snapcopy.sourceSnapshotId == latest.snapshotId {
+					copied = true
+				}
+			}
+			if copied == true {
+				slog.Debugf("Snapshot \"%s\" has already been copied to region \"%s\"", latest.snapshotId, region)
+				continue
+			}
+			if slots[region] <= 0 {
+				progress.Itemf("deferred", "Deferring copy of snapshot \"%s\" of volume \"%s\" to region \"%s\" as %d copies are in progress",
+					latest.snapshotId, curvol.volumeId, region, b.config.CopyConcurrency)
+				continue
+			}
+			if b.config.DryRunCreate == false {
+				apiBudgetWait("create")
+				starttime := time.Now()
+				copyId, err := ProviderAwsCopyEbsSnapshot(b.copyclients[region], b.config.AwsRegion, *latest)
+				emfSnapshotOperation(b.jobname, "CopySnapshot", curvol.volumeId, latest.snapshotId, time.Since(starttime), err)
+				if errors.Is(err, errAwsCopyLimitExceeded) == true {
+					// Copies started by other programs also count towards the limit of the region
+					slots[region] = 0
+					progress.Itemf("deferred", "Deferring copy of snapshot \"%s\" of volume \"%s\" to region \"%s\" as the limit has been reached",
+						latest.snapshotId, curvol.volumeId, region)
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to copy snapshot \"%s\" of volume \"%s\" to region \"%s\": %w", latest.snapshotId, curvol.volumeId, region, err)
+				}
+				progress.Itemf("copied", "Successfully copied snapshot \"%s\" of volume \"%s\" to \"%s\" in region \"%s\"",
+					latest.snapshotId, curvol.volumeId, copyId, region)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not copying snapshot \"%s\" of volume \"%s\" to region \"%s\"",
+					latest.snapshotId, curvol.volumeId, region)
+			}
+			slots[region]--
+		}
+	}
+
+	progress.Logf("copies of snapshots")
+
+	return nil
+}
+
+// Creating snapshots does not use the snapshots found when listing, but copies are only interleaved with
+// deletions when there are no copies as the latest snapshot could be deleted while it is being copied
+func (b *backup_ebs_snapshot) InterleavePhases() bool {
+	if len(b.copyregions) > 0 {
+		slog.Infof("Not interleaving phases as snapshots are copied to other regions")
+		return false
+	}
+	return true
+}
+
+func (b *backup_ebs_snapshot) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	// Enumerate volumes and their snapshots to get a list of relevant snapshots
+	for _, curvol := range b.volumes {
+		bkpitems, err := b.listVolumeBackups(curvol)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, bkpitems...)
+	}
+
+	b.logRecoveryObjectives()
+
+	// Reorder the snapshots by names alphabetically
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].description < results[j].description
+	})
+
+	return results, nil
+}
+
+// Process the snapshots one volume at a time when this has been enabled so the snapshots of jobs
+// covering many volumes are never all in memory at once, or all snapshots at once otherwise
+func (b *backup_ebs_snapshot) StreamBackups(process func(bkpitems []BackupItem) error) error {
+
+	if b.config.StreamSnapshots == false {
+		bkpitems, err := b.ListBackups()
+		if err != nil {
+			return err
+		}
+		return process(bkpitems)
+	}
+
+	progress := NewProgressSummary(len(b.volumes))
+
+	for _, curvol := range b.volumes {
+		bkpitems, err := b.listVolumeBackups(curvol)
+		if err != nil {
+			return err
+		}
+		b.logVolumeRecoveryObjectives(progress, curvol)
+		sort.SliceStable(bkpitems, func(i, j int) bool {
+			return bkpitems[i].description < bkpitems[j].description
+		})
+		if err := process(bkpitems); err != nil {
+			return err
+		}
+		// Details about these snapshots are no longer needed once they have been processed
+		for _, item := range bkpitems {
+			delete(b.snapshots, item.identifier)
+			delete(b.copies, item.identifier)
+			delete(b.copysources, item.identifier)
+		}
+	}
+
+	progress.Logf("recovery objectives")
+
+	return nil
+}
+
+// Return the snapshots of a volume and the copies of these snapshots in other regions
+func (b *backup_ebs_snapshot) listVolumeBackups(curvol ProviderAwsEbsVolume) ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing snapshots from volume: volumeId=\"%s\" ...", curvol.volumeId)
+
+	snapshots, err := ProviderAwsGetEbsSnapshots(b.client, curvol.volumeId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		item := BackupItem{}
+		item.identifier = snapshot.snapshotId
+		item.description = snapshot.snapshotDesc
+		item.timestamp = snapshot.snapshotTime
+		item.size = snapshot.volumeSize * 1024 * 1024 * 1024
+		item.metadata = snapshot.metadata
+		b.snapshots[snapshot.snapshotId] = snapshot
+		results = append(results, item)
+		snaptime := time.Unix(snapshot.snapshotTime, 0)
+		slog.Debugf("Found snapshot: id=\"%s\" desc=\"%s\" created=\"%v\" vol=\"%s\" metadata=%v",
+			snapshot.snapshotId, snapshot.snapshotDesc, snaptime.Format(time.RFC3339), snapshot.volumeId, snapshot.metadata)
+	}
+
+	// Include copies of the snapshots which have been made in other regions
+	for _, region := range b.copyregions {
+		slog.Debugf("Listing copies of snapshots from volume: volumeId=\"%s\" region=\"%s\" ...", curvol.volumeId, region)
+
+		copies, err := ProviderAwsGetEbsSnapshotCopies(b.copyclients[region], curvol.volumeId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, snapcopy := range copies {
+			item := BackupItem{}
+			item.identifier = snapcopy.snapshotId
+			item.description = snapcopy.snapshotDesc
+			item.timestamp = snapcopy.snapshotTime
+			item.size = snapcopy.volumeSize * 1024 * 1024 * 1024
+			item.metadata = snapcopy.metadata
+			b.copies[snapcopy.snapshotId] = region
+			b.copysources[snapcopy.snapshotId] = curvol.volumeId
+			b.snapshots[snapcopy.snapshotId] = snapcopy
+			results = append(results, item)
+			snaptime := time.Unix(snapcopy.snapshotTime, 0)
+			slog.Debugf("Found copy of snapshot: id=\"%s\" desc=\"%s\" created=\"%v\" vol=\"%s\" region=\"%s\"",
+				snapcopy.snapshotId, snapcopy.snapshotDesc, snaptime.Format(time.RFC3339), snapcopy.volumeId, region)
+		}
+	}
+
+	return results, nil
+}
+
+// Log the effective recovery point objective of each volume based on its most recent completed snapshot
+// and an estimate of the recovery time objective if the restore throughput has been configured
+func (b *backup_ebs_snapshot) logRecoveryObjectives() {
+
+	progress := NewProgressSummary(len(b.volumes))
+
+	for _, curvol := range b.volumes {
+		b.logVolumeRecoveryObjectives(progress, curvol)
+	}
+
+	progress.Logf("recovery objectives")
+}
+
+// Log the recovery objectives of a single volume based on the snapshots which have been listed
+func (b *backup_ebs_snapshot) logVolumeRecoveryObjectives(progress *ProgressSummary, curvol ProviderAwsEbsVolume) {
+
+	curtime := clockNow().Unix()
+
+	var latest *ProviderAwsEbsSnapshot
+	for _, snapshot := range b.snapshots {
+		if _, iscopy := b.copies[snapshot.snapshotId]; iscopy == true {
+			continue
+		}
+		if snapshot.volumeId != curvol.volumeId || snapshot.snapshotState != "completed" {
+			continue
+		}
+		if latest == nil || snapshot.snapshotTime > latest.snapshotTime {
+			snapcopy := snapshot
+			latest = &snapcopy
+		}
+	}
+	if latest == nil {
+		if b.config.RpoMaxHours > 0 {
+			slog.Warnf("Volume \"%s\" has no completed snapshot so it cannot be recovered", curvol.volumeId)
+		}
+		progress.Itemf("unprotected", "Recovery objectives of volume \"%s\": rpo=none", curvol.volumeId)
+		return
+	}
+
+	rpo := time.Duration(curtime-latest.snapshotTime) * time.Second
+	rto := "unknown"
+	if b.config.RestoreMbps > 0 {
+		seconds := latest.volumeSize * 1024 * 8 / b.config.RestoreMbps
+		rto = (time.Duration(seconds) * time.Second).String()
+	}
+	if b.config.RpoMaxHours > 0 && rpo > time.Duration(b.config.RpoMaxHours)*time.Hour {
+		slog.Warnf("Volume \"%s\" exceeds its recovery point objective: rpo=%v rpo_max_hours=%d",
+			curvol.volumeId, rpo.Truncate(time.Minute), b.config.RpoMaxHours)
+	}
+	progress.Itemf("measured", "Recovery objectives of volume \"%s\": rpo=%v rto=%s size=%dGB snapshot=\"%s\"",
+		curvol.volumeId, rpo.Truncate(time.Minute), rto, latest.volumeSize, latest.snapshotId)
+}
+
+// Decide which snapshots must be deleted and return the reason for each of these snapshots. Recent snapshots
+// are counted by keep_minimum and max_storage_gb like the other snapshots but they are never deleted. The
+// budget charges the size of the volume to each snapshot, as the API does not report the blocks stored by
+// incremental snapshots, so it over-estimates the storage used and can delete more snapshots than needed.
+func (b *backup_ebs_snapshot) selectSnapshotsToDelete(bkpitems []BackupItem, recent map[string]bool) map[string]string {
+
+	results := make(map[string]string)
+	protected := make(map[string]bool)
+	for identifier := range recent {
+		protected[identifier] = true
+	}
+	curtime := clockNow().Unix()
+
+	// Sort snapshots from the most recent to the oldest
+	sorted := make([]BackupItem, len(bkpitems))
+	copy(sorted, bkpitems)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].timestamp > sorted[j].timestamp
+	})
+
+	// The most recent snapshots of each volume in each region are always kept
+	counters := make(map[string]int)
+	for _, item := range sorted {
+		location := fmt.Sprintf("%s/%s", b.copies[item.identifier], b.snapshots[item.identifier].volumeId)
+		counters[location]++
+		if counters[location] <= b.config.KeepMinimum {
+			protected[item.identifier] = true
+		}
+	}
+
+	// Delete snapshots which are older than the retention period
+	for _, item := range sorted {
+		retention := b.snapshotRetention(item)
+		if (curtime-item.timestamp)/86400 > retention && protected[item.identifier] == false {
+			results[item.identifier] = "retention"
+		}
+	}
+
+	// Delete the oldest snapshots in the region of the job until the storage is within the budget
+	if b.config.MaxStorageGb > 0 {
+		var total, budgeted int64
+		for _, item := range sorted {
+			_, iscopy := b.copies[item.identifier]
+			if _, deleted := results[item.identifier]; iscopy == false && deleted == false {
+				total += b.snapshots[item.identifier].volumeSize
+			}
+		}
+		for i := len(sorted) - 1; i >= 0 && total > b.config.MaxStorageGb; i-- {
+			item := sorted[i]
+			_, iscopy := b.copies[item.identifier]
+			_, deleted := results[item.identifier]
+			if iscopy == true || deleted == true || protected[item.identifier] == true {
+				continue
+			}
+			results[item.identifier] = "budget"
+			total -= b.snapshots[item.identifier].volumeSize
+			budgeted++
+		}
+		if budgeted > 0 {
+			slog.Infof("Option max_storage_gb=%d selects %d snapshots for deletion in addition to the retention rules, "+
+				"based on an estimate which charges the size of the volume to each incremental snapshot", b.config.MaxStorageGb, budgeted)
+		}
+		if total > b.config.MaxStorageGb {
+			slog.Warnf("Snapshots use %dGB which exceeds max_storage_gb=%d but the remaining snapshots are protected by keep_minimum=%d",
+				total, b.config.MaxStorageGb, b.config.KeepMinimum)
+		}
+	}
+
+	return results
+}
+
+func (b *backup_ebs_snapshot) DeleteOldBackups(bkpitems []BackupItem) error {
+	return b.DeleteOldBackupsExcept(bkpitems, nil)
+}
+
+func (b *backup_ebs_snapshot) DeleteOldBackupsExcept(bkpitems []BackupItem, protected map[string]bool) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	curtime := clockNow().Unix()
+	selected := b.selectSnapshotsToDelete(bkpitems, protected)
+
+	for _, item := range bkpitems {
+		// Copies in other regions have their own retention
+		retention := b.snapshotRetention(item)
+		client := b.client
+		if region, ok := b.copies[item.identifier]; ok == true {
+			client = b.copyclients[region]
+		}
+		snapshotAge := (curtime - item.timestamp) / 86400
+		reason, snapDelete := selected[item.identifier]
+		slog.Debugf("Considering deletion of snapshot: id=\"%s\" desc=\"%s\" age=%v retention=%v ...",
+			item.identifier, item.description, snapshotAge, retention)
+		if snapDelete == true {
+			if b.config.DryRunDelete == false {
+				apiBudgetWait("delete")
+				starttime := time.Now()
+				err := ProviderAwsDeleteEbsSnapshot(client, item.identifier)
+				emfSnapshotOperation(b.jobname, "DeleteSnapshot", b.snapshots[item.identifier].volumeId, item.identifier, time.Since(starttime), err)
+				if err != nil {
+					return fmt.Errorf("failed to delete snapshot \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted snapshot: id=\"%s\" desc=\"%s\" age=%v retention=%v reason=%s", item.identifier, item.description, snapshotAge, retention, reason)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting snapshot: id=\"%s\" desc=\"%s\" age=%d retention=%v reason=%s", item.identifier, item.description, snapshotAge, retention, reason)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping snapshot: id=\"%s\" desc=\"%s\" age=%d retention=%d", item.identifier, item.description, snapshotAge, retention)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
+
+// Return the volumes found by the jobs executed by this process so they can be sent to the parent process
+func ebsVolumesSummary() (map[string]string, []string) {
+
+	var covered []string
+	for volumeId := range ebsVolumesCovered {
+		covered = append(covered, volumeId)
+	}
+	sort.Strings(covered)
+
+	return ebsVolumesAttached, covered
+}
+
+// Add the volumes found by a job executed in a subprocess to the volumes found by all jobs
+func ebsVolumesMerge(attached map[string]string, covered []string) {
+	for volumeId, instanceId := range attached {
+		ebsVolumesAttached[volumeId] = instanceId
+	}
+	for _, volumeId := range covered {
+		ebsVolumesCovered[volumeId] = true
+	}
+}
+
+// Return the volumes attached to instances in the scope of jobs which have not been covered by any job
+func ebsUncoveredVolumes() []string {
+
+	var volumeIds []string
+
+	for volumeId := range ebsVolumesAttached {
+		if ebsVolumesCovered[volumeId] == false {
+			volumeIds = append(volumeIds, volumeId)
+		}
+	}
+	sort.Strings(volumeIds)
+
+	return volumeIds
+}
+
+// Warn about volumes attached to instances in the scope of jobs which have not been covered by any job
+func ebsWarnUncoveredVolumes() {
+	for _, volumeId := range ebsUncoveredVolumes() {
+		slog.Warnf("Volume \"%s\" attached to instance \"%s\" is not covered by any job as it does not match volume_tags or it is excluded",
+			volumeId, ebsVolumesAttached[volumeId])
+	}
+}
+
+func (b *backup_ebs_snapshot) RetagBackups(bkpitems []BackupItem, tags map[string]string) error {
+
+	// Copies of snapshots must be tagged in the region where they are stored
+	regionIds := make(map[string][]string)
+	for _, item := range bkpitems {
+		region := b.copies[item.identifier]
+		regionIds[region] = append(regionIds[region], item.identifier)
+	}
+
+	for region, snapshotIds := range regionIds {
+		client := b.client
+		if region != "" {
+			client = b.copyclients[region]
+		}
+		if b.config.DryRunCreate == true || b.config.DryRunDelete == true {
+			slog.Infof("Dryrun: Not tagging %d snapshots with %v", len(snapshotIds), tags)
+			continue
+		}
+		if err := ProviderAwsTagEc2Resources(client, snapshotIds, tags); err != nil {
+			return fmt.Errorf("failed to tag %d snapshots: %w", len(snapshotIds), err)
+		}
+		slog.Infof("Successfully tagged %d snapshots with %v", len(snapshotIds), tags)
+	}
+
+	return nil
+}
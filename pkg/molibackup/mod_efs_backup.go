@@ -0,0 +1,342 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/backup"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigEfsBackup struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	BackupVault     string `koanf:"backup_vault"`
+	IamRoleArn      string `koanf:"iam_role_arn"`
+	FilesystemTags  any    `koanf:"filesystem_tags"`
+	WaitCompletion  bool   `koanf:"wait_completion"`
+	WaitTimeout     int64  `koanf:"wait_timeout"`
+}
+
+type backup_efs_backup struct {
+	config      JobConfigEfsBackup
+	cfg         aws.Config
+	client      *backup.Client
+	filesystems []string
+	vaults      map[string]string
+}
+
+// Type of resource used to find EFS file systems using the tagging API
+const efsResourceType = "elasticfilesystem:file-system"
+
+var validateConfigEfsBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"efs-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "backup_vault",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "iam_role_arn",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "filesystem_tags",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "wait_completion",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "wait_timeout",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "120",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_efs_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigEfsBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if len(configTagsToMap(b.config.FilesystemTags)) == 0 {
+		return fmt.Errorf("Option \"filesystem_tags\" must contain at least one tag")
+	}
+
+	if b.config.WaitTimeout <= 0 {
+		return fmt.Errorf("Option \"wait_timeout\" must be a valid number greater than 0")
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- BackupVault=\"%v\"", b.config.BackupVault)
+	slog.Debugf("- IamRoleArn=\"%v\"", b.config.IamRoleArn)
+	slog.Debugf("- FilesystemTags=\"%v\"", b.config.FilesystemTags)
+	slog.Debugf("- WaitCompletion=%v", b.config.WaitCompletion)
+	slog.Debugf("- WaitTimeout=%v", b.config.WaitTimeout)
+
+	return nil
+}
+
+func (b *backup_efs_backup) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewBackupClient(b.cfg)
+	b.vaults = make(map[string]string)
+
+	// Find list of all EFS file systems that have the tags specified in the configuration
+	fstags := configTagsToMap(b.config.FilesystemTags)
+	slog.Debugf("Listing EFS file systems based on filesystem_tags=\"%v\" ...", fstags)
+	b.filesystems, err = ProviderAwsGetTaggedResources(ProviderAwsNewTaggingClient(b.cfg), efsResourceType, fstags)
+	if err != nil {
+		return fmt.Errorf("failed to find the file systems to back up: %w", err)
+	}
+	for _, fsarn := range b.filesystems {
+		slog.Debugf("Found file system: arn=\"%s\"", fsarn)
+	}
+	if len(b.filesystems) == 0 {
+		slog.Warnf("Have not found any EFS file system matching the conditions")
+	}
+
+	return nil
+}
+
+func (b *backup_efs_backup) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.filesystems))
+
+	for _, fsarn := range b.filesystems {
+		slog.Debugf("Considering backup for file system: arn=\"%s\" ...", fsarn)
+		curtime := clockNow()
+		bkpname := fmt.Sprintf("%s-%s", path.Base(fsarn), curtime.Format(time.RFC3339))
+		bkpdate := fmt.Sprintf("%04d%02d%02d", curtime.Year(), curtime.Month(), curtime.Day())
+		bkptime := fmt.Sprintf("%v", curtime.Unix())
+		if b.config.DryRunCreate == false {
+			jobId, err := ProviderAwsStartBackupJob(b.client, b.config.BackupVault, b.config.IamRoleArn, fsarn, bkpname, bkpdate, bkptime)
+			if err != nil {
+				return fmt.Errorf("failed to start backup job for file system \"%s\": %w", fsarn, err)
+			}
+			progress.Itemf("started", "Successfully started backup job \"%s\" for file system \"%s\"", jobId, fsarn)
+			if b.config.WaitCompletion == true {
+				timeout := time.Duration(b.config.WaitTimeout) * time.Minute
+				if err := ProviderAwsWaitBackupJob(b.client, jobId, timeout); err != nil {
+					return fmt.Errorf("failed to wait for backup job \"%s\" of file system \"%s\": %w", jobId, fsarn, err)
+				}
+				progress.Itemf("completed", "Backup job \"%s\" for file system \"%s\" has completed", jobId, fsarn)
+			}
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not starting backup job for file system \"%s\"", fsarn)
+		}
+	}
+
+	progress.Logf("backup jobs")
+
+	return nil
+}
+
+func (b *backup_efs_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	// Enumerate file systems and their recovery points to get a list of relevant backups
+	for _, fsarn := range b.filesystems {
+		slog.Debugf("Listing recovery points of file system: arn=\"%s\" ...", fsarn)
+
+		recpoints, err := ProviderAwsGetRecoveryPoints(b.client, fsarn)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, recpoint := range recpoints {
+			item := BackupItem{}
+			item.identifier = recpoint.recoveryPointArn
+			item.description = fmt.Sprintf("%s/%s", path.Base(fsarn), recpoint.backupVaultName)
+			item.timestamp = recpoint.creationTime
+			b.vaults[recpoint.recoveryPointArn] = recpoint.backupVaultName
+			results = append(results, item)
+			rptime := time.Unix(recpoint.creationTime, 0)
+			slog.Debugf("Found recovery point: arn=\"%s\" vault=\"%s\" created=\"%v\" resource=\"%s\"",
+				recpoint.recoveryPointArn, recpoint.backupVaultName, rptime.Format(time.RFC3339), recpoint.resourceArn)
+		}
+	}
+
+	// Order recovery points by creation time
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (b *backup_efs_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		backupAge := (curtime - item.timestamp) / 86400
+		bkpDelete := backupAge > retention
+		slog.Debugf("Considering deletion of recovery point: arn=\"%s\" desc=\"%s\" age=%v retention=%v ...",
+			item.identifier, item.description, backupAge, retention)
+		if bkpDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderAwsDeleteRecoveryPoint(b.client, b.vaults[item.identifier], item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete recovery point \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted recovery point: arn=\"%s\" desc=\"%s\" age=%v retention=%v", item.identifier, item.description, backupAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting recovery point: arn=\"%s\" desc=\"%s\" age=%d retention=%v", item.identifier, item.description, backupAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping recovery point: arn=\"%s\" desc=\"%s\" age=%d retention=%d", item.identifier, item.description, backupAge, retention)
+		}
+	}
+
+	progress.Logf("recovery points")
+
+	return nil
+}
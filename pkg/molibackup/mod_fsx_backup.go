@@ -0,0 +1,251 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/fsx"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigFsxBackup struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	FilesystemId    string `koanf:"filesystem_id"`
+	FilesystemTags  any    `koanf:"filesystem_tags"`
+}
+
+type backup_fsx_backup struct {
+	snapshotRotation
+	config  JobConfigFsxBackup
+	cfg     aws.Config
+	client  *fsx.Client
+	targets map[string]ProviderAwsFsxTarget
+}
+
+var validateConfigFsxBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"fsx-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "filesystem_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "filesystem_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_fsx_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigFsxBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.FilesystemId != "" {
+		matched, _ := regexp.MatchString("^fs-[0-9a-f]{8,21}$", b.config.FilesystemId)
+		if matched == false {
+			return fmt.Errorf("Option \"filesystem_id\" must be in the \"fs-0123456789abcdef0\" format")
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- FilesystemId=\"%v\"", b.config.FilesystemId)
+	slog.Debugf("- FilesystemTags=\"%v\"", b.config.FilesystemTags)
+
+	return nil
+}
+
+func (b *backup_fsx_backup) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewFsxClient(b.cfg)
+	b.snapshotRotation = snapshotRotation{noun: "backup", timeformat: time.RFC3339, retention: b.config.Retention,
+		dryruncreate: b.config.DryRunCreate, dryrundelete: b.config.DryRunDelete, provider: b}
+
+	// Find list of all file systems and volumes that match the conditions specified in the configuration
+	fstags := configTagsToMap(b.config.FilesystemTags)
+	slog.Debugf("Listing FSx file systems based on filesystem_id=\"%s\" and filesystem_tags=\"%v\" ...", b.config.FilesystemId, fstags)
+	targets, err := ProviderAwsGetFsxTargets(b.client, b.config.FilesystemId, fstags)
+	if err != nil {
+		return fmt.Errorf("failed to find the file systems to back up: %w", err)
+	}
+	b.targets = make(map[string]ProviderAwsFsxTarget)
+	for _, target := range targets {
+		slog.Debugf("Found file system: fileSystemId=\"%s\" type=\"%s\" volumeId=\"%s\"", target.fileSystemId, target.fileSystemType, target.volumeId)
+		kind := "file system"
+		if target.volumeId != "" {
+			kind = "volume"
+		}
+		b.targets[target.name()] = target
+		b.sources = append(b.sources, snapshotSource{id: target.name(), kind: kind, prefix: target.name()})
+	}
+	if len(targets) == 0 {
+		slog.Warnf("Have not found any FSx file system matching the conditions")
+	}
+
+	return nil
+}
+
+func (b *backup_fsx_backup) CreateSnapshot(source snapshotSource, bkpname string, bkpdate string, bkptime string) (string, error) {
+	return ProviderAwsCreateFsxBackup(b.client, b.targets[source.id], bkpname, bkpdate, bkptime)
+}
+
+func (b *backup_fsx_backup) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	var results []BackupItem
+
+	backups, err := ProviderAwsGetFsxBackups(b.client, b.targets[source.id])
+	if err != nil {
+		return nil, err
+	}
+
+	// Backups are ordered by source and then by creation time as they have the name of their source
+	for _, backup := range backups {
+		item := BackupItem{}
+		item.identifier = backup.backupId
+		item.description = source.id
+		item.timestamp = backup.backupTime
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+func (b *backup_fsx_backup) DeleteSnapshot(item BackupItem) error {
+	return ProviderAwsDeleteFsxBackup(b.client, item.identifier)
+}
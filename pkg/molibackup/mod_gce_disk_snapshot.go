@@ -0,0 +1,271 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gookit/slog"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigGceDiskSnapshot struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	Project         string `koanf:"project"`
+	Zone            string `koanf:"zone"`
+	CredentialsFile string `koanf:"credentials_file"`
+	InstanceName    string `koanf:"instance_name"`
+	InstanceLabels  any    `koanf:"instance_labels"`
+}
+
+type backup_gce_disk_snapshot struct {
+	snapshotRotation
+	config  JobConfigGceDiskSnapshot
+	service *compute.Service
+	disks   map[string]ProviderGcpDisk
+}
+
+// Maximum length of the name of a snapshot in Compute Engine
+const gceSnapshotNameMaxLength = 63
+
+// Layout of the time at the end of the names of snapshots
+const gceSnapshotTimeFormat = "20060102-150405"
+
+var validateConfigGceDiskSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"gce-disk-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "project",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "zone",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "credentials_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_name",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_labels",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_gce_disk_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigGceDiskSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	matched, _ := regexp.MatchString("^[a-z][-a-z0-9]{4,28}[a-z0-9]$", b.config.Project)
+	if matched == false {
+		return fmt.Errorf("Option \"project\" must be a valid project ID")
+	}
+
+	matched, _ = regexp.MatchString("^[a-z]+-[a-z]+[0-9]+-[a-z]$", b.config.Zone)
+	if matched == false {
+		return fmt.Errorf("Option \"zone\" must be a valid zone such as \"europe-west1-b\"")
+	}
+
+	if b.config.InstanceName != "" {
+		matched, _ := regexp.MatchString("^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$", b.config.InstanceName)
+		if matched == false {
+			return fmt.Errorf("Option \"instance_name\" must be a valid instance name")
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- Project=\"%v\"", b.config.Project)
+	slog.Debugf("- Zone=\"%v\"", b.config.Zone)
+	slog.Debugf("- CredentialsFile=\"%v\"", b.config.CredentialsFile)
+	slog.Debugf("- InstanceName=\"%v\"", b.config.InstanceName)
+	slog.Debugf("- InstanceLabels=\"%v\"", b.config.InstanceLabels)
+
+	return nil
+}
+
+func (b *backup_gce_disk_snapshot) InitialiseModule() error {
+
+	var err error
+
+	// Create a client using a service account key file if it has been provided in the configuration
+	b.service, err = ProviderGcpNewComputeService(b.config.CredentialsFile)
+	if err != nil {
+		return fmt.Errorf("failed to create the Compute Engine client: %w", err)
+	}
+
+	b.snapshotRotation = snapshotRotation{noun: "snapshot", timeformat: gceSnapshotTimeFormat, retention: b.config.Retention,
+		dryruncreate: b.config.DryRunCreate, dryrundelete: b.config.DryRunDelete, provider: b}
+
+	// Find list of all instances that match the conditions specified in the configuration
+	inslabels := configTagsToMap(b.config.InstanceLabels)
+	slog.Debugf("Listing instances based on instance_name=\"%s\" and instance_labels=\"%v\" ...", b.config.InstanceName, inslabels)
+	instances, err := ProviderGcpGetInstances(b.service, b.config.Project, b.config.Zone, b.config.InstanceName, inslabels)
+	if err != nil {
+		return fmt.Errorf("failed to find the instances of project \"%s\" in zone \"%s\": %w", b.config.Project, b.config.Zone, err)
+	}
+	b.disks = make(map[string]ProviderGcpDisk)
+	for _, instance := range instances {
+		slog.Debugf("Found instance: instanceName=\"%s\" disks=%d", instance.instanceName, len(instance.disks))
+		for _, disk := range instance.disks {
+			// Disks attached to several instances in read-only mode are only snapshotted once
+			if _, found := b.disks[disk.diskName]; found == true {
+				continue
+			}
+			b.disks[disk.diskName] = disk
+			b.sources = append(b.sources, snapshotSource{id: disk.diskName, kind: "disk", prefix: gceSnapshotPrefix(disk.diskName)})
+		}
+	}
+	if len(instances) == 0 {
+		slog.Warnf("Have not found any instance matching the conditions")
+	}
+
+	return nil
+}
+
+// Return the beginning of the names of the snapshots of a disk so the names fit in the limit of their length
+func gceSnapshotPrefix(diskName string) string {
+	maxlength := gceSnapshotNameMaxLength - len(gceSnapshotTimeFormat) - 1
+	if len(diskName) > maxlength {
+		return strings.TrimRight(diskName[:maxlength], "-")
+	}
+	return diskName
+}
+
+func (b *backup_gce_disk_snapshot) CreateSnapshot(source snapshotSource, snapname string, snapdate string, snaptime string) (string, error) {
+	if err := ProviderGcpCreateDiskSnapshot(b.service, b.config.Project, b.config.Zone, b.disks[source.id], snapname, snapdate, snaptime); err != nil {
+		return "", err
+	}
+	return snapname, nil
+}
+
+func (b *backup_gce_disk_snapshot) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	var results []BackupItem
+
+	snapshots, err := ProviderGcpGetDiskSnapshots(b.service, b.config.Project, b.disks[source.id])
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot names start with the disk name followed by the date
+	for _, snapshot := range snapshots {
+		item := BackupItem{}
+		item.identifier = snapshot.snapshotName
+		item.description = snapshot.snapshotName
+		item.timestamp = snapshot.snapshotTime
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+func (b *backup_gce_disk_snapshot) DeleteSnapshot(item BackupItem) error {
+	return ProviderGcpDeleteSnapshot(b.service, b.config.Project, item.identifier)
+}
@@ -0,0 +1,412 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigGitMirror struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	GitPath         string `koanf:"git_path"`
+	Repositories    any    `koanf:"repositories"`
+	MirrorDir       string `koanf:"mirror_dir"`
+	SshKeyFile      string `koanf:"ssh_key_file"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_git_mirror struct {
+	config       JobConfigGitMirror
+	cfg          aws.Config
+	git          *ProviderGit
+	destination  BackupDestination
+	fileprefix   string
+	repositories map[string]string
+}
+
+// Extension of the bundles which can be cloned directly with "git clone <bundle>"
+const gitBundleExtension = ".bundle"
+
+var validateConfigGitMirror = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"git-mirror"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "git_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "git",
+		allowedval: nil,
+	},
+	{
+		entryname:  "repositories",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "mirror_dir",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "ssh_key_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_git_mirror) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigGitMirror); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// Repositories are either a list of URLs named after their last path component or a map of names and URLs
+	b.repositories = make(map[string]string)
+	if _, ismap := b.config.Repositories.(map[string]any); ismap == true {
+		b.repositories = configTagsToMap(b.config.Repositories)
+	} else {
+		for _, url := range configListToStrings(b.config.Repositories) {
+			name := gitRepositoryName(url)
+			if _, found := b.repositories[name]; found == true {
+				return fmt.Errorf("Option \"repositories\" contains several repositories named \"%s\" which must be given different names with a map", name)
+			}
+			b.repositories[name] = url
+		}
+	}
+	if len(b.repositories) == 0 {
+		return fmt.Errorf("Option \"repositories\" must contain at least one repository")
+	}
+	for name, url := range b.repositories {
+		if matched, _ := regexp.MatchString("^[a-zA-Z0-9_.-]+$", name); matched == false || strings.HasPrefix(name, ".") {
+			return fmt.Errorf("Option \"repositories\" contains an invalid name \"%s\" which must only contain letters, digits, dots, dashes and underscores", name)
+		}
+		if strings.TrimSpace(url) == "" {
+			return fmt.Errorf("Option \"repositories\" contains an empty URL for repository \"%s\"", name)
+		}
+	}
+	if filepath.IsAbs(b.config.MirrorDir) == false {
+		return fmt.Errorf("Option \"mirror_dir\" must be an absolute path")
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("git-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- GitPath=\"%v\"", b.config.GitPath)
+	slog.Debugf("- Repositories=\"%v\"", b.repositoryNames())
+	slog.Debugf("- MirrorDir=\"%v\"", b.config.MirrorDir)
+	slog.Debugf("- SshKeyFile=\"%v\"", b.config.SshKeyFile)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_git_mirror) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	b.git = ProviderGitNewClient(b.config.GitPath, b.config.SshKeyFile)
+
+	return nil
+}
+
+// Return the name of a repository from its URL such as "project" for "git@example.com:group/project.git"
+func gitRepositoryName(url string) string {
+	name := strings.TrimSuffix(strings.TrimRight(url, "/"), ".git")
+	if index := strings.LastIndexAny(name, "/:"); index >= 0 {
+		name = name[index+1:]
+	}
+	return name
+}
+
+// Return the names of the repositories sorted alphabetically so they are always processed in the same order
+func (b *backup_git_mirror) repositoryNames() []string {
+	var names []string
+	for name := range b.repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Return the name of the repository and the time of a bundle from its file name
+func (b *backup_git_mirror) parseBundleName(filename string) (string, time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false || strings.HasSuffix(filename, gitBundleExtension) == false {
+		return "", time.Time{}, false
+	}
+	name, datetime, found := gitCutLast(strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), gitBundleExtension), 15)
+	if found == false {
+		return "", time.Time{}, false
+	}
+	bundletime, err := time.Parse("20060102-150405", datetime)
+	return name, bundletime, err == nil
+}
+
+// Split a string before its last characters which must be preceded by a dash
+func gitCutLast(value string, length int) (string, string, bool) {
+	if len(value) < length+2 || value[len(value)-length-1] != '-' {
+		return "", "", false
+	}
+	return value[:len(value)-length-1], value[len(value)-length:], true
+}
+
+// Update the mirror of each repository and create a bundle of each of them. A repository which fails
+// does not prevent the other repositories from being backed up and the job fails once all are done.
+func (b *backup_git_mirror) CreateBackup() error {
+
+	var errmsgs []string
+
+	curtime := clockNow()
+	progress := NewProgressSummary(len(b.repositories))
+
+	for _, name := range b.repositoryNames() {
+		filename := fmt.Sprintf("%s%s-%s%s", b.fileprefix, name, curtime.UTC().Format("20060102-150405"), gitBundleExtension)
+
+		if b.config.DryRunCreate == true {
+			progress.Itemf("skipped", "Dryrun: Not creating bundle \"%s\" in \"%s\"", filename, b.destination)
+			continue
+		}
+
+		directory := filepath.Join(b.config.MirrorDir, name+".git")
+		slog.Debugf("Updating mirror of repository \"%s\" in \"%s\" ...", name, directory)
+		if err := ProviderGitUpdateMirror(b.git, b.repositories[name], directory); err != nil {
+			slog.Errorf("Failed to update mirror of repository \"%s\": %v", name, err)
+			errmsgs = append(errmsgs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		bundle, size, err := ProviderGitCreateBundle(b.git, directory)
+		if err != nil {
+			slog.Errorf("Failed to create bundle of repository \"%s\": %v", name, err)
+			errmsgs = append(errmsgs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if bundle == nil {
+			progress.Itemf("skipped", "Not creating bundle of repository \"%s\" as it is empty", name)
+			continue
+		}
+		err = b.destination.WriteFile(filename, bundle)
+		bundle.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write bundle \"%s\" to the destination: %w", filename, err)
+		}
+		progress.Itemf("created", "Successfully created bundle \"%s\" (%s) in \"%s\"", filename, formatBytes(size), b.destination)
+	}
+
+	progress.Logf("bundles")
+
+	if len(errmsgs) > 0 {
+		return fmt.Errorf("failed to back up %d out of %d repositories: %s", len(errmsgs), len(b.repositories), strings.Join(errmsgs, "; "))
+	}
+
+	return nil
+}
+
+func (b *backup_git_mirror) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing bundles in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Bundles of repositories which have been removed from the configuration are rotated as well
+	for _, filename := range filenames {
+		name, bundletime, ok := b.parseBundleName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = bundletime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found bundle: file=\"%s\" repository=\"%s\" created=\"%v\"", filename, name, bundletime.Format(time.RFC3339))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_git_mirror) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		bundleAge := (curtime - item.timestamp) / 86400
+		bundleDelete := bundleAge > retention
+		slog.Debugf("Considering deletion of bundle: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, bundleAge, retention)
+		if bundleDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted bundle: file=\"%s\" age=%v retention=%v", item.identifier, bundleAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting bundle: file=\"%s\" age=%d retention=%v", item.identifier, bundleAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping bundle: file=\"%s\" age=%d retention=%d", item.identifier, bundleAge, retention)
+		}
+	}
+
+	progress.Logf("bundles")
+
+	return nil
+}
@@ -0,0 +1,361 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigGiteaDump struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	GiteaPath       string `koanf:"gitea_path"`
+	ConfigFile      string `koanf:"config_file"`
+	WorkPath        string `koanf:"work_path"`
+	RunAs           string `koanf:"run_as"`
+	DumpType        string `koanf:"dump_type"`
+	Skip            any    `koanf:"skip"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_gitea_dump struct {
+	config      JobConfigGiteaDump
+	cfg         aws.Config
+	gitea       *ProviderGitea
+	destination BackupDestination
+	fileprefix  string
+	skip        []string
+}
+
+// Data which can be left out of dumps, which matches the --skip-<name> options of the dump command
+var giteaDumpSkipItems = []string{"repository", "log", "custom-dir", "lfs-data", "attachment-data", "package-data", "index", "repo-archives"}
+
+// Types of dumps produced by the dump command which are also the extensions of the files
+var giteaDumpTypes = []string{"zip", "tar", "tar.gz", "tar.xz", "tar.zst"}
+
+var validateConfigGiteaDump = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"gitea-dump"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "gitea_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "gitea",
+		allowedval: nil,
+	},
+	{
+		entryname:  "config_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "/etc/gitea/app.ini",
+		allowedval: nil,
+	},
+	{
+		entryname:  "work_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "run_as",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dump_type",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "tar.gz",
+		allowedval: []string{"zip", "tar", "tar.gz", "tar.xz", "tar.zst"},
+	},
+	{
+		entryname:  "skip",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_gitea_dump) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigGiteaDump); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+	b.skip = configListToStrings(b.config.Skip)
+	for _, item := range b.skip {
+		if slices.Contains(giteaDumpSkipItems, item) == false {
+			return fmt.Errorf("Option \"skip\" contains \"%s\" which must be one of %v", item, giteaDumpSkipItems)
+		}
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("gitea-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- GiteaPath=\"%v\"", b.config.GiteaPath)
+	slog.Debugf("- ConfigFile=\"%v\"", b.config.ConfigFile)
+	slog.Debugf("- WorkPath=\"%v\"", b.config.WorkPath)
+	slog.Debugf("- RunAs=\"%v\"", b.config.RunAs)
+	slog.Debugf("- DumpType=\"%v\"", b.config.DumpType)
+	slog.Debugf("- Skip=%v", b.skip)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_gitea_dump) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	b.gitea = &ProviderGitea{program: b.config.GiteaPath, configFile: b.config.ConfigFile, workPath: b.config.WorkPath, runAs: b.config.RunAs}
+
+	return nil
+}
+
+// Return the time of a dump from its file name, whatever the type of the dump so dumps are rotated
+// even if the type has been changed since they were created
+func (b *backup_gitea_dump) parseDumpName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false {
+		return time.Time{}, false
+	}
+	datetime, extension, found := strings.Cut(strings.TrimPrefix(filename, b.fileprefix), ".")
+	if found == false || slices.Contains(giteaDumpTypes, extension) == false {
+		return time.Time{}, false
+	}
+	dumptime, err := time.Parse("20060102-150405", datetime)
+	return dumptime, err == nil
+}
+
+func (b *backup_gitea_dump) CreateBackup() error {
+
+	curtime := clockNow()
+	filename := fmt.Sprintf("%s%s.%s", b.fileprefix, curtime.UTC().Format("20060102-150405"), b.config.DumpType)
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating dump \"%s\" in \"%s\"", filename, b.destination)
+		return nil
+	}
+
+	slog.Infof("Creating dump of the instance configured in \"%s\" ...", b.config.ConfigFile)
+
+	size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+		return ProviderGiteaDump(b.gitea, b.config.DumpType, b.skip, output)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write dump \"%s\" to the destination: %w", filename, err)
+	}
+	slog.Infof("Successfully created dump \"%s\" (%s) in \"%s\"", filename, formatBytes(size), b.destination)
+	eventItemf("created", "Created dump \"%s\" in \"%s\"", filename, b.destination)
+
+	return nil
+}
+
+func (b *backup_gitea_dump) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing dumps in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		dumptime, ok := b.parseDumpName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = dumptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found dump: file=\"%s\" created=\"%v\"", filename, dumptime.Format(time.RFC3339))
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_gitea_dump) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		dumpAge := (curtime - item.timestamp) / 86400
+		dumpDelete := dumpAge > retention
+		slog.Debugf("Considering deletion of dump: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, dumpAge, retention)
+		if dumpDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted dump: file=\"%s\" age=%v retention=%v", item.identifier, dumpAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting dump: file=\"%s\" age=%d retention=%v", item.identifier, dumpAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping dump: file=\"%s\" age=%d retention=%d", item.identifier, dumpAge, retention)
+		}
+	}
+
+	progress.Logf("dumps")
+
+	return nil
+}
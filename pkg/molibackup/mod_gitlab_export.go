@@ -0,0 +1,439 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigGitlabExport struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	GitlabUrl       string `koanf:"gitlab_url"`
+	Token           string `koanf:"token"`
+	TokenFile       string `koanf:"token_file"`
+	Projects        any    `koanf:"projects"`
+	Groups          any    `koanf:"groups"`
+	ExportTimeout   int64  `koanf:"export_timeout"`
+	TlsInsecure     bool   `koanf:"tls_insecure"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_gitlab_export struct {
+	config      JobConfigGitlabExport
+	cfg         aws.Config
+	gitlab      *ProviderGitlab
+	destination BackupDestination
+	fileprefix  string
+	targets     []GitlabExportTarget
+}
+
+// Project or group to export with its full path such as "group/subgroup/project"
+type GitlabExportTarget struct {
+	kind     string
+	fullpath string
+}
+
+// Extension of the export archives produced by GitLab which are gzipped tar archives
+const gitlabExportExtension = ".tar.gz"
+
+var validateConfigGitlabExport = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"gitlab-export"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "gitlab_url",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "https://gitlab.com",
+		allowedval: nil,
+	},
+	{
+		entryname:  "token",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "token_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "projects",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "groups",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "export_timeout",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "60",
+		allowedval: nil,
+	},
+	{
+		entryname:  "tls_insecure",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_gitlab_export) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigGitlabExport); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+	if b.config.ExportTimeout <= 0 {
+		return fmt.Errorf("Option \"export_timeout\" must be a valid number of minutes greater than 0")
+	}
+	if strings.HasPrefix(b.config.GitlabUrl, "http://") == false && strings.HasPrefix(b.config.GitlabUrl, "https://") == false {
+		return fmt.Errorf("Option \"gitlab_url\" must be an URL starting with http:// or https://")
+	}
+
+	// The default is the environment variable used by the glab command
+	if b.config.Token == "" && b.config.TokenFile == "" {
+		b.config.Token = os.Getenv("GITLAB_TOKEN")
+	}
+	if b.config.Token != "" && b.config.TokenFile != "" {
+		return fmt.Errorf("Options \"token\" and \"token_file\" cannot be used together")
+	}
+	if b.config.Token == "" && b.config.TokenFile == "" {
+		return fmt.Errorf("Option \"token\" or \"token_file\" must be specified when GITLAB_TOKEN is not set")
+	}
+
+	// Projects and groups are identified by their full path which is unique on the GitLab instance
+	b.targets = nil
+	for _, kind := range []string{"project", "group"} {
+		option := b.config.Projects
+		if kind == "group" {
+			option = b.config.Groups
+		}
+		for _, fullpath := range configListToStrings(option) {
+			fullpath = strings.Trim(strings.TrimSpace(fullpath), "/")
+			if fullpath == "" || strings.ContainsAny(fullpath, "+ ") {
+				return fmt.Errorf("Option \"%ss\" contains an invalid path \"%s\"", kind, fullpath)
+			}
+			b.targets = append(b.targets, GitlabExportTarget{kind: kind, fullpath: fullpath})
+		}
+	}
+	if len(b.targets) == 0 {
+		return fmt.Errorf("Option \"projects\" or \"groups\" must contain at least one path")
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("gitlab-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- GitlabUrl=\"%v\"", b.config.GitlabUrl)
+	slog.Debugf("- TokenFile=\"%v\"", b.config.TokenFile)
+	slog.Debugf("- Projects=%v", configListToStrings(b.config.Projects))
+	slog.Debugf("- Groups=%v", configListToStrings(b.config.Groups))
+	slog.Debugf("- ExportTimeout=%v", b.config.ExportTimeout)
+	slog.Debugf("- TlsInsecure=%v", b.config.TlsInsecure)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_gitlab_export) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// Tokens stored in a file can be rotated without changing the configuration
+	token := b.config.Token
+	if b.config.TokenFile != "" {
+		data, err := os.ReadFile(b.config.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the token file: %v", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	b.gitlab = ProviderGitlabNewClient(b.config.GitlabUrl, token, b.config.TlsInsecure)
+
+	return nil
+}
+
+// Return the name used in file names for a project or a group, where slashes are replaced by a
+// character which is not allowed in the paths of GitLab so names cannot be ambiguous
+func (t GitlabExportTarget) fileName() string {
+	return fmt.Sprintf("%s-%s", t.kind, strings.ReplaceAll(t.fullpath, "/", "+"))
+}
+
+// Return the project or group and the time of an export from its file name
+func (b *backup_gitlab_export) parseExportName(filename string) (string, time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false || strings.HasSuffix(filename, gitlabExportExtension) == false {
+		return "", time.Time{}, false
+	}
+	name, datetime, found := gitCutLast(strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), gitlabExportExtension), 15)
+	if found == false {
+		return "", time.Time{}, false
+	}
+	exporttime, err := time.Parse("20060102-150405", datetime)
+	return name, exporttime, err == nil
+}
+
+// Export each project and group and store the archives in the destination. A project or group which fails
+// does not prevent the others from being exported and the job fails once all of them have been processed.
+func (b *backup_gitlab_export) CreateBackup() error {
+
+	var errmsgs []string
+
+	curtime := clockNow()
+	timeout := time.Duration(b.config.ExportTimeout) * time.Minute
+	progress := NewProgressSummary(len(b.targets))
+
+	for _, target := range b.targets {
+		filename := fmt.Sprintf("%s%s-%s%s", b.fileprefix, target.fileName(), curtime.UTC().Format("20060102-150405"), gitlabExportExtension)
+
+		if b.config.DryRunCreate == true {
+			progress.Itemf("skipped", "Dryrun: Not exporting %s \"%s\" to \"%s\" in \"%s\"", target.kind, target.fullpath, filename, b.destination)
+			continue
+		}
+
+		slog.Infof("Exporting %s \"%s\" from \"%s\" ...", target.kind, target.fullpath, b.config.GitlabUrl)
+		archive, err := ProviderGitlabExport(b.gitlab, target.kind, target.fullpath, timeout)
+		if err != nil {
+			slog.Errorf("Failed to export %s \"%s\": %v", target.kind, target.fullpath, err)
+			errmsgs = append(errmsgs, fmt.Sprintf("%s: %v", target.fullpath, err))
+			continue
+		}
+		size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+			if _, err := io.Copy(output, archive); err != nil {
+				return fmt.Errorf("failed to download export of %s \"%s\": %v", target.kind, target.fullpath, err)
+			}
+			return nil
+		})
+		archive.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write export \"%s\" to the destination: %w", filename, err)
+		}
+		progress.Itemf("created", "Successfully created export \"%s\" (%s) in \"%s\"", filename, formatBytes(size), b.destination)
+	}
+
+	progress.Logf("exports")
+
+	if len(errmsgs) > 0 {
+		return fmt.Errorf("failed to export %d out of %d projects and groups: %s", len(errmsgs), len(b.targets), strings.Join(errmsgs, "; "))
+	}
+
+	return nil
+}
+
+func (b *backup_gitlab_export) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing exports in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Exports of projects and groups which have been removed from the configuration are rotated as well
+	for _, filename := range filenames {
+		name, exporttime, ok := b.parseExportName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = exporttime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found export: file=\"%s\" source=\"%s\" created=\"%v\"", filename, name, exporttime.Format(time.RFC3339))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_gitlab_export) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		exportAge := (curtime - item.timestamp) / 86400
+		exportDelete := exportAge > retention
+		slog.Debugf("Considering deletion of export: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, exportAge, retention)
+		if exportDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted export: file=\"%s\" age=%v retention=%v", item.identifier, exportAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting export: file=\"%s\" age=%d retention=%v", item.identifier, exportAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping export: file=\"%s\" age=%d retention=%d", item.identifier, exportAge, retention)
+		}
+	}
+
+	progress.Logf("exports")
+
+	return nil
+}
@@ -0,0 +1,226 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gookit/slog"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigHcloudSnapshot struct {
+	Module       string `koanf:"module"`
+	Enabled      any    `koanf:"enabled"`
+	DryRun       bool   `koanf:"dryrun"`
+	DryRunCreate bool   `koanf:"dryrun_create"`
+	DryRunDelete bool   `koanf:"dryrun_delete"`
+	Retention    int64  `koanf:"retention"`
+	ApiToken     string `koanf:"api_token"`
+	ServerName   string `koanf:"server_name"`
+	ServerLabels any    `koanf:"server_labels"`
+}
+
+type backup_hcloud_snapshot struct {
+	snapshotRotation
+	config  JobConfigHcloudSnapshot
+	client  *hcloud.Client
+	servers map[string]ProviderHcloudServer
+}
+
+var validateConfigHcloudSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"hcloud-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "api_token",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "server_name",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "server_labels",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_hcloud_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigHcloudSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	// The token can be provided in the environment so it does not have to be stored in the configuration
+	if b.config.ApiToken == "" {
+		b.config.ApiToken = os.Getenv("HCLOUD_TOKEN")
+	}
+	if b.config.ApiToken == "" {
+		return fmt.Errorf("Option \"api_token\" or the HCLOUD_TOKEN environment variable must be specified")
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- ServerName=\"%v\"", b.config.ServerName)
+	slog.Debugf("- ServerLabels=\"%v\"", b.config.ServerLabels)
+
+	return nil
+}
+
+func (b *backup_hcloud_snapshot) InitialiseModule() error {
+
+	var err error
+
+	// Create a client
+	b.client = ProviderHcloudNewClient(b.config.ApiToken)
+	b.snapshotRotation = snapshotRotation{noun: "snapshot", timeformat: "20060102-150405", retention: b.config.Retention,
+		dryruncreate: b.config.DryRunCreate, dryrundelete: b.config.DryRunDelete, provider: b}
+
+	// Find list of all servers that match the conditions specified in the configuration
+	srvlabels := configTagsToMap(b.config.ServerLabels)
+	slog.Debugf("Listing servers based on server_name=\"%s\" and server_labels=\"%v\" ...", b.config.ServerName, srvlabels)
+	servers, err := ProviderHcloudGetServers(b.client, b.config.ServerName, srvlabels)
+	if err != nil {
+		return fmt.Errorf("failed to find the servers to back up: %w", err)
+	}
+	b.servers = make(map[string]ProviderHcloudServer)
+	for _, server := range servers {
+		slog.Debugf("Found server: serverId=%d serverName=\"%s\"", server.serverId, server.serverName)
+		serverId := fmt.Sprintf("%d", server.serverId)
+		b.servers[serverId] = server
+		b.sources = append(b.sources, snapshotSource{id: serverId, kind: "server", prefix: server.serverName})
+	}
+	if len(servers) == 0 {
+		slog.Warnf("Have not found any server matching the conditions")
+	}
+
+	return nil
+}
+
+func (b *backup_hcloud_snapshot) CreateSnapshot(source snapshotSource, snapname string, snapdate string, snaptime string) (string, error) {
+	imageId, err := ProviderHcloudCreateSnapshot(b.client, b.servers[source.id].serverId, snapname, snapdate, snaptime)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", imageId), nil
+}
+
+func (b *backup_hcloud_snapshot) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	var results []BackupItem
+
+	snapshots, err := ProviderHcloudGetSnapshots(b.client, b.servers[source.id].serverId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot descriptions start with the server name followed by the date
+	for _, snapshot := range snapshots {
+		item := BackupItem{}
+		item.identifier = fmt.Sprintf("%d", snapshot.imageId)
+		item.description = snapshot.imageDesc
+		item.timestamp = snapshot.snapshotTime
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+func (b *backup_hcloud_snapshot) DeleteSnapshot(item BackupItem) error {
+	imageId, err := strconv.ParseInt(item.identifier, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot identifier \"%s\": %v", item.identifier, err)
+	}
+	return ProviderHcloudDeleteSnapshot(b.client, imageId)
+}
@@ -0,0 +1,483 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigJenkinsBackup struct {
+	Module            string `koanf:"module"`
+	Enabled           any    `koanf:"enabled"`
+	DryRun            bool   `koanf:"dryrun"`
+	DryRunCreate      bool   `koanf:"dryrun_create"`
+	DryRunDelete      bool   `koanf:"dryrun_delete"`
+	Retention         int64  `koanf:"retention"`
+	AwsRegion         string `koanf:"aws_region"`
+	AccessKeyId       string `koanf:"accesskey_id"`
+	AccessKeySecret   string `koanf:"accesskey_secret"`
+	JenkinsHome       string `koanf:"jenkins_home"`
+	IncludePlugins    bool   `koanf:"include_plugins"`
+	IncludeArtifacts  bool   `koanf:"include_artifacts"`
+	Exclude           any    `koanf:"exclude"`
+	Compression       string `koanf:"compression"`
+	EncryptionKeyFile string `koanf:"encryption_key_file"`
+	Destination       string `koanf:"destination"`
+}
+
+type backup_jenkins_backup struct {
+	config      JobConfigJenkinsBackup
+	cfg         aws.Config
+	destination BackupDestination
+	exclude     []string
+	key         []byte
+	fileprefix  string
+}
+
+// Directories at the top of the Jenkins home which can be recreated and are never archived
+var jenkinsTransientDirs = []string{"workspace", "war", "caches", ".cache"}
+
+// Name of the file added to archives with the list of plugins in the format used by jenkins-plugin-cli
+const jenkinsPluginsFile = "molibackup-jenkins-plugins.txt"
+
+// Extension added to the names of the archives which are encrypted
+const jenkinsEncryptedExtension = ".enc"
+
+var validateConfigJenkinsBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"jenkins-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "jenkins_home",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "include_plugins",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "include_artifacts",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "exclude",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "compression",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "gzip",
+		allowedval: []string{"none", "gzip", "zstd"},
+	},
+	{
+		entryname:  "encryption_key_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_jenkins_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigJenkinsBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// The default is the environment variable used by Jenkins and then the location used by its packages
+	if b.config.JenkinsHome == "" {
+		b.config.JenkinsHome = os.Getenv("JENKINS_HOME")
+	}
+	if b.config.JenkinsHome == "" {
+		b.config.JenkinsHome = "/var/lib/jenkins"
+	}
+	if filepath.IsAbs(b.config.JenkinsHome) == false {
+		return fmt.Errorf("Option \"jenkins_home\" must be an absolute path")
+	}
+	b.config.JenkinsHome = filepath.Clean(b.config.JenkinsHome)
+
+	b.exclude = configListToStrings(b.config.Exclude)
+	for _, pattern := range b.exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Pattern \"%s\" in option \"exclude\" is invalid: %v", pattern, err)
+		}
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("jenkins-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- JenkinsHome=\"%v\"", b.config.JenkinsHome)
+	slog.Debugf("- IncludePlugins=%v", b.config.IncludePlugins)
+	slog.Debugf("- IncludeArtifacts=%v", b.config.IncludeArtifacts)
+	slog.Debugf("- Exclude=\"%v\"", b.exclude)
+	slog.Debugf("- Compression=\"%v\"", b.config.Compression)
+	slog.Debugf("- EncryptionKeyFile=\"%v\"", b.config.EncryptionKeyFile)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_jenkins_backup) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	// Read the key before creating any archive so credentials are never written unencrypted by mistake
+	if b.config.EncryptionKeyFile != "" {
+		b.key, err = archiveReadKeyFile(b.config.EncryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the encryption key of the job: %w", err)
+		}
+		slog.Debugf("Archives are encrypted with key %s", archiveKeyId(b.key))
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// A missing home directory is reported before anything is written to the destination
+	if _, err := os.Stat(filepath.Join(b.config.JenkinsHome, "config.xml")); err != nil {
+		return fmt.Errorf("directory %s is not a Jenkins home directory: %v", b.config.JenkinsHome, err)
+	}
+
+	return nil
+}
+
+// Return true for the paths relative to the Jenkins home which must not be archived
+func (b *backup_jenkins_backup) skipPath(relpath string, isdir bool) bool {
+
+	parts := strings.Split(relpath, "/")
+	count := len(parts)
+	if isdir == true && count == 1 && slices.Contains(jenkinsTransientDirs, parts[0]) == true {
+		return true
+	}
+	if isdir == true && count == 1 && parts[0] == "plugins" && b.config.IncludePlugins == false {
+		return true
+	}
+	// Workspaces of jobs created by old versions of Jenkins are stored in "jobs/<job>/workspace"
+	if isdir == true && count >= 3 && parts[count-1] == "workspace" && parts[count-3] == "jobs" {
+		return true
+	}
+	// Artifacts are stored in "jobs/<job>/builds/<number>/archive" and jobs may be nested in folders
+	if isdir == true && count >= 3 && parts[count-1] == "archive" && parts[count-3] == "builds" && b.config.IncludeArtifacts == false {
+		return true
+	}
+
+	return archiveMatchPatterns(relpath, b.exclude)
+}
+
+// Return the list of plugins in the format used by jenkins-plugin-cli so they can be installed again
+func (b *backup_jenkins_backup) pluginsList() ([]byte, int, error) {
+
+	plugins, err := ProviderJenkinsGetPlugins(b.config.JenkinsHome)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list the plugins in \"%s\": %w", b.config.JenkinsHome, err)
+	}
+
+	var builder strings.Builder
+	for _, plugin := range plugins {
+		if plugin.disabled == true {
+			builder.WriteString("# disabled\n")
+		}
+		if plugin.version != "" {
+			builder.WriteString(fmt.Sprintf("%s:%s\n", plugin.name, plugin.version))
+		} else {
+			builder.WriteString(fmt.Sprintf("%s\n", plugin.name))
+		}
+	}
+
+	return []byte(builder.String()), len(plugins), nil
+}
+
+// Return the extension of the archives produced with the current configuration
+func (b *backup_jenkins_backup) extension() string {
+	if b.key != nil {
+		return tarArchiveExtensions[b.config.Compression] + jenkinsEncryptedExtension
+	}
+	return tarArchiveExtensions[b.config.Compression]
+}
+
+// Return the time of an archive from its file name. Archives are rotated even if the compression or
+// the encryption have been changed since they were created.
+func (b *backup_jenkins_backup) parseArchiveName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false {
+		return time.Time{}, false
+	}
+	datetime := strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), jenkinsEncryptedExtension)
+	for _, extension := range tarArchiveExtensions {
+		if strings.HasSuffix(datetime, extension) {
+			archivetime, err := time.Parse("20060102-150405", strings.TrimSuffix(datetime, extension))
+			return archivetime, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+func (b *backup_jenkins_backup) CreateBackup() error {
+
+	curtime := clockNow()
+	filename := fmt.Sprintf("%s%s%s", b.fileprefix, curtime.UTC().Format("20060102-150405"), b.extension())
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating archive \"%s\" in \"%s\"", filename, b.destination)
+		return nil
+	}
+
+	slog.Infof("Creating archive of Jenkins home %s ...", b.config.JenkinsHome)
+
+	plugins, plugincount, err := b.pluginsList()
+	if err != nil {
+		return fmt.Errorf("failed to list the plugins to back up: %w", err)
+	}
+	extra := map[string][]byte{jenkinsPluginsFile: plugins}
+	// The archive is encrypted as it is written so neither the archive nor its plaintext is held in memory
+	filecount := 0
+	size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+		if b.key == nil {
+			var err error
+			filecount, err = archiveTarDirectoriesFunc(output, []string{b.config.JenkinsHome}, b.skipPath, extra, b.config.Compression)
+			return err
+		}
+		encrypter, err := archiveNewEncryptWriter(output, b.key)
+		if err != nil {
+			return err
+		}
+		if filecount, err = archiveTarDirectoriesFunc(encrypter, []string{b.config.JenkinsHome}, b.skipPath, extra, b.config.Compression); err != nil {
+			return err
+		}
+		return encrypter.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive \"%s\" to the destination: %w", filename, err)
+	}
+	slog.Infof("Successfully created archive \"%s\" with %d files and %d plugins (%s) in \"%s\"", filename, filecount, plugincount, formatBytes(size), b.destination)
+	eventItemf("created", "Created archive \"%s\" in \"%s\"", filename, b.destination)
+
+	return nil
+}
+
+func (b *backup_jenkins_backup) RekeyBackups(bkpitems []BackupItem, oldkeys [][]byte) error {
+
+	if b.key == nil {
+		return fmt.Errorf("Option \"encryption_key_file\" must be specified to encrypt archives with a new key")
+	}
+
+	// Archives created before encryption was enabled are left as they are
+	var filenames []string
+	for _, item := range bkpitems {
+		if strings.HasSuffix(item.identifier, jenkinsEncryptedExtension) == true {
+			filenames = append(filenames, item.identifier)
+		}
+	}
+
+	return rekeyArchives(b.destination, filenames, oldkeys, b.key, b.config.DryRun)
+}
+
+func (b *backup_jenkins_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing archives in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		archivetime, ok := b.parseArchiveName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = archivetime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found archive: file=\"%s\" created=\"%v\"", filename, archivetime.Format(time.RFC3339))
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_jenkins_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		archiveAge := (curtime - item.timestamp) / 86400
+		archiveDelete := archiveAge > retention
+		slog.Debugf("Considering deletion of archive: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, archiveAge, retention)
+		if archiveDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted archive: file=\"%s\" age=%v retention=%v", item.identifier, archiveAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting archive: file=\"%s\" age=%d retention=%v", item.identifier, archiveAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping archive: file=\"%s\" age=%d retention=%d", item.identifier, archiveAge, retention)
+		}
+	}
+
+	progress.Logf("archives")
+
+	return nil
+}
@@ -0,0 +1,560 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigKafkaExport struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	KcatPath        string `koanf:"kcat_path"`
+	Brokers         any    `koanf:"brokers"`
+	KcatConfigFile  string `koanf:"kcat_config_file"`
+	Topics          any    `koanf:"topics"`
+	ExcludeTopics   any    `koanf:"exclude_topics"`
+	MaxMessages     int64  `koanf:"max_messages"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_kafka_export struct {
+	config      JobConfigKafkaExport
+	cfg         aws.Config
+	kafka       *ProviderKafka
+	destination BackupDestination
+	brokers     []string
+	topics      []string
+	excludes    []string
+	fileprefix  string
+	sets        map[string][]string
+	required    map[string]string
+}
+
+// Segment of a partition exported to a file as compressed JSON lines
+type KafkaExportSegment struct {
+	filename    string
+	setname     string
+	settime     time.Time
+	topic       string
+	partition   int32
+	firstOffset int64
+	lastOffset  int64
+}
+
+// Extension of the segments which contain one message per line in the JSON format of kcat
+const kafkaSegmentExtension = ".jsonl.gz"
+
+var validateConfigKafkaExport = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"kafka-export"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "kcat_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "kcat",
+		allowedval: nil,
+	},
+	{
+		entryname:  "brokers",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "kcat_config_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "topics",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude_topics",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "max_messages",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "1000000",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_kafka_export) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigKafkaExport); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+	if b.config.MaxMessages < 0 {
+		return fmt.Errorf("Option \"max_messages\" must not be negative")
+	}
+
+	b.brokers = configListToStrings(b.config.Brokers)
+	if len(b.brokers) == 0 {
+		return fmt.Errorf("Option \"brokers\" must contain at least one broker")
+	}
+	b.topics = configListToStrings(b.config.Topics)
+	if len(b.topics) == 0 {
+		return fmt.Errorf("Option \"topics\" must contain at least one topic or pattern")
+	}
+	b.excludes = configListToStrings(b.config.ExcludeTopics)
+	for _, pattern := range append(b.topics, b.excludes...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Option \"topics\" or \"exclude_topics\" contains an invalid pattern: \"%s\"", pattern)
+		}
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("kafka-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- KcatPath=\"%v\"", b.config.KcatPath)
+	slog.Debugf("- Brokers=%v", b.brokers)
+	slog.Debugf("- KcatConfigFile=\"%v\"", b.config.KcatConfigFile)
+	slog.Debugf("- Topics=%v", b.topics)
+	slog.Debugf("- ExcludeTopics=%v", b.excludes)
+	slog.Debugf("- MaxMessages=%v", b.config.MaxMessages)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_kafka_export) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	b.kafka = &ProviderKafka{
+		program:    b.config.KcatPath,
+		brokers:    strings.Join(b.brokers, ","),
+		configFile: b.config.KcatConfigFile,
+	}
+
+	return nil
+}
+
+// Parse the name of a segment which is <prefix><YYYYMMDD-HHMMSS>-<topic>-<partition>-<first>-<last>.jsonl.gz,
+// where the topic can contain dashes so the fields which follow it are parsed from the end of the name
+func (b *backup_kafka_export) parseSegmentName(filename string) (KafkaExportSegment, bool) {
+	segment := KafkaExportSegment{}
+	if strings.HasPrefix(filename, b.fileprefix) == false || strings.HasSuffix(filename, kafkaSegmentExtension) == false {
+		return segment, false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), kafkaSegmentExtension)
+	datetime, rest, found := strings.Cut(name, "-")
+	if found == false {
+		return segment, false
+	}
+	timepart, rest, found := strings.Cut(rest, "-")
+	if found == false {
+		return segment, false
+	}
+	settime, err := time.Parse("20060102-150405", datetime+"-"+timepart)
+	if err != nil {
+		return segment, false
+	}
+	fields := strings.Split(rest, "-")
+	if len(fields) < 4 {
+		return segment, false
+	}
+	count := len(fields)
+	partition, err1 := strconv.ParseInt(fields[count-3], 10, 32)
+	first, err2 := strconv.ParseInt(fields[count-2], 10, 64)
+	last, err3 := strconv.ParseInt(fields[count-1], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return segment, false
+	}
+	segment.filename = filename
+	segment.setname = b.fileprefix + datetime + "-" + timepart
+	segment.settime = settime
+	segment.topic = strings.Join(fields[:count-3], "-")
+	segment.partition = int32(partition)
+	segment.firstOffset = first
+	segment.lastOffset = last
+	return segment, true
+}
+
+// Check if a topic matches the patterns of the job, where internal topics must be matched explicitly
+func (b *backup_kafka_export) topicSelected(topic string) bool {
+	if archiveMatchPatterns(topic, b.excludes) == true {
+		return false
+	}
+	for _, pattern := range b.topics {
+		if matched, _ := filepath.Match(pattern, topic); matched == true {
+			return strings.HasPrefix(topic, "__") == false || strings.HasPrefix(pattern, "__") == true
+		}
+	}
+	return false
+}
+
+// Return the segments exported by the job which are present in the destination
+func (b *backup_kafka_export) listSegments() ([]KafkaExportSegment, error) {
+	var results []KafkaExportSegment
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range filenames {
+		if segment, ok := b.parseSegmentName(filename); ok == true {
+			results = append(results, segment)
+		}
+	}
+	return results, nil
+}
+
+func (b *backup_kafka_export) CreateBackup() error {
+
+	curtime := clockNow().UTC().Format("20060102-150405")
+	setname := fmt.Sprintf("%s%s", b.fileprefix, curtime)
+
+	// Offsets are recorded in the names of the segments so the next export starts after the last message exported
+	segments, err := b.listSegments()
+	if err != nil {
+		return fmt.Errorf("failed to list the segments in the destination: %w", err)
+	}
+	nextOffsets := make(map[string]int64)
+	for _, segment := range segments {
+		key := fmt.Sprintf("%s/%d", segment.topic, segment.partition)
+		if segment.lastOffset+1 > nextOffsets[key] {
+			nextOffsets[key] = segment.lastOffset + 1
+		}
+	}
+
+	partitions, err := ProviderKafkaListPartitions(b.kafka)
+	if err != nil {
+		return fmt.Errorf("failed to list the partitions of the topics: %w", err)
+	}
+	var topics []string
+	for topic := range partitions {
+		if b.topicSelected(topic) == true {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	if len(topics) == 0 {
+		return fmt.Errorf("have not found any topic matching %v", b.topics)
+	}
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not exporting topics %v to \"%s\"", topics, b.destination)
+		return nil
+	}
+
+	slog.Infof("Exporting new messages of topics %v ...", topics)
+
+	var filecount, msgcount, totalsize int64
+	for _, topic := range topics {
+		sort.Slice(partitions[topic], func(i, j int) bool { return partitions[topic][i] < partitions[topic][j] })
+		for _, partition := range partitions[topic] {
+			key := fmt.Sprintf("%s/%d", topic, partition)
+			offset := nextOffsets[key]
+			segment, reader, err := b.consumeSegment(topic, partition, offset)
+			if err != nil {
+				return fmt.Errorf("failed to consume partition %d of topic \"%s\": %w", partition, topic, err)
+			}
+			if segment.count == 0 {
+				slog.Debugf("No new message in partition %d of topic \"%s\" after offset %d", partition, topic, offset)
+				continue
+			}
+			if offset > 0 && segment.firstOffset > offset {
+				slog.Warnf("Messages %d to %d of partition %d of topic \"%s\" have been deleted before they could be exported",
+					offset, segment.firstOffset-1, partition, topic)
+			}
+			filename := fmt.Sprintf("%s-%s-%d-%d-%d%s", setname, topic, partition, segment.firstOffset, segment.lastOffset, kafkaSegmentExtension)
+			size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+				_, err := io.Copy(output, reader)
+				return err
+			})
+			reader.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write segment \"%s\" to the destination: %w", filename, err)
+			}
+			slog.Debugf("Exported %d messages of partition %d of topic \"%s\" to \"%s\"", segment.count, partition, topic, filename)
+			filecount++
+			msgcount += segment.count
+			totalsize += size
+		}
+	}
+
+	if filecount == 0 {
+		slog.Infof("Have not found any new message to export in topics %v", topics)
+		return nil
+	}
+	slog.Infof("Successfully exported %d messages to %d segments of set \"%s\" (%s) in \"%s\"", msgcount, filecount, setname, formatBytes(totalsize), b.destination)
+	eventItemf("created", "Created export set \"%s\" with %d segments in \"%s\"", setname, filecount, b.destination)
+
+	return nil
+}
+
+// Consume the new messages of a partition and compress them as they are received to a temporary file, as the name
+// of the segment depends on the offsets of its messages. The file is returned with a reader which removes it when
+// it is closed, or no reader is returned when there is no new message.
+func (b *backup_kafka_export) consumeSegment(topic string, partition int32, offset int64) (ProviderKafkaSegment, io.ReadCloser, error) {
+
+	tmpfile, err := os.CreateTemp("", "molibackup-kafka-*")
+	if err != nil {
+		return ProviderKafkaSegment{}, nil, fmt.Errorf("failed to create temporary segment file: %v", err)
+	}
+	compressor := gzip.NewWriter(tmpfile)
+	segment, err := ProviderKafkaConsumePartition(b.kafka, topic, partition, offset, b.config.MaxMessages, compressor)
+	if err == nil {
+		if err = compressor.Close(); err != nil {
+			err = fmt.Errorf("failed to compress segment: %v", err)
+		}
+	}
+	if closeerr := tmpfile.Close(); err == nil && closeerr != nil {
+		err = fmt.Errorf("failed to write temporary segment file: %v", closeerr)
+	}
+	if err != nil || segment.count == 0 {
+		os.Remove(tmpfile.Name())
+		return segment, nil, err
+	}
+
+	reader, _, err := openCommandOutput(tmpfile.Name(), tmpfile.Name())
+	if err != nil {
+		return segment, nil, fmt.Errorf("failed to read temporary segment file: %v", err)
+	}
+
+	return segment, reader, nil
+}
+
+func (b *backup_kafka_export) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing export sets in \"%s\" ...", b.destination)
+	segments, err := b.listSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	// Segments created by the same run are grouped in a set which is rotated as a whole, and the set which
+	// holds the last offset of each partition is required to know where the next export has to start
+	b.sets = make(map[string][]string)
+	b.required = make(map[string]string)
+	setTimes := make(map[string]time.Time)
+	lastOffsets := make(map[string]int64)
+	for _, segment := range segments {
+		b.sets[segment.setname] = append(b.sets[segment.setname], segment.filename)
+		setTimes[segment.setname] = segment.settime
+		key := fmt.Sprintf("%s/%d", segment.topic, segment.partition)
+		if last, found := lastOffsets[key]; found == false || segment.lastOffset > last {
+			lastOffsets[key] = segment.lastOffset
+			b.required[key] = segment.setname
+		}
+	}
+
+	for setname, settime := range setTimes {
+		item := BackupItem{}
+		item.identifier = setname
+		item.description = fmt.Sprintf("%s (%d segments)", setname, len(b.sets[setname]))
+		item.timestamp = settime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found export set: name=\"%s\" segments=%d created=\"%v\"", setname, len(b.sets[setname]), settime.Format(time.RFC3339))
+	}
+
+	// Set names end with the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_kafka_export) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	requiredSets := make(map[string][]string)
+	for key, setname := range b.required {
+		requiredSets[setname] = append(requiredSets[setname], key)
+	}
+
+	for _, item := range bkpitems {
+		setAge := (curtime - item.timestamp) / 86400
+		setDelete := setAge > retention
+		slog.Debugf("Considering deletion of export set: name=\"%s\" age=%v retention=%v ...",
+			item.identifier, setAge, retention)
+		if setDelete == true && len(requiredSets[item.identifier]) > 0 {
+			sort.Strings(requiredSets[item.identifier])
+			progress.Itemf("kept", "Keeping export set as it holds the last offsets of partitions %v: name=\"%s\" age=%d retention=%d",
+				requiredSets[item.identifier], item.identifier, setAge, retention)
+			continue
+		}
+		if setDelete == true {
+			if b.config.DryRunDelete == false {
+				for _, filename := range b.sets[item.identifier] {
+					if err := b.destination.DeleteFile(filename); err != nil {
+						return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", filename, err)
+					}
+				}
+				progress.Itemf("deleted", "Deleted export set: name=\"%s\" age=%v retention=%v", item.identifier, setAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting export set: name=\"%s\" age=%d retention=%v", item.identifier, setAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping export set: name=\"%s\" age=%d retention=%d", item.identifier, setAge, retention)
+		}
+	}
+
+	progress.Logf("export sets")
+
+	return nil
+}
@@ -0,0 +1,290 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigKopia struct {
+	Module       string `koanf:"module"`
+	Enabled      any    `koanf:"enabled"`
+	DryRun       bool   `koanf:"dryrun"`
+	DryRunCreate bool   `koanf:"dryrun_create"`
+	DryRunDelete bool   `koanf:"dryrun_delete"`
+	Retention    int64  `koanf:"retention"`
+	KopiaPath    string `koanf:"kopia_path"`
+	ConfigFile   string `koanf:"config_file"`
+	PasswordFile string `koanf:"password_file"`
+	Environment  any    `koanf:"environment"`
+	Sources      any    `koanf:"sources"`
+}
+
+type backup_kopia struct {
+	config      JobConfigKopia
+	kopia       *ProviderKopia
+	sources     []string
+	description string
+}
+
+var validateConfigKopia = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"kopia"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "kopia_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "kopia",
+		allowedval: nil,
+	},
+	{
+		entryname:  "config_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "password_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "environment",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "sources",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_kopia) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigKopia); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	b.sources = configListToStrings(b.config.Sources)
+	if len(b.sources) == 0 {
+		return fmt.Errorf("Option \"sources\" must contain at least one directory")
+	}
+	for _, source := range b.sources {
+		if filepath.IsAbs(source) == false {
+			return fmt.Errorf("Option \"sources\" must contain absolute paths but \"%s\" is not", source)
+		}
+	}
+
+	// Snapshots get a description with the name of the job so multiple jobs can share the same sources
+	b.description = fmt.Sprintf("molibackup-%s", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- KopiaPath=\"%v\"", b.config.KopiaPath)
+	slog.Debugf("- ConfigFile=\"%v\"", b.config.ConfigFile)
+	slog.Debugf("- PasswordFile=\"%v\"", b.config.PasswordFile)
+	slog.Debugf("- Environment=\"%v\"", b.config.Environment)
+	slog.Debugf("- Sources=\"%v\"", b.sources)
+
+	return nil
+}
+
+func (b *backup_kopia) InitialiseModule() error {
+
+	// Options of the job take precedence over the environment
+	env := configTagsToMap(b.config.Environment)
+
+	// Kopia only reads the password of the repository from its environment or its own configuration
+	if b.config.PasswordFile != "" {
+		password, err := os.ReadFile(b.config.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the password file: %v", err)
+		}
+		env["KOPIA_PASSWORD"] = strings.TrimSpace(string(password))
+	}
+
+	b.kopia = &ProviderKopia{program: b.config.KopiaPath, configFile: b.config.ConfigFile, env: env}
+
+	return nil
+}
+
+func (b *backup_kopia) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.sources))
+
+	for _, source := range b.sources {
+		if b.config.DryRunCreate == false {
+			slog.Infof("Creating snapshot of \"%s\" ...", source)
+			if err := ProviderKopiaCreateSnapshot(b.kopia, source, b.description); err != nil {
+				return fmt.Errorf("failed to create snapshot of \"%s\": %w", source, err)
+			}
+			progress.Itemf("created", "Successfully created snapshot of \"%s\"", source)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating snapshot of \"%s\"", source)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
+
+func (b *backup_kopia) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	for _, source := range b.sources {
+		slog.Debugf("Listing snapshots of \"%s\" ...", source)
+		snapshots, err := ProviderKopiaGetSnapshots(b.kopia, source, b.description)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, snapshot := range snapshots {
+			item := BackupItem{}
+			item.identifier = snapshot.snapshotId
+			item.description = snapshot.sourcePath
+			item.timestamp = snapshot.snapshotTime
+			item.size = snapshot.totalSize
+			results = append(results, item)
+			snaptime := time.Unix(snapshot.snapshotTime, 0)
+			slog.Debugf("Found snapshot: id=\"%s\" source=\"%s\" created=\"%v\" size=%s",
+				snapshot.snapshotId, snapshot.sourcePath, snaptime.Format(time.RFC3339), formatBytes(snapshot.totalSize))
+		}
+	}
+
+	// Snapshots are sorted by source and then by time
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].description != results[j].description {
+			return results[i].description < results[j].description
+		}
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (b *backup_kopia) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		snapAge := (curtime - item.timestamp) / 86400
+		snapDelete := snapAge > retention
+		slog.Debugf("Considering deletion of snapshot: id=\"%s\" source=\"%s\" age=%v retention=%v ...",
+			item.identifier, item.description, snapAge, retention)
+		if snapDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderKopiaDeleteSnapshot(b.kopia, item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete snapshot \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted snapshot: id=\"%s\" source=\"%s\" age=%v retention=%v", item.identifier, item.description, snapAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting snapshot: id=\"%s\" source=\"%s\" age=%d retention=%v", item.identifier, item.description, snapAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping snapshot: id=\"%s\" source=\"%s\" age=%d retention=%d", item.identifier, item.description, snapAge, retention)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
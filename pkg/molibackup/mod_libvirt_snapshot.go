@@ -0,0 +1,297 @@
+//go:build linux
+
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigLibvirtSnapshot struct {
+	Module        string `koanf:"module"`
+	Enabled       any    `koanf:"enabled"`
+	DryRun        bool   `koanf:"dryrun"`
+	DryRunCreate  bool   `koanf:"dryrun_create"`
+	DryRunDelete  bool   `koanf:"dryrun_delete"`
+	Retention     int64  `koanf:"retention"`
+	VirshPath     string `koanf:"virsh_path"`
+	LibvirtUri    string `koanf:"libvirt_uri"`
+	DomainPattern string `koanf:"domain_pattern"`
+	SnapshotType  string `koanf:"snapshot_type"`
+	Quiesce       bool   `koanf:"quiesce"`
+}
+
+type backup_libvirt_snapshot struct {
+	config    JobConfigLibvirtSnapshot
+	virsh     *ProviderLibvirt
+	domains   []string
+	snapshots map[string]ProviderLibvirtSnapshot
+}
+
+var validateConfigLibvirtSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"libvirt-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "7",
+		allowedval: nil,
+	},
+	{
+		entryname:  "virsh_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "virsh",
+		allowedval: nil,
+	},
+	{
+		entryname:  "libvirt_uri",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "domain_pattern",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "snapshot_type",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "internal",
+		allowedval: []string{"internal", "external"},
+	},
+	{
+		entryname:  "quiesce",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+}
+
+func (b *backup_libvirt_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigLibvirtSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	if _, err := path.Match(b.config.DomainPattern, ""); err != nil {
+		return fmt.Errorf("Option \"domain_pattern\" must be a valid pattern: %v", err)
+	}
+
+	// The guest agent can only freeze filesystems for snapshots which do not include the memory
+	if b.config.Quiesce == true && b.config.SnapshotType != "external" {
+		return fmt.Errorf("Option \"quiesce\" requires option \"snapshot_type\" to be set to \"external\"")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- VirshPath=\"%v\"", b.config.VirshPath)
+	slog.Debugf("- LibvirtUri=\"%v\"", b.config.LibvirtUri)
+	slog.Debugf("- DomainPattern=\"%v\"", b.config.DomainPattern)
+	slog.Debugf("- SnapshotType=\"%v\"", b.config.SnapshotType)
+	slog.Debugf("- Quiesce=%v", b.config.Quiesce)
+
+	return nil
+}
+
+func (b *backup_libvirt_snapshot) InitialiseModule() error {
+
+	var err error
+
+	b.virsh = &ProviderLibvirt{program: b.config.VirshPath, uri: b.config.LibvirtUri}
+	b.snapshots = make(map[string]ProviderLibvirtSnapshot)
+
+	// Find list of all domains that match the pattern specified in the configuration
+	slog.Debugf("Listing domains based on domain_pattern=\"%s\" ...", b.config.DomainPattern)
+	b.domains, err = ProviderLibvirtGetDomains(b.virsh, b.config.DomainPattern)
+	if err != nil {
+		return fmt.Errorf("failed to find the domains matching \"%s\": %w", b.config.DomainPattern, err)
+	}
+	for _, domainName := range b.domains {
+		slog.Debugf("Found domain: name=\"%s\"", domainName)
+	}
+	if len(b.domains) == 0 {
+		slog.Warnf("Have not found any domain matching the pattern \"%s\"", b.config.DomainPattern)
+	}
+
+	return nil
+}
+
+func (b *backup_libvirt_snapshot) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.domains))
+
+	external := b.config.SnapshotType == "external"
+
+	for _, domainName := range b.domains {
+		slog.Debugf("Considering snapshot for domain: name=\"%s\" ...", domainName)
+		curtime := clockNow().UTC()
+		snapshotName := fmt.Sprintf("%s%s", libvirtSnapshotPrefix, curtime.Format("20060102-150405"))
+		snapshotDesc := fmt.Sprintf("Created by molibackup job_id=%s", jobExecutionId)
+		if b.config.DryRunCreate == false {
+			err := ProviderLibvirtCreateSnapshot(b.virsh, domainName, snapshotName, snapshotDesc, external, b.config.Quiesce)
+			if err != nil {
+				return fmt.Errorf("failed to create snapshot of domain \"%s\": %w", domainName, err)
+			}
+			progress.Itemf("created", "Successfully created %s snapshot \"%s\" of domain \"%s\"", b.config.SnapshotType, snapshotName, domainName)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating %s snapshot of domain \"%s\"", b.config.SnapshotType, domainName)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
+
+func (b *backup_libvirt_snapshot) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	// Enumerate domains and their snapshots to get a list of relevant snapshots
+	for _, domainName := range b.domains {
+		slog.Debugf("Listing snapshots of domain: name=\"%s\" ...", domainName)
+
+		snapshots, err := ProviderLibvirtGetSnapshots(b.virsh, domainName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, snapshot := range snapshots {
+			// Snapshot names are only unique within a domain
+			identifier := fmt.Sprintf("%s/%s", domainName, snapshot.snapshotName)
+			item := BackupItem{}
+			item.identifier = identifier
+			item.description = snapshot.snapshotDesc
+			item.timestamp = snapshot.snapshotTime
+			results = append(results, item)
+			b.snapshots[identifier] = snapshot
+			snaptime := time.Unix(snapshot.snapshotTime, 0)
+			slog.Debugf("Found snapshot: domain=\"%s\" name=\"%s\" created=\"%v\"",
+				domainName, snapshot.snapshotName, snaptime.Format(time.RFC3339))
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_libvirt_snapshot) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		snapshot := b.snapshots[item.identifier]
+		snapshotAge := (curtime - item.timestamp) / 86400
+		snapshotDelete := snapshotAge > retention
+		slog.Debugf("Considering deletion of snapshot: id=\"%s\" age=%v retention=%v ...",
+			item.identifier, snapshotAge, retention)
+		if snapshotDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderLibvirtDeleteSnapshot(b.virsh, snapshot.domainName, snapshot.snapshotName)
+				if err != nil {
+					return fmt.Errorf("failed to delete snapshot \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted snapshot: id=\"%s\" age=%v retention=%v", item.identifier, snapshotAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting snapshot: id=\"%s\" age=%d retention=%v", item.identifier, snapshotAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping snapshot: id=\"%s\" age=%d retention=%d", item.identifier, snapshotAge, retention)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
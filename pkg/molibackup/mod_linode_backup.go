@@ -0,0 +1,318 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/linode/linodego"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigLinodeBackup struct {
+	Module       string `koanf:"module"`
+	Enabled      any    `koanf:"enabled"`
+	DryRun       bool   `koanf:"dryrun"`
+	DryRunCreate bool   `koanf:"dryrun_create"`
+	DryRunDelete bool   `koanf:"dryrun_delete"`
+	Retention    int64  `koanf:"retention"`
+	ApiToken     string `koanf:"api_token"`
+	BackupMode   string `koanf:"backup_mode"`
+	LinodeLabel  string `koanf:"linode_label"`
+	LinodeTags   any    `koanf:"linode_tags"`
+}
+
+type backup_linode_backup struct {
+	config    JobConfigLinodeBackup
+	client    *linodego.Client
+	instances []ProviderLinodeInstance
+}
+
+// Maximum length of the label of an image
+const linodeImageLabelMaxLength = 50
+
+var validateConfigLinodeBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"linode-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "api_token",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "backup_mode",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "image",
+		allowedval: []string{"image", "snapshot"},
+	},
+	{
+		entryname:  "linode_label",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "linode_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_linode_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigLinodeBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	// The token can be provided in the environment so it does not have to be stored in the configuration
+	if b.config.ApiToken == "" {
+		b.config.ApiToken = os.Getenv("LINODE_TOKEN")
+	}
+	if b.config.ApiToken == "" {
+		return fmt.Errorf("Option \"api_token\" or the LINODE_TOKEN environment variable must be specified")
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- BackupMode=\"%v\"", b.config.BackupMode)
+	slog.Debugf("- LinodeLabel=\"%v\"", b.config.LinodeLabel)
+	slog.Debugf("- LinodeTags=\"%v\"", b.config.LinodeTags)
+
+	return nil
+}
+
+func (b *backup_linode_backup) InitialiseModule() error {
+
+	var err error
+
+	// Create a client
+	b.client = ProviderLinodeNewClient(b.config.ApiToken)
+
+	// Find list of all Linodes that match the conditions specified in the configuration
+	lintags := configListToStrings(b.config.LinodeTags)
+	slog.Debugf("Listing linodes based on linode_label=\"%s\" and linode_tags=\"%v\" ...", b.config.LinodeLabel, lintags)
+	b.instances, err = ProviderLinodeGetInstances(b.client, b.config.LinodeLabel, lintags)
+	if err != nil {
+		return fmt.Errorf("failed to find the linodes to back up: %w", err)
+	}
+	for _, instance := range b.instances {
+		slog.Debugf("Found linode: linodeId=%d linodeLabel=\"%s\" disks=%v", instance.linodeId, instance.linodeLabel, instance.diskIds)
+	}
+	if len(b.instances) == 0 {
+		slog.Warnf("Have not found any linode matching the conditions")
+	}
+
+	return nil
+}
+
+// Return the label of a new image which fits in the limit of the length of image labels
+func linodeImageLabel(linodeLabel string, diskId int, curtime time.Time) string {
+	suffix := fmt.Sprintf("-%d-%s", diskId, curtime.UTC().Format("20060102-150405"))
+	basename := linodeLabel
+	if len(basename)+len(suffix) > linodeImageLabelMaxLength {
+		basename = basename[:linodeImageLabelMaxLength-len(suffix)]
+	}
+	return basename + suffix
+}
+
+func (b *backup_linode_backup) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.instances))
+
+	for _, instance := range b.instances {
+		slog.Debugf("Considering backup for linode: linodeId=%d ...", instance.linodeId)
+		curtime := clockNow()
+		snaptime := fmt.Sprintf("%v", curtime.Unix())
+
+		// The backup service only keeps a single manual snapshot which is replaced each time
+		if b.config.BackupMode == "snapshot" {
+			snapname := fmt.Sprintf("molibackup-%s", curtime.UTC().Format("20060102-150405"))
+			if b.config.DryRunCreate == false {
+				snapshotId, err := ProviderLinodeCreateSnapshot(b.client, instance.linodeId, snapname)
+				if err != nil {
+					return fmt.Errorf("failed to create snapshot of linode \"%s\": %w", instance.linodeLabel, err)
+				}
+				progress.Itemf("created", "Successfully created snapshot %d of linode \"%s\"", snapshotId, instance.linodeLabel)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not creating snapshot of linode \"%s\"", instance.linodeLabel)
+			}
+			continue
+		}
+
+		for _, diskId := range instance.diskIds {
+			imagename := linodeImageLabel(instance.linodeLabel, diskId, curtime)
+			if b.config.DryRunCreate == false {
+				imageId, err := ProviderLinodeCreateImage(b.client, instance.linodeId, diskId, imagename, snaptime)
+				if err != nil {
+					return fmt.Errorf("failed to create image of disk %d of linode \"%s\": %w", diskId, instance.linodeLabel, err)
+				}
+				progress.Itemf("created", "Successfully created image \"%s\" of disk %d of linode \"%s\"", imageId, diskId, instance.linodeLabel)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not creating image of disk %d of linode \"%s\"", diskId, instance.linodeLabel)
+			}
+		}
+	}
+
+	progress.Logf("backups")
+
+	return nil
+}
+
+func (b *backup_linode_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	// Manual snapshots of the backup service are replaced rather than rotated
+	if b.config.BackupMode == "snapshot" {
+		return nil, nil
+	}
+
+	// Enumerate Linodes and their images to get a list of relevant images
+	for _, instance := range b.instances {
+		slog.Debugf("Listing images from linode: linodeId=%d ...", instance.linodeId)
+
+		images, err := ProviderLinodeGetImages(b.client, instance.linodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range images {
+			item := BackupItem{}
+			item.identifier = image.imageId
+			item.description = image.imageLabel
+			item.timestamp = image.imageTime
+			results = append(results, item)
+			imgtime := time.Unix(image.imageTime, 0)
+			slog.Debugf("Found image: id=\"%s\" label=\"%s\" created=\"%v\" linode=%d",
+				image.imageId, image.imageLabel, imgtime.Format(time.RFC3339), image.linodeId)
+		}
+	}
+
+	// Image labels start with the Linode label followed by the date
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].description < results[j].description
+	})
+
+	return results, nil
+}
+
+func (b *backup_linode_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		imageAge := (curtime - item.timestamp) / 86400
+		imageDelete := imageAge > retention
+		slog.Debugf("Considering deletion of image: id=\"%s\" age=%v retention=%v ...",
+			item.identifier, imageAge, retention)
+		if imageDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderLinodeDeleteImage(b.client, item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete image \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted image: id=\"%s\" age=%v retention=%v", item.identifier, imageAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting image: id=\"%s\" age=%d retention=%v", item.identifier, imageAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping image: id=\"%s\" age=%d retention=%d", item.identifier, imageAge, retention)
+		}
+	}
+
+	progress.Logf("images")
+
+	return nil
+}
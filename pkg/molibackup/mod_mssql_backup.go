@@ -0,0 +1,452 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigMssqlBackup struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	SqlcmdPath      string `koanf:"sqlcmd_path"`
+	Server          string `koanf:"server"`
+	Username        string `koanf:"username"`
+	PasswordFile    string `koanf:"password_file"`
+	Databases       any    `koanf:"databases"`
+	BackupType      string `koanf:"backup_type"`
+	Compression     bool   `koanf:"compression"`
+	StagingDir      string `koanf:"staging_dir"`
+	LocalStagingDir string `koanf:"local_staging_dir"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_mssql_backup struct {
+	config      JobConfigMssqlBackup
+	cfg         aws.Config
+	mssql       *ProviderMssql
+	destination BackupDestination
+	databases   []string
+	fileprefix  string
+}
+
+// Extension of the backup files created by SQL Server
+const mssqlBackupExtension = ".bak"
+
+var validateConfigMssqlBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"mssql-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "sqlcmd_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "sqlcmd",
+		allowedval: nil,
+	},
+	{
+		entryname:  "server",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "localhost",
+		allowedval: nil,
+	},
+	{
+		entryname:  "username",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "password_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "databases",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "backup_type",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "full",
+		allowedval: []string{"full", "differential"},
+	},
+	{
+		entryname:  "compression",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "staging_dir",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "local_staging_dir",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_mssql_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigMssqlBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// Names of databases are part of the names of the files
+	b.databases = configListToStrings(b.config.Databases)
+	if len(b.databases) == 0 {
+		return fmt.Errorf("Option \"databases\" must contain at least one database")
+	}
+	for _, database := range b.databases {
+		if strings.ContainsAny(database, "/\\") == true {
+			return fmt.Errorf("Option \"databases\" must contain names of databases but \"%s\" is not valid", database)
+		}
+	}
+
+	// The server writes backups in the staging directory and the program reads them from the local path
+	// of the same directory, which is the same path unless the directory is shared over the network
+	if b.config.LocalStagingDir == "" {
+		b.config.LocalStagingDir = b.config.StagingDir
+	}
+	if filepath.IsAbs(b.config.LocalStagingDir) == false {
+		return fmt.Errorf("Option \"local_staging_dir\" must be an absolute path when the staging directory of the server is not local")
+	}
+
+	if b.config.PasswordFile != "" && b.config.Username == "" {
+		return fmt.Errorf("Option \"password_file\" requires option \"username\"")
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("mssql-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- SqlcmdPath=\"%v\"", b.config.SqlcmdPath)
+	slog.Debugf("- Server=\"%v\"", b.config.Server)
+	slog.Debugf("- Username=\"%v\"", b.config.Username)
+	slog.Debugf("- PasswordFile=\"%v\"", b.config.PasswordFile)
+	slog.Debugf("- Databases=\"%v\"", b.databases)
+	slog.Debugf("- BackupType=\"%v\"", b.config.BackupType)
+	slog.Debugf("- Compression=%v", b.config.Compression)
+	slog.Debugf("- StagingDir=\"%v\"", b.config.StagingDir)
+	slog.Debugf("- LocalStagingDir=\"%v\"", b.config.LocalStagingDir)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_mssql_backup) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// Sqlcmd reads the password from its environment so it does not appear on the command line
+	env := make(map[string]string)
+	if b.config.PasswordFile != "" {
+		password, err := os.ReadFile(b.config.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the password file: %v", err)
+		}
+		env["SQLCMDPASSWORD"] = strings.TrimSpace(string(password))
+	}
+
+	b.mssql = &ProviderMssql{program: b.config.SqlcmdPath, server: b.config.Server, username: b.config.Username, env: env}
+
+	return nil
+}
+
+// Return the path of a file in the staging directory as seen by the server which can run on Windows
+func (b *backup_mssql_backup) serverStagingPath(filename string) string {
+	if strings.Contains(b.config.StagingDir, "\\") == true {
+		return strings.TrimRight(b.config.StagingDir, "\\") + "\\" + filename
+	}
+	return strings.TrimRight(b.config.StagingDir, "/") + "/" + filename
+}
+
+// Return the database and the time of a backup from its file name
+func (b *backup_mssql_backup) parseBackupName(filename string) (string, time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false || strings.HasSuffix(filename, mssqlBackupExtension) == false {
+		return "", time.Time{}, false
+	}
+	// Names of databases can contain dashes so the date is found at the end of the name
+	name := strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), mssqlBackupExtension)
+	datelen := len("20060102-150405")
+	if len(name) < datelen+2 || name[len(name)-datelen-1] != '-' {
+		return "", time.Time{}, false
+	}
+	backuptime, err := time.Parse("20060102-150405", name[len(name)-datelen:])
+	return name[:len(name)-datelen-1], backuptime, err == nil
+}
+
+func (b *backup_mssql_backup) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.databases))
+
+	for _, database := range b.databases {
+		curtime := clockNow()
+		filename := fmt.Sprintf("%s%s-%s%s", b.fileprefix, database, curtime.UTC().Format("20060102-150405"), mssqlBackupExtension)
+		if b.config.DryRunCreate == false {
+			slog.Infof("Creating %s backup of database \"%s\" ...", b.config.BackupType, database)
+			size, err := b.createDatabaseBackup(database, filename)
+			if err != nil {
+				return fmt.Errorf("failed to back up database \"%s\": %w", database, err)
+			}
+			progress.Itemf("created", "Successfully created %s backup \"%s\" (%s) of database \"%s\" in \"%s\"",
+				b.config.BackupType, filename, formatBytes(size), database, b.destination)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating %s backup \"%s\" of database \"%s\" in \"%s\"",
+				b.config.BackupType, filename, database, b.destination)
+		}
+	}
+
+	progress.Logf("backups")
+
+	return nil
+}
+
+// Back up a database to the staging directory and move the file to the destination
+func (b *backup_mssql_backup) createDatabaseBackup(database string, filename string) (int64, error) {
+
+	localpath := filepath.Join(b.config.LocalStagingDir, filename)
+	defer os.Remove(localpath)
+
+	differential := b.config.BackupType == "differential"
+	err := ProviderMssqlBackupDatabase(b.mssql, database, b.serverStagingPath(filename), differential, b.config.Compression)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(localpath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read backup written by the server in the staging directory: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read backup written by the server in the staging directory: %v", err)
+	}
+	if err := b.destination.WriteFile(filename, file); err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (b *backup_mssql_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing backups in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		database, backuptime, ok := b.parseBackupName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = database
+		item.timestamp = backuptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found backup: file=\"%s\" database=\"%s\" created=\"%v\"", filename, database, backuptime.Format(time.RFC3339))
+	}
+
+	// Backups are sorted by database and then by time
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].description != results[j].description {
+			return results[i].description < results[j].description
+		}
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (b *backup_mssql_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		backupAge := (curtime - item.timestamp) / 86400
+		backupDelete := backupAge > retention
+		slog.Debugf("Considering deletion of backup: file=\"%s\" database=\"%s\" age=%v retention=%v ...",
+			item.identifier, item.description, backupAge, retention)
+		if backupDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted backup: file=\"%s\" database=\"%s\" age=%v retention=%v", item.identifier, item.description, backupAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting backup: file=\"%s\" database=\"%s\" age=%d retention=%v", item.identifier, item.description, backupAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping backup: file=\"%s\" database=\"%s\" age=%d retention=%d", item.identifier, item.description, backupAge, retention)
+		}
+	}
+
+	progress.Logf("backups")
+
+	return nil
+}
@@ -0,0 +1,461 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigMysqlBinlog struct {
+	Module             string `koanf:"module"`
+	Enabled            any    `koanf:"enabled"`
+	DryRun             bool   `koanf:"dryrun"`
+	DryRunCreate       bool   `koanf:"dryrun_create"`
+	DryRunDelete       bool   `koanf:"dryrun_delete"`
+	Retention          int64  `koanf:"retention"`
+	AwsRegion          string `koanf:"aws_region"`
+	AccessKeyId        string `koanf:"accesskey_id"`
+	AccessKeySecret    string `koanf:"accesskey_secret"`
+	MysqlHost          string `koanf:"mysql_host"`
+	MysqlDefaultsFile  string `koanf:"mysql_defaults_file"`
+	MysqlBinDir        string `koanf:"mysql_bin_dir"`
+	FullBackupInterval int64  `koanf:"full_backup_interval"`
+	Destination        string `koanf:"destination"`
+}
+
+type backup_mysql_binlog struct {
+	config      JobConfigMysqlBinlog
+	cfg         aws.Config
+	mysql       *ProviderMysql
+	destination BackupDestination
+	fullprefix  string
+	logprefix   string
+	binlogs     map[string]string
+}
+
+var validateConfigMysqlBinlog = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"mysql-binlog"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "mysql_host",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "mysql_defaults_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "mysql_bin_dir",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "full_backup_interval",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_mysql_binlog) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigMysqlBinlog); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	if b.config.FullBackupInterval <= 0 {
+		return fmt.Errorf("Option \"full_backup_interval\" must be a valid number of hours greater than 0")
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fullprefix = fmt.Sprintf("mysql-%s-full-", jobname)
+	b.logprefix = fmt.Sprintf("mysql-%s-binlog-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- MysqlHost=\"%v\"", b.config.MysqlHost)
+	slog.Debugf("- MysqlDefaultsFile=\"%v\"", b.config.MysqlDefaultsFile)
+	slog.Debugf("- MysqlBinDir=\"%v\"", b.config.MysqlBinDir)
+	slog.Debugf("- FullBackupInterval=%v", b.config.FullBackupInterval)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_mysql_binlog) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	bindir := b.config.MysqlBinDir
+	if bindir != "" {
+		bindir = strings.TrimSuffix(bindir, "/") + "/"
+	}
+	b.mysql = &ProviderMysql{
+		mysqlPath:       bindir + "mysql",
+		mysqldumpPath:   bindir + "mysqldump",
+		mysqlbinlogPath: bindir + "mysqlbinlog",
+		defaultsFile:    b.config.MysqlDefaultsFile,
+		host:            b.config.MysqlHost,
+	}
+	b.binlogs = make(map[string]string)
+
+	return nil
+}
+
+// Return the time of a full backup and the first binary log it requires from its file name
+func (b *backup_mysql_binlog) parseFullBackupName(filename string) (time.Time, string, bool) {
+	if strings.HasPrefix(filename, b.fullprefix) == false || strings.HasSuffix(filename, ".sql.gz") == false {
+		return time.Time{}, "", false
+	}
+	datetime, binlog, found := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(filename, b.fullprefix), ".sql.gz"), "_")
+	if found == false {
+		return time.Time{}, "", false
+	}
+	backuptime, err := time.Parse("20060102-150405", datetime)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return backuptime, binlog, true
+}
+
+func (b *backup_mysql_binlog) CreateBackup() error {
+
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list the files in the destination: %w", err)
+	}
+
+	// Find the most recent full backup and the binary logs which have already been copied
+	var latest time.Time
+	var firstlog int64 = -1
+	copied := make(map[string]bool)
+	for _, filename := range filenames {
+		if backuptime, binlog, ok := b.parseFullBackupName(filename); ok == true {
+			if backuptime.After(latest) {
+				latest = backuptime
+			}
+			if sequence, err := mysqlBinlogSequence(binlog); err == nil && (firstlog < 0 || sequence < firstlog) {
+				firstlog = sequence
+			}
+		}
+		if strings.HasPrefix(filename, b.logprefix) && strings.HasSuffix(filename, ".gz") {
+			copied[strings.TrimSuffix(strings.TrimPrefix(filename, b.logprefix), ".gz")] = true
+		}
+	}
+
+	// A new full backup is created when the most recent one is older than the interval
+	curtime := clockNow()
+	if curtime.Sub(latest) >= time.Duration(b.config.FullBackupInterval)*time.Hour {
+		if b.config.DryRunCreate == false {
+			slog.Infof("Creating full backup of the MySQL server ...")
+			dump, binlog, err := ProviderMysqlDumpAll(b.mysql)
+			if err != nil {
+				return fmt.Errorf("failed to create the full backup of the MySQL server: %w", err)
+			}
+			filename := fmt.Sprintf("%s%s_%s.sql.gz", b.fullprefix, curtime.UTC().Format("20060102-150405"), binlog)
+			_, err = destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+				return archiveCompressStream(output, dump)
+			})
+			dump.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write full backup \"%s\" to the destination: %w", filename, err)
+			}
+			slog.Infof("Successfully created full backup \"%s\" in \"%s\"", filename, b.destination)
+			eventItemf("created", "Created full backup \"%s\" in \"%s\"", filename, b.destination)
+			if sequence, err := mysqlBinlogSequence(binlog); err == nil && firstlog < 0 {
+				firstlog = sequence
+			}
+		} else {
+			slog.Infof("Dryrun: Not creating full backup in \"%s\"", b.destination)
+		}
+	}
+
+	// Binary logs are only useful when they follow a full backup
+	if firstlog < 0 {
+		slog.Infof("There is no full backup yet so binary logs are not copied")
+		return nil
+	}
+
+	binlogs, err := ProviderMysqlListClosedBinlogs(b.mysql)
+	if err != nil {
+		return fmt.Errorf("failed to list the closed binary logs: %w", err)
+	}
+
+	progress := NewProgressSummary(len(binlogs))
+
+	for _, binlog := range binlogs {
+		sequence, err := mysqlBinlogSequence(binlog)
+		if err != nil {
+			return fmt.Errorf("invalid name of binary log \"%s\": %w", binlog, err)
+		}
+		if sequence < firstlog || copied[binlog] == true {
+			continue
+		}
+		filename := fmt.Sprintf("%s%s.gz", b.logprefix, binlog)
+		if b.config.DryRunCreate == false {
+			rawdata, err := ProviderMysqlFetchBinlog(b.mysql, binlog)
+			if err != nil {
+				return fmt.Errorf("failed to fetch binary log \"%s\": %w", binlog, err)
+			}
+			_, err = destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+				return archiveCompressStream(output, rawdata)
+			})
+			rawdata.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write binary log \"%s\" to the destination: %w", filename, err)
+			}
+			progress.Itemf("copied", "Successfully copied binary log \"%s\" to \"%s\"", binlog, filename)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not copying binary log \"%s\"", binlog)
+		}
+	}
+
+	progress.Logf("binary logs")
+
+	return nil
+}
+
+func (b *backup_mysql_binlog) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing backups in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		backuptime, binlog, ok := b.parseFullBackupName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = backuptime.Unix()
+		results = append(results, item)
+		b.binlogs[filename] = binlog
+		slog.Debugf("Found full backup: file=\"%s\" created=\"%v\" binlog=\"%s\"", filename, backuptime.Format(time.RFC3339), binlog)
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_mysql_binlog) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	// Binary logs written before the oldest full backup which is kept are not required anymore
+	var firstlog int64 = -1
+
+	for _, item := range bkpitems {
+		backupAge := (curtime - item.timestamp) / 86400
+		backupDelete := backupAge > retention
+		slog.Debugf("Considering deletion of full backup: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, backupAge, retention)
+		if backupDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted full backup: file=\"%s\" age=%v retention=%v", item.identifier, backupAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting full backup: file=\"%s\" age=%d retention=%v", item.identifier, backupAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping full backup: file=\"%s\" age=%d retention=%d", item.identifier, backupAge, retention)
+			if sequence, err := mysqlBinlogSequence(b.binlogs[item.identifier]); err == nil && (firstlog < 0 || sequence < firstlog) {
+				firstlog = sequence
+			}
+		}
+	}
+
+	progress.Logf("full backups")
+
+	// Binary logs are kept as long as there is no full backup to define which ones are required
+	if firstlog < 0 {
+		return nil
+	}
+
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list the files in the destination: %w", err)
+	}
+
+	logprogress := NewProgressSummary(len(filenames))
+
+	for _, filename := range filenames {
+		if strings.HasPrefix(filename, b.logprefix) == false || strings.HasSuffix(filename, ".gz") == false {
+			continue
+		}
+		binlog := strings.TrimSuffix(strings.TrimPrefix(filename, b.logprefix), ".gz")
+		sequence, err := mysqlBinlogSequence(binlog)
+		if err != nil || sequence >= firstlog {
+			continue
+		}
+		if b.config.DryRunDelete == false {
+			if err := b.destination.DeleteFile(filename); err != nil {
+				return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", filename, err)
+			}
+			logprogress.Itemf("deleted", "Deleted binary log: file=\"%s\" as it precedes the oldest full backup", filename)
+		} else {
+			logprogress.Itemf("skipped", "Dryrun: Not deleting binary log: file=\"%s\"", filename)
+		}
+	}
+
+	logprogress.Logf("binary logs")
+
+	return nil
+}
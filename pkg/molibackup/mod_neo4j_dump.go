@@ -0,0 +1,383 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigNeo4jDump struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	Neo4jAdminPath  string `koanf:"neo4j_admin_path"`
+	Neo4jConf       string `koanf:"neo4j_conf"`
+	Databases       any    `koanf:"databases"`
+	Mode            string `koanf:"mode"`
+	BackupFrom      string `koanf:"backup_from"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_neo4j_dump struct {
+	config      JobConfigNeo4jDump
+	cfg         aws.Config
+	neo4j       *ProviderNeo4j
+	destination BackupDestination
+	databases   []string
+	fileprefix  string
+}
+
+// Extensions of the files created by neo4j-admin in each mode, all of them are considered
+// when listing files so the retention still applies to old files after the mode is changed
+var neo4jDumpExtensions = map[string]string{
+	"dump":   ".dump",
+	"backup": ".backup",
+}
+
+var validateConfigNeo4jDump = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"neo4j-dump"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "neo4j_admin_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "neo4j-admin",
+		allowedval: nil,
+	},
+	{
+		entryname:  "neo4j_conf",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "databases",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "mode",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "dump",
+		allowedval: []string{"dump", "backup"},
+	},
+	{
+		entryname:  "backup_from",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "localhost:6362",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_neo4j_dump) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigNeo4jDump); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// Names of databases are part of the names of the files
+	b.databases = configListToStrings(b.config.Databases)
+	if len(b.databases) == 0 {
+		return fmt.Errorf("Option \"databases\" must contain at least one database")
+	}
+	for _, database := range b.databases {
+		if strings.ContainsAny(database, "/\\") == true {
+			return fmt.Errorf("Option \"databases\" must contain names of databases but \"%s\" is not valid", database)
+		}
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("neo4j-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- Neo4jAdminPath=\"%v\"", b.config.Neo4jAdminPath)
+	slog.Debugf("- Neo4jConf=\"%v\"", b.config.Neo4jConf)
+	slog.Debugf("- Databases=\"%v\"", b.databases)
+	slog.Debugf("- Mode=\"%v\"", b.config.Mode)
+	slog.Debugf("- BackupFrom=\"%v\"", b.config.BackupFrom)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_neo4j_dump) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// Neo4j-admin finds the configuration of the server in the directory specified in its environment
+	env := make(map[string]string)
+	if b.config.Neo4jConf != "" {
+		env["NEO4J_CONF"] = b.config.Neo4jConf
+	}
+
+	b.neo4j = &ProviderNeo4j{program: b.config.Neo4jAdminPath, env: env}
+
+	return nil
+}
+
+// Return the database and the time of a dump from its file name
+func (b *backup_neo4j_dump) parseDumpName(filename string) (string, time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false {
+		return "", time.Time{}, false
+	}
+	name := strings.TrimPrefix(filename, b.fileprefix)
+	matched := false
+	for _, extension := range neo4jDumpExtensions {
+		if strings.HasSuffix(name, extension) == true {
+			name = strings.TrimSuffix(name, extension)
+			matched = true
+			break
+		}
+	}
+	if matched == false {
+		return "", time.Time{}, false
+	}
+	// Names of databases can contain dashes so the date is found at the end of the name
+	datelen := len("20060102-150405")
+	if len(name) < datelen+2 || name[len(name)-datelen-1] != '-' {
+		return "", time.Time{}, false
+	}
+	dumptime, err := time.Parse("20060102-150405", name[len(name)-datelen:])
+	return name[:len(name)-datelen-1], dumptime, err == nil
+}
+
+func (b *backup_neo4j_dump) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.databases))
+	online := b.config.Mode == "backup"
+
+	for _, database := range b.databases {
+		curtime := clockNow()
+		filename := fmt.Sprintf("%s%s-%s%s", b.fileprefix, database, curtime.UTC().Format("20060102-150405"), neo4jDumpExtensions[b.config.Mode])
+		if b.config.DryRunCreate == false {
+			slog.Infof("Creating %s of database \"%s\" ...", b.config.Mode, database)
+			dump, size, err := ProviderNeo4jDumpDatabase(b.neo4j, database, online, b.config.BackupFrom)
+			if err != nil {
+				return fmt.Errorf("failed to dump database \"%s\": %w", database, err)
+			}
+			err = b.destination.WriteFile(filename, dump)
+			dump.Close()
+			if err != nil {
+				return fmt.Errorf("failed to write dump \"%s\" to the destination: %w", filename, err)
+			}
+			progress.Itemf("created", "Successfully created %s \"%s\" (%s) of database \"%s\" in \"%s\"",
+				b.config.Mode, filename, formatBytes(size), database, b.destination)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating %s \"%s\" of database \"%s\" in \"%s\"",
+				b.config.Mode, filename, database, b.destination)
+		}
+	}
+
+	progress.Logf("dumps")
+
+	return nil
+}
+
+func (b *backup_neo4j_dump) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing dumps in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		database, dumptime, ok := b.parseDumpName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = database
+		item.timestamp = dumptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found dump: file=\"%s\" database=\"%s\" created=\"%v\"", filename, database, dumptime.Format(time.RFC3339))
+	}
+
+	// Dumps are sorted by database and then by time
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].description != results[j].description {
+			return results[i].description < results[j].description
+		}
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (b *backup_neo4j_dump) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		dumpAge := (curtime - item.timestamp) / 86400
+		dumpDelete := dumpAge > retention
+		slog.Debugf("Considering deletion of dump: file=\"%s\" database=\"%s\" age=%v retention=%v ...",
+			item.identifier, item.description, dumpAge, retention)
+		if dumpDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted dump: file=\"%s\" database=\"%s\" age=%v retention=%v", item.identifier, item.description, dumpAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting dump: file=\"%s\" database=\"%s\" age=%d retention=%v", item.identifier, item.description, dumpAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping dump: file=\"%s\" database=\"%s\" age=%d retention=%d", item.identifier, item.description, dumpAge, retention)
+		}
+	}
+
+	progress.Logf("dumps")
+
+	return nil
+}
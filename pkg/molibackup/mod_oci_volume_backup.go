@@ -0,0 +1,261 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gookit/slog"
+
+	"github.com/oracle/oci-go-sdk/v65/core"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigOciVolumeBackup struct {
+	Module            string `koanf:"module"`
+	Enabled           any    `koanf:"enabled"`
+	DryRun            bool   `koanf:"dryrun"`
+	DryRunCreate      bool   `koanf:"dryrun_create"`
+	DryRunDelete      bool   `koanf:"dryrun_delete"`
+	Retention         int64  `koanf:"retention"`
+	ConfigFile        string `koanf:"config_file"`
+	ConfigProfile     string `koanf:"config_profile"`
+	InstancePrincipal bool   `koanf:"instance_principal"`
+	Region            string `koanf:"region"`
+	CompartmentIds    any    `koanf:"compartment_ids"`
+	VolumeTags        any    `koanf:"volume_tags"`
+	BackupType        string `koanf:"backup_type"`
+}
+
+type backup_oci_volume_backup struct {
+	snapshotRotation
+	config       JobConfigOciVolumeBackup
+	client       core.BlockstorageClient
+	compartments []string
+	volumes      map[string]ProviderOciVolume
+}
+
+var validateConfigOciVolumeBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"oci-volume-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "config_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "config_profile",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "DEFAULT",
+		allowedval: nil,
+	},
+	{
+		entryname:  "instance_principal",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "compartment_ids",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "volume_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "backup_type",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "incremental",
+		allowedval: []string{"full", "incremental"},
+	},
+}
+
+func (b *backup_oci_volume_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigOciVolumeBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	b.compartments = configListToStrings(b.config.CompartmentIds)
+	if len(b.compartments) == 0 {
+		return fmt.Errorf("Option \"compartment_ids\" must contain at least one compartment")
+	}
+	for _, compartmentId := range b.compartments {
+		matched, _ := regexp.MatchString("^ocid1\\.(compartment|tenancy)\\.[a-z0-9]+\\.[a-z0-9-]*\\.[a-z0-9]+$", compartmentId)
+		if matched == false {
+			return fmt.Errorf("Option \"compartment_ids\" contains an invalid compartment OCID: \"%s\"", compartmentId)
+		}
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- ConfigFile=\"%v\"", b.config.ConfigFile)
+	slog.Debugf("- ConfigProfile=\"%v\"", b.config.ConfigProfile)
+	slog.Debugf("- InstancePrincipal=%v", b.config.InstancePrincipal)
+	slog.Debugf("- Region=\"%v\"", b.config.Region)
+	slog.Debugf("- CompartmentIds=\"%v\"", b.compartments)
+	slog.Debugf("- VolumeTags=\"%v\"", b.config.VolumeTags)
+	slog.Debugf("- BackupType=\"%v\"", b.config.BackupType)
+
+	return nil
+}
+
+func (b *backup_oci_volume_backup) InitialiseModule() error {
+
+	var err error
+
+	// Create a client
+	b.client, err = ProviderOciNewBlockstorageClient(b.config.ConfigFile, b.config.ConfigProfile, b.config.InstancePrincipal, b.config.Region)
+	if err != nil {
+		return fmt.Errorf("failed to create the block storage client: %w", err)
+	}
+	b.snapshotRotation = snapshotRotation{noun: "backup", timeformat: "20060102-150405", retention: b.config.Retention,
+		dryruncreate: b.config.DryRunCreate, dryrundelete: b.config.DryRunDelete, provider: b}
+
+	// Find list of all volumes that match the conditions specified in the configuration
+	voltags := configTagsToMap(b.config.VolumeTags)
+	b.volumes = make(map[string]ProviderOciVolume)
+	for _, compartmentId := range b.compartments {
+		slog.Debugf("Listing volumes in compartment \"%s\" with volume_tags=\"%v\" ...", compartmentId, voltags)
+		volumes, err := ProviderOciGetVolumes(b.client, compartmentId, voltags)
+		if err != nil {
+			return fmt.Errorf("failed to find the volumes of compartment \"%s\": %w", compartmentId, err)
+		}
+		for _, volume := range volumes {
+			slog.Debugf("Found volume: volumeId=\"%s\" volumeName=\"%s\" compartmentId=\"%s\"", volume.volumeId, volume.volumeName, volume.compartmentId)
+			b.volumes[volume.volumeId] = volume
+			b.sources = append(b.sources, snapshotSource{id: volume.volumeId, kind: "volume", prefix: volume.volumeName})
+		}
+	}
+	if len(b.volumes) == 0 {
+		slog.Warnf("Have not found any volume matching the conditions")
+	}
+
+	return nil
+}
+
+func (b *backup_oci_volume_backup) CreateSnapshot(source snapshotSource, bkpname string, bkpdate string, bkptime string) (string, error) {
+	return ProviderOciCreateVolumeBackup(b.client, source.id, b.config.BackupType, bkpname, bkpdate, bkptime)
+}
+
+func (b *backup_oci_volume_backup) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	var results []BackupItem
+
+	backups, err := ProviderOciGetVolumeBackups(b.client, b.volumes[source.id].compartmentId, source.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Backup names start with the volume name followed by the date
+	for _, backup := range backups {
+		item := BackupItem{}
+		item.identifier = backup.backupId
+		item.description = backup.backupName
+		item.timestamp = backup.backupTime
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+func (b *backup_oci_volume_backup) DeleteSnapshot(item BackupItem) error {
+	return ProviderOciDeleteVolumeBackup(b.client, item.identifier)
+}
@@ -0,0 +1,248 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/gookit/slog"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigOpenstackCinderSnapshot struct {
+	Module         string `koanf:"module"`
+	Enabled        any    `koanf:"enabled"`
+	DryRun         bool   `koanf:"dryrun"`
+	DryRunCreate   bool   `koanf:"dryrun_create"`
+	DryRunDelete   bool   `koanf:"dryrun_delete"`
+	Retention      int64  `koanf:"retention"`
+	Region         string `koanf:"region"`
+	ServerName     string `koanf:"server_name"`
+	ServerMetadata any    `koanf:"server_metadata"`
+	ServerTags     any    `koanf:"server_tags"`
+}
+
+type backup_openstack_cinder_snapshot struct {
+	snapshotRotation
+	config       JobConfigOpenstackCinderSnapshot
+	compute      *gophercloud.ServiceClient
+	blockstorage *gophercloud.ServiceClient
+	servertags   []string
+	volumes      map[string]string
+}
+
+var validateConfigOpenstackCinderSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"openstack-cinder-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "server_name",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "server_metadata",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "server_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_openstack_cinder_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigOpenstackCinderSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	// The region can be provided in the environment together with the credentials
+	if b.config.Region == "" {
+		b.config.Region = os.Getenv("OS_REGION_NAME")
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	b.servertags = configListToStrings(b.config.ServerTags)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- Region=\"%v\"", b.config.Region)
+	slog.Debugf("- ServerName=\"%v\"", b.config.ServerName)
+	slog.Debugf("- ServerMetadata=\"%v\"", b.config.ServerMetadata)
+	slog.Debugf("- ServerTags=\"%v\"", b.servertags)
+
+	return nil
+}
+
+func (b *backup_openstack_cinder_snapshot) InitialiseModule() error {
+
+	var err error
+
+	// Create clients using the credentials provided in the environment
+	b.compute, b.blockstorage, err = ProviderOpenstackNewClients(b.config.Region)
+	if err != nil {
+		return fmt.Errorf("failed to create the OpenStack clients: %w", err)
+	}
+	b.snapshotRotation = snapshotRotation{noun: "snapshot", timeformat: "20060102-150405", retention: b.config.Retention,
+		dryruncreate: b.config.DryRunCreate, dryrundelete: b.config.DryRunDelete, provider: b}
+
+	// Find list of all servers that match the conditions specified in the configuration
+	srvmetadata := configTagsToMap(b.config.ServerMetadata)
+	slog.Debugf("Listing servers based on server_name=\"%s\" server_metadata=\"%v\" and server_tags=\"%v\" ...", b.config.ServerName, srvmetadata, b.servertags)
+	servers, err := ProviderOpenstackGetServers(b.compute, b.config.ServerName, srvmetadata, b.servertags)
+	if err != nil {
+		return fmt.Errorf("failed to find the servers to back up: %w", err)
+	}
+	if len(servers) == 0 {
+		slog.Warnf("Have not found any server matching the conditions")
+	}
+
+	// Keep track of the server each volume is attached to
+	b.volumes = make(map[string]string)
+	for _, server := range servers {
+		for _, volumeId := range server.volumeIds {
+			slog.Debugf("Found volume: volumeId=\"%s\" serverId=\"%s\" serverName=\"%s\"", volumeId, server.serverId, server.serverName)
+			b.volumes[volumeId] = server.serverName
+		}
+	}
+	if len(servers) > 0 && len(b.volumes) == 0 {
+		slog.Warnf("Have not found any volume attached to the servers matching the conditions")
+	}
+
+	// Volumes are processed in a predictable order and the names of their snapshots start with the server name
+	var volumeIds []string
+	for volumeId := range b.volumes {
+		volumeIds = append(volumeIds, volumeId)
+	}
+	sort.Strings(volumeIds)
+	for _, volumeId := range volumeIds {
+		prefix := fmt.Sprintf("%s-%s", b.volumes[volumeId], volumeId)
+		b.sources = append(b.sources, snapshotSource{id: volumeId, kind: "volume", prefix: prefix})
+	}
+
+	return nil
+}
+
+func (b *backup_openstack_cinder_snapshot) CreateSnapshot(source snapshotSource, snapname string, snapdate string, snaptime string) (string, error) {
+	return ProviderOpenstackCreateSnapshot(b.blockstorage, source.id, snapname, snapdate, snaptime)
+}
+
+func (b *backup_openstack_cinder_snapshot) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	var results []BackupItem
+
+	snapshots, err := ProviderOpenstackGetSnapshots(b.blockstorage, source.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot names start with the server name and the volume identifier followed by the date
+	for _, snapshot := range snapshots {
+		item := BackupItem{}
+		item.identifier = snapshot.snapshotId
+		item.description = snapshot.snapshotName
+		item.timestamp = snapshot.snapshotTime
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+func (b *backup_openstack_cinder_snapshot) DeleteSnapshot(item BackupItem) error {
+	return ProviderOpenstackDeleteSnapshot(b.blockstorage, item.identifier)
+}
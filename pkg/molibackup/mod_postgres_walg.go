@@ -0,0 +1,314 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigPostgresWalg struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	AwsRegion       string `koanf:"aws_region"`
+	WalgPath        string `koanf:"walg_path"`
+	WalgConfig      string `koanf:"walg_config"`
+	WalgS3Prefix    string `koanf:"walg_s3_prefix"`
+	PgData          string `koanf:"pgdata"`
+	KeepBaseBackups int    `koanf:"keep_base_backups"`
+	VerifyWal       bool   `koanf:"verify_wal"`
+}
+
+type backup_postgres_walg struct {
+	config  JobConfigPostgresWalg
+	walg    *ProviderWalg
+	backups map[string]ProviderWalgBackup
+}
+
+var validateConfigPostgresWalg = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"postgres-walg"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "walg_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "wal-g",
+		allowedval: nil,
+	},
+	{
+		entryname:  "walg_config",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "walg_s3_prefix",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "pgdata",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "keep_base_backups",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "7",
+		allowedval: nil,
+	},
+	{
+		entryname:  "verify_wal",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+}
+
+func (b *backup_postgres_walg) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigPostgresWalg); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if filepath.IsAbs(b.config.PgData) == false {
+		return fmt.Errorf("Option \"pgdata\" must be an absolute path")
+	}
+
+	if b.config.KeepBaseBackups <= 0 {
+		return fmt.Errorf("Option \"keep_base_backups\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- WalgPath=\"%v\"", b.config.WalgPath)
+	slog.Debugf("- WalgConfig=\"%v\"", b.config.WalgConfig)
+	slog.Debugf("- WalgS3Prefix=\"%v\"", b.config.WalgS3Prefix)
+	slog.Debugf("- PgData=\"%v\"", b.config.PgData)
+	slog.Debugf("- KeepBaseBackups=%v", b.config.KeepBaseBackups)
+	slog.Debugf("- VerifyWal=%v", b.config.VerifyWal)
+
+	return nil
+}
+
+func (b *backup_postgres_walg) InitialiseModule() error {
+
+	// Options of the job take precedence over the environment and the configuration file of wal-g
+	env := make(map[string]string)
+	if b.config.WalgS3Prefix != "" {
+		env["WALG_S3_PREFIX"] = b.config.WalgS3Prefix
+	}
+	if b.config.AwsRegion != "" {
+		env["AWS_REGION"] = b.config.AwsRegion
+	}
+
+	b.walg = &ProviderWalg{program: b.config.WalgPath, configFile: b.config.WalgConfig, env: env}
+	b.backups = make(map[string]ProviderWalgBackup)
+
+	return nil
+}
+
+func (b *backup_postgres_walg) CreateBackup() error {
+
+	if b.config.DryRunCreate == false {
+		slog.Infof("Creating base backup of \"%s\" ...", b.config.PgData)
+		if err := ProviderWalgBackupPush(b.walg, b.config.PgData); err != nil {
+			return fmt.Errorf("failed to create base backup of \"%s\": %w", b.config.PgData, err)
+		}
+		slog.Infof("Successfully created base backup of \"%s\"", b.config.PgData)
+		eventItemf("created", "Created base backup of \"%s\"", b.config.PgData)
+	} else {
+		slog.Infof("Dryrun: Not creating base backup of \"%s\"", b.config.PgData)
+	}
+
+	// Base backups are only useful for a point-in-time recovery if the WAL segments have been archived
+	if b.config.VerifyWal == true {
+		if err := ProviderWalgVerifyWal(b.walg); err != nil {
+			return fmt.Errorf("failed to verify the archived WAL segments: %w", err)
+		}
+		slog.Infof("Successfully verified the integrity of the WAL archive")
+	}
+
+	return nil
+}
+
+func (b *backup_postgres_walg) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing base backups ...")
+	backups, err := ProviderWalgListBackups(b.walg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, backup := range backups {
+		item := BackupItem{}
+		item.identifier = backup.backupName
+		item.description = backup.backupName
+		item.timestamp = backup.backupTime
+		results = append(results, item)
+		b.backups[backup.backupName] = backup
+		bkptime := time.Unix(backup.backupTime, 0)
+		slog.Debugf("Found base backup: name=\"%s\" created=\"%v\" full=%v permanent=%v",
+			backup.backupName, bkptime.Format(time.RFC3339), backup.isFull, backup.isPermanent)
+	}
+
+	// Base backups are sorted chronologically to determine which ones are retained
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (b *backup_postgres_walg) DeleteOldBackups(bkpitems []BackupItem) error {
+	return b.DeleteOldBackupsExcept(bkpitems, nil)
+}
+
+// Full backups are counted among all base backups including recent ones, and nothing is deleted when wal-g
+// would delete a recent backup as it cannot be told to keep specific backups
+func (b *backup_postgres_walg) DeleteOldBackupsExcept(bkpitems []BackupItem, protected map[string]bool) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	// Find the oldest of the full backups which are retained
+	var boundary int64
+	fullcount := 0
+	for i := len(bkpitems) - 1; i >= 0; i-- {
+		if b.backups[bkpitems[i].identifier].isFull == true {
+			fullcount++
+			if fullcount == b.config.KeepBaseBackups {
+				boundary = bkpitems[i].timestamp
+				break
+			}
+		}
+	}
+
+	// Backups older than the boundary are deleted together with the WAL segments only they require
+	obsolete := make(map[string]bool)
+	for _, item := range bkpitems {
+		if fullcount == b.config.KeepBaseBackups && item.timestamp < boundary && b.backups[item.identifier].isPermanent == false {
+			obsolete[item.identifier] = true
+		}
+	}
+
+	for identifier := range obsolete {
+		if protected[identifier] == true {
+			slog.Warnf("Not deleting any base backup as base backup \"%s\" would be deleted but it is younger than min_age_before_delete", identifier)
+			obsolete = make(map[string]bool)
+			break
+		}
+	}
+
+	// A single wal-g command deletes all obsolete backups as deltas cannot be deleted independently
+	if len(obsolete) > 0 && b.config.DryRunDelete == false {
+		if err := ProviderWalgDeleteRetainFull(b.walg, b.config.KeepBaseBackups); err != nil {
+			return fmt.Errorf("failed to delete the base backups beyond the %d most recent ones: %w", b.config.KeepBaseBackups, err)
+		}
+	}
+
+	for _, item := range bkpitems {
+		if obsolete[item.identifier] == true {
+			if b.config.DryRunDelete == false {
+				progress.Itemf("deleted", "Deleted base backup: name=\"%s\" keep=%d", item.identifier, b.config.KeepBaseBackups)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting base backup: name=\"%s\" keep=%d", item.identifier, b.config.KeepBaseBackups)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping base backup: name=\"%s\" keep=%d", item.identifier, b.config.KeepBaseBackups)
+		}
+	}
+
+	progress.Logf("base backups")
+
+	return nil
+}
@@ -0,0 +1,377 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigProxmoxBackup struct {
+	Module       string `koanf:"module"`
+	Enabled      any    `koanf:"enabled"`
+	DryRun       bool   `koanf:"dryrun"`
+	DryRunCreate bool   `koanf:"dryrun_create"`
+	DryRunDelete bool   `koanf:"dryrun_delete"`
+	Retention    int64  `koanf:"retention"`
+	KeepLast     int    `koanf:"keep_last"`
+	ApiUrl       string `koanf:"api_url"`
+	ApiToken     string `koanf:"api_token"`
+	TlsInsecure  bool   `koanf:"tls_insecure"`
+	Storage      string `koanf:"storage"`
+	Mode         string `koanf:"mode"`
+	Compress     string `koanf:"compress"`
+	TaskTimeout  int64  `koanf:"task_timeout"`
+	VmIds        any    `koanf:"vm_ids"`
+	VmTags       any    `koanf:"vm_tags"`
+}
+
+type backup_proxmox_backup struct {
+	config   JobConfigProxmoxBackup
+	client   *ProviderProxmox
+	vmids    []int
+	vmtags   []string
+	guests   []ProviderProxmoxGuest
+	archives map[string]ProviderProxmoxArchive
+}
+
+var validateConfigProxmoxBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"proxmox-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "keep_last",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "api_url",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "api_token",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "tls_insecure",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "storage",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "mode",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "snapshot",
+		allowedval: []string{"snapshot", "suspend", "stop"},
+	},
+	{
+		entryname:  "compress",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "zstd",
+		allowedval: []string{"0", "gzip", "lzo", "zstd"},
+	},
+	{
+		entryname:  "task_timeout",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "360",
+		allowedval: nil,
+	},
+	{
+		entryname:  "vm_ids",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "vm_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_proxmox_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigProxmoxBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if strings.HasPrefix(b.config.ApiUrl, "https://") == false {
+		return fmt.Errorf("Option \"api_url\" must be an https URL such as \"https://pve.example.com:8006\"")
+	}
+
+	// The token can be provided in the environment so it does not have to be stored in the configuration
+	if b.config.ApiToken == "" {
+		b.config.ApiToken = os.Getenv("PROXMOX_API_TOKEN")
+	}
+	if strings.Contains(b.config.ApiToken, "!") == false || strings.Contains(b.config.ApiToken, "=") == false {
+		return fmt.Errorf("Option \"api_token\" or the PROXMOX_API_TOKEN environment variable must be specified in the \"user@realm!tokenid=secret\" format")
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+	if b.config.KeepLast < 0 {
+		return fmt.Errorf("Option \"keep_last\" must be a valid number greater than or equal to 0")
+	}
+	if b.config.TaskTimeout <= 0 {
+		return fmt.Errorf("Option \"task_timeout\" must be a valid number of minutes greater than 0")
+	}
+
+	for _, vmid := range configListToStrings(b.config.VmIds) {
+		value, err := strconv.Atoi(vmid)
+		if err != nil || value < 100 {
+			return fmt.Errorf("Option \"vm_ids\" contains an invalid VM ID: \"%s\"", vmid)
+		}
+		b.vmids = append(b.vmids, value)
+	}
+	b.vmtags = configListToStrings(b.config.VmTags)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- KeepLast=%v", b.config.KeepLast)
+	slog.Debugf("- ApiUrl=\"%v\"", b.config.ApiUrl)
+	slog.Debugf("- TlsInsecure=%v", b.config.TlsInsecure)
+	slog.Debugf("- Storage=\"%v\"", b.config.Storage)
+	slog.Debugf("- Mode=\"%v\"", b.config.Mode)
+	slog.Debugf("- Compress=\"%v\"", b.config.Compress)
+	slog.Debugf("- TaskTimeout=%v", b.config.TaskTimeout)
+	slog.Debugf("- VmIds=\"%v\"", b.vmids)
+	slog.Debugf("- VmTags=\"%v\"", b.vmtags)
+
+	return nil
+}
+
+func (b *backup_proxmox_backup) InitialiseModule() error {
+
+	var err error
+
+	// Create a client
+	b.client = ProviderProxmoxNewClient(b.config.ApiUrl, b.config.ApiToken, b.config.TlsInsecure)
+	b.archives = make(map[string]ProviderProxmoxArchive)
+
+	// Find list of all guests that match the conditions specified in the configuration
+	slog.Debugf("Listing guests based on vm_ids=\"%v\" and vm_tags=\"%v\" ...", b.vmids, b.vmtags)
+	b.guests, err = ProviderProxmoxGetGuests(b.client, b.vmids, b.vmtags)
+	if err != nil {
+		return fmt.Errorf("failed to find the guests to back up: %w", err)
+	}
+	for _, guest := range b.guests {
+		slog.Debugf("Found guest: vmid=%d name=\"%s\" type=\"%s\" node=\"%s\"", guest.vmid, guest.guestName, guest.guestType, guest.node)
+	}
+	if len(b.guests) == 0 {
+		slog.Warnf("Have not found any virtual machine or container matching the conditions")
+	}
+
+	return nil
+}
+
+func (b *backup_proxmox_backup) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.guests))
+	timeout := time.Duration(b.config.TaskTimeout) * time.Minute
+
+	for _, guest := range b.guests {
+		slog.Debugf("Considering backup for guest: vmid=%d ...", guest.vmid)
+		snaptime := fmt.Sprintf("%v", clockNow().Unix())
+		if b.config.DryRunCreate == false {
+			err := ProviderProxmoxRunVzdump(b.client, guest, b.config.Storage, b.config.Mode, b.config.Compress, snaptime, timeout)
+			if err != nil {
+				return fmt.Errorf("failed to create vzdump archive of guest %d: %w", guest.vmid, err)
+			}
+			progress.Itemf("created", "Successfully created vzdump archive of guest %d \"%s\" in storage \"%s\"", guest.vmid, guest.guestName, b.config.Storage)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating vzdump archive of guest %d \"%s\"", guest.vmid, guest.guestName)
+		}
+	}
+
+	progress.Logf("archives")
+
+	return nil
+}
+
+func (b *backup_proxmox_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	// Enumerate guests and their archives to get a list of relevant archives
+	for _, guest := range b.guests {
+		slog.Debugf("Listing archives of guest: vmid=%d ...", guest.vmid)
+
+		archives, err := ProviderProxmoxGetArchives(b.client, guest, b.config.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, archive := range archives {
+			item := BackupItem{}
+			item.identifier = archive.volid
+			item.description = archive.volid
+			item.timestamp = archive.archiveTime
+			results = append(results, item)
+			b.archives[archive.volid] = archive
+			archtime := time.Unix(archive.archiveTime, 0)
+			slog.Debugf("Found archive: volid=\"%s\" created=\"%v\" vmid=%d protected=%v",
+				archive.volid, archtime.Format(time.RFC3339), archive.vmid, archive.protected)
+		}
+	}
+
+	// Archive names include the guest type, the VM ID and the date
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_proxmox_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+	return b.DeleteOldBackupsExcept(bkpitems, nil)
+}
+
+// Archives are ranked among all the archives of their guest for keep_last, including recent archives which are protected
+func (b *backup_proxmox_backup) DeleteOldBackupsExcept(bkpitems []BackupItem, protected map[string]bool) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	// Rank archives of each guest from the most recent to the oldest to apply keep_last
+	ranks := make(map[string]int)
+	sorted := append([]BackupItem(nil), bkpitems...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].timestamp > sorted[j].timestamp
+	})
+	counts := make(map[int]int)
+	for _, item := range sorted {
+		vmid := b.archives[item.identifier].vmid
+		counts[vmid]++
+		ranks[item.identifier] = counts[vmid]
+	}
+
+	for _, item := range bkpitems {
+		archive := b.archives[item.identifier]
+		archiveAge := (curtime - item.timestamp) / 86400
+		archiveDelete := archiveAge > retention || (b.config.KeepLast > 0 && ranks[item.identifier] > b.config.KeepLast)
+		slog.Debugf("Considering deletion of archive: volid=\"%s\" age=%v retention=%v rank=%v ...",
+			item.identifier, archiveAge, retention, ranks[item.identifier])
+		if archiveDelete == true && (archive.protected == true || protected[item.identifier] == true) {
+			progress.Itemf("kept", "Keeping protected archive: volid=\"%s\" age=%d retention=%d", item.identifier, archiveAge, retention)
+		} else if archiveDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderProxmoxDeleteArchive(b.client, archive.node, b.config.Storage, item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete archive \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted archive: volid=\"%s\" age=%v retention=%v", item.identifier, archiveAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting archive: volid=\"%s\" age=%d retention=%v", item.identifier, archiveAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping archive: volid=\"%s\" age=%d retention=%d", item.identifier, archiveAge, retention)
+		}
+	}
+
+	progress.Logf("archives")
+
+	return nil
+}
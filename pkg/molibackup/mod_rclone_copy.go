@@ -0,0 +1,333 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigRcloneCopy struct {
+	Module       string `koanf:"module"`
+	Enabled      any    `koanf:"enabled"`
+	DryRun       bool   `koanf:"dryrun"`
+	DryRunCreate bool   `koanf:"dryrun_create"`
+	DryRunDelete bool   `koanf:"dryrun_delete"`
+	Retention    int64  `koanf:"retention"`
+	RclonePath   string `koanf:"rclone_path"`
+	ConfigFile   string `koanf:"config_file"`
+	Environment  any    `koanf:"environment"`
+	Remote       string `koanf:"remote"`
+	Sources      any    `koanf:"sources"`
+	SourceJob    string `koanf:"source_job"`
+	BwLimit      string `koanf:"bwlimit"`
+}
+
+type backup_rclone_copy struct {
+	config    JobConfigRcloneCopy
+	rclone    *ProviderRclone
+	sources   []string
+	dirprefix string
+}
+
+var validateConfigRcloneCopy = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"rclone-copy"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "rclone_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "rclone",
+		allowedval: nil,
+	},
+	{
+		entryname:  "config_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "environment",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "remote",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "sources",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "source_job",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "bwlimit",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_rclone_copy) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigRcloneCopy); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	if strings.Contains(b.config.Remote, ":") == false {
+		return fmt.Errorf("Option \"remote\" must be an rclone remote such as \"remote:path\" but \"%s\" is not", b.config.Remote)
+	}
+
+	b.sources = configListToStrings(b.config.Sources)
+	if (len(b.sources) == 0) == (b.config.SourceJob == "") {
+		return fmt.Errorf("Either option \"sources\" or option \"source_job\" must be specified")
+	}
+
+	// Files produced by another job are copied from its local destination after this job has run
+	if b.config.SourceJob != "" {
+		if _, found := jobmetadefs[b.config.SourceJob]; found == false {
+			return fmt.Errorf("Option \"source_job\" refers to job \"%s\" which does not exist", b.config.SourceJob)
+		}
+		destination := kconfig.String(fmt.Sprintf("jobs.%s.destination", b.config.SourceJob))
+		if destination == "" || filepath.IsAbs(destination) == false {
+			return fmt.Errorf("Option \"source_job\" must refer to a job with a local destination but job \"%s\" has none", b.config.SourceJob)
+		}
+		if b.config.SourceJob > jobname {
+			slog.Warnf("Job \"%s\" runs after this job as jobs run in alphabetical order so its latest files are copied by the next run", b.config.SourceJob)
+		}
+		b.sources = []string{destination}
+	}
+	for _, source := range b.sources {
+		if filepath.IsAbs(source) == false {
+			return fmt.Errorf("Option \"sources\" must contain absolute paths but \"%s\" is not", source)
+		}
+	}
+
+	// Copies are stored in directories named after the job so multiple jobs can share the same remote
+	b.dirprefix = fmt.Sprintf("rclone-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- RclonePath=\"%v\"", b.config.RclonePath)
+	slog.Debugf("- ConfigFile=\"%v\"", b.config.ConfigFile)
+	slog.Debugf("- Environment=\"%v\"", b.config.Environment)
+	slog.Debugf("- Remote=\"%v\"", b.config.Remote)
+	slog.Debugf("- Sources=\"%v\"", b.sources)
+	slog.Debugf("- SourceJob=\"%v\"", b.config.SourceJob)
+	slog.Debugf("- BwLimit=\"%v\"", b.config.BwLimit)
+
+	return nil
+}
+
+func (b *backup_rclone_copy) InitialiseModule() error {
+
+	env := configTagsToMap(b.config.Environment)
+
+	b.rclone = &ProviderRclone{program: b.config.RclonePath, configFile: b.config.ConfigFile, bwlimit: b.config.BwLimit, env: env}
+
+	return nil
+}
+
+// Return the time of a copy from the name of its directory
+func (b *backup_rclone_copy) parseDirectoryName(dirname string) (time.Time, bool) {
+	if strings.HasPrefix(dirname, b.dirprefix) == false {
+		return time.Time{}, false
+	}
+	copytime, err := time.Parse("20060102-150405", strings.TrimPrefix(dirname, b.dirprefix))
+	return copytime, err == nil
+}
+
+func (b *backup_rclone_copy) CreateBackup() error {
+
+	curtime := clockNow()
+	dirname := fmt.Sprintf("%s%s", b.dirprefix, curtime.UTC().Format("20060102-150405"))
+	remotedir := rcloneJoinPath(b.config.Remote, dirname)
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating copy \"%s\" of %v", remotedir, b.sources)
+		return nil
+	}
+
+	slog.Infof("Creating copy \"%s\" of %v ...", remotedir, b.sources)
+	for _, source := range b.sources {
+		info, err := os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("failed to access source \"%s\": %v", source, err)
+		}
+		// Directories are copied into a sub-directory with the same name so several sources do not get mixed
+		target := remotedir
+		if info.IsDir() == true && b.config.SourceJob == "" {
+			target = rcloneJoinPath(remotedir, filepath.Base(source))
+		}
+		if err := ProviderRcloneCopy(b.rclone, source, target); err != nil {
+			return fmt.Errorf("failed to copy \"%s\" to \"%s\": %w", source, target, err)
+		}
+	}
+	slog.Infof("Successfully created copy \"%s\" of %v", remotedir, b.sources)
+	eventItemf("created", "Created copy \"%s\"", remotedir)
+
+	return nil
+}
+
+func (b *backup_rclone_copy) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing copies in \"%s\" ...", b.config.Remote)
+	dirnames, err := ProviderRcloneListDirectories(b.rclone, b.config.Remote)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dirname := range dirnames {
+		copytime, ok := b.parseDirectoryName(dirname)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = dirname
+		item.description = rcloneJoinPath(b.config.Remote, dirname)
+		item.timestamp = copytime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found copy: dir=\"%s\" created=\"%v\"", dirname, copytime.Format(time.RFC3339))
+	}
+
+	// Directory names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_rclone_copy) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		copyAge := (curtime - item.timestamp) / 86400
+		copyDelete := copyAge > retention
+		slog.Debugf("Considering deletion of copy: dir=\"%s\" age=%v retention=%v ...",
+			item.identifier, copyAge, retention)
+		if copyDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderRclonePurge(b.rclone, item.description)
+				if err != nil {
+					return fmt.Errorf("failed to purge \"%s\": %w", item.description, err)
+				}
+				progress.Itemf("deleted", "Deleted copy: dir=\"%s\" age=%v retention=%v", item.identifier, copyAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting copy: dir=\"%s\" age=%d retention=%v", item.identifier, copyAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping copy: dir=\"%s\" age=%d retention=%d", item.identifier, copyAge, retention)
+		}
+	}
+
+	progress.Logf("copies")
+
+	return nil
+}
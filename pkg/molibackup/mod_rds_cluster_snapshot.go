@@ -0,0 +1,271 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+)
+
+// Structure of the job configuration for modules which manage snapshots of clusters using the RDS API
+type JobConfigRdsClusterSnapshot struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	ClusterId       string `koanf:"cluster_id"`
+	ClusterTags     any    `koanf:"cluster_tags"`
+}
+
+// Snapshots of the clusters managed using the RDS API which are identified by their engine, where each
+// module such as "aurora-cluster-snapshot" or "neptune-snapshot" has its own list of engines
+type backup_rds_cluster_snapshot struct {
+	snapshotRotation
+	module  string
+	label   string
+	engines []string
+	config  JobConfigRdsClusterSnapshot
+	cfg     aws.Config
+	client  *rds.Client
+}
+
+// Database engines which are considered as Aurora clusters
+var auroraClusterEngines = []string{"aurora", "aurora-mysql", "aurora-postgresql"}
+
+// Neptune clusters are managed using the RDS API and they are identified by their engine
+var neptuneClusterEngines = []string{"neptune"}
+
+// Create a module which manages the clusters of the engines specified, where the label is the name of
+// these clusters in messages such as "Aurora"
+func newRdsClusterSnapshot(module string, label string, engines []string) *backup_rds_cluster_snapshot {
+	return &backup_rds_cluster_snapshot{module: module, label: label, engines: engines}
+}
+
+func newAuroraClusterSnapshot() BackupModule {
+	return newRdsClusterSnapshot("aurora-cluster-snapshot", "Aurora", auroraClusterEngines)
+}
+
+func newNeptuneSnapshot() BackupModule {
+	return newRdsClusterSnapshot("neptune-snapshot", "Neptune", neptuneClusterEngines)
+}
+
+// Return the validation of the job configuration of the module specified which manages clusters using the RDS API
+func validateConfigRdsClusterSnapshot(module string) []ConfigEntryValidation {
+	return []ConfigEntryValidation{
+		{
+			entryname:  "module",
+			entrytype:  "string",
+			mandatory:  true,
+			allowedval: []string{module},
+		},
+		{
+			entryname:  "enabled",
+			entrytype:  "",
+			mandatory:  false,
+			defaultval: "true",
+			allowedval: []string{"true", "false"},
+		},
+		{
+			entryname:  "snooze_until",
+			entrytype:  "",
+			mandatory:  false,
+			defaultval: "",
+			allowedval: nil,
+		},
+		{
+			entryname:  "dryrun",
+			entrytype:  "bool",
+			mandatory:  false,
+			defaultval: "false",
+			allowedval: []string{"true", "false"},
+		},
+		{
+			entryname:  "dryrun_create",
+			entrytype:  "bool",
+			mandatory:  false,
+			defaultval: "",
+			allowedval: []string{"true", "false"},
+		},
+		{
+			entryname:  "dryrun_delete",
+			entrytype:  "bool",
+			mandatory:  false,
+			defaultval: "",
+			allowedval: []string{"true", "false"},
+		},
+		{
+			entryname:  "min_age_before_delete",
+			entrytype:  "int",
+			mandatory:  false,
+			defaultval: "24",
+			allowedval: nil,
+		},
+		{
+			entryname:  "retention",
+			entrytype:  "int",
+			mandatory:  false,
+			defaultval: "30",
+			allowedval: nil,
+		},
+		{
+			entryname:  "aws_region",
+			entrytype:  "string",
+			mandatory:  true,
+			allowedval: nil,
+		},
+		{
+			entryname:  "accesskey_id",
+			entrytype:  "string",
+			mandatory:  false,
+			defaultval: "",
+			allowedval: nil,
+		},
+		{
+			entryname:  "accesskey_secret",
+			entrytype:  "string",
+			mandatory:  false,
+			defaultval: "",
+			allowedval: nil,
+		},
+		{
+			entryname:  "cluster_id",
+			entrytype:  "string",
+			mandatory:  false,
+			defaultval: "",
+			allowedval: nil,
+		},
+		{
+			entryname:  "cluster_tags",
+			entrytype:  "",
+			mandatory:  false,
+			defaultval: "",
+			allowedval: nil,
+		},
+	}
+}
+
+func (b *backup_rds_cluster_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigRdsClusterSnapshot(b.module)); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.ClusterId != "" {
+		matched, _ := regexp.MatchString("^[a-zA-Z][a-zA-Z0-9-]{0,62}$", b.config.ClusterId)
+		if matched == false {
+			return fmt.Errorf("Option \"cluster_id\" must be a valid DB cluster identifier")
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- ClusterId=\"%v\"", b.config.ClusterId)
+	slog.Debugf("- ClusterTags=\"%v\"", b.config.ClusterTags)
+
+	return nil
+}
+
+func (b *backup_rds_cluster_snapshot) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewRdsClient(b.cfg)
+	b.snapshotRotation = snapshotRotation{noun: "snapshot", timeformat: "20060102-150405", retention: b.config.Retention,
+		dryruncreate: b.config.DryRunCreate, dryrundelete: b.config.DryRunDelete, provider: b}
+
+	// Find list of all clusters that match the conditions specified in the configuration
+	clustags := configTagsToMap(b.config.ClusterTags)
+	slog.Debugf("Listing %s clusters based on cluster_id=\"%s\" and cluster_tags=\"%v\" ...", b.label, b.config.ClusterId, clustags)
+	clusters, err := ProviderAwsGetRdsClusters(b.client, b.config.ClusterId, clustags, b.engines)
+	if err != nil {
+		return fmt.Errorf("failed to find the %s clusters to back up: %w", b.label, err)
+	}
+	for _, cluster := range clusters {
+		slog.Debugf("Found cluster: clusterId=\"%s\" engine=\"%s\"", cluster.clusterId, cluster.clusterEngine)
+		b.sources = append(b.sources, snapshotSource{id: cluster.clusterId, kind: "cluster", prefix: cluster.clusterId})
+	}
+	if len(clusters) == 0 {
+		slog.Warnf("Have not found any %s cluster matching the conditions", b.label)
+	}
+
+	return nil
+}
+
+func (b *backup_rds_cluster_snapshot) CreateSnapshot(source snapshotSource, snapname string, snapdate string, snaptime string) (string, error) {
+	return ProviderAwsCreateRdsClusterSnapshot(b.client, source.id, snapname, snapdate, snaptime)
+}
+
+func (b *backup_rds_cluster_snapshot) ListSnapshots(source snapshotSource) ([]BackupItem, error) {
+	var results []BackupItem
+
+	snapshots, err := ProviderAwsGetRdsClusterSnapshots(b.client, source.id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot identifiers start with the cluster identifier followed by the date
+	for _, snapshot := range snapshots {
+		item := BackupItem{}
+		item.identifier = snapshot.snapshotId
+		item.description = snapshot.snapshotId
+		item.timestamp = snapshot.snapshotTime
+		results = append(results, item)
+	}
+
+	return results, nil
+}
+
+func (b *backup_rds_cluster_snapshot) DeleteSnapshot(item BackupItem) error {
+	return ProviderAwsDeleteRdsClusterSnapshot(b.client, item.identifier)
+}
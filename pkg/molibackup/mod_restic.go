@@ -0,0 +1,321 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigRestic struct {
+	Module       string `koanf:"module"`
+	Enabled      any    `koanf:"enabled"`
+	DryRun       bool   `koanf:"dryrun"`
+	DryRunCreate bool   `koanf:"dryrun_create"`
+	DryRunDelete bool   `koanf:"dryrun_delete"`
+	Retention    int64  `koanf:"retention"`
+	ResticPath   string `koanf:"restic_path"`
+	Repository   string `koanf:"repository"`
+	PasswordFile string `koanf:"password_file"`
+	Environment  any    `koanf:"environment"`
+	Directories  any    `koanf:"directories"`
+	Exclude      any    `koanf:"exclude"`
+	Prune        bool   `koanf:"prune"`
+}
+
+type backup_restic struct {
+	config      JobConfigRestic
+	restic      *ProviderRestic
+	directories []string
+	exclude     []string
+	tag         string
+}
+
+var validateConfigRestic = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"restic"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "restic_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "restic",
+		allowedval: nil,
+	},
+	{
+		entryname:  "repository",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "password_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "environment",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "directories",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "prune",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+}
+
+func (b *backup_restic) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigRestic); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// The repository can be provided in the environment in the same way as for restic itself
+	if b.config.Repository == "" && os.Getenv("RESTIC_REPOSITORY") == "" {
+		return fmt.Errorf("Option \"repository\" or the RESTIC_REPOSITORY environment variable must be specified")
+	}
+
+	b.directories = configListToStrings(b.config.Directories)
+	if len(b.directories) == 0 {
+		return fmt.Errorf("Option \"directories\" must contain at least one directory")
+	}
+	for _, directory := range b.directories {
+		if filepath.IsAbs(directory) == false {
+			return fmt.Errorf("Option \"directories\" must contain absolute paths but \"%s\" is not", directory)
+		}
+	}
+	b.exclude = configListToStrings(b.config.Exclude)
+
+	// Snapshots are tagged with the name of the job so multiple jobs can share the same repository
+	b.tag = fmt.Sprintf("molibackup-%s", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- ResticPath=\"%v\"", b.config.ResticPath)
+	slog.Debugf("- Repository=\"%v\"", b.config.Repository)
+	slog.Debugf("- PasswordFile=\"%v\"", b.config.PasswordFile)
+	slog.Debugf("- Environment=\"%v\"", b.config.Environment)
+	slog.Debugf("- Directories=\"%v\"", b.directories)
+	slog.Debugf("- Exclude=\"%v\"", b.exclude)
+	slog.Debugf("- Prune=%v", b.config.Prune)
+
+	return nil
+}
+
+func (b *backup_restic) InitialiseModule() error {
+
+	// Options of the job take precedence over the environment
+	env := configTagsToMap(b.config.Environment)
+	if b.config.Repository != "" {
+		env["RESTIC_REPOSITORY"] = b.config.Repository
+	}
+	if b.config.PasswordFile != "" {
+		env["RESTIC_PASSWORD_FILE"] = b.config.PasswordFile
+	}
+
+	// Snapshots are filtered by host so multiple hosts can run jobs with the same name
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get the hostname: %v", err)
+	}
+
+	b.restic = &ProviderRestic{program: b.config.ResticPath, hostname: hostname, env: env}
+
+	return nil
+}
+
+func (b *backup_restic) CreateBackup() error {
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating snapshot of %v", b.directories)
+		return nil
+	}
+
+	slog.Infof("Creating snapshot of %v ...", b.directories)
+	snapshotId, err := ProviderResticBackup(b.restic, b.directories, b.exclude, b.tag)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot of %v: %w", b.directories, err)
+	}
+	slog.Infof("Successfully created snapshot \"%s\" of %v", snapshotId, b.directories)
+	eventItemf("created", "Created snapshot \"%s\"", snapshotId)
+
+	return nil
+}
+
+func (b *backup_restic) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing snapshots with tag \"%s\" ...", b.tag)
+	snapshots, err := ProviderResticGetSnapshots(b.restic, b.tag)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		item := BackupItem{}
+		item.identifier = snapshot.snapshotId
+		item.description = snapshot.shortId
+		item.timestamp = snapshot.snapshotTime
+		results = append(results, item)
+		snaptime := time.Unix(snapshot.snapshotTime, 0)
+		slog.Debugf("Found snapshot: id=\"%s\" created=\"%v\" paths=%v",
+			snapshot.shortId, snaptime.Format(time.RFC3339), snapshot.paths)
+	}
+
+	// Snapshot IDs are random so snapshots are sorted by time
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (b *backup_restic) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	var expired []BackupItem
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		snapAge := (curtime - item.timestamp) / 86400
+		snapDelete := snapAge > retention
+		slog.Debugf("Considering deletion of snapshot: id=\"%s\" age=%v retention=%v ...",
+			item.description, snapAge, retention)
+		if snapDelete == true {
+			if b.config.DryRunDelete == false {
+				expired = append(expired, item)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting snapshot: id=\"%s\" age=%d retention=%v", item.description, snapAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping snapshot: id=\"%s\" age=%d retention=%d", item.description, snapAge, retention)
+		}
+	}
+
+	// All expired snapshots are forgotten at once so the repository is only pruned once
+	if len(expired) > 0 {
+		var snapshotIds []string
+		for _, item := range expired {
+			snapshotIds = append(snapshotIds, item.identifier)
+		}
+		if err := ProviderResticForget(b.restic, snapshotIds, b.config.Prune); err != nil {
+			return fmt.Errorf("failed to forget %d snapshots: %w", len(snapshotIds), err)
+		}
+		for _, item := range expired {
+			snapAge := (curtime - item.timestamp) / 86400
+			progress.Itemf("deleted", "Deleted snapshot: id=\"%s\" age=%v retention=%v", item.description, snapAge, retention)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
@@ -0,0 +1,355 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigRoute53Export struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	ZonePattern     string `koanf:"zone_pattern"`
+	ZoneTags        any    `koanf:"zone_tags"`
+	Destination     string `koanf:"destination"`
+}
+
+type backup_route53_export struct {
+	config      JobConfigRoute53Export
+	cfg         aws.Config
+	client      *route53.Client
+	destination BackupDestination
+	zones       []ProviderAwsRoute53Zone
+}
+
+// Contents of each file created by this module
+type Route53Export struct {
+	ZoneId      string                    `json:"zone_id"`
+	ZoneName    string                    `json:"zone_name"`
+	PrivateZone bool                      `json:"private_zone"`
+	Timestamp   int64                     `json:"timestamp"`
+	JobId       string                    `json:"job_id"`
+	RecordSets  []types.ResourceRecordSet `json:"record_sets"`
+}
+
+var validateConfigRoute53Export = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"route53-export"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "us-east-1",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "zone_pattern",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "zone_tags",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_route53_export) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigRoute53Export); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- ZonePattern=\"%v\"", b.config.ZonePattern)
+	slog.Debugf("- ZoneTags=\"%v\"", b.config.ZoneTags)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_route53_export) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewRoute53Client(b.cfg)
+
+	// Exports are written either to a local directory or to an S3 bucket in the region of the job
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// Find list of all hosted zones that match the conditions specified in the configuration
+	zonetags := configTagsToMap(b.config.ZoneTags)
+	slog.Debugf("Listing hosted zones based on zone_pattern=\"%s\" and zone_tags=\"%v\" ...", b.config.ZonePattern, zonetags)
+	b.zones, err = ProviderAwsGetRoute53Zones(b.client, b.config.ZonePattern, zonetags)
+	if err != nil {
+		return fmt.Errorf("failed to find the hosted zones to export: %w", err)
+	}
+	for _, zone := range b.zones {
+		slog.Debugf("Found hosted zone: zoneId=\"%s\" zoneName=\"%s\" private=%v", zone.zoneId, zone.zoneName, zone.privateZone)
+	}
+	if len(b.zones) == 0 {
+		slog.Warnf("Have not found any hosted zone matching the conditions")
+	}
+
+	return nil
+}
+
+// Exports are named after the zone identifier so the zone name can be changed without affecting the rotation
+func route53ExportPrefix(zone ProviderAwsRoute53Zone) string {
+	return fmt.Sprintf("route53-%s-", zone.zoneId)
+}
+
+func (b *backup_route53_export) CreateBackup() error {
+
+	progress := NewProgressSummary(len(b.zones))
+
+	for _, zone := range b.zones {
+		slog.Debugf("Considering export of hosted zone: zoneId=\"%s\" zoneName=\"%s\" ...", zone.zoneId, zone.zoneName)
+		curtime := clockNow()
+		filename := fmt.Sprintf("%s%s-%s.json", route53ExportPrefix(zone), zone.zoneName, curtime.UTC().Format("20060102-150405"))
+
+		recordsets, err := ProviderAwsGetRoute53RecordSets(b.client, zone.zoneId)
+		if err != nil {
+			return fmt.Errorf("failed to list the records of hosted zone \"%s\": %w", zone.zoneName, err)
+		}
+		export := Route53Export{
+			ZoneId:      zone.zoneId,
+			ZoneName:    zone.zoneName,
+			PrivateZone: zone.privateZone,
+			Timestamp:   curtime.Unix(),
+			JobId:       jobExecutionId,
+			RecordSets:  recordsets,
+		}
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode the export of hosted zone %s: %v", zone.zoneId, err)
+		}
+
+		if b.config.DryRunCreate == false {
+			if err := b.destination.WriteFile(filename, bytes.NewReader(data)); err != nil {
+				return fmt.Errorf("failed to write export \"%s\" to the destination: %w", filename, err)
+			}
+			progress.Itemf("created", "Successfully exported %d record sets of hosted zone \"%s\" to \"%s\" in \"%s\"",
+				len(recordsets), zone.zoneName, filename, b.destination)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not exporting %d record sets of hosted zone \"%s\"", len(recordsets), zone.zoneName)
+		}
+	}
+
+	progress.Logf("exports")
+
+	return nil
+}
+
+func (b *backup_route53_export) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing exports in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zone := range b.zones {
+		for _, filename := range filenames {
+			// The date and time of the export are at the end of the file name
+			if strings.HasPrefix(filename, route53ExportPrefix(zone)) == false || strings.HasSuffix(filename, ".json") == false {
+				continue
+			}
+			datetime := strings.TrimSuffix(filename, ".json")
+			if len(datetime) < 15 {
+				continue
+			}
+			exporttime, err := time.Parse("20060102-150405", datetime[len(datetime)-15:])
+			if err != nil {
+				continue
+			}
+			item := BackupItem{}
+			item.identifier = filename
+			item.description = filename
+			item.timestamp = exporttime.Unix()
+			results = append(results, item)
+			slog.Debugf("Found export: file=\"%s\" created=\"%v\" zone=\"%s\"", filename, exporttime.Format(time.RFC3339), zone.zoneName)
+		}
+	}
+
+	// File names start with the zone identifier and end with the date
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_route53_export) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		exportAge := (curtime - item.timestamp) / 86400
+		exportDelete := exportAge > retention
+		slog.Debugf("Considering deletion of export: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, exportAge, retention)
+		if exportDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted export: file=\"%s\" age=%v retention=%v", item.identifier, exportAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting export: file=\"%s\" age=%d retention=%v", item.identifier, exportAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping export: file=\"%s\" age=%d retention=%d", item.identifier, exportAge, retention)
+		}
+	}
+
+	progress.Logf("exports")
+
+	return nil
+}
@@ -0,0 +1,311 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigRsyncMirror struct {
+	Module       string `koanf:"module"`
+	Enabled      any    `koanf:"enabled"`
+	DryRun       bool   `koanf:"dryrun"`
+	DryRunCreate bool   `koanf:"dryrun_create"`
+	DryRunDelete bool   `koanf:"dryrun_delete"`
+	Retention    int64  `koanf:"retention"`
+	RsyncPath    string `koanf:"rsync_path"`
+	SshPath      string `koanf:"ssh_path"`
+	Directories  any    `koanf:"directories"`
+	Exclude      any    `koanf:"exclude"`
+	Target       string `koanf:"target"`
+}
+
+type backup_rsync_mirror struct {
+	config      JobConfigRsyncMirror
+	rsync       *ProviderRsync
+	directories []string
+	exclude     []string
+	dirprefix   string
+}
+
+var validateConfigRsyncMirror = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"rsync-mirror"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "rsync_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "rsync",
+		allowedval: nil,
+	},
+	{
+		entryname:  "ssh_path",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "ssh",
+		allowedval: nil,
+	},
+	{
+		entryname:  "directories",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "target",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_rsync_mirror) LoadConfiguration(jobname string) error {
+
+	var err error
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigRsyncMirror); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	b.directories = configListToStrings(b.config.Directories)
+	if len(b.directories) == 0 {
+		return fmt.Errorf("Option \"directories\" must contain at least one directory")
+	}
+	for _, directory := range b.directories {
+		if filepath.IsAbs(directory) == false {
+			return fmt.Errorf("Option \"directories\" must contain absolute paths but \"%s\" is not", directory)
+		}
+	}
+	b.exclude = configListToStrings(b.config.Exclude)
+
+	b.rsync, err = ProviderRsyncNewTarget(b.config.RsyncPath, b.config.SshPath, b.config.Target)
+	if err != nil {
+		return fmt.Errorf("Option \"target\" is invalid: %w", err)
+	}
+
+	// Directories are named after the job so multiple jobs can share the same target
+	b.dirprefix = fmt.Sprintf("rsync-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- RsyncPath=\"%v\"", b.config.RsyncPath)
+	slog.Debugf("- SshPath=\"%v\"", b.config.SshPath)
+	slog.Debugf("- Directories=\"%v\"", b.directories)
+	slog.Debugf("- Exclude=\"%v\"", b.exclude)
+	slog.Debugf("- Target=\"%v\"", b.config.Target)
+
+	return nil
+}
+
+func (b *backup_rsync_mirror) InitialiseModule() error {
+
+	return nil
+}
+
+// Return the time of a copy from the name of its directory
+func (b *backup_rsync_mirror) parseDirectoryName(dirname string) (time.Time, bool) {
+	if strings.HasPrefix(dirname, b.dirprefix) == false {
+		return time.Time{}, false
+	}
+	copytime, err := time.Parse("20060102-150405", strings.TrimPrefix(dirname, b.dirprefix))
+	return copytime, err == nil
+}
+
+func (b *backup_rsync_mirror) CreateBackup() error {
+
+	dirnames, err := ProviderRsyncListDirectories(b.rsync)
+	if err != nil {
+		return fmt.Errorf("failed to list the copies in \"%s\": %w", b.rsync, err)
+	}
+
+	// Unchanged files are hard links to the most recent complete copy
+	var latest string
+	var latesttime time.Time
+	for _, dirname := range dirnames {
+		if copytime, ok := b.parseDirectoryName(dirname); ok == true && copytime.After(latesttime) {
+			latest, latesttime = dirname, copytime
+		}
+	}
+
+	curtime := clockNow()
+	dirname := fmt.Sprintf("%s%s", b.dirprefix, curtime.UTC().Format("20060102-150405"))
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating copy \"%s\" in \"%s\" based on \"%s\"", dirname, b.rsync, latest)
+		return nil
+	}
+
+	// Copies which have been interrupted are removed as they are never used as a reference
+	for _, tmpname := range dirnames {
+		if strings.HasPrefix(tmpname, b.dirprefix) && strings.HasSuffix(tmpname, ".tmp") {
+			slog.Infof("Deleting incomplete copy \"%s\" in \"%s\"", tmpname, b.rsync)
+			if err := ProviderRsyncDeleteDirectory(b.rsync, tmpname); err != nil {
+				return fmt.Errorf("failed to delete incomplete copy \"%s\": %w", tmpname, err)
+			}
+		}
+	}
+
+	slog.Infof("Creating copy \"%s\" of %v in \"%s\" based on \"%s\" ...", dirname, b.directories, b.rsync, latest)
+	if err := ProviderRsyncMirror(b.rsync, b.directories, b.exclude, dirname, latest); err != nil {
+		return fmt.Errorf("failed to create copy \"%s\": %w", dirname, err)
+	}
+	slog.Infof("Successfully created copy \"%s\" in \"%s\"", dirname, b.rsync)
+	eventItemf("created", "Created copy \"%s\" in \"%s\"", dirname, b.rsync)
+
+	return nil
+}
+
+func (b *backup_rsync_mirror) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing copies in \"%s\" ...", b.rsync)
+	dirnames, err := ProviderRsyncListDirectories(b.rsync)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dirname := range dirnames {
+		copytime, ok := b.parseDirectoryName(dirname)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = dirname
+		item.description = dirname
+		item.timestamp = copytime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found copy: dir=\"%s\" created=\"%v\"", dirname, copytime.Format(time.RFC3339))
+	}
+
+	// Directory names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_rsync_mirror) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		copyAge := (curtime - item.timestamp) / 86400
+		copyDelete := copyAge > retention
+		slog.Debugf("Considering deletion of copy: dir=\"%s\" age=%v retention=%v ...",
+			item.identifier, copyAge, retention)
+		if copyDelete == true {
+			if b.config.DryRunDelete == false {
+				err := ProviderRsyncDeleteDirectory(b.rsync, item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete copy \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted copy: dir=\"%s\" age=%v retention=%v", item.identifier, copyAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting copy: dir=\"%s\" age=%d retention=%v", item.identifier, copyAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping copy: dir=\"%s\" age=%d retention=%d", item.identifier, copyAge, retention)
+		}
+	}
+
+	progress.Logf("copies")
+
+	return nil
+}
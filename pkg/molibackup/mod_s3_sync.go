@@ -0,0 +1,394 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Name of the object which describes the contents of each backup in the destination bucket or container
+const s3SyncManifestName = "molibackup-manifest.json"
+
+// Objects larger than this size cannot be copied with a single call to CopyObject()
+const s3SyncMaxObjectSize = 5 * 1024 * 1024 * 1024
+
+// Structure of the job configuration for this specific module
+type JobConfigS3Sync struct {
+	Module            string `koanf:"module"`
+	Enabled           any    `koanf:"enabled"`
+	DryRun            bool   `koanf:"dryrun"`
+	DryRunCreate      bool   `koanf:"dryrun_create"`
+	DryRunDelete      bool   `koanf:"dryrun_delete"`
+	Retention         int64  `koanf:"retention"`
+	AwsRegion         string `koanf:"aws_region"`
+	AccessKeyId       string `koanf:"accesskey_id"`
+	AccessKeySecret   string `koanf:"accesskey_secret"`
+	SourceBucket      string `koanf:"source_bucket"`
+	SourcePrefix      string `koanf:"source_prefix"`
+	DestinationBucket string `koanf:"destination_bucket"`
+	DestinationPrefix string `koanf:"destination_prefix"`
+	DestinationRegion string `koanf:"destination_region"`
+}
+
+type backup_s3_sync struct {
+	config    JobConfigS3Sync
+	cfg       aws.Config
+	srcclient *s3.Client
+	dstclient *s3.Client
+	basepath  string
+}
+
+// Manifest stored with each backup in the destination bucket
+type S3SyncManifest struct {
+	SourceBucket string                `json:"source_bucket"`
+	SourcePrefix string                `json:"source_prefix"`
+	Timestamp    int64                 `json:"timestamp"`
+	JobId        string                `json:"job_id"`
+	Objects      []S3SyncManifestEntry `json:"objects"`
+}
+
+type S3SyncManifestEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+var validateConfigS3Sync = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"s3-sync"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "source_bucket",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "source_prefix",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination_bucket",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination_prefix",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "molibackup",
+		allowedval: nil,
+	},
+	{
+		entryname:  "destination_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_s3_sync) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigS3Sync); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	for _, bucket := range []string{b.config.SourceBucket, b.config.DestinationBucket} {
+		matched, _ := regexp.MatchString("^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$", bucket)
+		if matched == false {
+			return fmt.Errorf("Bucket name \"%s\" is not a valid S3 bucket name", bucket)
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// The destination is in the same region as the source unless specified otherwise
+	if b.config.DestinationRegion == "" {
+		b.config.DestinationRegion = b.config.AwsRegion
+	}
+
+	// Backups are stored in a dated folder under a folder named after the source bucket
+	b.basepath = path.Join(b.config.DestinationPrefix, b.config.SourceBucket) + "/"
+	if b.config.SourceBucket == b.config.DestinationBucket && strings.HasPrefix(b.basepath, b.config.SourcePrefix) {
+		return fmt.Errorf("Option \"destination_prefix\" must not be inside the source prefix when using the same bucket")
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- SourceBucket=\"%v\"", b.config.SourceBucket)
+	slog.Debugf("- SourcePrefix=\"%v\"", b.config.SourcePrefix)
+	slog.Debugf("- DestinationBucket=\"%v\"", b.config.DestinationBucket)
+	slog.Debugf("- DestinationPrefix=\"%v\"", b.config.DestinationPrefix)
+	slog.Debugf("- DestinationRegion=\"%v\"", b.config.DestinationRegion)
+
+	return nil
+}
+
+func (b *backup_s3_sync) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion, b.config.DestinationRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for regions \"%s\" and \"%s\": %w", b.config.AwsRegion, b.config.DestinationRegion, err)
+	}
+
+	// Objects are copied using the client of the destination region as copies are performed on its side
+	b.srcclient = ProviderAwsNewS3Client(b.cfg)
+	b.dstclient = s3.NewFromConfig(b.cfg, func(o *s3.Options) {
+		o.Region = b.config.DestinationRegion
+	})
+
+	return nil
+}
+
+func (b *backup_s3_sync) CreateBackup() error {
+
+	curtime := clockNow()
+	backuppath := b.basepath + curtime.UTC().Format("20060102-150405") + "/"
+	manifest := S3SyncManifest{
+		SourceBucket: b.config.SourceBucket,
+		SourcePrefix: b.config.SourcePrefix,
+		Timestamp:    curtime.Unix(),
+		JobId:        jobExecutionId,
+	}
+
+	slog.Debugf("Listing objects in bucket \"%s\" with prefix \"%s\" ...", b.config.SourceBucket, b.config.SourcePrefix)
+	objects, err := ProviderAwsListS3Objects(b.srcclient, b.config.SourceBucket, b.config.SourcePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list the objects of bucket \"%s\": %w", b.config.SourceBucket, err)
+	}
+	if len(objects) == 0 {
+		slog.Warnf("Have not found any object in bucket \"%s\" with prefix \"%s\"", b.config.SourceBucket, b.config.SourcePrefix)
+	}
+
+	progress := NewProgressSummary(len(objects))
+
+	for _, object := range objects {
+		if object.size > s3SyncMaxObjectSize {
+			return fmt.Errorf("object \"%s\" is larger than 5GB which is not supported", object.key)
+		}
+		dstkey := backuppath + strings.TrimPrefix(object.key, b.config.SourcePrefix)
+		if b.config.DryRunCreate == false {
+			err := ProviderAwsCopyS3Object(b.dstclient, b.config.SourceBucket, object.key, b.config.DestinationBucket, dstkey)
+			if err != nil {
+				return fmt.Errorf("failed to copy object \"%s\" to bucket \"%s\": %w", object.key, b.config.DestinationBucket, err)
+			}
+			progress.Itemf("copied", "Copied object \"%s\" to \"%s\"", object.key, dstkey)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not copying object \"%s\" to \"%s\"", object.key, dstkey)
+		}
+		manifest.Objects = append(manifest.Objects, S3SyncManifestEntry{Key: object.key, Size: object.size, ETag: object.etag})
+	}
+
+	progress.Logf("objects")
+
+	// The manifest is written last so its presence indicates that the backup is complete
+	if b.config.DryRunCreate == false {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode the manifest: %v", err)
+		}
+		if err := ProviderAwsPutS3Object(b.dstclient, b.config.DestinationBucket, backuppath+s3SyncManifestName, data); err != nil {
+			return fmt.Errorf("failed to upload the manifest of backup \"%s\": %w", backuppath, err)
+		}
+		slog.Infof("Successfully created backup \"s3://%s/%s\" with %d objects", b.config.DestinationBucket, backuppath, len(manifest.Objects))
+		eventItemf("created", "Created backup \"s3://%s/%s\"", b.config.DestinationBucket, backuppath)
+	} else {
+		slog.Infof("Dryrun: Not creating backup \"s3://%s/%s\"", b.config.DestinationBucket, backuppath)
+	}
+
+	return nil
+}
+
+func (b *backup_s3_sync) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing backups in bucket \"%s\" with prefix \"%s\" ...", b.config.DestinationBucket, b.basepath)
+	prefixes, err := ProviderAwsListS3Prefixes(b.dstclient, b.config.DestinationBucket, b.basepath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prefix := range prefixes {
+		// Only consider folders named after the date and time of a backup created by this program
+		name := strings.TrimSuffix(strings.TrimPrefix(prefix, b.basepath), "/")
+		backuptime, err := time.Parse("20060102-150405", name)
+		if err != nil {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = prefix
+		item.description = prefix
+		item.timestamp = backuptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found backup: prefix=\"%s\" created=\"%v\"", prefix, backuptime.Format(time.RFC3339))
+	}
+
+	// Backup folders are named after the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_s3_sync) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		backupAge := (curtime - item.timestamp) / 86400
+		backupDelete := backupAge > retention
+		slog.Debugf("Considering deletion of backup: prefix=\"%s\" age=%v retention=%v ...",
+			item.identifier, backupAge, retention)
+		if backupDelete == true {
+			if b.config.DryRunDelete == false {
+				objects, err := ProviderAwsListS3Objects(b.dstclient, b.config.DestinationBucket, item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to list the objects of backup \"%s\": %w", item.identifier, err)
+				}
+				var keys []string
+				for _, object := range objects {
+					keys = append(keys, object.key)
+				}
+				if err := ProviderAwsDeleteS3Objects(b.dstclient, b.config.DestinationBucket, keys); err != nil {
+					return fmt.Errorf("failed to delete the objects of backup \"%s\": %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted backup: prefix=\"%s\" objects=%d age=%v retention=%v", item.identifier, len(keys), backupAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting backup: prefix=\"%s\" age=%d retention=%v", item.identifier, backupAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping backup: prefix=\"%s\" age=%d retention=%d", item.identifier, backupAge, retention)
+		}
+	}
+
+	progress.Logf("backups")
+
+	return nil
+}
@@ -0,0 +1,360 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigSsmParamsExport struct {
+	Module            string `koanf:"module"`
+	Enabled           any    `koanf:"enabled"`
+	DryRun            bool   `koanf:"dryrun"`
+	DryRunCreate      bool   `koanf:"dryrun_create"`
+	DryRunDelete      bool   `koanf:"dryrun_delete"`
+	Retention         int64  `koanf:"retention"`
+	AwsRegion         string `koanf:"aws_region"`
+	AccessKeyId       string `koanf:"accesskey_id"`
+	AccessKeySecret   string `koanf:"accesskey_secret"`
+	ParameterPaths    any    `koanf:"parameter_paths"`
+	WithDecryption    bool   `koanf:"with_decryption"`
+	Destination       string `koanf:"destination"`
+	EncryptionKeyFile string `koanf:"encryption_key_file"`
+}
+
+type backup_ssm_params_export struct {
+	config      JobConfigSsmParamsExport
+	cfg         aws.Config
+	client      *ssm.Client
+	destination BackupDestination
+	paths       []string
+	key         []byte
+	fileprefix  string
+}
+
+// Contents of each archive created by this module before it gets encrypted
+type SsmParamsExport struct {
+	Paths      []string                  `json:"paths"`
+	Timestamp  int64                     `json:"timestamp"`
+	JobId      string                    `json:"job_id"`
+	Parameters []ProviderAwsSsmParameter `json:"parameters"`
+}
+
+var validateConfigSsmParamsExport = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"ssm-params-export"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "parameter_paths",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "with_decryption",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "encryption_key_file",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_ssm_params_export) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigSsmParamsExport); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	b.paths = configListToStrings(b.config.ParameterPaths)
+	if len(b.paths) == 0 {
+		return fmt.Errorf("Option \"parameter_paths\" must be a list of at least one path")
+	}
+	for _, paramPath := range b.paths {
+		if strings.HasPrefix(paramPath, "/") == false {
+			return fmt.Errorf("Option \"parameter_paths\" contains an invalid path: \"%s\"", paramPath)
+		}
+	}
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// Archives are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("ssm-params-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- ParameterPaths=\"%v\"", b.paths)
+	slog.Debugf("- WithDecryption=%v", b.config.WithDecryption)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+	slog.Debugf("- EncryptionKeyFile=\"%v\"", b.config.EncryptionKeyFile)
+
+	return nil
+}
+
+func (b *backup_ssm_params_export) InitialiseModule() error {
+
+	var err error
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+
+	// Create a client
+	b.client = ProviderAwsNewSsmClient(b.cfg)
+
+	// Read the key before exporting anything so parameters are never written unencrypted
+	b.key, err = archiveReadKeyFile(b.config.EncryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the encryption key of the job: %w", err)
+	}
+	slog.Debugf("Archives are encrypted with key %s", archiveKeyId(b.key))
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	return nil
+}
+
+func (b *backup_ssm_params_export) CreateBackup() error {
+
+	curtime := clockNow()
+	filename := fmt.Sprintf("%s%s.json.gz.enc", b.fileprefix, curtime.UTC().Format("20060102-150405"))
+	export := SsmParamsExport{Paths: b.paths, Timestamp: curtime.Unix(), JobId: jobExecutionId}
+
+	for _, paramPath := range b.paths {
+		slog.Debugf("Listing parameters under path \"%s\" ...", paramPath)
+		parameters, err := ProviderAwsGetSsmParameters(b.client, paramPath, b.config.WithDecryption)
+		if err != nil {
+			return fmt.Errorf("failed to list the parameters under path \"%s\": %w", paramPath, err)
+		}
+		if len(parameters) == 0 {
+			slog.Warnf("Have not found any parameter under path \"%s\"", paramPath)
+		}
+		export.Parameters = append(export.Parameters, parameters...)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the export of parameters: %v", err)
+	}
+	archive, err := archiveEncrypt(b.key, data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt export \"%s\": %w", filename, err)
+	}
+
+	if b.config.DryRunCreate == false {
+		if err := b.destination.WriteFile(filename, bytes.NewReader(archive)); err != nil {
+			return fmt.Errorf("failed to write export \"%s\" to the destination: %w", filename, err)
+		}
+		slog.Infof("Successfully exported %d parameters to \"%s\" in \"%s\"", len(export.Parameters), filename, b.destination)
+		eventItemf("created", "Created export \"%s\" in \"%s\"", filename, b.destination)
+	} else {
+		slog.Infof("Dryrun: Not exporting %d parameters to \"%s\"", len(export.Parameters), b.destination)
+	}
+
+	return nil
+}
+
+func (b *backup_ssm_params_export) RekeyBackups(bkpitems []BackupItem, oldkeys [][]byte) error {
+	var filenames []string
+	for _, item := range bkpitems {
+		filenames = append(filenames, item.identifier)
+	}
+	return rekeyArchives(b.destination, filenames, oldkeys, b.key, b.config.DryRun)
+}
+
+func (b *backup_ssm_params_export) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing exports in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		if strings.HasPrefix(filename, b.fileprefix) == false || strings.HasSuffix(filename, ".json.gz.enc") == false {
+			continue
+		}
+		datetime := strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), ".json.gz.enc")
+		exporttime, err := time.Parse("20060102-150405", datetime)
+		if err != nil {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = exporttime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found export: file=\"%s\" created=\"%v\"", filename, exporttime.Format(time.RFC3339))
+	}
+
+	// File names end with the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_ssm_params_export) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		exportAge := (curtime - item.timestamp) / 86400
+		exportDelete := exportAge > retention
+		slog.Debugf("Considering deletion of export: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, exportAge, retention)
+		if exportDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted export: file=\"%s\" age=%v retention=%v", item.identifier, exportAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting export: file=\"%s\" age=%d retention=%v", item.identifier, exportAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping export: file=\"%s\" age=%d retention=%d", item.identifier, exportAge, retention)
+		}
+	}
+
+	progress.Logf("exports")
+
+	return nil
+}
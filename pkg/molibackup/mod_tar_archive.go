@@ -0,0 +1,367 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigTarArchive struct {
+	Module          string `koanf:"module"`
+	Enabled         any    `koanf:"enabled"`
+	DryRun          bool   `koanf:"dryrun"`
+	DryRunCreate    bool   `koanf:"dryrun_create"`
+	DryRunDelete    bool   `koanf:"dryrun_delete"`
+	Retention       int64  `koanf:"retention"`
+	AwsRegion       string `koanf:"aws_region"`
+	AccessKeyId     string `koanf:"accesskey_id"`
+	AccessKeySecret string `koanf:"accesskey_secret"`
+	Directories     any    `koanf:"directories"`
+	Include         any    `koanf:"include"`
+	Exclude         any    `koanf:"exclude"`
+	Compression     string `koanf:"compression"`
+	Destination     string `koanf:"destination"`
+}
+
+// Extensions of the archive files for each type of compression
+var tarArchiveExtensions = map[string]string{
+	"none": ".tar",
+	"gzip": ".tar.gz",
+	"zstd": ".tar.zst",
+}
+
+type backup_tar_archive struct {
+	config      JobConfigTarArchive
+	cfg         aws.Config
+	destination BackupDestination
+	directories []string
+	include     []string
+	exclude     []string
+	fileprefix  string
+}
+
+var validateConfigTarArchive = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"tar-archive"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "directories",
+		entrytype:  "",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "include",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "exclude",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "compression",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "gzip",
+		allowedval: []string{"none", "gzip", "zstd"},
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_tar_archive) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigTarArchive); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	b.directories = configListToStrings(b.config.Directories)
+	if len(b.directories) == 0 {
+		return fmt.Errorf("Option \"directories\" must contain at least one directory")
+	}
+	for _, directory := range b.directories {
+		if filepath.IsAbs(directory) == false {
+			return fmt.Errorf("Option \"directories\" must contain absolute paths but \"%s\" is not", directory)
+		}
+	}
+
+	b.include = configListToStrings(b.config.Include)
+	b.exclude = configListToStrings(b.config.Exclude)
+	for _, pattern := range append(append([]string{}, b.include...), b.exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Pattern \"%s\" in options \"include\" or \"exclude\" is invalid: %v", pattern, err)
+		}
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("tar-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- Directories=\"%v\"", b.directories)
+	slog.Debugf("- Include=\"%v\"", b.include)
+	slog.Debugf("- Exclude=\"%v\"", b.exclude)
+	slog.Debugf("- Compression=\"%v\"", b.config.Compression)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_tar_archive) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	// Missing directories are reported before anything is written to the destination
+	for _, directory := range b.directories {
+		info, err := os.Stat(directory)
+		if err != nil {
+			return fmt.Errorf("failed to access directory %s: %v", directory, err)
+		}
+		if info.IsDir() == false {
+			return fmt.Errorf("path %s in option \"directories\" is not a directory", directory)
+		}
+	}
+
+	return nil
+}
+
+// Return the time of an archive from its file name
+func (b *backup_tar_archive) parseArchiveName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false {
+		return time.Time{}, false
+	}
+	datetime := strings.TrimPrefix(filename, b.fileprefix)
+	for _, extension := range tarArchiveExtensions {
+		if strings.HasSuffix(datetime, extension) {
+			archivetime, err := time.Parse("20060102-150405", strings.TrimSuffix(datetime, extension))
+			return archivetime, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+func (b *backup_tar_archive) CreateBackup() error {
+
+	curtime := clockNow()
+	filename := fmt.Sprintf("%s%s%s", b.fileprefix, curtime.UTC().Format("20060102-150405"), tarArchiveExtensions[b.config.Compression])
+
+	if b.config.DryRunCreate == false {
+		slog.Infof("Creating archive of %v ...", b.directories)
+		filecount := 0
+		size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+			var err error
+			filecount, err = archiveTarDirectories(output, b.directories, b.include, b.exclude, b.config.Compression)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write archive \"%s\" to the destination: %w", filename, err)
+		}
+		slog.Infof("Successfully created archive \"%s\" with %d files (%s) in \"%s\"", filename, filecount, formatBytes(size), b.destination)
+		eventItemf("created", "Created archive \"%s\" in \"%s\"", filename, b.destination)
+	} else {
+		slog.Infof("Dryrun: Not creating archive \"%s\" in \"%s\"", filename, b.destination)
+	}
+
+	return nil
+}
+
+func (b *backup_tar_archive) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing archives in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		archivetime, ok := b.parseArchiveName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = archivetime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found archive: file=\"%s\" created=\"%v\"", filename, archivetime.Format(time.RFC3339))
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_tar_archive) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		archiveAge := (curtime - item.timestamp) / 86400
+		archiveDelete := archiveAge > retention
+		slog.Debugf("Considering deletion of archive: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, archiveAge, retention)
+		if archiveDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted archive: file=\"%s\" age=%v retention=%v", item.identifier, archiveAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting archive: file=\"%s\" age=%d retention=%v", item.identifier, archiveAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping archive: file=\"%s\" age=%d retention=%d", item.identifier, archiveAge, retention)
+		}
+	}
+
+	progress.Logf("archives")
+
+	return nil
+}
@@ -0,0 +1,489 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigVaultSnapshot struct {
+	Module              string `koanf:"module"`
+	Enabled             any    `koanf:"enabled"`
+	DryRun              bool   `koanf:"dryrun"`
+	DryRunCreate        bool   `koanf:"dryrun_create"`
+	DryRunDelete        bool   `koanf:"dryrun_delete"`
+	Retention           int64  `koanf:"retention"`
+	AwsRegion           string `koanf:"aws_region"`
+	AccessKeyId         string `koanf:"accesskey_id"`
+	AccessKeySecret     string `koanf:"accesskey_secret"`
+	VaultAddr           string `koanf:"vault_addr"`
+	VaultNamespace      string `koanf:"vault_namespace"`
+	Token               string `koanf:"token"`
+	TokenFile           string `koanf:"token_file"`
+	AppRoleMount        string `koanf:"approle_mount"`
+	AppRoleRoleId       string `koanf:"approle_role_id"`
+	AppRoleSecretIdFile string `koanf:"approle_secret_id_file"`
+	TlsInsecure         bool   `koanf:"tls_insecure"`
+	Destination         string `koanf:"destination"`
+	EncryptionKeyFile   string `koanf:"encryption_key_file"`
+}
+
+type backup_vault_snapshot struct {
+	config      JobConfigVaultSnapshot
+	cfg         aws.Config
+	vault       *ProviderVault
+	destination BackupDestination
+	key         []byte
+	fileprefix  string
+	extension   string
+}
+
+// Extensions of the snapshots which are written as produced by Vault or compressed and encrypted
+var vaultSnapshotExtensions = []string{".snap", ".snap.gz.enc"}
+
+var validateConfigVaultSnapshot = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"vault-snapshot"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "vault_addr",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "vault_namespace",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "token",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "token_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "approle_mount",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "approle",
+		allowedval: nil,
+	},
+	{
+		entryname:  "approle_role_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "approle_secret_id_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "tls_insecure",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+	{
+		entryname:  "encryption_key_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+}
+
+func (b *backup_vault_snapshot) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigVaultSnapshot); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	// Defaults are the environment variables used by the vault command so both find the same server
+	if b.config.VaultAddr == "" {
+		b.config.VaultAddr = os.Getenv("VAULT_ADDR")
+	}
+	if b.config.VaultAddr == "" {
+		b.config.VaultAddr = "https://127.0.0.1:8200"
+	}
+	if strings.Contains(b.config.VaultAddr, "://") == false {
+		b.config.VaultAddr = "https://" + b.config.VaultAddr
+	}
+	if b.config.VaultNamespace == "" {
+		b.config.VaultNamespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	// Exactly one authentication method must be used, and the token of the environment is only a fallback
+	methods := 0
+	for _, option := range []string{b.config.Token, b.config.TokenFile, b.config.AppRoleRoleId} {
+		if option != "" {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return fmt.Errorf("Options \"token\", \"token_file\" and \"approle_role_id\" cannot be used together")
+	}
+	if (b.config.AppRoleRoleId == "") != (b.config.AppRoleSecretIdFile == "") {
+		return fmt.Errorf("Options \"approle_role_id\" and \"approle_secret_id_file\" must be used together")
+	}
+	if methods == 0 {
+		b.config.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if methods == 0 && b.config.Token == "" {
+		return fmt.Errorf("Option \"token\", \"token_file\" or \"approle_role_id\" must be specified when VAULT_TOKEN is not set")
+	}
+	b.config.AppRoleMount = strings.Trim(b.config.AppRoleMount, "/")
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("vault-%s-", jobname)
+	b.extension = vaultSnapshotExtensions[0]
+	if b.config.EncryptionKeyFile != "" {
+		b.extension = vaultSnapshotExtensions[1]
+	}
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- VaultAddr=\"%v\"", b.config.VaultAddr)
+	slog.Debugf("- VaultNamespace=\"%v\"", b.config.VaultNamespace)
+	slog.Debugf("- TokenFile=\"%v\"", b.config.TokenFile)
+	slog.Debugf("- AppRoleMount=\"%v\"", b.config.AppRoleMount)
+	slog.Debugf("- AppRoleRoleId=\"%v\"", b.config.AppRoleRoleId)
+	slog.Debugf("- AppRoleSecretIdFile=\"%v\"", b.config.AppRoleSecretIdFile)
+	slog.Debugf("- TlsInsecure=%v", b.config.TlsInsecure)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+	slog.Debugf("- EncryptionKeyFile=\"%v\"", b.config.EncryptionKeyFile)
+
+	return nil
+}
+
+func (b *backup_vault_snapshot) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	// Read the key before taking any snapshot so they are never written unencrypted by mistake
+	if b.config.EncryptionKeyFile != "" {
+		b.key, err = archiveReadKeyFile(b.config.EncryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the encryption key of the job: %w", err)
+		}
+		slog.Debugf("Snapshots are encrypted with key %s", archiveKeyId(b.key))
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	b.vault = ProviderVaultNewClient(b.config.VaultAddr, b.config.VaultNamespace, b.config.TlsInsecure)
+
+	// Tokens and secret IDs stored in files can be rotated without changing the configuration
+	switch {
+	case b.config.TokenFile != "":
+		data, err := os.ReadFile(b.config.TokenFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the token file: %v", err)
+		}
+		ProviderVaultSetToken(b.vault, strings.TrimSpace(string(data)))
+	case b.config.AppRoleRoleId != "":
+		data, err := os.ReadFile(b.config.AppRoleSecretIdFile)
+		if err != nil {
+			return fmt.Errorf("failed to read the AppRole secret ID file: %v", err)
+		}
+		if err := ProviderVaultLoginAppRole(b.vault, b.config.AppRoleMount, b.config.AppRoleRoleId, strings.TrimSpace(string(data))); err != nil {
+			return fmt.Errorf("failed to log in with AppRole at mount \"%s\": %w", b.config.AppRoleMount, err)
+		}
+	default:
+		ProviderVaultSetToken(b.vault, b.config.Token)
+	}
+
+	return nil
+}
+
+// Return the time of a snapshot from its file name
+func (b *backup_vault_snapshot) parseSnapshotName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false {
+		return time.Time{}, false
+	}
+	// Snapshots are rotated even if encryption has been enabled or disabled since they were created
+	for _, extension := range vaultSnapshotExtensions {
+		if strings.HasSuffix(filename, extension) == true {
+			datetime := strings.TrimSuffix(strings.TrimPrefix(filename, b.fileprefix), extension)
+			snaptime, err := time.Parse("20060102-150405", datetime)
+			return snaptime, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+func (b *backup_vault_snapshot) CreateBackup() error {
+
+	curtime := clockNow()
+	filename := fmt.Sprintf("%s%s%s", b.fileprefix, curtime.UTC().Format("20060102-150405"), b.extension)
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating snapshot \"%s\" in \"%s\"", filename, b.destination)
+		return nil
+	}
+
+	slog.Infof("Creating raft snapshot of Vault at \"%s\" ...", b.config.VaultAddr)
+
+	snapshot, err := ProviderVaultSaveRaftSnapshot(b.vault)
+	if err != nil {
+		return fmt.Errorf("failed to save the raft snapshot of the Vault servers: %w", err)
+	}
+	defer snapshot.Close()
+	// The snapshot is encrypted as it is received so neither the snapshot nor its plaintext is held in memory
+	size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+		if b.key == nil {
+			return vaultCopySnapshot(output, snapshot)
+		}
+		encrypter, err := archiveNewEncryptWriter(output, b.key)
+		if err != nil {
+			return err
+		}
+		if err := vaultCopySnapshot(encrypter, snapshot); err != nil {
+			return err
+		}
+		return encrypter.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot \"%s\" to the destination: %w", filename, err)
+	}
+	slog.Infof("Successfully created snapshot \"%s\" (%s) in \"%s\"", filename, formatBytes(size), b.destination)
+	eventItemf("created", "Created snapshot \"%s\" in \"%s\"", filename, b.destination)
+
+	return nil
+}
+
+// Copy the snapshot received from the API to the writer
+func vaultCopySnapshot(output io.Writer, snapshot io.Reader) error {
+	if _, err := io.Copy(output, snapshot); err != nil {
+		return fmt.Errorf("failed to read raft snapshot: %v", err)
+	}
+	return nil
+}
+
+func (b *backup_vault_snapshot) RekeyBackups(bkpitems []BackupItem, oldkeys [][]byte) error {
+
+	if b.key == nil {
+		return fmt.Errorf("Option \"encryption_key_file\" must be specified to encrypt snapshots with a new key")
+	}
+
+	// Snapshots created before encryption was enabled are left as they are
+	var filenames []string
+	for _, item := range bkpitems {
+		if strings.HasSuffix(item.identifier, vaultSnapshotExtensions[1]) == true {
+			filenames = append(filenames, item.identifier)
+		}
+	}
+
+	return rekeyArchives(b.destination, filenames, oldkeys, b.key, b.config.DryRun)
+}
+
+func (b *backup_vault_snapshot) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing snapshots in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		snaptime, ok := b.parseSnapshotName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = snaptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found snapshot: file=\"%s\" created=\"%v\"", filename, snaptime.Format(time.RFC3339))
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_vault_snapshot) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		snapshotAge := (curtime - item.timestamp) / 86400
+		snapshotDelete := snapshotAge > retention
+		slog.Debugf("Considering deletion of snapshot: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, snapshotAge, retention)
+		if snapshotDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted snapshot: file=\"%s\" age=%v retention=%v", item.identifier, snapshotAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting snapshot: file=\"%s\" age=%d retention=%v", item.identifier, snapshotAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping snapshot: file=\"%s\" age=%d retention=%d", item.identifier, snapshotAge, retention)
+		}
+	}
+
+	progress.Logf("snapshots")
+
+	return nil
+}
@@ -0,0 +1,448 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Structure of the job configuration for this specific module
+type JobConfigZookeeperBackup struct {
+	Module            string `koanf:"module"`
+	Enabled           any    `koanf:"enabled"`
+	DryRun            bool   `koanf:"dryrun"`
+	DryRunCreate      bool   `koanf:"dryrun_create"`
+	DryRunDelete      bool   `koanf:"dryrun_delete"`
+	Retention         int64  `koanf:"retention"`
+	AwsRegion         string `koanf:"aws_region"`
+	AccessKeyId       string `koanf:"accesskey_id"`
+	AccessKeySecret   string `koanf:"accesskey_secret"`
+	Mode              string `koanf:"mode"`
+	DataDir           string `koanf:"data_dir"`
+	DataLogDir        string `koanf:"data_log_dir"`
+	Compression       string `koanf:"compression"`
+	Members           any    `koanf:"members"`
+	AdminUser         string `koanf:"admin_user"`
+	AdminPasswordFile string `koanf:"admin_password_file"`
+	TlsInsecure       bool   `koanf:"tls_insecure"`
+	Destination       string `koanf:"destination"`
+}
+
+type backup_zookeeper_backup struct {
+	config      JobConfigZookeeperBackup
+	cfg         aws.Config
+	zookeeper   *ProviderZookeeper
+	destination BackupDestination
+	members     []string
+	directories []string
+	fileprefix  string
+}
+
+// Extension of the snapshots streamed from the admin server, while directories are archived with the extensions of tar archives
+const zookeeperSnapshotExtension = ".snap"
+
+// Files of the data directories which are required to restore a member, the data tree being rebuilt
+// from the most recent snapshot and the transaction logs which follow it
+var zookeeperDataFiles = []string{"snapshot.*", "log.*", "acceptedEpoch", "currentEpoch", "myid"}
+
+var validateConfigZookeeperBackup = []ConfigEntryValidation{
+	{
+		entryname:  "module",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: []string{"zookeeper-backup"},
+	},
+	{
+		entryname:  "enabled",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "true",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "snooze_until",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "dryrun",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_create",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "dryrun_delete",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "min_age_before_delete",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "24",
+		allowedval: nil,
+	},
+	{
+		entryname:  "retention",
+		entrytype:  "int",
+		mandatory:  false,
+		defaultval: "30",
+		allowedval: nil,
+	},
+	{
+		entryname:  "aws_region",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_id",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "accesskey_secret",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "mode",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "directory",
+		allowedval: []string{"directory", "admin"},
+	},
+	{
+		entryname:  "data_dir",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "data_log_dir",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "compression",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "gzip",
+		allowedval: []string{"none", "gzip", "zstd"},
+	},
+	{
+		entryname:  "members",
+		entrytype:  "",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "admin_user",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "admin_password_file",
+		entrytype:  "string",
+		mandatory:  false,
+		defaultval: "",
+		allowedval: nil,
+	},
+	{
+		entryname:  "tls_insecure",
+		entrytype:  "bool",
+		mandatory:  false,
+		defaultval: "false",
+		allowedval: []string{"true", "false"},
+	},
+	{
+		entryname:  "destination",
+		entrytype:  "string",
+		mandatory:  true,
+		allowedval: nil,
+	},
+}
+
+func (b *backup_zookeeper_backup) LoadConfiguration(jobname string) error {
+
+	// Path of the job config section relative to the root of the config file
+	jobpath := fmt.Sprintf("jobs.%s", jobname)
+
+	slog.Debugf("Validating the job configuration and setting default values ...")
+
+	if err := configValidateAndSetDefaults(jobpath, validateConfigZookeeperBackup); err != nil {
+		return fmt.Errorf("failed to validate job configuration: %w", err)
+	}
+
+	slog.Debugf("Getting processed job configuration (after validation and defaults) ...")
+
+	if err := kconfig.Unmarshal(jobpath, &b.config); err != nil {
+		return fmt.Errorf("failed to unmarshal path %s: %v", jobpath, err)
+	}
+
+	slog.Debugf("Advanced validation of the job configuration ...")
+
+	if b.config.Retention <= 0 {
+		return fmt.Errorf("Option \"retention\" must be a valid number greater than 0")
+	}
+
+	switch b.config.Mode {
+	case "directory":
+		if b.config.DataDir == "" {
+			return fmt.Errorf("Option \"data_dir\" must be specified when \"mode\" is \"directory\"")
+		}
+		// Transaction logs are stored in the data directory unless a separate directory has been configured
+		b.directories = []string{b.config.DataDir}
+		if b.config.DataLogDir != "" && filepath.Clean(b.config.DataLogDir) != filepath.Clean(b.config.DataDir) {
+			b.directories = append(b.directories, b.config.DataLogDir)
+		}
+	case "admin":
+		b.members = configListToStrings(b.config.Members)
+		if len(b.members) == 0 {
+			return fmt.Errorf("Option \"members\" must contain at least one member when \"mode\" is \"admin\"")
+		}
+		for i, member := range b.members {
+			if strings.Contains(member, "://") == false {
+				b.members[i] = "http://" + member
+			}
+		}
+		if (b.config.AdminUser == "") != (b.config.AdminPasswordFile == "") {
+			return fmt.Errorf("Options \"admin_user\" and \"admin_password_file\" must be used together")
+		}
+	}
+
+	// Files are named after the job so multiple jobs can share the same destination
+	b.fileprefix = fmt.Sprintf("zookeeper-%s-", jobname)
+
+	slog.Debugf("Dump of the processed configuration:")
+	slog.Debugf("- Module=\"%v\"", b.config.Module)
+	slog.Debugf("- Enabled=%v", b.config.Enabled)
+	slog.Debugf("- DryRun=%v", b.config.DryRun)
+	slog.Debugf("- DryRunCreate=%v", b.config.DryRunCreate)
+	slog.Debugf("- DryRunDelete=%v", b.config.DryRunDelete)
+	slog.Debugf("- Retention=%v", b.config.Retention)
+	slog.Debugf("- AwsRegion=\"%v\"", b.config.AwsRegion)
+	slog.Debugf("- AccessKeyId=\"%v\"", b.config.AccessKeyId)
+	slog.Debugf("- AccessKeySecret=\"%v\"", b.config.AccessKeySecret)
+	slog.Debugf("- Mode=\"%v\"", b.config.Mode)
+	slog.Debugf("- DataDir=\"%v\"", b.config.DataDir)
+	slog.Debugf("- DataLogDir=\"%v\"", b.config.DataLogDir)
+	slog.Debugf("- Compression=\"%v\"", b.config.Compression)
+	slog.Debugf("- Members=%v", b.members)
+	slog.Debugf("- AdminUser=\"%v\"", b.config.AdminUser)
+	slog.Debugf("- AdminPasswordFile=\"%v\"", b.config.AdminPasswordFile)
+	slog.Debugf("- TlsInsecure=%v", b.config.TlsInsecure)
+	slog.Debugf("- Destination=\"%v\"", b.config.Destination)
+
+	return nil
+}
+
+func (b *backup_zookeeper_backup) InitialiseModule() error {
+
+	var err error
+
+	// The AWS configuration is only used when the destination is an S3 bucket
+	b.cfg, err = ProviderAwsLoadConfig(b.config.AwsRegion, b.config.AccessKeyId, b.config.AccessKeySecret)
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", b.config.AwsRegion, err)
+	}
+	if strings.HasPrefix(b.config.Destination, "s3://") {
+		if err := ProviderAwsCheckGuardrails(b.cfg, b.config.AwsRegion); err != nil {
+			return fmt.Errorf("failed to check the guardrails for region \"%s\": %w", b.config.AwsRegion, err)
+		}
+	}
+
+	b.destination, err = NewBackupDestination(b.config.Destination, b.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open destination \"%s\": %w", b.config.Destination, err)
+	}
+
+	if b.config.Mode == "admin" {
+		var password string
+		if b.config.AdminPasswordFile != "" {
+			data, err := os.ReadFile(b.config.AdminPasswordFile)
+			if err != nil {
+				return fmt.Errorf("failed to read the admin password file: %v", err)
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		b.zookeeper = ProviderZookeeperNewClient(b.members, b.config.AdminUser, password, b.config.TlsInsecure)
+	}
+
+	return nil
+}
+
+// Return the time of a backup from its file name, which can be a snapshot or an archive as the mode may have changed
+func (b *backup_zookeeper_backup) parseBackupName(filename string) (time.Time, bool) {
+	if strings.HasPrefix(filename, b.fileprefix) == false {
+		return time.Time{}, false
+	}
+	datetime := strings.TrimPrefix(filename, b.fileprefix)
+	extensions := []string{zookeeperSnapshotExtension}
+	for _, extension := range tarArchiveExtensions {
+		extensions = append(extensions, extension)
+	}
+	for _, extension := range extensions {
+		if strings.HasSuffix(datetime, extension) {
+			backuptime, err := time.Parse("20060102-150405", strings.TrimSuffix(datetime, extension))
+			return backuptime, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+func (b *backup_zookeeper_backup) CreateBackup() error {
+
+	curtime := clockNow().UTC().Format("20060102-150405")
+	extension := zookeeperSnapshotExtension
+	if b.config.Mode == "directory" {
+		extension = tarArchiveExtensions[b.config.Compression]
+	}
+	filename := fmt.Sprintf("%s%s%s", b.fileprefix, curtime, extension)
+
+	if b.config.DryRunCreate == true {
+		slog.Infof("Dryrun: Not creating backup \"%s\" in \"%s\"", filename, b.destination)
+		return nil
+	}
+
+	if b.config.Mode == "admin" {
+		slog.Infof("Streaming snapshot from the admin server of members %v ...", b.members)
+		snapshot, member, zxid, err := ProviderZookeeperStreamSnapshot(b.zookeeper)
+		if err != nil {
+			return fmt.Errorf("failed to stream the snapshot from the admin server: %w", err)
+		}
+		defer snapshot.Close()
+		size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+			_, err := io.Copy(output, snapshot)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write snapshot \"%s\" to the destination: %w", filename, err)
+		}
+		slog.Infof("Successfully created snapshot \"%s\" (%s) of member \"%s\" at zxid %s in \"%s\"", filename, formatBytes(size), member, zxid, b.destination)
+		eventItemf("created", "Created snapshot \"%s\" in \"%s\"", filename, b.destination)
+		return nil
+	}
+
+	// Snapshots are fuzzy and the transaction logs which follow them are replayed on startup, so files
+	// can be archived while the member is running as long as the logs are archived with the snapshots
+	slog.Infof("Creating archive of the data directories %v ...", b.directories)
+	// The archive is discarded by the destination when no file is found as the error is returned while it is written
+	filecount := 0
+	size, err := destinationStreamFile(b.destination, filename, func(output io.Writer) error {
+		var err error
+		if filecount, err = archiveTarDirectories(output, b.directories, zookeeperDataFiles, nil, b.config.Compression); err != nil {
+			return err
+		}
+		if filecount == 0 {
+			return fmt.Errorf("have not found any snapshot or transaction log in %v", b.directories)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write archive \"%s\" to the destination: %w", filename, err)
+	}
+	slog.Infof("Successfully created archive \"%s\" with %d files (%s) in \"%s\"", filename, filecount, formatBytes(size), b.destination)
+	eventItemf("created", "Created archive \"%s\" in \"%s\"", filename, b.destination)
+
+	return nil
+}
+
+func (b *backup_zookeeper_backup) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	slog.Debugf("Listing backups in \"%s\" ...", b.destination)
+	filenames, err := b.destination.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range filenames {
+		backuptime, ok := b.parseBackupName(filename)
+		if ok == false {
+			continue
+		}
+		item := BackupItem{}
+		item.identifier = filename
+		item.description = filename
+		item.timestamp = backuptime.Unix()
+		results = append(results, item)
+		slog.Debugf("Found backup: file=\"%s\" created=\"%v\"", filename, backuptime.Format(time.RFC3339))
+	}
+
+	// File names include the date so they are sorted chronologically
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].identifier < results[j].identifier
+	})
+
+	return results, nil
+}
+
+func (b *backup_zookeeper_backup) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := b.config.Retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		backupAge := (curtime - item.timestamp) / 86400
+		backupDelete := backupAge > retention
+		slog.Debugf("Considering deletion of backup: file=\"%s\" age=%v retention=%v ...",
+			item.identifier, backupAge, retention)
+		if backupDelete == true {
+			if b.config.DryRunDelete == false {
+				err := b.destination.DeleteFile(item.identifier)
+				if err != nil {
+					return fmt.Errorf("failed to delete file \"%s\" from the destination: %w", item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted backup: file=\"%s\" age=%v retention=%v", item.identifier, backupAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting backup: file=\"%s\" age=%d retention=%v", item.identifier, backupAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping backup: file=\"%s\" age=%d retention=%d", item.identifier, backupAge, retention)
+		}
+	}
+
+	progress.Logf("backups")
+
+	return nil
+}
@@ -0,0 +1,411 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Definition of a backup module which can be used in the configuration of a job
+type ModuleDefinition struct {
+	name        string
+	description string
+	validation  []ConfigEntryValidation
+	create      func() BackupModule
+	aws         bool
+	fileprefix  string // Files written to a destination by jobs of this module are named "<fileprefix>-<job>-..."
+}
+
+// List of all backup modules supported by the program
+var moduleDefinitions = []ModuleDefinition{
+	{
+		name:        "ebs-snapshot",
+		description: "Create and rotate snapshots of EBS volumes",
+		validation:  validateConfigEbsSnapshot,
+		create:      func() BackupModule { return &backup_ebs_snapshot{} },
+		aws:         true,
+	},
+	{
+		name:        "aurora-cluster-snapshot",
+		description: "Create and rotate snapshots of Aurora clusters",
+		validation:  validateConfigRdsClusterSnapshot("aurora-cluster-snapshot"),
+		create:      newAuroraClusterSnapshot,
+		aws:         true,
+	},
+	{
+		name:        "dynamodb-backup",
+		description: "Create and rotate on-demand backups of DynamoDB tables",
+		validation:  validateConfigDynamodbBackup,
+		create:      func() BackupModule { return &backup_dynamodb_backup{} },
+		aws:         true,
+	},
+	{
+		name:        "efs-backup",
+		description: "Create and rotate backups of EFS file systems using AWS Backup",
+		validation:  validateConfigEfsBackup,
+		create:      func() BackupModule { return &backup_efs_backup{} },
+		aws:         true,
+	},
+	{
+		name:        "redshift-snapshot",
+		description: "Create and rotate manual snapshots of Redshift clusters",
+		validation:  validateConfigRedshiftSnapshot,
+		create:      func() BackupModule { return &backup_redshift_snapshot{} },
+		aws:         true,
+	},
+	{
+		name:        "fsx-backup",
+		description: "Create and rotate user-initiated backups of FSx file systems",
+		validation:  validateConfigFsxBackup,
+		create:      func() BackupModule { return &backup_fsx_backup{} },
+		aws:         true,
+	},
+	{
+		name:        "neptune-snapshot",
+		description: "Create and rotate snapshots of Neptune clusters",
+		validation:  validateConfigRdsClusterSnapshot("neptune-snapshot"),
+		create:      newNeptuneSnapshot,
+		aws:         true,
+	},
+	{
+		name:        "ami-image",
+		description: "Create and rotate AMI images of EC2 instances",
+		validation:  validateConfigAmiImage,
+		create:      func() BackupModule { return &backup_ami_image{} },
+		aws:         true,
+	},
+	{
+		name:        "s3-sync",
+		description: "Create and rotate dated copies of S3 buckets",
+		validation:  validateConfigS3Sync,
+		create:      func() BackupModule { return &backup_s3_sync{} },
+		aws:         true,
+	},
+	{
+		name:        "route53-export",
+		description: "Create and rotate exports of Route53 hosted zones",
+		validation:  validateConfigRoute53Export,
+		create:      func() BackupModule { return &backup_route53_export{} },
+		aws:         true,
+	},
+	{
+		name:        "ssm-params-export",
+		description: "Create and rotate encrypted exports of SSM parameters",
+		validation:  validateConfigSsmParamsExport,
+		create:      func() BackupModule { return &backup_ssm_params_export{} },
+		aws:         true,
+		fileprefix:  "ssm-params",
+	},
+	{
+		name:        "azure-blob-backup",
+		description: "Create and rotate dated copies of Azure Blob containers",
+		validation:  validateConfigAzureBlobBackup,
+		create:      func() BackupModule { return &backup_azure_blob_backup{} },
+	},
+	{
+		name:        "gce-disk-snapshot",
+		description: "Create and rotate snapshots of Compute Engine persistent disks",
+		validation:  validateConfigGceDiskSnapshot,
+		create:      func() BackupModule { return &backup_gce_disk_snapshot{} },
+	},
+	{
+		name:        "hcloud-snapshot",
+		description: "Create and rotate snapshots of Hetzner Cloud servers",
+		validation:  validateConfigHcloudSnapshot,
+		create:      func() BackupModule { return &backup_hcloud_snapshot{} },
+	},
+	{
+		name:        "postgres-walg",
+		description: "Create and rotate PostgreSQL base backups with wal-g",
+		validation:  validateConfigPostgresWalg,
+		create:      func() BackupModule { return &backup_postgres_walg{} },
+	},
+	{
+		name:        "linode-backup",
+		description: "Create and rotate images of Linodes or take backup service snapshots",
+		validation:  validateConfigLinodeBackup,
+		create:      func() BackupModule { return &backup_linode_backup{} },
+	},
+	{
+		name:        "mysql-binlog",
+		description: "Create full dumps of MySQL and archive binary logs for point-in-time recovery",
+		validation:  validateConfigMysqlBinlog,
+		create:      func() BackupModule { return &backup_mysql_binlog{} },
+		fileprefix:  "mysql",
+	},
+	{
+		name:        "openstack-cinder-snapshot",
+		description: "Create and rotate snapshots of Cinder volumes attached to OpenStack servers",
+		validation:  validateConfigOpenstackCinderSnapshot,
+		create:      func() BackupModule { return &backup_openstack_cinder_snapshot{} },
+	},
+	{
+		name:        "oci-volume-backup",
+		description: "Create and rotate backups of block volumes on Oracle Cloud Infrastructure",
+		validation:  validateConfigOciVolumeBackup,
+		create:      func() BackupModule { return &backup_oci_volume_backup{} },
+	},
+	{
+		name:        "proxmox-backup",
+		description: "Create and rotate vzdump archives of Proxmox VE virtual machines and containers",
+		validation:  validateConfigProxmoxBackup,
+		create:      func() BackupModule { return &backup_proxmox_backup{} },
+	},
+	{
+		name:        "tar-archive",
+		description: "Create and rotate tar archives of local directories",
+		validation:  validateConfigTarArchive,
+		create:      func() BackupModule { return &backup_tar_archive{} },
+		fileprefix:  "tar",
+	},
+	{
+		name:        "rsync-mirror",
+		description: "Mirror directories with rsync to dated directories using hard links",
+		validation:  validateConfigRsyncMirror,
+		create:      func() BackupModule { return &backup_rsync_mirror{} },
+	},
+	{
+		name:        "restic",
+		description: "Create and forget snapshots of directories in a restic repository",
+		validation:  validateConfigRestic,
+		create:      func() BackupModule { return &backup_restic{} },
+	},
+	{
+		name:        "borg",
+		description: "Create archives of directories in a borg repository and prune them",
+		validation:  validateConfigBorg,
+		create:      func() BackupModule { return &backup_borg{} },
+	},
+	{
+		name:        "kopia",
+		description: "Create and delete snapshots of directories in a kopia repository",
+		validation:  validateConfigKopia,
+		create:      func() BackupModule { return &backup_kopia{} },
+	},
+	{
+		name:        "rclone-copy",
+		description: "Create and rotate dated copies of local files on an rclone remote",
+		validation:  validateConfigRcloneCopy,
+		create:      func() BackupModule { return &backup_rclone_copy{} },
+	},
+	{
+		name:        "mongodb-dump",
+		description: "Create and rotate compressed dumps of MongoDB databases",
+		validation:  validateConfigMongodbDump,
+		create:      func() BackupModule { return &backup_mongodb_dump{} },
+		fileprefix:  "mongodb",
+	},
+	{
+		name:        "mssql-backup",
+		description: "Create and rotate full or differential backups of SQL Server databases",
+		validation:  validateConfigMssqlBackup,
+		create:      func() BackupModule { return &backup_mssql_backup{} },
+		fileprefix:  "mssql",
+	},
+	{
+		name:        "cassandra-snapshot",
+		description: "Create and clear snapshots of Cassandra keyspaces with optional archives",
+		validation:  validateConfigCassandraSnapshot,
+		create:      func() BackupModule { return &backup_cassandra_snapshot{} },
+		fileprefix:  "cassandra",
+	},
+	{
+		name:        "clickhouse-backup",
+		description: "Create and rotate backups of ClickHouse databases and tables",
+		validation:  validateConfigClickhouseBackup,
+		create:      func() BackupModule { return &backup_clickhouse_backup{} },
+		fileprefix:  "clickhouse",
+	},
+	{
+		name:        "neo4j-dump",
+		description: "Create and rotate dumps or online backups of Neo4j databases",
+		validation:  validateConfigNeo4jDump,
+		create:      func() BackupModule { return &backup_neo4j_dump{} },
+		fileprefix:  "neo4j",
+	},
+	{
+		name:        "consul-snapshot",
+		description: "Create and rotate snapshots of the state of Consul servers",
+		validation:  validateConfigConsulSnapshot,
+		create:      func() BackupModule { return &backup_consul_snapshot{} },
+		fileprefix:  "consul",
+	},
+	{
+		name:        "vault-snapshot",
+		description: "Create and rotate raft snapshots of Vault servers with optional encryption",
+		validation:  validateConfigVaultSnapshot,
+		create:      func() BackupModule { return &backup_vault_snapshot{} },
+		fileprefix:  "vault",
+	},
+	{
+		name:        "nomad-snapshot",
+		description: "Create and rotate raft snapshots of Nomad servers",
+		validation:  validateConfigNomadSnapshot,
+		create:      func() BackupModule { return &backup_nomad_snapshot{} },
+		fileprefix:  "nomad",
+	},
+	{
+		name:        "zookeeper-backup",
+		description: "Create and rotate archives of ZooKeeper data directories or snapshots from the admin server",
+		validation:  validateConfigZookeeperBackup,
+		create:      func() BackupModule { return &backup_zookeeper_backup{} },
+		fileprefix:  "zookeeper",
+	},
+	{
+		name:        "kafka-export",
+		description: "Export new messages of Kafka topics incrementally and rotate the exported sets",
+		validation:  validateConfigKafkaExport,
+		create:      func() BackupModule { return &backup_kafka_export{} },
+		fileprefix:  "kafka",
+	},
+	{
+		name:        "git-mirror",
+		description: "Mirror git repositories and rotate bundles of these repositories",
+		validation:  validateConfigGitMirror,
+		create:      func() BackupModule { return &backup_git_mirror{} },
+		fileprefix:  "git",
+	},
+	{
+		name:        "gitlab-export",
+		description: "Export GitLab projects and groups and rotate the export archives",
+		validation:  validateConfigGitlabExport,
+		create:      func() BackupModule { return &backup_gitlab_export{} },
+		fileprefix:  "gitlab",
+	},
+	{
+		name:        "gitea-dump",
+		description: "Create and rotate dumps of Gitea and Forgejo instances",
+		validation:  validateConfigGiteaDump,
+		create:      func() BackupModule { return &backup_gitea_dump{} },
+		fileprefix:  "gitea",
+	},
+	{
+		name:        "jenkins-backup",
+		description: "Create and rotate archives of Jenkins home directories",
+		validation:  validateConfigJenkinsBackup,
+		create:      func() BackupModule { return &backup_jenkins_backup{} },
+		fileprefix:  "jenkins",
+	},
+	{
+		name:        "canary",
+		description: "Check the AWS credentials and connectivity before the other jobs",
+		validation:  validateConfigCanary,
+		create:      func() BackupModule { return &backup_canary{} },
+		aws:         true,
+	},
+}
+
+// Modules which depend on features of the operating system are only compiled and registered on the
+// platforms listed here, by files with the matching build constraints, so a configuration which uses
+// them on another platform is rejected with a clear error rather than failing when the job runs
+var platformModules = map[string][]string{
+	"libvirt-snapshot": {"linux"},
+}
+
+// Register a module from a file which is only compiled on the platforms supported by the module
+func registerPlatformModule(moddef ModuleDefinition) {
+	moduleDefinitions = append(moduleDefinitions, moddef)
+}
+
+// Return an error when a module is supported by the program but not on the platform it has been built for
+func moduleUnavailableError(name string) error {
+	platforms, found := platformModules[name]
+	if found == false {
+		return nil
+	}
+	if _, ok := findModuleDefinition(name); ok == true {
+		return nil
+	}
+	return fmt.Errorf("module \"%s\" is not available on %s/%s as it is only supported on %s",
+		name, runtime.GOOS, runtime.GOARCH, strings.Join(platforms, ", "))
+}
+
+// Return the definition of the module with the name specified
+func findModuleDefinition(name string) (ModuleDefinition, bool) {
+	for _, moddef := range moduleDefinitions {
+		if moddef.name == name {
+			return moddef, true
+		}
+	}
+	return ModuleDefinition{}, false
+}
+
+// Check if a job relies on AWS, either because its module only works with AWS or because its destination is an S3 bucket
+func jobUsesAws(jobname string, module string) bool {
+	if moddef, ok := findModuleDefinition(module); ok == true && moddef.aws == true {
+		return true
+	}
+	return strings.HasPrefix(kconfig.String(fmt.Sprintf("jobs.%s.destination", jobname)), "s3://")
+}
+
+// Return the names of all modules supported by the program
+func moduleNames() []string {
+	var results []string
+	for _, moddef := range moduleDefinitions {
+		results = append(results, moddef.name)
+	}
+	return results
+}
+
+// Return the names of the modules which accept an entry in the configuration of their jobs
+func moduleNamesWithEntry(entryname string) []string {
+	var results []string
+	for _, moddef := range moduleDefinitions {
+		for _, entry := range moddef.validation {
+			if entry.entryname == entryname {
+				results = append(results, moddef.name)
+				break
+			}
+		}
+	}
+	return results
+}
+
+// Describe the options of all modules based on the rules used to validate job configurations
+func printModules(w io.Writer) {
+	for _, moddef := range moduleDefinitions {
+		fmt.Fprintf(w, "%s: %s\n", moddef.name, moddef.description)
+		for _, entry := range moddef.validation {
+			if entry.entryname == "module" {
+				continue
+			}
+			if entry.replacedby != "" {
+				fmt.Fprintf(w, "  %-28s deprecated replacedby=%s\n", entry.entryname, entry.replacedby)
+				continue
+			}
+			entrytype := entry.entrytype
+			if entrytype == "" {
+				entrytype = "any"
+			}
+			details := []string{fmt.Sprintf("type=%s", entrytype)}
+			if entry.mandatory == true {
+				details = append(details, "mandatory")
+			} else if entry.defaultval != "" {
+				details = append(details, fmt.Sprintf("default=%s", entry.defaultval))
+			}
+			if len(entry.allowedval) > 0 {
+				details = append(details, fmt.Sprintf("allowed=%s", strings.Join(entry.allowedval, ",")))
+			}
+			fmt.Fprintf(w, "  %-28s %s\n", entry.entryname, strings.Join(details, " "))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	var unavailable []string
+	for name := range platformModules {
+		if err := moduleUnavailableError(name); err != nil {
+			unavailable = append(unavailable, err.Error())
+		}
+	}
+	sort.Strings(unavailable)
+	for _, message := range unavailable {
+		fmt.Fprintf(w, "%s\n", strings.ToUpper(message[:1])+message[1:])
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+// Modules which are only available on Linux, as listed in platformModules
+func init() {
+	registerPlatformModule(ModuleDefinition{
+		name:        "libvirt-snapshot",
+		description: "Create and rotate snapshots of libvirt/KVM domains using virsh",
+		validation:  validateConfigLibvirtSnapshot,
+		create:      func() BackupModule { return &backup_libvirt_snapshot{} },
+	})
+}
@@ -0,0 +1,844 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/gookit/slog"
+)
+
+// Error returned when a copy cannot be started as too many copies are in progress in the destination region
+var errAwsCopyLimitExceeded = errors.New("the limit of concurrent snapshot copies has been reached")
+
+// Tags which are set by this program on the resources it creates and which cannot be overridden
+var awsReservedTags = []string{"Name", "CreatedBy", "CreateDate", "Timestamp", "JobId"}
+
+// Resources are tagged in batches with a pause between batches to stay below the API rate limits
+const awsTagBatchSize = 100
+const awsTagBatchInterval = time.Second
+
+// Prefix of the tags which store the custom metadata defined in the configuration of a job
+const awsMetadataTagPrefix = "Metadata:"
+
+// Limits of EC2 on the tags and on the description of resources such as snapshots
+const awsMaxTagsPerResource = 50
+const awsMaxTagKeyLength = 128
+const awsMaxTagValueLength = 256
+const awsMaxDescriptionLength = 255
+
+// Characters which are not accepted in descriptions of EC2 resources
+var awsDescriptionInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9 ._:/()#,@\[\]+=&;{}!$*-]`)
+
+type ProviderAwsEc2Instance struct {
+	instanceId    string
+	instanceName  string
+	instanceOwner string
+	instanceTags  map[string]string
+}
+
+type ProviderAwsEbsVolume struct {
+	volumeId   string
+	volumeName string
+	volumeTags map[string]string
+}
+
+type ProviderAwsEbsSnapshot struct {
+	volumeId         string
+	snapshotId       string
+	snapshotDesc     string
+	snapshotTime     int64
+	snapshotState    string
+	sourceSnapshotId string
+	volumeSize       int64
+	metadata         map[string]string
+}
+
+// Calls of the EC2 API which describe resources, implemented by *ec2.Client and by mocked clients in tests
+type ProviderAwsEc2DescribeApi interface {
+	DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error)
+	ec2.DescribeInstancesAPIClient
+	ec2.DescribeVolumesAPIClient
+	ec2.DescribeSnapshotsAPIClient
+}
+
+// Error returned when a response from the AWS API lacks an attribute which is required
+type ProviderAwsDecodeError struct {
+	resource  string
+	attribute string
+}
+
+func (e *ProviderAwsDecodeError) Error() string {
+	return fmt.Sprintf("invalid response from the AWS API: %s has no %s attribute", e.resource, e.attribute)
+}
+
+// Return the value of an optional string attribute or an empty string if it is not set
+func awsOptionalString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// Return the value of a mandatory string attribute or a decode error if it is not set
+func awsMandatoryString(value *string, resource string, attribute string) (string, error) {
+	if value == nil || *value == "" {
+		return "", &ProviderAwsDecodeError{resource: resource, attribute: attribute}
+	}
+	return *value, nil
+}
+
+// Collect all EC2 tags in a map and ignore tags which have no key
+func awsEc2TagsToMap(tags []types.Tag) map[string]string {
+	tagsdict := make(map[string]string)
+	for _, curtag := range tags {
+		if curtag.Key != nil {
+			tagsdict[*curtag.Key] = awsOptionalString(curtag.Value)
+		}
+	}
+	return tagsdict
+}
+
+// Check if all tags in the conditions are present in the dictionary with a matching value. The value
+// of a condition is a comma separated list of values where "*" and "?" can be used as wildcards.
+func awsTagsMatch(tagsdict map[string]string, conditions map[string]string) bool {
+	for tagkey, tagval := range conditions {
+		val, ok := tagsdict[tagkey]
+		if (ok == false) || (awsTagValueMatch(val, tagval) == false) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check if any of the tags to exclude is present in the dictionary with a matching value
+func awsTagsExcluded(tagsdict map[string]string, exclusions map[string]string) bool {
+	for tagkey, tagval := range exclusions {
+		if val, ok := tagsdict[tagkey]; ok == true && awsTagValueMatch(val, tagval) == true {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert conditions on tags into EC2 filters which support lists of values and the same wildcards
+func awsTagFilters(conditions map[string]string) []types.Filter {
+	var filters []types.Filter
+	for tagkey, tagval := range conditions {
+		var values []string
+		for _, value := range strings.Split(tagval, ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+		filters = append(filters, types.Filter{Name: aws.String("tag:" + tagkey), Values: values})
+	}
+	return filters
+}
+
+// Check if a tag value matches any of the values or patterns of a condition
+func awsTagValueMatch(value string, condition string) bool {
+	for _, pattern := range strings.Split(condition, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if strings.ContainsAny(pattern, "*?") == false {
+			if value == pattern {
+				return true
+			}
+			continue
+		}
+		expr := regexp.QuoteMeta(pattern)
+		expr = strings.ReplaceAll(expr, "\\*", ".*")
+		expr = strings.ReplaceAll(expr, "\\?", ".")
+		if matched, _ := regexp.MatchString("^"+expr+"$", value); matched == true {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that a tag which will be set on a resource is within the limits of EC2
+func awsValidateTag(tagkey string, tagval string) error {
+	if tagkey == "" || utf8.RuneCountInString(tagkey) > awsMaxTagKeyLength {
+		return fmt.Errorf("tag key \"%s\" must have between 1 and %d characters", tagkey, awsMaxTagKeyLength)
+	}
+	if strings.HasPrefix(strings.ToLower(tagkey), "aws:") == true {
+		return fmt.Errorf("tag key \"%s\" must not start with \"aws:\" which is reserved", tagkey)
+	}
+	if utf8.RuneCountInString(tagval) > awsMaxTagValueLength {
+		return fmt.Errorf("value of tag \"%s\" must have at most %d characters", tagkey, awsMaxTagValueLength)
+	}
+	return nil
+}
+
+// Return a name made of a base name and a suffix which can be used both in a tag and in a description.
+// Invalid characters of the base name are replaced and the base name is truncated so the suffix is kept.
+func awsResourceName(basename string, suffix string) string {
+	basename = awsDescriptionInvalidChars.ReplaceAllString(basename, "_")
+	if maxlen := awsMaxDescriptionLength - len(suffix); len(basename) > maxlen {
+		basename = basename[:maxlen]
+	}
+	return basename + suffix
+}
+
+// Convert custom metadata to the tags which are used to store it on resources
+func awsMetadataToTags(metadata map[string]string) map[string]string {
+	tagsdict := make(map[string]string)
+	for key, value := range metadata {
+		tagsdict[awsMetadataTagPrefix+key] = value
+	}
+	return tagsdict
+}
+
+// Extract custom metadata from the tags of a resource or return nil if there is none
+func awsMetadataFromTags(tagsdict map[string]string) map[string]string {
+	var metadata map[string]string
+	for tagkey, tagval := range tagsdict {
+		if strings.HasPrefix(tagkey, awsMetadataTagPrefix) == true {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[strings.TrimPrefix(tagkey, awsMetadataTagPrefix)] = tagval
+		}
+	}
+	return metadata
+}
+
+// Return the creation time stored in the Timestamp tag by this program if it is valid
+func awsTimestampFromTags(tagsdict map[string]string) (int64, bool) {
+	value, ok := tagsdict["Timestamp"]
+	if ok == false {
+		return 0, false
+	}
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || timestamp <= 0 {
+		return 0, false
+	}
+	return timestamp, true
+}
+
+// Decode an EC2 instance returned by DescribeInstances()
+func awsDecodeEc2Instance(instance types.Instance, ownerId *string) (ProviderAwsEc2Instance, error) {
+	var err error
+	instdata := ProviderAwsEc2Instance{}
+	if instdata.instanceId, err = awsMandatoryString(instance.InstanceId, "instance", "InstanceId"); err != nil {
+		return instdata, err
+	}
+	instdata.instanceTags = awsEc2TagsToMap(instance.Tags)
+	instdata.instanceName = instdata.instanceTags["Name"]
+	instdata.instanceOwner = awsOptionalString(ownerId)
+	return instdata, nil
+}
+
+// Decode an EBS volume returned by DescribeVolumes()
+func awsDecodeEbsVolume(volume types.Volume) (ProviderAwsEbsVolume, error) {
+	var err error
+	voldata := ProviderAwsEbsVolume{}
+	if voldata.volumeId, err = awsMandatoryString(volume.VolumeId, "volume", "VolumeId"); err != nil {
+		return voldata, err
+	}
+	voldata.volumeTags = awsEc2TagsToMap(volume.Tags)
+	voldata.volumeName = voldata.volumeTags["Name"]
+	return voldata, nil
+}
+
+// Decode an EBS snapshot returned by DescribeSnapshots()
+func awsDecodeEbsSnapshot(snapshot types.Snapshot) (ProviderAwsEbsSnapshot, error) {
+	var err error
+	snapdata := ProviderAwsEbsSnapshot{}
+	if snapdata.snapshotId, err = awsMandatoryString(snapshot.SnapshotId, "snapshot", "SnapshotId"); err != nil {
+		return snapdata, err
+	}
+	resource := fmt.Sprintf("snapshot %s", snapdata.snapshotId)
+	if snapshot.StartTime == nil {
+		return snapdata, &ProviderAwsDecodeError{resource: resource, attribute: "StartTime"}
+	}
+	snapdata.volumeId = awsOptionalString(snapshot.VolumeId)
+	snapdata.snapshotDesc = awsOptionalString(snapshot.Description)
+	snapdata.snapshotTime = (*snapshot.StartTime).Unix()
+	snapdata.snapshotState = string(snapshot.State)
+	snapdata.volumeSize = int64(aws.ToInt32(snapshot.VolumeSize))
+	tagsdict := awsEc2TagsToMap(snapshot.Tags)
+	// Copies of snapshots have a new StartTime so the original creation time takes precedence
+	if timestamp, ok := awsTimestampFromTags(tagsdict); ok == true {
+		snapdata.snapshotTime = timestamp
+	}
+	// Copies of snapshots have an arbitrary VolumeId so the source volume is stored in tags
+	if sourceVolumeId, ok := tagsdict["SourceVolumeId"]; ok == true {
+		snapdata.volumeId = sourceVolumeId
+	}
+	snapdata.sourceSnapshotId = tagsdict["SourceSnapshotId"]
+	snapdata.metadata = awsMetadataFromTags(tagsdict)
+	return snapdata, nil
+}
+
+func ProviderAwsLoadConfig(region string, accesskey_id string, accesskey_secret string) (aws.Config, error) {
+
+	var cfg aws.Config
+	var err error
+
+	os.Setenv("AWS_REGION", region)
+
+	// Settings of the retries of the SDK where empty values keep the defaults of the SDK
+	var options []func(*config.LoadOptions) error
+	if mode := kconfig.String("global.aws_retry_mode"); mode != "" {
+		options = append(options, config.WithRetryMode(aws.RetryMode(mode)))
+	}
+	if attempts := kconfig.Int("global.aws_max_attempts"); attempts > 0 {
+		options = append(options, config.WithRetryMaxAttempts(attempts))
+	}
+
+	// Load the configuration using an access key pair if it has been provided in the configuration
+	if accesskey_id != "" && accesskey_secret != "" {
+		staticProvider := credentials.NewStaticCredentialsProvider(accesskey_id, accesskey_secret, "")
+		options = append(options, config.WithCredentialsProvider(staticProvider))
+		cfg, err = config.LoadDefaultConfig(context.TODO(), options...)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to load the aws configuration with explicit access key pair: %v", err)
+		}
+	} else {
+		cfg, err = config.LoadDefaultConfig(context.TODO(), options...)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to load the aws configuration without an explicit access key pair: %v", err)
+		}
+	}
+
+	// Measure the calls and their attempts for the run report
+	cfg.APIOptions = append(cfg.APIOptions, awsMetricsAwsMiddleware)
+
+	// Detect jobs which have stalled when the watchdog is enabled
+	if kconfig.Int64("global.stall_timeout") > 0 {
+		cfg.APIOptions = append(cfg.APIOptions, watchdogAwsMiddleware)
+	}
+
+	// Rehearse outages of AWS when faults have been requested on the command line
+	if faultInjectionEnabled() == true {
+		cfg.APIOptions = append(cfg.APIOptions, faultInjectionAwsMiddleware)
+	}
+
+	return cfg, nil
+}
+
+func ProviderAwsNewEc2Client(cfg aws.Config) *ec2.Client {
+
+	return ec2.NewFromConfig(cfg)
+
+}
+
+// Create a client for a region which is different from the region of the configuration
+func ProviderAwsNewEc2ClientForRegion(cfg aws.Config, region string) *ec2.Client {
+
+	return ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+		o.Region = region
+	})
+
+}
+
+// Get the InstanceId of the EC2 instance currently running this program
+func ProviderAwsGetCurrentInstance(cfg aws.Config) (string, error) {
+
+	var instanceId string
+
+	clientImds := imds.NewFromConfig(cfg)
+	res1, err := clientImds.GetMetadata(context.TODO(), &imds.GetMetadataInput{
+		Path: "instance-id",
+	})
+	if err != nil {
+		return instanceId, fmt.Errorf("unable to determine the EC2 instance ID: %v", err)
+	}
+
+	defer res1.Content.Close()
+	res2, err := io.ReadAll(res1.Content)
+	if err != nil {
+		return instanceId, fmt.Errorf("unable to retrieve the EC2 instance ID: %v", err)
+	}
+	instanceId = string(res2)
+
+	return instanceId, nil
+}
+
+// Get the region of the EC2 instance currently running this program
+func ProviderAwsGetCurrentRegion(cfg aws.Config) (string, error) {
+
+	clientImds := imds.NewFromConfig(cfg)
+	res, err := clientImds.GetRegion(context.TODO(), &imds.GetRegionInput{})
+	if err != nil {
+		return "", fmt.Errorf("unable to determine the region of the EC2 instance: %v", err)
+	}
+
+	return res.Region, nil
+}
+
+// Return the names of the regions enabled in the account, which is a cheap read-only call to check the access to EC2
+func ProviderAwsGetEnabledRegions(client ProviderAwsEc2DescribeApi) ([]string, error) {
+
+	var results []string
+
+	result, err := client.DescribeRegions(context.TODO(), &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, newProviderError("DescribeRegions", "", "", err)
+	}
+
+	for _, region := range result.Regions {
+		results = append(results, awsOptionalString(region.RegionName))
+	}
+
+	return results, nil
+}
+
+// Return basic information about all instances that match conditions specified in the arguments.
+// Conditions on tags are sent to the API so only matching instances are returned, while instances
+// which have any of the tags to exclude are filtered out locally as the API has no negative filters.
+func ProviderAwsGetEc2Instances(client ProviderAwsEc2DescribeApi, instanceId string, instanceTags map[string]string, excludeTags map[string]string) ([]ProviderAwsEc2Instance, error) {
+
+	var results []ProviderAwsEc2Instance
+	var params *ec2.DescribeInstancesInput
+	var filters []types.Filter
+	var filtcnt int
+	var count int
+
+	if instanceId != "" {
+		curfilter := types.Filter{
+			Name:   aws.String("instance-id"),
+			Values: []string{instanceId},
+		}
+		filters = append(filters, curfilter)
+		filtcnt++
+	}
+
+	for _, curfilter := range awsTagFilters(instanceTags) {
+		filters = append(filters, curfilter)
+		filtcnt++
+	}
+
+	// Pages are decoded one at a time so only the attributes used by the program are kept in memory
+	params = &ec2.DescribeInstancesInput{Filters: filters}
+	paginator := ec2.NewDescribeInstancesPaginator(client, params)
+	for paginator.HasMorePages() {
+		res, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("DescribeInstances", "", "", err)
+		}
+		for _, reservation := range res.Reservations {
+			for _, instance := range reservation.Instances {
+				// Add instance to the results if all the tags specified in instance_tags match and none of the tags to exclude
+				tagsdict := awsEc2TagsToMap(instance.Tags)
+				if awsTagsMatch(tagsdict, instanceTags) == true && awsTagsExcluded(tagsdict, excludeTags) == false {
+					instdata, err := awsDecodeEc2Instance(instance, reservation.OwnerId)
+					if err != nil {
+						return nil, err
+					}
+					results = append(results, instdata)
+					count++
+				}
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Return basic information about all volumes that match conditions specified in the arguments
+func ProviderAwsGetEbsVolumes(client ProviderAwsEc2DescribeApi, instanceId string, volumeTags map[string]string, excludeTags map[string]string) ([]ProviderAwsEbsVolume, error) {
+
+	var results []ProviderAwsEbsVolume
+
+	params := &ec2.DescribeVolumesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("attachment.instance-id"),
+				Values: []string{instanceId},
+			},
+			{
+				Name:   aws.String("attachment.status"),
+				Values: []string{"attached"},
+			},
+		},
+	}
+	params.Filters = append(params.Filters, awsTagFilters(volumeTags)...)
+
+	paginator := ec2.NewDescribeVolumesPaginator(client, params)
+	for paginator.HasMorePages() {
+		resvols, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("DescribeVolumes", "", "", err)
+		}
+		for _, volume := range resvols.Volumes {
+			// Add volume to the results if all the tags specified in volume_tags match and none of the tags to exclude
+			tagsdict := awsEc2TagsToMap(volume.Tags)
+			if awsTagsMatch(tagsdict, volumeTags) == true && awsTagsExcluded(tagsdict, excludeTags) == false {
+				voldata, err := awsDecodeEbsVolume(volume)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, voldata)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Return basic information about the volumes with the identifiers specified which do not have any of the
+// tags to exclude, and the instance to which each volume is attached. Volumes which do not exist are ignored.
+func ProviderAwsGetEbsVolumesByIds(client ProviderAwsEc2DescribeApi, volumeIds []string, excludeTags map[string]string) ([]ProviderAwsEbsVolume, map[string]string, error) {
+
+	var results []ProviderAwsEbsVolume
+	attachments := make(map[string]string)
+
+	// Filters accept at most 200 values so volumes are requested in batches
+	for start := 0; start < len(volumeIds); start += 200 {
+		end := start + 200
+		if end > len(volumeIds) {
+			end = len(volumeIds)
+		}
+		params := &ec2.DescribeVolumesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("volume-id"),
+					Values: volumeIds[start:end],
+				},
+			},
+		}
+		paginator := ec2.NewDescribeVolumesPaginator(client, params)
+		for paginator.HasMorePages() {
+			resvols, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return nil, nil, newProviderError("DescribeVolumes", "", "", err)
+			}
+			for _, volume := range resvols.Volumes {
+				if awsTagsExcluded(awsEc2TagsToMap(volume.Tags), excludeTags) == true {
+					continue
+				}
+				voldata, err := awsDecodeEbsVolume(volume)
+				if err != nil {
+					return nil, nil, err
+				}
+				for _, attachment := range volume.Attachments {
+					if attachment.State == types.VolumeAttachmentStateAttached && attachment.InstanceId != nil {
+						attachments[voldata.volumeId] = *attachment.InstanceId
+					}
+				}
+				results = append(results, voldata)
+			}
+		}
+	}
+
+	return results, attachments, nil
+}
+
+// Get basic information about snapshots which are related to a particular volume
+func ProviderAwsGetEbsSnapshots(client ProviderAwsEc2DescribeApi, volumeId string) ([]ProviderAwsEbsSnapshot, error) {
+
+	var results []ProviderAwsEbsSnapshot
+
+	params := &ec2.DescribeSnapshotsInput{
+		Filters: []types.Filter{
+			{
+				Name: aws.String("tag:CreatedBy"),
+				Values: []string{
+					"molibackup",
+				},
+			},
+			{
+				Name: aws.String("volume-id"),
+				Values: []string{
+					volumeId,
+				},
+			},
+		},
+	}
+
+	paginator := ec2.NewDescribeSnapshotsPaginator(client, params)
+	for paginator.HasMorePages() {
+		ressnaps, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("DescribeSnapshots", "", "", err)
+		}
+		for _, snapshot := range ressnaps.Snapshots {
+			snapdata, err := awsDecodeEbsSnapshot(snapshot)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, snapdata)
+		}
+	}
+
+	return results, nil
+}
+
+// Get basic information about copies of snapshots which are related to a particular source volume
+func ProviderAwsGetEbsSnapshotCopies(client ProviderAwsEc2DescribeApi, volumeId string) ([]ProviderAwsEbsSnapshot, error) {
+
+	var results []ProviderAwsEbsSnapshot
+
+	params := &ec2.DescribeSnapshotsInput{
+		Filters: []types.Filter{
+			{
+				Name: aws.String("tag:CreatedBy"),
+				Values: []string{
+					"molibackup",
+				},
+			},
+			{
+				Name: aws.String("tag:SourceVolumeId"),
+				Values: []string{
+					volumeId,
+				},
+			},
+		},
+	}
+
+	paginator := ec2.NewDescribeSnapshotsPaginator(client, params)
+	for paginator.HasMorePages() {
+		ressnaps, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("DescribeSnapshots", "", "", err)
+		}
+		for _, snapshot := range ressnaps.Snapshots {
+			snapdata, err := awsDecodeEbsSnapshot(snapshot)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, snapdata)
+		}
+	}
+
+	return results, nil
+}
+
+// Return the number of copies of snapshots created by this program which are still in progress
+func ProviderAwsCountPendingEbsSnapshotCopies(client ProviderAwsEc2DescribeApi) (int, error) {
+
+	params := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:CreatedBy"),
+				Values: []string{"molibackup"},
+			},
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"SourceSnapshotId"},
+			},
+			{
+				Name:   aws.String("status"),
+				Values: []string{"pending"},
+			},
+		},
+	}
+
+	ressnaps, err := client.DescribeSnapshots(context.TODO(), params)
+	if err != nil {
+		return 0, newProviderError("DescribeSnapshots", "", "", err)
+	}
+
+	return len(ressnaps.Snapshots), nil
+}
+
+// Copy a snapshot from the source region to the region of the client and return the ID of the copy
+func ProviderAwsCopyEbsSnapshot(client *ec2.Client, sourceRegion string, source ProviderAwsEbsSnapshot) (string, error) {
+
+	snaptime := time.Unix(source.snapshotTime, 0)
+	snapdate := fmt.Sprintf("%04d%02d%02d", snaptime.Year(), snaptime.Month(), snaptime.Day())
+
+	tags := []types.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(source.snapshotDesc),
+		},
+		{
+			Key:   aws.String("CreatedBy"),
+			Value: aws.String("molibackup"),
+		},
+		{
+			Key:   aws.String("CreateDate"),
+			Value: aws.String(snapdate),
+		},
+		{
+			Key:   aws.String("Timestamp"),
+			Value: aws.String(fmt.Sprintf("%v", source.snapshotTime)),
+		},
+		{
+			Key:   aws.String("JobId"),
+			Value: aws.String(jobExecutionId),
+		},
+		{
+			Key:   aws.String("SourceRegion"),
+			Value: aws.String(sourceRegion),
+		},
+		{
+			Key:   aws.String("SourceSnapshotId"),
+			Value: aws.String(source.snapshotId),
+		},
+		{
+			Key:   aws.String("SourceVolumeId"),
+			Value: aws.String(source.volumeId),
+		},
+	}
+
+	// Copies keep the custom metadata of the source snapshot so it can still be reported
+	for tagkey, tagval := range awsMetadataToTags(source.metadata) {
+		tags = append(tags, types.Tag{Key: aws.String(tagkey), Value: aws.String(tagval)})
+	}
+
+	params := &ec2.CopySnapshotInput{
+		SourceRegion:     &sourceRegion,
+		SourceSnapshotId: &source.snapshotId,
+		Description:      &source.snapshotDesc,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         tags,
+			},
+		},
+	}
+
+	result, err := client.CopySnapshot(context.TODO(), params)
+	if err != nil {
+		var apierr smithy.APIError
+		if errors.As(err, &apierr) == true && apierr.ErrorCode() == "ResourceLimitExceeded" {
+			return "", &ProviderError{operation: "CopySnapshot", kind: "snapshot", resource: source.snapshotId, code: apierr.ErrorCode(), err: errAwsCopyLimitExceeded}
+		}
+		return "", newProviderError("CopySnapshot", "snapshot", source.snapshotId, err)
+	}
+
+	return awsMandatoryString(result.SnapshotId, "copy of snapshot", "SnapshotId")
+}
+
+func ProviderAwsCreateEbsSnapshot(client *ec2.Client, volumeId string, snapname string, snapdate string, snaptime string, lockmode string, lockduration int32, extraTags map[string]string) (string, error) {
+
+	tags := []types.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(snapname),
+		},
+		{
+			Key:   aws.String("CreatedBy"),
+			Value: aws.String("molibackup"),
+		},
+		{
+			Key:   aws.String("CreateDate"),
+			Value: aws.String(snapdate),
+		},
+		{
+			Key:   aws.String("Timestamp"),
+			Value: aws.String(snaptime),
+		},
+		{
+			Key:   aws.String("JobId"),
+			Value: aws.String(jobExecutionId),
+		},
+	}
+
+	// Additional tags never replace the tags which are required by this program
+	for tagkey, tagval := range extraTags {
+		if slices.Contains(awsReservedTags, tagkey) == false {
+			tags = append(tags, types.Tag{Key: aws.String(tagkey), Value: aws.String(tagval)})
+		}
+	}
+
+	params1 := &ec2.CreateSnapshotInput{
+		VolumeId:    &volumeId,
+		Description: &snapname,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         tags,
+			},
+		},
+	}
+
+	// Create snapshot of the volume
+	result, err := client.CreateSnapshot(context.TODO(), params1)
+	if err != nil {
+		return "", newProviderError("CreateSnapshot", "volume", volumeId, err)
+	}
+	snapid, err := awsMandatoryString(result.SnapshotId, "new snapshot", "SnapshotId")
+	if err != nil {
+		return "", err
+	}
+
+	// Lock the new snapshot is this has been requested
+	curmode := types.LockMode(lockmode)
+	lockmodes := curmode.Values()
+	if slices.Contains(lockmodes, curmode) {
+		params2 := &ec2.LockSnapshotInput{
+			SnapshotId:   &snapid,
+			LockMode:     curmode,
+			LockDuration: &lockduration,
+		}
+
+		if _, err := client.LockSnapshot(context.TODO(), params2); err != nil {
+			return snapid, newProviderError("LockSnapshot", "snapshot", snapid, err)
+		}
+	}
+
+	return snapid, nil
+}
+
+func ProviderAwsDeleteEbsSnapshot(client *ec2.Client, snapshotId string) error {
+
+	params := &ec2.DeleteSnapshotInput{
+		SnapshotId: &snapshotId,
+	}
+	_, err := client.DeleteSnapshot(context.TODO(), params)
+	if err != nil {
+		err = newProviderError("DeleteSnapshot", "snapshot", snapshotId, err)
+		// Snapshots deleted manually or by another run since they were listed are already gone
+		if errorHasCode(err, "InvalidSnapshot.NotFound") == true {
+			slog.Warnf("Snapshot \"%s\" has already been deleted: %v", snapshotId, err)
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Add or update tags on EC2 resources such as snapshots and images in batches
+func ProviderAwsTagEc2Resources(client *ec2.Client, resourceIds []string, tags map[string]string) error {
+
+	var ec2tags []types.Tag
+	for tagkey, tagval := range tags {
+		ec2tags = append(ec2tags, types.Tag{Key: aws.String(tagkey), Value: aws.String(tagval)})
+	}
+
+	for start := 0; start < len(resourceIds); start += awsTagBatchSize {
+		end := start + awsTagBatchSize
+		if end > len(resourceIds) {
+			end = len(resourceIds)
+		}
+		if start > 0 {
+			time.Sleep(awsTagBatchInterval)
+		}
+		params := &ec2.CreateTagsInput{
+			Resources: resourceIds[start:end],
+			Tags:      ec2tags,
+		}
+		if _, err := client.CreateTags(context.TODO(), params); err != nil {
+			return newProviderError("CreateTags", "", "", err)
+		}
+	}
+
+	return nil
+}
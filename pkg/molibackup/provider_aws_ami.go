@@ -0,0 +1,178 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+type ProviderAwsAmiImage struct {
+	instanceId  string
+	imageId     string
+	imageName   string
+	imageTime   int64
+	snapshotIds []string
+	metadata    map[string]string
+}
+
+// Decode an image returned by DescribeImages()
+func awsDecodeAmiImage(image types.Image) (ProviderAwsAmiImage, error) {
+	var err error
+	imgdata := ProviderAwsAmiImage{}
+	if imgdata.imageId, err = awsMandatoryString(image.ImageId, "image", "ImageId"); err != nil {
+		return imgdata, err
+	}
+	resource := fmt.Sprintf("image %s", imgdata.imageId)
+	creationDate, err := awsMandatoryString(image.CreationDate, resource, "CreationDate")
+	if err != nil {
+		return imgdata, err
+	}
+	imgtime, err := time.Parse(time.RFC3339, creationDate)
+	if err != nil {
+		return imgdata, fmt.Errorf("invalid creation date \"%s\" for %s: %v", creationDate, resource, err)
+	}
+	tagsdict := awsEc2TagsToMap(image.Tags)
+	imgdata.instanceId = tagsdict["SourceInstanceId"]
+	imgdata.imageName = awsOptionalString(image.Name)
+	imgdata.imageTime = imgtime.Unix()
+	imgdata.metadata = awsMetadataFromTags(tagsdict)
+	if timestamp, ok := awsTimestampFromTags(tagsdict); ok == true {
+		imgdata.imageTime = timestamp
+	}
+	// Snapshots backing the image must be deleted explicitly after the image has been deregistered
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			imgdata.snapshotIds = append(imgdata.snapshotIds, *mapping.Ebs.SnapshotId)
+		}
+	}
+	return imgdata, nil
+}
+
+// Get basic information about images created by this program for a particular instance
+func ProviderAwsGetAmiImages(client *ec2.Client, instanceId string) ([]ProviderAwsAmiImage, error) {
+
+	var results []ProviderAwsAmiImage
+
+	params := &ec2.DescribeImagesInput{
+		Owners: []string{"self"},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:CreatedBy"),
+				Values: []string{"molibackup"},
+			},
+			{
+				Name:   aws.String("tag:SourceInstanceId"),
+				Values: []string{instanceId},
+			},
+		},
+	}
+
+	resimgs, err := client.DescribeImages(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("DescribeImages", "", "", err)
+	}
+
+	for _, image := range resimgs.Images {
+		imgdata, err := awsDecodeAmiImage(image)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, imgdata)
+	}
+
+	return results, nil
+}
+
+// Create an image of an instance and tag both the image and the snapshots backing it
+func ProviderAwsCreateAmiImage(client *ec2.Client, instanceId string, imagename string, snapdate string, snaptime string, noReboot bool, extraTags map[string]string) (string, error) {
+
+	tags := []types.Tag{
+		{
+			Key:   aws.String("Name"),
+			Value: aws.String(imagename),
+		},
+		{
+			Key:   aws.String("CreatedBy"),
+			Value: aws.String("molibackup"),
+		},
+		{
+			Key:   aws.String("CreateDate"),
+			Value: aws.String(snapdate),
+		},
+		{
+			Key:   aws.String("Timestamp"),
+			Value: aws.String(snaptime),
+		},
+		{
+			Key:   aws.String("JobId"),
+			Value: aws.String(jobExecutionId),
+		},
+		{
+			Key:   aws.String("SourceInstanceId"),
+			Value: aws.String(instanceId),
+		},
+	}
+
+	// Additional tags never replace the tags which are required by this program
+	for tagkey, tagval := range extraTags {
+		if slices.Contains(awsReservedTags, tagkey) == false && tagkey != "SourceInstanceId" {
+			tags = append(tags, types.Tag{Key: aws.String(tagkey), Value: aws.String(tagval)})
+		}
+	}
+
+	params := &ec2.CreateImageInput{
+		InstanceId:  &instanceId,
+		Name:        &imagename,
+		Description: &imagename,
+		NoReboot:    &noReboot,
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeImage,
+				Tags:         tags,
+			},
+			{
+				ResourceType: types.ResourceTypeSnapshot,
+				Tags:         tags,
+			},
+		},
+	}
+
+	result, err := client.CreateImage(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("CreateImage", "instance", instanceId, err)
+	}
+
+	return awsMandatoryString(result.ImageId, "new image", "ImageId")
+}
+
+// Deregister an image and delete the snapshots which were backing it
+func ProviderAwsDeleteAmiImage(client *ec2.Client, image ProviderAwsAmiImage) error {
+
+	params := &ec2.DeregisterImageInput{
+		ImageId: &image.imageId,
+	}
+	_, err := client.DeregisterImage(context.TODO(), params)
+	if err != nil {
+		return newProviderError("DeregisterImage", "image", image.imageId, err)
+	}
+
+	for _, snapshotId := range image.snapshotIds {
+		if err := ProviderAwsDeleteEbsSnapshot(client, snapshotId); err != nil {
+			return fmt.Errorf("failed to delete snapshot backing image %s: %w", image.imageId, err)
+		}
+	}
+
+	return nil
+}
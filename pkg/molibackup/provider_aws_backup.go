@@ -0,0 +1,193 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/backup"
+	backuptypes "github.com/aws/aws-sdk-go-v2/service/backup/types"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	taggingtypes "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+)
+
+// Interval between two checks of the state of a backup job
+const awsBackupJobPollInterval = 30 * time.Second
+
+type ProviderAwsRecoveryPoint struct {
+	resourceArn      string
+	recoveryPointArn string
+	backupVaultName  string
+	creationTime     int64
+}
+
+func ProviderAwsNewBackupClient(cfg aws.Config) *backup.Client {
+
+	return backup.NewFromConfig(cfg)
+
+}
+
+func ProviderAwsNewTaggingClient(cfg aws.Config) *resourcegroupstaggingapi.Client {
+
+	return resourcegroupstaggingapi.NewFromConfig(cfg)
+
+}
+
+// Return the ARN of all resources of a particular type which have all the tags specified
+func ProviderAwsGetTaggedResources(client *resourcegroupstaggingapi.Client, resourceType string, resourceTags map[string]string) ([]string, error) {
+
+	var results []string
+	var filters []taggingtypes.TagFilter
+
+	for tagkey, tagval := range resourceTags {
+		curfilter := taggingtypes.TagFilter{
+			Key:    aws.String(tagkey),
+			Values: []string{tagval},
+		}
+		filters = append(filters, curfilter)
+	}
+
+	params := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []string{resourceType},
+		TagFilters:          filters,
+	}
+
+	res, err := client.GetResources(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("GetResources", "", "", err)
+	}
+
+	for _, resource := range res.ResourceTagMappingList {
+		arn, err := awsMandatoryString(resource.ResourceARN, "tagged resource", "ResourceARN")
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, arn)
+	}
+
+	return results, nil
+}
+
+// Start an on-demand backup job in AWS Backup and return the identifier of the job
+func ProviderAwsStartBackupJob(client *backup.Client, backupVault string, iamRoleArn string, resourceArn string, snapname string, snapdate string, snaptime string) (string, error) {
+
+	params := &backup.StartBackupJobInput{
+		BackupVaultName: &backupVault,
+		IamRoleArn:      &iamRoleArn,
+		ResourceArn:     &resourceArn,
+		RecoveryPointTags: map[string]string{
+			"Name":       snapname,
+			"CreatedBy":  "molibackup",
+			"CreateDate": snapdate,
+			"Timestamp":  snaptime,
+			"JobId":      jobExecutionId,
+		},
+	}
+
+	result, err := client.StartBackupJob(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("StartBackupJob", "resource", resourceArn, err)
+	}
+
+	return awsMandatoryString(result.BackupJobId, "new backup job", "BackupJobId")
+}
+
+// Wait until a backup job has completed and return an error if it has not completed successfully
+func ProviderAwsWaitBackupJob(client *backup.Client, backupJobId string, timeout time.Duration) error {
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		params := &backup.DescribeBackupJobInput{
+			BackupJobId: &backupJobId,
+		}
+		result, err := client.DescribeBackupJob(context.TODO(), params)
+		if err != nil {
+			return newProviderError("DescribeBackupJob", "backup job", backupJobId, err)
+		}
+
+		switch result.State {
+		case backuptypes.BackupJobStateCompleted:
+			return nil
+		case backuptypes.BackupJobStateAborted, backuptypes.BackupJobStateFailed, backuptypes.BackupJobStateExpired, backuptypes.BackupJobStatePartial:
+			return fmt.Errorf("backup job %s has finished with state %s: %s", backupJobId, result.State, awsOptionalString(result.StatusMessage))
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("backup job %s has not completed after %v (current state is %s)", backupJobId, timeout, result.State)
+		}
+
+		slog.Debugf("Waiting for backup job %s to complete: state=%s progress=%s%% ...", backupJobId, result.State, awsOptionalString(result.PercentDone))
+		time.Sleep(awsBackupJobPollInterval)
+	}
+}
+
+// Get basic information about recovery points created by this program for a particular resource
+func ProviderAwsGetRecoveryPoints(client *backup.Client, resourceArn string) ([]ProviderAwsRecoveryPoint, error) {
+
+	var results []ProviderAwsRecoveryPoint
+
+	params := &backup.ListRecoveryPointsByResourceInput{
+		ResourceArn: &resourceArn,
+	}
+
+	res, err := client.ListRecoveryPointsByResource(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("ListRecoveryPointsByResource", "resource", resourceArn, err)
+	}
+
+	for _, recpoint := range res.RecoveryPoints {
+		rpdata := ProviderAwsRecoveryPoint{}
+		rpdata.resourceArn = resourceArn
+		if rpdata.recoveryPointArn, err = awsMandatoryString(recpoint.RecoveryPointArn, "recovery point", "RecoveryPointArn"); err != nil {
+			return nil, err
+		}
+		resource := fmt.Sprintf("recovery point %s", rpdata.recoveryPointArn)
+		if rpdata.backupVaultName, err = awsMandatoryString(recpoint.BackupVaultName, resource, "BackupVaultName"); err != nil {
+			return nil, err
+		}
+		if recpoint.CreationDate == nil {
+			return nil, &ProviderAwsDecodeError{resource: resource, attribute: "CreationDate"}
+		}
+		rpdata.creationTime = (*recpoint.CreationDate).Unix()
+
+		// Recovery points are listed without their tags so these must be retrieved separately
+		restags, err := client.ListTags(context.TODO(), &backup.ListTagsInput{ResourceArn: &rpdata.recoveryPointArn})
+		if err != nil {
+			return nil, newProviderError("ListTags", "recovery point", rpdata.recoveryPointArn, err)
+		}
+		if restags.Tags["CreatedBy"] != "molibackup" {
+			continue
+		}
+		if timestamp, ok := awsTimestampFromTags(restags.Tags); ok == true {
+			rpdata.creationTime = timestamp
+		}
+
+		results = append(results, rpdata)
+	}
+
+	return results, nil
+}
+
+func ProviderAwsDeleteRecoveryPoint(client *backup.Client, backupVault string, recoveryPointArn string) error {
+
+	params := &backup.DeleteRecoveryPointInput{
+		BackupVaultName:  &backupVault,
+		RecoveryPointArn: &recoveryPointArn,
+	}
+	_, err := client.DeleteRecoveryPoint(context.TODO(), params)
+	if err != nil {
+		return newProviderError("DeleteRecoveryPoint", "recovery point", recoveryPointArn, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Prefix of the name of all DynamoDB backups created by this program
+const awsDynamodbBackupPrefix = "molibackup-"
+
+type ProviderAwsDynamodbTable struct {
+	tableName string
+	tableArn  string
+}
+
+type ProviderAwsDynamodbBackup struct {
+	tableName  string
+	backupArn  string
+	backupName string
+	backupTime int64
+}
+
+// Decode a backup summary returned by ListBackups()
+func awsDecodeDynamodbBackup(backup types.BackupSummary) (ProviderAwsDynamodbBackup, error) {
+	var err error
+	bkpdata := ProviderAwsDynamodbBackup{}
+	if bkpdata.backupArn, err = awsMandatoryString(backup.BackupArn, "DynamoDB backup", "BackupArn"); err != nil {
+		return bkpdata, err
+	}
+	resource := fmt.Sprintf("DynamoDB backup %s", bkpdata.backupArn)
+	if backup.BackupCreationDateTime == nil {
+		return bkpdata, &ProviderAwsDecodeError{resource: resource, attribute: "BackupCreationDateTime"}
+	}
+	bkpdata.tableName = awsOptionalString(backup.TableName)
+	bkpdata.backupName = awsOptionalString(backup.BackupName)
+	bkpdata.backupTime = (*backup.BackupCreationDateTime).Unix()
+	return bkpdata, nil
+}
+
+func ProviderAwsNewDynamodbClient(cfg aws.Config) *dynamodb.Client {
+
+	return dynamodb.NewFromConfig(cfg)
+
+}
+
+// Return all DynamoDB tables with a name matching the pattern and having all the tags specified
+func ProviderAwsGetDynamodbTables(client *dynamodb.Client, tablePattern string, tableTags map[string]string) ([]ProviderAwsDynamodbTable, error) {
+
+	var results []ProviderAwsDynamodbTable
+
+	restables, err := client.ListTables(context.TODO(), &dynamodb.ListTablesInput{})
+	if err != nil {
+		return nil, newProviderError("ListTables", "", "", err)
+	}
+
+	for _, tableName := range restables.TableNames {
+		// Check if the name of the table matches the pattern specified in table_pattern
+		if tablePattern != "" {
+			matched, err := path.Match(tablePattern, tableName)
+			if err != nil {
+				return nil, fmt.Errorf("invalid table name pattern \"%s\": %v", tablePattern, err)
+			}
+			if matched == false {
+				continue
+			}
+		}
+
+		resdesc, err := client.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return nil, newProviderError("DescribeTable", "table", tableName, err)
+		}
+		if resdesc.Table == nil {
+			return nil, &ProviderAwsDecodeError{resource: fmt.Sprintf("table %s", tableName), attribute: "Table"}
+		}
+		tableArn, err := awsMandatoryString(resdesc.Table.TableArn, fmt.Sprintf("table %s", tableName), "TableArn")
+		if err != nil {
+			return nil, err
+		}
+
+		// Check if all tags specified in table_tags match
+		if len(tableTags) > 0 {
+			restags, err := client.ListTagsOfResource(context.TODO(), &dynamodb.ListTagsOfResourceInput{ResourceArn: aws.String(tableArn)})
+			if err != nil {
+				return nil, newProviderError("ListTagsOfResource", "table", tableName, err)
+			}
+			tagsdict := make(map[string]string)
+			for _, curtag := range restags.Tags {
+				if curtag.Key != nil {
+					tagsdict[*curtag.Key] = awsOptionalString(curtag.Value)
+				}
+			}
+			if awsTagsMatch(tagsdict, tableTags) == false {
+				continue
+			}
+		}
+
+		tabledata := ProviderAwsDynamodbTable{}
+		tabledata.tableName = tableName
+		tabledata.tableArn = tableArn
+		results = append(results, tabledata)
+	}
+
+	return results, nil
+}
+
+// Get basic information about on-demand backups created by this program for a particular table
+func ProviderAwsGetDynamodbBackups(client *dynamodb.Client, tableName string) ([]ProviderAwsDynamodbBackup, error) {
+
+	var results []ProviderAwsDynamodbBackup
+
+	params := &dynamodb.ListBackupsInput{
+		TableName:  aws.String(tableName),
+		BackupType: types.BackupTypeFilterUser,
+	}
+
+	resbkps, err := client.ListBackups(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("ListBackups", "table", tableName, err)
+	}
+
+	for _, backup := range resbkps.BackupSummaries {
+		// DynamoDB backups cannot be tagged so they are identified by their name
+		if strings.HasPrefix(awsOptionalString(backup.BackupName), awsDynamodbBackupPrefix) == false {
+			continue
+		}
+		bkpdata, err := awsDecodeDynamodbBackup(backup)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, bkpdata)
+	}
+
+	return results, nil
+}
+
+func ProviderAwsCreateDynamodbBackup(client *dynamodb.Client, tableName string, backupName string) (string, error) {
+
+	params := &dynamodb.CreateBackupInput{
+		TableName:  &tableName,
+		BackupName: &backupName,
+	}
+
+	result, err := client.CreateBackup(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("CreateBackup", "table", tableName, err)
+	}
+	if result.BackupDetails == nil {
+		return "", &ProviderAwsDecodeError{resource: "new DynamoDB backup", attribute: "BackupDetails"}
+	}
+
+	return awsMandatoryString(result.BackupDetails.BackupArn, "new DynamoDB backup", "BackupArn")
+}
+
+func ProviderAwsDeleteDynamodbBackup(client *dynamodb.Client, backupArn string) error {
+
+	params := &dynamodb.DeleteBackupInput{
+		BackupArn: &backupArn,
+	}
+	_, err := client.DeleteBackup(context.TODO(), params)
+	if err != nil {
+		return newProviderError("DeleteBackup", "backup", backupArn, err)
+	}
+
+	return nil
+}
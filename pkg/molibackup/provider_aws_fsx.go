@@ -0,0 +1,222 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/fsx"
+	"github.com/aws/aws-sdk-go-v2/service/fsx/types"
+)
+
+// Backups are created for file systems or for volumes depending on the type of file system
+type ProviderAwsFsxTarget struct {
+	fileSystemId   string
+	fileSystemType string
+	volumeId       string
+}
+
+type ProviderAwsFsxBackup struct {
+	backupId   string
+	backupTime int64
+}
+
+// Return the name of the file system or volume which is being backed up
+func (t ProviderAwsFsxTarget) name() string {
+	if t.volumeId != "" {
+		return t.volumeId
+	}
+	return t.fileSystemId
+}
+
+// Collect all FSx tags in a map and ignore tags which have no key
+func awsFsxTagsToMap(tags []types.Tag) map[string]string {
+	tagsdict := make(map[string]string)
+	for _, curtag := range tags {
+		if curtag.Key != nil {
+			tagsdict[*curtag.Key] = awsOptionalString(curtag.Value)
+		}
+	}
+	return tagsdict
+}
+
+func ProviderAwsNewFsxClient(cfg aws.Config) *fsx.Client {
+
+	return fsx.NewFromConfig(cfg)
+
+}
+
+// Return all resources to backup for the file systems which match conditions specified in the arguments
+func ProviderAwsGetFsxTargets(client *fsx.Client, fileSystemId string, fileSystemTags map[string]string) ([]ProviderAwsFsxTarget, error) {
+
+	var results []ProviderAwsFsxTarget
+
+	params := &fsx.DescribeFileSystemsInput{}
+	if fileSystemId != "" {
+		params.FileSystemIds = []string{fileSystemId}
+	}
+
+	resfs, err := client.DescribeFileSystems(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("DescribeFileSystems", "", "", err)
+	}
+
+	for _, filesystem := range resfs.FileSystems {
+		if awsTagsMatch(awsFsxTagsToMap(filesystem.Tags), fileSystemTags) == false {
+			continue
+		}
+		fsid, err := awsMandatoryString(filesystem.FileSystemId, "FSx file system", "FileSystemId")
+		if err != nil {
+			return nil, err
+		}
+
+		switch filesystem.FileSystemType {
+		case types.FileSystemTypeWindows, types.FileSystemTypeLustre:
+			target := ProviderAwsFsxTarget{}
+			target.fileSystemId = fsid
+			target.fileSystemType = string(filesystem.FileSystemType)
+			results = append(results, target)
+		case types.FileSystemTypeOntap:
+			// Backups of ONTAP file systems are created for each volume except the root volumes
+			params := &fsx.DescribeVolumesInput{
+				Filters: []types.VolumeFilter{
+					{
+						Name:   types.VolumeFilterNameFileSystemId,
+						Values: []string{fsid},
+					},
+				},
+			}
+			resvols, err := client.DescribeVolumes(context.TODO(), params)
+			if err != nil {
+				return nil, newProviderError("DescribeVolumes", "file system", fsid, err)
+			}
+			for _, volume := range resvols.Volumes {
+				if volume.OntapConfiguration != nil && aws.ToBool(volume.OntapConfiguration.StorageVirtualMachineRoot) == true {
+					continue
+				}
+				target := ProviderAwsFsxTarget{}
+				target.fileSystemId = fsid
+				target.fileSystemType = string(filesystem.FileSystemType)
+				if target.volumeId, err = awsMandatoryString(volume.VolumeId, "FSx volume", "VolumeId"); err != nil {
+					return nil, err
+				}
+				results = append(results, target)
+			}
+		default:
+			slog.Warnf("Ignoring FSx file system %s as type %s is not supported", fsid, filesystem.FileSystemType)
+		}
+	}
+
+	return results, nil
+}
+
+// Get basic information about user initiated backups created by this program for a particular target
+func ProviderAwsGetFsxBackups(client *fsx.Client, target ProviderAwsFsxTarget) ([]ProviderAwsFsxBackup, error) {
+
+	var results []ProviderAwsFsxBackup
+
+	filter := types.Filter{Name: types.FilterNameFileSystemId, Values: []string{target.fileSystemId}}
+	if target.volumeId != "" {
+		filter = types.Filter{Name: types.FilterNameVolumeId, Values: []string{target.volumeId}}
+	}
+
+	params := &fsx.DescribeBackupsInput{
+		Filters: []types.Filter{
+			filter,
+			{
+				Name:   types.FilterNameBackupType,
+				Values: []string{string(types.BackupTypeUserInitiated)},
+			},
+		},
+	}
+
+	resbkps, err := client.DescribeBackups(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("DescribeBackups", "", "", err)
+	}
+
+	for _, backup := range resbkps.Backups {
+		tagsdict := awsFsxTagsToMap(backup.Tags)
+		if tagsdict["CreatedBy"] != "molibackup" {
+			continue
+		}
+		bkpdata := ProviderAwsFsxBackup{}
+		if bkpdata.backupId, err = awsMandatoryString(backup.BackupId, "FSx backup", "BackupId"); err != nil {
+			return nil, err
+		}
+		if backup.CreationTime == nil {
+			return nil, &ProviderAwsDecodeError{resource: fmt.Sprintf("FSx backup %s", bkpdata.backupId), attribute: "CreationTime"}
+		}
+		bkpdata.backupTime = (*backup.CreationTime).Unix()
+		if timestamp, ok := awsTimestampFromTags(tagsdict); ok == true {
+			bkpdata.backupTime = timestamp
+		}
+		results = append(results, bkpdata)
+	}
+
+	return results, nil
+}
+
+func ProviderAwsCreateFsxBackup(client *fsx.Client, target ProviderAwsFsxTarget, bkpname string, bkpdate string, bkptime string) (string, error) {
+
+	params := &fsx.CreateBackupInput{
+		Tags: []types.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(bkpname),
+			},
+			{
+				Key:   aws.String("CreatedBy"),
+				Value: aws.String("molibackup"),
+			},
+			{
+				Key:   aws.String("CreateDate"),
+				Value: aws.String(bkpdate),
+			},
+			{
+				Key:   aws.String("Timestamp"),
+				Value: aws.String(bkptime),
+			},
+			{
+				Key:   aws.String("JobId"),
+				Value: aws.String(jobExecutionId),
+			},
+		},
+	}
+	if target.volumeId != "" {
+		params.VolumeId = aws.String(target.volumeId)
+	} else {
+		params.FileSystemId = aws.String(target.fileSystemId)
+	}
+
+	result, err := client.CreateBackup(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("CreateBackup", "file system", target.fileSystemId, err)
+	}
+	if result.Backup == nil {
+		return "", &ProviderAwsDecodeError{resource: "new FSx backup", attribute: "Backup"}
+	}
+
+	return awsMandatoryString(result.Backup.BackupId, "new FSx backup", "BackupId")
+}
+
+func ProviderAwsDeleteFsxBackup(client *fsx.Client, backupId string) error {
+
+	params := &fsx.DeleteBackupInput{
+		BackupId: &backupId,
+	}
+	_, err := client.DeleteBackup(context.TODO(), params)
+	if err != nil {
+		return newProviderError("DeleteBackup", "backup", backupId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+// Calls of the RDS API which describe resources, implemented by *rds.Client and by mocked clients in tests
+type ProviderAwsRdsDescribeApi interface {
+	rds.DescribeDBClustersAPIClient
+	rds.DescribeDBClusterSnapshotsAPIClient
+}
+
+type ProviderAwsRdsCluster struct {
+	clusterId     string
+	clusterEngine string
+}
+
+type ProviderAwsRdsClusterSnapshot struct {
+	clusterId    string
+	snapshotId   string
+	snapshotTime int64
+}
+
+// Collect all RDS tags in a map and ignore tags which have no key
+func awsRdsTagsToMap(tags []types.Tag) map[string]string {
+	tagsdict := make(map[string]string)
+	for _, curtag := range tags {
+		if curtag.Key != nil {
+			tagsdict[*curtag.Key] = awsOptionalString(curtag.Value)
+		}
+	}
+	return tagsdict
+}
+
+// Decode a DB cluster returned by DescribeDBClusters()
+func awsDecodeRdsCluster(cluster types.DBCluster) (ProviderAwsRdsCluster, error) {
+	var err error
+	clusterdata := ProviderAwsRdsCluster{}
+	if clusterdata.clusterId, err = awsMandatoryString(cluster.DBClusterIdentifier, "DB cluster", "DBClusterIdentifier"); err != nil {
+		return clusterdata, err
+	}
+	clusterdata.clusterEngine = awsOptionalString(cluster.Engine)
+	return clusterdata, nil
+}
+
+// Decode a DB cluster snapshot returned by DescribeDBClusterSnapshots()
+func awsDecodeRdsClusterSnapshot(snapshot types.DBClusterSnapshot) (ProviderAwsRdsClusterSnapshot, error) {
+	var err error
+	snapdata := ProviderAwsRdsClusterSnapshot{}
+	if snapdata.snapshotId, err = awsMandatoryString(snapshot.DBClusterSnapshotIdentifier, "DB cluster snapshot", "DBClusterSnapshotIdentifier"); err != nil {
+		return snapdata, err
+	}
+	resource := fmt.Sprintf("DB cluster snapshot %s", snapdata.snapshotId)
+	if snapshot.SnapshotCreateTime == nil {
+		return snapdata, &ProviderAwsDecodeError{resource: resource, attribute: "SnapshotCreateTime"}
+	}
+	snapdata.clusterId = awsOptionalString(snapshot.DBClusterIdentifier)
+	snapdata.snapshotTime = (*snapshot.SnapshotCreateTime).Unix()
+	// Copies of snapshots have a new creation time so the original creation time takes precedence
+	if timestamp, ok := awsTimestampFromTags(awsRdsTagsToMap(snapshot.TagList)); ok == true {
+		snapdata.snapshotTime = timestamp
+	}
+	return snapdata, nil
+}
+
+func ProviderAwsNewRdsClient(cfg aws.Config) *rds.Client {
+
+	return rds.NewFromConfig(cfg)
+
+}
+
+// Return basic information about all DB clusters that match conditions specified in the arguments
+func ProviderAwsGetRdsClusters(client ProviderAwsRdsDescribeApi, clusterId string, clusterTags map[string]string, engines []string) ([]ProviderAwsRdsCluster, error) {
+
+	var results []ProviderAwsRdsCluster
+	var filters []types.Filter
+
+	if clusterId != "" {
+		curfilter := types.Filter{
+			Name:   aws.String("db-cluster-id"),
+			Values: []string{clusterId},
+		}
+		filters = append(filters, curfilter)
+	}
+
+	params := &rds.DescribeDBClustersInput{Filters: filters}
+	paginator := rds.NewDescribeDBClustersPaginator(client, params)
+	for paginator.HasMorePages() {
+		res, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("DescribeDBClusters", "", "", err)
+		}
+		for _, cluster := range res.DBClusters {
+			// The RDS API also returns clusters of other database engines
+			if slices.Contains(engines, awsOptionalString(cluster.Engine)) == false {
+				continue
+			}
+			// Add cluster to the results if all the tags specified in cluster_tags match
+			if awsTagsMatch(awsRdsTagsToMap(cluster.TagList), clusterTags) == true {
+				clusterdata, err := awsDecodeRdsCluster(cluster)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, clusterdata)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Get basic information about manual snapshots created by this program for a particular DB cluster
+func ProviderAwsGetRdsClusterSnapshots(client ProviderAwsRdsDescribeApi, clusterId string) ([]ProviderAwsRdsClusterSnapshot, error) {
+
+	var results []ProviderAwsRdsClusterSnapshot
+
+	params := &rds.DescribeDBClusterSnapshotsInput{
+		DBClusterIdentifier: aws.String(clusterId),
+		SnapshotType:        aws.String("manual"),
+	}
+
+	paginator := rds.NewDescribeDBClusterSnapshotsPaginator(client, params)
+	for paginator.HasMorePages() {
+		ressnaps, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("DescribeDBClusterSnapshots", "", "", err)
+		}
+		for _, snapshot := range ressnaps.DBClusterSnapshots {
+			// The RDS API does not support filtering cluster snapshots on tags
+			if awsRdsTagsToMap(snapshot.TagList)["CreatedBy"] != "molibackup" {
+				continue
+			}
+			snapdata, err := awsDecodeRdsClusterSnapshot(snapshot)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, snapdata)
+		}
+	}
+
+	return results, nil
+}
+
+func ProviderAwsCreateRdsClusterSnapshot(client *rds.Client, clusterId string, snapname string, snapdate string, snaptime string) (string, error) {
+
+	params := &rds.CreateDBClusterSnapshotInput{
+		DBClusterIdentifier:         &clusterId,
+		DBClusterSnapshotIdentifier: &snapname,
+		Tags: []types.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(snapname),
+			},
+			{
+				Key:   aws.String("CreatedBy"),
+				Value: aws.String("molibackup"),
+			},
+			{
+				Key:   aws.String("CreateDate"),
+				Value: aws.String(snapdate),
+			},
+			{
+				Key:   aws.String("Timestamp"),
+				Value: aws.String(snaptime),
+			},
+			{
+				Key:   aws.String("JobId"),
+				Value: aws.String(jobExecutionId),
+			},
+		},
+	}
+
+	result, err := client.CreateDBClusterSnapshot(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("CreateDBClusterSnapshot", "cluster", clusterId, err)
+	}
+
+	if result.DBClusterSnapshot == nil {
+		return "", &ProviderAwsDecodeError{resource: "new DB cluster snapshot", attribute: "DBClusterSnapshot"}
+	}
+
+	return awsMandatoryString(result.DBClusterSnapshot.DBClusterSnapshotIdentifier, "new DB cluster snapshot", "DBClusterSnapshotIdentifier")
+}
+
+func ProviderAwsDeleteRdsClusterSnapshot(client *rds.Client, snapshotId string) error {
+
+	params := &rds.DeleteDBClusterSnapshotInput{
+		DBClusterSnapshotIdentifier: &snapshotId,
+	}
+	_, err := client.DeleteDBClusterSnapshot(context.TODO(), params)
+	if err != nil {
+		return newProviderError("DeleteDBClusterSnapshot", "snapshot", snapshotId, err)
+	}
+
+	return nil
+}
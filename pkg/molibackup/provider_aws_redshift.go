@@ -0,0 +1,175 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/aws/aws-sdk-go-v2/service/redshift/types"
+)
+
+type ProviderAwsRedshiftCluster struct {
+	clusterId string
+}
+
+type ProviderAwsRedshiftSnapshot struct {
+	clusterId    string
+	snapshotId   string
+	snapshotTime int64
+}
+
+// Collect all Redshift tags in a map and ignore tags which have no key
+func awsRedshiftTagsToMap(tags []types.Tag) map[string]string {
+	tagsdict := make(map[string]string)
+	for _, curtag := range tags {
+		if curtag.Key != nil {
+			tagsdict[*curtag.Key] = awsOptionalString(curtag.Value)
+		}
+	}
+	return tagsdict
+}
+
+// Decode a cluster snapshot returned by DescribeClusterSnapshots()
+func awsDecodeRedshiftSnapshot(snapshot types.Snapshot) (ProviderAwsRedshiftSnapshot, error) {
+	var err error
+	snapdata := ProviderAwsRedshiftSnapshot{}
+	if snapdata.snapshotId, err = awsMandatoryString(snapshot.SnapshotIdentifier, "Redshift snapshot", "SnapshotIdentifier"); err != nil {
+		return snapdata, err
+	}
+	resource := fmt.Sprintf("Redshift snapshot %s", snapdata.snapshotId)
+	if snapdata.clusterId, err = awsMandatoryString(snapshot.ClusterIdentifier, resource, "ClusterIdentifier"); err != nil {
+		return snapdata, err
+	}
+	if snapshot.SnapshotCreateTime == nil {
+		return snapdata, &ProviderAwsDecodeError{resource: resource, attribute: "SnapshotCreateTime"}
+	}
+	snapdata.snapshotTime = (*snapshot.SnapshotCreateTime).Unix()
+	if timestamp, ok := awsTimestampFromTags(awsRedshiftTagsToMap(snapshot.Tags)); ok == true {
+		snapdata.snapshotTime = timestamp
+	}
+	return snapdata, nil
+}
+
+func ProviderAwsNewRedshiftClient(cfg aws.Config) *redshift.Client {
+
+	return redshift.NewFromConfig(cfg)
+
+}
+
+// Return basic information about all Redshift clusters that match conditions specified in the arguments
+func ProviderAwsGetRedshiftClusters(client *redshift.Client, clusterId string, clusterTags map[string]string) ([]ProviderAwsRedshiftCluster, error) {
+
+	var results []ProviderAwsRedshiftCluster
+
+	params := &redshift.DescribeClustersInput{}
+	if clusterId != "" {
+		params.ClusterIdentifier = aws.String(clusterId)
+	}
+
+	res, err := client.DescribeClusters(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("DescribeClusters", "", "", err)
+	}
+
+	for _, cluster := range res.Clusters {
+		// Add cluster to the results if all the tags specified in cluster_tags match
+		if awsTagsMatch(awsRedshiftTagsToMap(cluster.Tags), clusterTags) == true {
+			clusterdata := ProviderAwsRedshiftCluster{}
+			if clusterdata.clusterId, err = awsMandatoryString(cluster.ClusterIdentifier, "Redshift cluster", "ClusterIdentifier"); err != nil {
+				return nil, err
+			}
+			results = append(results, clusterdata)
+		}
+	}
+
+	return results, nil
+}
+
+// Get basic information about manual snapshots created by this program for a particular cluster
+func ProviderAwsGetRedshiftSnapshots(client *redshift.Client, clusterId string) ([]ProviderAwsRedshiftSnapshot, error) {
+
+	var results []ProviderAwsRedshiftSnapshot
+
+	params := &redshift.DescribeClusterSnapshotsInput{
+		ClusterIdentifier: aws.String(clusterId),
+		SnapshotType:      aws.String("manual"),
+		TagKeys:           []string{"CreatedBy"},
+		TagValues:         []string{"molibackup"},
+	}
+
+	ressnaps, err := client.DescribeClusterSnapshots(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("DescribeClusterSnapshots", "", "", err)
+	}
+
+	for _, snapshot := range ressnaps.Snapshots {
+		snapdata, err := awsDecodeRedshiftSnapshot(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, snapdata)
+	}
+
+	return results, nil
+}
+
+func ProviderAwsCreateRedshiftSnapshot(client *redshift.Client, clusterId string, snapname string, snapdate string, snaptime string) (string, error) {
+
+	params := &redshift.CreateClusterSnapshotInput{
+		ClusterIdentifier:  &clusterId,
+		SnapshotIdentifier: &snapname,
+		Tags: []types.Tag{
+			{
+				Key:   aws.String("Name"),
+				Value: aws.String(snapname),
+			},
+			{
+				Key:   aws.String("CreatedBy"),
+				Value: aws.String("molibackup"),
+			},
+			{
+				Key:   aws.String("CreateDate"),
+				Value: aws.String(snapdate),
+			},
+			{
+				Key:   aws.String("Timestamp"),
+				Value: aws.String(snaptime),
+			},
+			{
+				Key:   aws.String("JobId"),
+				Value: aws.String(jobExecutionId),
+			},
+		},
+	}
+
+	result, err := client.CreateClusterSnapshot(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("CreateClusterSnapshot", "cluster", clusterId, err)
+	}
+	if result.Snapshot == nil {
+		return "", &ProviderAwsDecodeError{resource: "new Redshift snapshot", attribute: "Snapshot"}
+	}
+
+	return awsMandatoryString(result.Snapshot.SnapshotIdentifier, "new Redshift snapshot", "SnapshotIdentifier")
+}
+
+func ProviderAwsDeleteRedshiftSnapshot(client *redshift.Client, clusterId string, snapshotId string) error {
+
+	params := &redshift.DeleteClusterSnapshotInput{
+		SnapshotIdentifier:        &snapshotId,
+		SnapshotClusterIdentifier: &clusterId,
+	}
+	_, err := client.DeleteClusterSnapshot(context.TODO(), params)
+	if err != nil {
+		return newProviderError("DeleteClusterSnapshot", "snapshot", snapshotId, err)
+	}
+
+	return nil
+}
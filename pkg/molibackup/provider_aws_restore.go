@@ -0,0 +1,396 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Maximum time to wait for a resource to reach the expected state during a restore
+const awsRestoreWaitTimeout = 30 * time.Minute
+
+// Interval between two checks of the state of fast snapshot restores which take at least several minutes to be enabled
+const awsFastRestorePollInterval = 30 * time.Second
+
+// Attributes of an instance which are required to launch an equivalent instance
+type ProviderAwsInstanceLayout struct {
+	instanceId       string
+	instanceName     string
+	imageId          string
+	instanceType     string
+	availabilityZone string
+	subnetId         string
+	keyName          string
+	iamProfileArn    string
+	securityGroupIds []string
+	devices          map[string]string
+}
+
+// Settings of the volumes created from snapshots, where zero values use the defaults of the volume type
+type ProviderAwsRestoreVolumeSettings struct {
+	volumeType  string
+	iops        int32
+	throughput  int32
+	kmsKeyId    string
+	copyTags    []string
+	tagRestored bool
+	initialize  string
+	initTimeout time.Duration
+}
+
+type ProviderAwsRestoreSnapshot struct {
+	volumeId   string
+	snapshotId string
+	runId      string
+	createTime int64
+	tags       map[string]string
+}
+
+// Return the block device mapping and the attributes of an instance
+func ProviderAwsGetInstanceLayout(client *ec2.Client, instanceId string) (ProviderAwsInstanceLayout, error) {
+
+	layout := ProviderAwsInstanceLayout{instanceId: instanceId, devices: make(map[string]string)}
+
+	res, err := client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: []string{instanceId}})
+	if err != nil {
+		return layout, newProviderError("DescribeInstances", "instance", instanceId, err)
+	}
+	if len(res.Reservations) != 1 || len(res.Reservations[0].Instances) != 1 {
+		return layout, fmt.Errorf("instance %s has not been found", instanceId)
+	}
+	instance := res.Reservations[0].Instances[0]
+	resource := fmt.Sprintf("instance %s", instanceId)
+
+	layout.instanceName = awsEc2TagsToMap(instance.Tags)["Name"]
+	if layout.imageId, err = awsMandatoryString(instance.ImageId, resource, "ImageId"); err != nil {
+		return layout, err
+	}
+	layout.instanceType = string(instance.InstanceType)
+	if instance.Placement == nil {
+		return layout, &ProviderAwsDecodeError{resource: resource, attribute: "Placement"}
+	}
+	if layout.availabilityZone, err = awsMandatoryString(instance.Placement.AvailabilityZone, resource, "AvailabilityZone"); err != nil {
+		return layout, err
+	}
+	layout.subnetId = awsOptionalString(instance.SubnetId)
+	layout.keyName = awsOptionalString(instance.KeyName)
+	if instance.IamInstanceProfile != nil {
+		layout.iamProfileArn = awsOptionalString(instance.IamInstanceProfile.Arn)
+	}
+	for _, group := range instance.SecurityGroups {
+		if group.GroupId != nil {
+			layout.securityGroupIds = append(layout.securityGroupIds, *group.GroupId)
+		}
+	}
+	for _, mapping := range instance.BlockDeviceMappings {
+		if mapping.DeviceName != nil && mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+			layout.devices[*mapping.DeviceName] = *mapping.Ebs.VolumeId
+		}
+	}
+
+	return layout, nil
+}
+
+// Return completed snapshots created by this program on a particular date for any of the volumes specified.
+// Snapshots are associated with the run which created them using the consistency group or the job identifier.
+func ProviderAwsGetRestoreSnapshots(client *ec2.Client, volumeIds []string, snapdate string) ([]ProviderAwsRestoreSnapshot, error) {
+
+	var results []ProviderAwsRestoreSnapshot
+
+	params := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("tag:CreatedBy"),
+				Values: []string{"molibackup"},
+			},
+			{
+				Name:   aws.String("tag:CreateDate"),
+				Values: []string{snapdate},
+			},
+			{
+				Name:   aws.String("volume-id"),
+				Values: volumeIds,
+			},
+			{
+				Name:   aws.String("status"),
+				Values: []string{"completed"},
+			},
+		},
+	}
+
+	paginator := ec2.NewDescribeSnapshotsPaginator(client, params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("DescribeSnapshots", "", "", err)
+		}
+		for _, snapshot := range page.Snapshots {
+			snapdata, err := awsDecodeEbsSnapshot(snapshot)
+			if err != nil {
+				return nil, err
+			}
+			tagsdict := awsEc2TagsToMap(snapshot.Tags)
+			runId := tagsdict["ConsistencyGroupId"]
+			if runId == "" {
+				runId = tagsdict["JobId"]
+			}
+			results = append(results, ProviderAwsRestoreSnapshot{
+				volumeId:   snapdata.volumeId,
+				snapshotId: snapdata.snapshotId,
+				runId:      runId,
+				createTime: snapdata.snapshotTime,
+				tags:       tagsdict,
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// Create a volume from a snapshot with the tags specified and wait until it is available
+func ProviderAwsCreateVolumeFromSnapshot(client *ec2.Client, snapshotId string, availabilityZone string, tags map[string]string, settings ProviderAwsRestoreVolumeSettings) (string, error) {
+
+	var ec2tags []types.Tag
+	for tagkey, tagval := range tags {
+		ec2tags = append(ec2tags, types.Tag{Key: aws.String(tagkey), Value: aws.String(tagval)})
+	}
+
+	params := &ec2.CreateVolumeInput{
+		SnapshotId:       aws.String(snapshotId),
+		AvailabilityZone: aws.String(availabilityZone),
+		VolumeType:       types.VolumeType(settings.volumeType),
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeVolume,
+				Tags:         ec2tags,
+			},
+		},
+	}
+
+	// Volumes created from encrypted snapshots are always encrypted, with the key of the snapshot by default
+	if settings.kmsKeyId != "" {
+		params.Encrypted = aws.Bool(true)
+		params.KmsKeyId = aws.String(settings.kmsKeyId)
+	}
+	if settings.iops > 0 {
+		params.Iops = aws.Int32(settings.iops)
+	}
+	if settings.throughput > 0 {
+		params.Throughput = aws.Int32(settings.throughput)
+	}
+
+	result, err := client.CreateVolume(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("CreateVolume", "snapshot", snapshotId, err)
+	}
+	volumeId, err := awsMandatoryString(result.VolumeId, "new volume", "VolumeId")
+	if err != nil {
+		return "", err
+	}
+
+	waiter := ec2.NewVolumeAvailableWaiter(client)
+	if err := waiter.Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{volumeId}}, awsRestoreWaitTimeout); err != nil {
+		return volumeId, fmt.Errorf("volume %s has not become available: %v", volumeId, err)
+	}
+
+	return volumeId, nil
+}
+
+// Launch an instance equivalent to the original one and replace its volumes with the volumes specified.
+// Volumes cannot be attached when an instance is launched, so the instance is stopped to swap volumes.
+func ProviderAwsLaunchInstanceWithVolumes(client *ec2.Client, layout ProviderAwsInstanceLayout, volumes map[string]string, tagRestored bool) (string, error) {
+
+	tags := []types.Tag{
+		{Key: aws.String("Name"), Value: aws.String(fmt.Sprintf("restore-of-%s", layout.instanceId))},
+	}
+	if tagRestored == true {
+		tags = append(tags, types.Tag{Key: aws.String("RestoredBy"), Value: aws.String("molibackup")})
+		tags = append(tags, types.Tag{Key: aws.String("SourceInstanceId"), Value: aws.String(layout.instanceId)})
+	}
+
+	params := &ec2.RunInstancesInput{
+		ImageId:      aws.String(layout.imageId),
+		InstanceType: types.InstanceType(layout.instanceType),
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
+		Placement:    &types.Placement{AvailabilityZone: aws.String(layout.availabilityZone)},
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeInstance,
+				Tags:         tags,
+			},
+		},
+	}
+	if layout.subnetId != "" {
+		params.SubnetId = aws.String(layout.subnetId)
+		params.SecurityGroupIds = layout.securityGroupIds
+	}
+	if layout.keyName != "" {
+		params.KeyName = aws.String(layout.keyName)
+	}
+	if layout.iamProfileArn != "" {
+		params.IamInstanceProfile = &types.IamInstanceProfileSpecification{Arn: aws.String(layout.iamProfileArn)}
+	}
+
+	result, err := client.RunInstances(context.TODO(), params)
+	if err != nil {
+		return "", newProviderError("RunInstances", "image", layout.imageId, err)
+	}
+	if len(result.Instances) != 1 {
+		return "", &ProviderAwsDecodeError{resource: "new instance", attribute: "Instances"}
+	}
+	instanceId, err := awsMandatoryString(result.Instances[0].InstanceId, "new instance", "InstanceId")
+	if err != nil {
+		return "", err
+	}
+	slog.Infof("Launched instance %s, waiting for it to be running before replacing its volumes ...", instanceId)
+
+	running := ec2.NewInstanceRunningWaiter(client)
+	if err := running.Wait(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: []string{instanceId}}, awsRestoreWaitTimeout); err != nil {
+		return instanceId, fmt.Errorf("instance %s has not started: %v", instanceId, err)
+	}
+	if _, err := client.StopInstances(context.TODO(), &ec2.StopInstancesInput{InstanceIds: []string{instanceId}}); err != nil {
+		return instanceId, newProviderError("StopInstances", "instance", instanceId, err)
+	}
+	stopped := ec2.NewInstanceStoppedWaiter(client)
+	if err := stopped.Wait(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: []string{instanceId}}, awsRestoreWaitTimeout); err != nil {
+		return instanceId, fmt.Errorf("instance %s has not stopped: %v", instanceId, err)
+	}
+
+	// Detach and delete the volumes created from the image
+	newlayout, err := ProviderAwsGetInstanceLayout(client, instanceId)
+	if err != nil {
+		return instanceId, err
+	}
+	available := ec2.NewVolumeAvailableWaiter(client)
+	for _, volumeId := range newlayout.devices {
+		if _, err := client.DetachVolume(context.TODO(), &ec2.DetachVolumeInput{VolumeId: aws.String(volumeId)}); err != nil {
+			return instanceId, newProviderError("DetachVolume", "volume", volumeId, err)
+		}
+		if err := available.Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{volumeId}}, awsRestoreWaitTimeout); err != nil {
+			return instanceId, fmt.Errorf("volume %s has not been detached: %v", volumeId, err)
+		}
+		if _, err := client.DeleteVolume(context.TODO(), &ec2.DeleteVolumeInput{VolumeId: aws.String(volumeId)}); err != nil {
+			return instanceId, newProviderError("DeleteVolume", "volume", volumeId, err)
+		}
+	}
+
+	// Attach the restored volumes using the device names of the original instance
+	for device, volumeId := range volumes {
+		params := &ec2.AttachVolumeInput{
+			Device:     aws.String(device),
+			InstanceId: aws.String(instanceId),
+			VolumeId:   aws.String(volumeId),
+		}
+		if _, err := client.AttachVolume(context.TODO(), params); err != nil {
+			return instanceId, newProviderError("AttachVolume", "volume", volumeId, err)
+		}
+	}
+	inuse := ec2.NewVolumeInUseWaiter(client)
+	for _, volumeId := range volumes {
+		if err := inuse.Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{volumeId}}, awsRestoreWaitTimeout); err != nil {
+			return instanceId, fmt.Errorf("volume %s has not been attached: %v", volumeId, err)
+		}
+	}
+
+	if _, err := client.StartInstances(context.TODO(), &ec2.StartInstancesInput{InstanceIds: []string{instanceId}}); err != nil {
+		return instanceId, newProviderError("StartInstances", "instance", instanceId, err)
+	}
+
+	return instanceId, nil
+}
+
+// Enable fast snapshot restores of snapshots in an availability zone, so volumes created from these
+// snapshots once they are enabled are fully initialized and deliver their full performance immediately
+func ProviderAwsEnableFastSnapshotRestores(client *ec2.Client, snapshotIds []string, availabilityZone string) error {
+
+	params := &ec2.EnableFastSnapshotRestoresInput{
+		AvailabilityZones: []string{availabilityZone},
+		SourceSnapshotIds: snapshotIds,
+	}
+	result, err := client.EnableFastSnapshotRestores(context.TODO(), params)
+	if err != nil {
+		return newProviderError("EnableFastSnapshotRestores", "snapshots", strings.Join(snapshotIds, ","), err)
+	}
+	for _, item := range result.Unsuccessful {
+		for _, failure := range item.FastSnapshotRestoreStateErrors {
+			if failure.Error != nil {
+				return fmt.Errorf("failed to enable fast snapshot restore of snapshot %s: %s",
+					awsOptionalString(item.SnapshotId), awsOptionalString(failure.Error.Message))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Wait until fast snapshot restores of snapshots are enabled in an availability zone, which takes about one hour per TiB
+func ProviderAwsWaitFastSnapshotRestores(client *ec2.Client, snapshotIds []string, availabilityZone string, timeout time.Duration) error {
+
+	describe := &ec2.DescribeFastSnapshotRestoresInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("snapshot-id"),
+				Values: snapshotIds,
+			},
+			{
+				Name:   aws.String("availability-zone"),
+				Values: []string{availabilityZone},
+			},
+		},
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		enabled := 0
+		paginator := ec2.NewDescribeFastSnapshotRestoresPaginator(client, describe)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return newProviderError("DescribeFastSnapshotRestores", "snapshots", strings.Join(snapshotIds, ","), err)
+			}
+			for _, item := range page.FastSnapshotRestores {
+				if item.State == types.FastSnapshotRestoreStateCodeEnabled {
+					enabled++
+				}
+			}
+		}
+		if enabled >= len(snapshotIds) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("fast snapshot restore is only enabled on %d out of %d snapshots after %v", enabled, len(snapshotIds), timeout)
+		}
+		slog.Infof("Waiting for fast snapshot restore to be enabled on %d out of %d snapshots ...", len(snapshotIds)-enabled, len(snapshotIds))
+		time.Sleep(awsFastRestorePollInterval)
+	}
+}
+
+// Disable fast snapshot restores of snapshots in an availability zone as they are charged for as long as they are enabled
+func ProviderAwsDisableFastSnapshotRestores(client *ec2.Client, snapshotIds []string, availabilityZone string) error {
+
+	params := &ec2.DisableFastSnapshotRestoresInput{
+		AvailabilityZones: []string{availabilityZone},
+		SourceSnapshotIds: snapshotIds,
+	}
+	if _, err := client.DisableFastSnapshotRestores(context.TODO(), params); err != nil {
+		return newProviderError("DisableFastSnapshotRestores", "snapshots", strings.Join(snapshotIds, ","), err)
+	}
+
+	return nil
+}
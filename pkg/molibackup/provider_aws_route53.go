@@ -0,0 +1,130 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+type ProviderAwsRoute53Zone struct {
+	zoneId      string
+	zoneName    string
+	privateZone bool
+}
+
+// Decode a hosted zone returned by ListHostedZones()
+func awsDecodeRoute53Zone(zone types.HostedZone) (ProviderAwsRoute53Zone, error) {
+	var err error
+	zonedata := ProviderAwsRoute53Zone{}
+	if zonedata.zoneId, err = awsMandatoryString(zone.Id, "hosted zone", "Id"); err != nil {
+		return zonedata, err
+	}
+	// Identifiers are returned with a "/hostedzone/" prefix which is not accepted by other calls
+	zonedata.zoneId = strings.TrimPrefix(zonedata.zoneId, "/hostedzone/")
+	resource := fmt.Sprintf("hosted zone %s", zonedata.zoneId)
+	if zonedata.zoneName, err = awsMandatoryString(zone.Name, resource, "Name"); err != nil {
+		return zonedata, err
+	}
+	zonedata.zoneName = strings.TrimSuffix(zonedata.zoneName, ".")
+	if zone.Config != nil {
+		zonedata.privateZone = zone.Config.PrivateZone
+	}
+	return zonedata, nil
+}
+
+func ProviderAwsNewRoute53Client(cfg aws.Config) *route53.Client {
+
+	return route53.NewFromConfig(cfg)
+
+}
+
+// Return all hosted zones with a name matching the pattern and having all the tags specified
+func ProviderAwsGetRoute53Zones(client *route53.Client, zonePattern string, zoneTags map[string]string) ([]ProviderAwsRoute53Zone, error) {
+
+	var results []ProviderAwsRoute53Zone
+
+	paginator := route53.NewListHostedZonesPaginator(client, &route53.ListHostedZonesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("ListHostedZones", "", "", err)
+		}
+
+		for _, zone := range page.HostedZones {
+			zonedata, err := awsDecodeRoute53Zone(zone)
+			if err != nil {
+				return nil, err
+			}
+
+			// Check if the name of the zone matches the pattern specified in zone_pattern
+			if zonePattern != "" {
+				matched, err := path.Match(zonePattern, zonedata.zoneName)
+				if err != nil {
+					return nil, fmt.Errorf("invalid zone name pattern \"%s\": %v", zonePattern, err)
+				}
+				if matched == false {
+					continue
+				}
+			}
+
+			// Check if all tags specified in zone_tags match
+			if len(zoneTags) > 0 {
+				params := &route53.ListTagsForResourceInput{
+					ResourceId:   aws.String(zonedata.zoneId),
+					ResourceType: types.TagResourceTypeHostedzone,
+				}
+				restags, err := client.ListTagsForResource(context.TODO(), params)
+				if err != nil {
+					return nil, newProviderError("ListTagsForResource", "hosted zone", zonedata.zoneId, err)
+				}
+				tagsdict := make(map[string]string)
+				if restags.ResourceTagSet != nil {
+					for _, curtag := range restags.ResourceTagSet.Tags {
+						if curtag.Key != nil {
+							tagsdict[*curtag.Key] = awsOptionalString(curtag.Value)
+						}
+					}
+				}
+				if awsTagsMatch(tagsdict, zoneTags) == false {
+					continue
+				}
+			}
+
+			results = append(results, zonedata)
+		}
+	}
+
+	return results, nil
+}
+
+// Return all record sets of a hosted zone in the format used by the Route53 API
+func ProviderAwsGetRoute53RecordSets(client *route53.Client, zoneId string) ([]types.ResourceRecordSet, error) {
+
+	var results []types.ResourceRecordSet
+
+	params := &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneId),
+	}
+
+	paginator := route53.NewListResourceRecordSetsPaginator(client, params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("ListResourceRecordSets", "hosted zone", zoneId, err)
+		}
+		results = append(results, page.ResourceRecordSets...)
+	}
+
+	return results, nil
+}
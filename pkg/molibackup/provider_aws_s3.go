@@ -0,0 +1,274 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gookit/slog"
+)
+
+// Maximum number of objects which can be deleted with a single call to DeleteObjects()
+const awsS3DeleteBatchSize = 1000
+
+// Initial size of the parts of multipart uploads, which is doubled every 1000 parts as uploads are limited
+// to 10000 parts, so objects of up to several terabytes can be uploaded without knowing their size in advance
+const (
+	awsS3PartSize      = 16 * 1024 * 1024
+	awsS3PartsPerSize  = 1000
+	awsS3MaxPartNumber = 10000
+)
+
+type ProviderAwsS3Object struct {
+	key  string
+	size int64
+	etag string
+}
+
+func ProviderAwsNewS3Client(cfg aws.Config) *s3.Client {
+
+	return s3.NewFromConfig(cfg)
+
+}
+
+// Return all objects stored in a bucket under a particular prefix
+func ProviderAwsListS3Objects(client *s3.Client, bucket string, prefix string) ([]ProviderAwsS3Object, error) {
+
+	var results []ProviderAwsS3Object
+
+	params := &s3.ListObjectsV2Input{
+		Bucket: &bucket,
+		Prefix: &prefix,
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("ListObjectsV2", "bucket", bucket, err)
+		}
+		for _, object := range page.Contents {
+			objdata := ProviderAwsS3Object{}
+			if objdata.key, err = awsMandatoryString(object.Key, fmt.Sprintf("object in bucket %s", bucket), "Key"); err != nil {
+				return nil, err
+			}
+			objdata.size = aws.ToInt64(object.Size)
+			objdata.etag = awsOptionalString(object.ETag)
+			results = append(results, objdata)
+		}
+	}
+
+	return results, nil
+}
+
+// Return the common prefixes found immediately under a prefix, such as sub-directories of a directory
+func ProviderAwsListS3Prefixes(client *s3.Client, bucket string, prefix string) ([]string, error) {
+
+	var results []string
+
+	params := &s3.ListObjectsV2Input{
+		Bucket:    &bucket,
+		Prefix:    &prefix,
+		Delimiter: aws.String("/"),
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("ListObjectsV2", "bucket", bucket, err)
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			if commonPrefix.Prefix != nil {
+				results = append(results, *commonPrefix.Prefix)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Copy an object on the server side to a bucket which can be in another region than the source
+func ProviderAwsCopyS3Object(client *s3.Client, srcBucket string, srcKey string, dstBucket string, dstKey string) error {
+
+	// The copy source must be URL encoded except for the slashes
+	source := (&url.URL{Path: fmt.Sprintf("%s/%s", srcBucket, srcKey)}).EscapedPath()
+
+	params := &s3.CopyObjectInput{
+		Bucket:     &dstBucket,
+		Key:        &dstKey,
+		CopySource: &source,
+	}
+
+	_, err := client.CopyObject(context.TODO(), params)
+	if err != nil {
+		return newProviderError("CopyObject", "object", fmt.Sprintf("s3://%s/%s", srcBucket, srcKey), err)
+	}
+
+	return nil
+}
+
+// Delete multiple objects from a bucket using as few calls to the API as possible
+func ProviderAwsDeleteS3Objects(client *s3.Client, bucket string, keys []string) error {
+
+	for start := 0; start < len(keys); start += awsS3DeleteBatchSize {
+		end := start + awsS3DeleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var objects []types.ObjectIdentifier
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		params := &s3.DeleteObjectsInput{
+			Bucket: &bucket,
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		}
+
+		result, err := client.DeleteObjects(context.TODO(), params)
+		if err != nil {
+			return newProviderError("DeleteObjects", "bucket", bucket, err)
+		}
+		if len(result.Errors) > 0 {
+			first := result.Errors[0]
+			return fmt.Errorf("DeleteObjects() has failed for %d objects in bucket %s: %s: %s", len(result.Errors),
+				bucket, awsOptionalString(first.Key), awsOptionalString(first.Message))
+		}
+	}
+
+	return nil
+}
+
+// Upload an object with the data of a reader as it is read, using a multipart upload when the data does
+// not fit in a single part so objects are never held entirely in memory
+func ProviderAwsUploadS3Object(client *s3.Client, bucket string, key string, reader io.Reader) error {
+
+	buffer := make([]byte, awsS3PartSize)
+	count, err := io.ReadFull(reader, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ProviderAwsPutS3Object(client, bucket, key, buffer[:count])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data of object s3://%s/%s: %v", bucket, key, err)
+	}
+
+	create, err := client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return newProviderError("CreateMultipartUpload", "object", fmt.Sprintf("s3://%s/%s", bucket, key), err)
+	}
+
+	parts, err := providerAwsUploadS3Parts(client, bucket, key, create.UploadId, reader, buffer)
+	if err != nil {
+		// Parts which have been uploaded are stored and billed until the upload is aborted
+		abort := &s3.AbortMultipartUploadInput{Bucket: &bucket, Key: &key, UploadId: create.UploadId}
+		if _, aborterr := client.AbortMultipartUpload(context.TODO(), abort); aborterr != nil {
+			slog.Warnf("Failed to abort upload of object s3://%s/%s: %v", bucket, key, aborterr)
+		}
+		return err
+	}
+
+	complete := &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}
+	if _, err := client.CompleteMultipartUpload(context.TODO(), complete); err != nil {
+		return newProviderError("CompleteMultipartUpload", "object", fmt.Sprintf("s3://%s/%s", bucket, key), err)
+	}
+
+	return nil
+}
+
+// Upload the parts of a multipart upload starting with the full buffer which has already been read
+func providerAwsUploadS3Parts(client *s3.Client, bucket string, key string, uploadid *string, reader io.Reader, buffer []byte) ([]types.CompletedPart, error) {
+
+	var parts []types.CompletedPart
+	data := buffer
+	last := false
+
+	for number := int32(1); ; number++ {
+		params := &s3.UploadPartInput{
+			Bucket:     &bucket,
+			Key:        &key,
+			UploadId:   uploadid,
+			PartNumber: aws.Int32(number),
+			Body:       bytes.NewReader(data),
+		}
+		output, err := client.UploadPart(context.TODO(), params)
+		if err != nil {
+			return nil, newProviderError("UploadPart", "object", fmt.Sprintf("s3://%s/%s", bucket, key), err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: output.ETag, PartNumber: aws.Int32(number)})
+		if last == true {
+			return parts, nil
+		}
+
+		if number == awsS3MaxPartNumber {
+			return nil, fmt.Errorf("object s3://%s/%s is too large to be uploaded", bucket, key)
+		}
+		if number%awsS3PartsPerSize == 0 {
+			buffer = make([]byte, len(buffer)*2)
+		}
+		count, err := io.ReadFull(reader, buffer)
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			last = true
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read data of object s3://%s/%s: %v", bucket, key, err)
+		}
+		data = buffer[:count]
+	}
+}
+
+func ProviderAwsGetS3Object(client *s3.Client, bucket string, key string) ([]byte, error) {
+
+	params := &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}
+
+	result, err := client.GetObject(context.TODO(), params)
+	if err != nil {
+		return nil, newProviderError("GetObject", "object", fmt.Sprintf("s3://%s/%s", bucket, key), err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s in bucket %s: %v", key, bucket, err)
+	}
+
+	return data, nil
+}
+
+func ProviderAwsPutS3Object(client *s3.Client, bucket string, key string, data []byte) error {
+
+	params := &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Body:   bytes.NewReader(data),
+	}
+
+	_, err := client.PutObject(context.TODO(), params)
+	if err != nil {
+		return newProviderError("PutObject", "object", fmt.Sprintf("s3://%s/%s", bucket, key), err)
+	}
+
+	return nil
+}
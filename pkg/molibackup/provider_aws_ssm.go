@@ -0,0 +1,180 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// Interval between two checks of the state of a command sent to instances
+const awsSsmCommandPollInterval = 2 * time.Second
+
+// Interval between two checks of the registration of the agent of an instance
+const awsSsmAgentPollInterval = 10 * time.Second
+
+type ProviderAwsSsmParameter struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Version int64  `json:"version"`
+}
+
+// Decode a parameter returned by GetParametersByPath()
+func awsDecodeSsmParameter(parameter types.Parameter) (ProviderAwsSsmParameter, error) {
+	var err error
+	paramdata := ProviderAwsSsmParameter{}
+	if paramdata.Name, err = awsMandatoryString(parameter.Name, "parameter", "Name"); err != nil {
+		return paramdata, err
+	}
+	paramdata.Type = string(parameter.Type)
+	paramdata.Value = awsOptionalString(parameter.Value)
+	paramdata.Version = parameter.Version
+	return paramdata, nil
+}
+
+func ProviderAwsNewSsmClient(cfg aws.Config) *ssm.Client {
+
+	return ssm.NewFromConfig(cfg)
+
+}
+
+// Return all parameters stored under a path, including parameters in sub-paths
+func ProviderAwsGetSsmParameters(client *ssm.Client, parameterPath string, withDecryption bool) ([]ProviderAwsSsmParameter, error) {
+
+	var results []ProviderAwsSsmParameter
+
+	params := &ssm.GetParametersByPathInput{
+		Path:           aws.String(parameterPath),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(withDecryption),
+	}
+
+	paginator := ssm.NewGetParametersByPathPaginator(client, params)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("GetParametersByPath", "path", parameterPath, err)
+		}
+		for _, parameter := range page.Parameters {
+			paramdata, err := awsDecodeSsmParameter(parameter)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, paramdata)
+		}
+	}
+
+	return results, nil
+}
+
+// Run shell commands on instances using the AWS-RunShellScript document and wait for the result on each instance
+func ProviderAwsRunShellCommands(client *ssm.Client, instanceIds []string, commands []string, timeout time.Duration) error {
+
+	// The execution timeout of the document defaults to one hour and it cannot exceed two days
+	execTimeout := int64(timeout.Seconds())
+	if execTimeout > 172800 {
+		execTimeout = 172800
+	}
+
+	params := &ssm.SendCommandInput{
+		DocumentName: aws.String("AWS-RunShellScript"),
+		InstanceIds:  instanceIds,
+		Parameters: map[string][]string{
+			"commands":         commands,
+			"executionTimeout": {strconv.FormatInt(execTimeout, 10)},
+		},
+		TimeoutSeconds: aws.Int32(int32(timeout.Seconds())),
+	}
+
+	result, err := client.SendCommand(context.TODO(), params)
+	if err != nil {
+		return newProviderError("SendCommand", "instances", strings.Join(instanceIds, ","), err)
+	}
+	if result.Command == nil {
+		return &ProviderAwsDecodeError{resource: "new command", attribute: "Command"}
+	}
+	commandId, err := awsMandatoryString(result.Command.CommandId, "new command", "CommandId")
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for _, instanceId := range instanceIds {
+		status := types.CommandInvocationStatusPending
+		for status != types.CommandInvocationStatusSuccess {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("command %s has not completed on instance %s after %v", commandId, instanceId, timeout)
+			}
+			time.Sleep(awsSsmCommandPollInterval)
+			params := &ssm.GetCommandInvocationInput{
+				CommandId:  &commandId,
+				InstanceId: aws.String(instanceId),
+			}
+			res, err := client.GetCommandInvocation(context.TODO(), params)
+			// The invocation may not exist yet immediately after the command has been sent
+			var notfound *types.InvocationDoesNotExist
+			if errors.As(err, &notfound) {
+				continue
+			}
+			if err != nil {
+				return newProviderError("GetCommandInvocation", "command", commandId, err)
+			}
+			status = res.Status
+			switch status {
+			case types.CommandInvocationStatusCancelled, types.CommandInvocationStatusTimedOut, types.CommandInvocationStatusFailed:
+				return fmt.Errorf("command %s has finished with status %s on instance %s: %s", commandId, status, instanceId, awsOptionalString(res.StandardErrorContent))
+			}
+		}
+		slog.Debugf("Command %s has succeeded on instance %s", commandId, instanceId)
+	}
+
+	return nil
+}
+
+// Wait until the SSM agent of an instance is online so commands can be sent to it, which takes
+// some time after an instance has been started as the agent has to register itself
+func ProviderAwsWaitSsmAgentOnline(client *ssm.Client, instanceId string, timeout time.Duration) error {
+
+	params := &ssm.DescribeInstanceInformationInput{
+		Filters: []types.InstanceInformationStringFilter{
+			{
+				Key:    aws.String(string(types.InstanceInformationFilterKeyInstanceIds)),
+				Values: []string{instanceId},
+			},
+		},
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		res, err := client.DescribeInstanceInformation(context.TODO(), params)
+		if err != nil {
+			return newProviderError("DescribeInstanceInformation", "instance", instanceId, err)
+		}
+		for _, info := range res.InstanceInformationList {
+			if info.PingStatus == types.PingStatusOnline {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the SSM agent of instance %s is not online after %v", instanceId, timeout)
+		}
+		time.Sleep(awsSsmAgentPollInterval)
+	}
+}
@@ -0,0 +1,57 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Return the identifier of the AWS account which the credentials of the configuration belong to
+func ProviderAwsGetCurrentAccount(cfg aws.Config) (string, error) {
+
+	client := sts.NewFromConfig(cfg)
+
+	result, err := client.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", newProviderError("GetCallerIdentity", "", "", err)
+	}
+
+	return awsMandatoryString(result.Account, "caller identity", "Account")
+}
+
+// Refuse to use an account or regions which are not in the allow-lists of the global configuration
+func ProviderAwsCheckGuardrails(cfg aws.Config, regions ...string) error {
+
+	allowedRegions := configListToStrings(kconfig.Get("global.allowed_regions"))
+	if len(allowedRegions) > 0 {
+		for _, region := range regions {
+			if slices.Contains(allowedRegions, region) == false {
+				return fmt.Errorf("region \"%s\" is not in the list of allowed_regions %v", region, allowedRegions)
+			}
+		}
+	}
+
+	// The account is only resolved when required as this requires an additional API call
+	allowedAccounts := configListToStrings(kconfig.Get("global.allowed_accounts"))
+	if len(allowedAccounts) > 0 {
+		account, err := ProviderAwsGetCurrentAccount(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the account of the credentials: %w", err)
+		}
+		if slices.Contains(allowedAccounts, account) == false {
+			return fmt.Errorf("account \"%s\" is not in the list of allowed_accounts %v", account, allowedAccounts)
+		}
+	}
+
+	return nil
+}
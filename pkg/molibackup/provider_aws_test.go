@@ -0,0 +1,586 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	rdstypes "github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+// Mocked EC2 and RDS clients which return the pages of each call in order, where the token of the next page is
+// the index of this page, and which fail with the error specified instead of returning the page at this index
+type mockAwsDescribeClient struct {
+	regions          *ec2.DescribeRegionsOutput
+	instancePages    [][]ec2types.Reservation
+	volumePages      [][]ec2types.Volume
+	snapshotPages    [][]ec2types.Snapshot
+	clusterPages     [][]rdstypes.DBCluster
+	clusterSnapPages [][]rdstypes.DBClusterSnapshot
+	failPage         int
+	failErr          error
+	calls            int
+}
+
+// Return the index of the page requested and the token of the following page
+func (m *mockAwsDescribeClient) page(token *string, count int) (int, *string, error) {
+	m.calls++
+	index := 0
+	if token != nil {
+		index, _ = strconv.Atoi(*token)
+	}
+	if m.failErr != nil && index == m.failPage {
+		return 0, nil, m.failErr
+	}
+	if index+1 < count {
+		return index, aws.String(strconv.Itoa(index + 1)), nil
+	}
+	return index, nil, nil
+}
+
+func (m *mockAwsDescribeClient) DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
+	m.calls++
+	if m.failErr != nil {
+		return nil, m.failErr
+	}
+	return m.regions, nil
+}
+
+func (m *mockAwsDescribeClient) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	index, next, err := m.page(params.NextToken, len(m.instancePages))
+	if err != nil || len(m.instancePages) == 0 {
+		return &ec2.DescribeInstancesOutput{}, err
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: m.instancePages[index], NextToken: next}, nil
+}
+
+func (m *mockAwsDescribeClient) DescribeVolumes(ctx context.Context, params *ec2.DescribeVolumesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	index, next, err := m.page(params.NextToken, len(m.volumePages))
+	if err != nil || len(m.volumePages) == 0 {
+		return &ec2.DescribeVolumesOutput{}, err
+	}
+	return &ec2.DescribeVolumesOutput{Volumes: m.volumePages[index], NextToken: next}, nil
+}
+
+func (m *mockAwsDescribeClient) DescribeSnapshots(ctx context.Context, params *ec2.DescribeSnapshotsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	index, next, err := m.page(params.NextToken, len(m.snapshotPages))
+	if err != nil || len(m.snapshotPages) == 0 {
+		return &ec2.DescribeSnapshotsOutput{}, err
+	}
+	return &ec2.DescribeSnapshotsOutput{Snapshots: m.snapshotPages[index], NextToken: next}, nil
+}
+
+func (m *mockAwsDescribeClient) DescribeDBClusters(ctx context.Context, params *rds.DescribeDBClustersInput, optFns ...func(*rds.Options)) (*rds.DescribeDBClustersOutput, error) {
+	index, next, err := m.page(params.Marker, len(m.clusterPages))
+	if err != nil || len(m.clusterPages) == 0 {
+		return &rds.DescribeDBClustersOutput{}, err
+	}
+	return &rds.DescribeDBClustersOutput{DBClusters: m.clusterPages[index], Marker: next}, nil
+}
+
+func (m *mockAwsDescribeClient) DescribeDBClusterSnapshots(ctx context.Context, params *rds.DescribeDBClusterSnapshotsInput, optFns ...func(*rds.Options)) (*rds.DescribeDBClusterSnapshotsOutput, error) {
+	index, next, err := m.page(params.Marker, len(m.clusterSnapPages))
+	if err != nil || len(m.clusterSnapPages) == 0 {
+		return &rds.DescribeDBClusterSnapshotsOutput{}, err
+	}
+	return &rds.DescribeDBClusterSnapshotsOutput{DBClusterSnapshots: m.clusterSnapPages[index], Marker: next}, nil
+}
+
+var testAwsTime = time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+
+func testEc2Tags(pairs ...string) []ec2types.Tag {
+	var tags []ec2types.Tag
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tags = append(tags, ec2types.Tag{Key: aws.String(pairs[i]), Value: aws.String(pairs[i+1])})
+	}
+	return tags
+}
+
+func testRdsTags(pairs ...string) []rdstypes.Tag {
+	var tags []rdstypes.Tag
+	for i := 0; i+1 < len(pairs); i += 2 {
+		tags = append(tags, rdstypes.Tag{Key: aws.String(pairs[i]), Value: aws.String(pairs[i+1])})
+	}
+	return tags
+}
+
+// Check that an error is a decode error about the attribute specified, or that there is no error if the attribute is empty
+func checkDecodeError(t *testing.T, err error, attribute string) {
+	t.Helper()
+	if attribute == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	var decodeErr *ProviderAwsDecodeError
+	if errors.As(err, &decodeErr) == false {
+		t.Fatalf("expected a decode error about %s, got %v", attribute, err)
+	}
+	if decodeErr.attribute != attribute {
+		t.Fatalf("expected a decode error about %s, got one about %s", attribute, decodeErr.attribute)
+	}
+}
+
+func TestAwsMandatoryString(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   *string
+		want    string
+		wantErr bool
+	}{
+		{"nil", nil, "", true},
+		{"empty", aws.String(""), "", true},
+		{"set", aws.String("snap-1"), "snap-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := awsMandatoryString(tt.value, "snapshot", "SnapshotId")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Fatalf("got (%q, %v), want (%q, error=%v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAwsEc2TagsToMap(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []ec2types.Tag
+		want map[string]string
+	}{
+		{"no tags", nil, map[string]string{}},
+		{"nil key is ignored", []ec2types.Tag{{Key: nil, Value: aws.String("x")}}, map[string]string{}},
+		{"nil value is empty", []ec2types.Tag{{Key: aws.String("Name"), Value: nil}}, map[string]string{"Name": ""}},
+		{"values", testEc2Tags("Name", "web", "Env", "prod"), map[string]string{"Name": "web", "Env": "prod"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := awsEc2TagsToMap(tt.tags); reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAwsDecodeEc2Instance(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   ec2types.Instance
+		owner   *string
+		want    ProviderAwsEc2Instance
+		wantErr string
+	}{
+		{
+			name:    "missing identifier",
+			input:   ec2types.Instance{Tags: testEc2Tags("Name", "web")},
+			wantErr: "InstanceId",
+		},
+		{
+			name:  "missing owner and tags",
+			input: ec2types.Instance{InstanceId: aws.String("i-1")},
+			want:  ProviderAwsEc2Instance{instanceId: "i-1", instanceTags: map[string]string{}},
+		},
+		{
+			name:  "complete",
+			input: ec2types.Instance{InstanceId: aws.String("i-2"), Tags: testEc2Tags("Name", "web")},
+			owner: aws.String("123456789012"),
+			want:  ProviderAwsEc2Instance{instanceId: "i-2", instanceName: "web", instanceOwner: "123456789012", instanceTags: map[string]string{"Name": "web"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := awsDecodeEc2Instance(tt.input, tt.owner)
+			checkDecodeError(t, err, tt.wantErr)
+			if tt.wantErr == "" && reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAwsDecodeEbsVolume(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   ec2types.Volume
+		want    ProviderAwsEbsVolume
+		wantErr string
+	}{
+		{"missing identifier", ec2types.Volume{}, ProviderAwsEbsVolume{}, "VolumeId"},
+		{"empty identifier", ec2types.Volume{VolumeId: aws.String("")}, ProviderAwsEbsVolume{}, "VolumeId"},
+		{"no tags", ec2types.Volume{VolumeId: aws.String("vol-1")}, ProviderAwsEbsVolume{volumeId: "vol-1", volumeTags: map[string]string{}}, ""},
+		{
+			"named",
+			ec2types.Volume{VolumeId: aws.String("vol-2"), Tags: testEc2Tags("Name", "data")},
+			ProviderAwsEbsVolume{volumeId: "vol-2", volumeName: "data", volumeTags: map[string]string{"Name": "data"}},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := awsDecodeEbsVolume(tt.input)
+			checkDecodeError(t, err, tt.wantErr)
+			if tt.wantErr == "" && reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAwsDecodeEbsSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   ec2types.Snapshot
+		want    ProviderAwsEbsSnapshot
+		wantErr string
+	}{
+		{
+			name:    "missing identifier",
+			input:   ec2types.Snapshot{StartTime: aws.Time(testAwsTime)},
+			wantErr: "SnapshotId",
+		},
+		{
+			name:    "missing start time",
+			input:   ec2types.Snapshot{SnapshotId: aws.String("snap-1")},
+			wantErr: "StartTime",
+		},
+		{
+			name:  "missing description, volume and size",
+			input: ec2types.Snapshot{SnapshotId: aws.String("snap-2"), StartTime: aws.Time(testAwsTime), State: ec2types.SnapshotStateCompleted},
+			want:  ProviderAwsEbsSnapshot{snapshotId: "snap-2", snapshotTime: testAwsTime.Unix(), snapshotState: "completed"},
+		},
+		{
+			name: "complete",
+			input: ec2types.Snapshot{SnapshotId: aws.String("snap-3"), StartTime: aws.Time(testAwsTime), VolumeId: aws.String("vol-1"),
+				Description: aws.String("web-data"), VolumeSize: aws.Int32(20), Tags: testEc2Tags("Metadata:app", "shop")},
+			want: ProviderAwsEbsSnapshot{snapshotId: "snap-3", snapshotTime: testAwsTime.Unix(), volumeId: "vol-1", snapshotDesc: "web-data",
+				volumeSize: 20, metadata: map[string]string{"app": "shop"}},
+		},
+		{
+			name: "copy with the source in tags",
+			input: ec2types.Snapshot{SnapshotId: aws.String("snap-4"), StartTime: aws.Time(testAwsTime), VolumeId: aws.String("vol-ffffffff"),
+				Tags: testEc2Tags("Timestamp", "1700000000", "SourceVolumeId", "vol-1", "SourceSnapshotId", "snap-3")},
+			want: ProviderAwsEbsSnapshot{snapshotId: "snap-4", snapshotTime: 1700000000, volumeId: "vol-1", sourceSnapshotId: "snap-3"},
+		},
+		{
+			name:  "invalid timestamp tag",
+			input: ec2types.Snapshot{SnapshotId: aws.String("snap-5"), StartTime: aws.Time(testAwsTime), Tags: testEc2Tags("Timestamp", "yesterday")},
+			want:  ProviderAwsEbsSnapshot{snapshotId: "snap-5", snapshotTime: testAwsTime.Unix()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := awsDecodeEbsSnapshot(tt.input)
+			checkDecodeError(t, err, tt.wantErr)
+			if tt.wantErr == "" && reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAwsDecodeRdsClusterSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   rdstypes.DBClusterSnapshot
+		want    ProviderAwsRdsClusterSnapshot
+		wantErr string
+	}{
+		{
+			name:    "missing identifier",
+			input:   rdstypes.DBClusterSnapshot{SnapshotCreateTime: aws.Time(testAwsTime)},
+			wantErr: "DBClusterSnapshotIdentifier",
+		},
+		{
+			name:    "missing creation time",
+			input:   rdstypes.DBClusterSnapshot{DBClusterSnapshotIdentifier: aws.String("snap-1")},
+			wantErr: "SnapshotCreateTime",
+		},
+		{
+			name:  "missing cluster",
+			input: rdstypes.DBClusterSnapshot{DBClusterSnapshotIdentifier: aws.String("snap-2"), SnapshotCreateTime: aws.Time(testAwsTime)},
+			want:  ProviderAwsRdsClusterSnapshot{snapshotId: "snap-2", snapshotTime: testAwsTime.Unix()},
+		},
+		{
+			name: "timestamp tag",
+			input: rdstypes.DBClusterSnapshot{DBClusterSnapshotIdentifier: aws.String("snap-3"), DBClusterIdentifier: aws.String("db"),
+				SnapshotCreateTime: aws.Time(testAwsTime), TagList: testRdsTags("Timestamp", "1700000000")},
+			want: ProviderAwsRdsClusterSnapshot{snapshotId: "snap-3", clusterId: "db", snapshotTime: 1700000000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := awsDecodeRdsClusterSnapshot(tt.input)
+			checkDecodeError(t, err, tt.wantErr)
+			if tt.wantErr == "" && reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderAwsGetEnabledRegions(t *testing.T) {
+	client := &mockAwsDescribeClient{regions: &ec2.DescribeRegionsOutput{Regions: []ec2types.Region{
+		{RegionName: aws.String("eu-west-1")}, {RegionName: nil}, {RegionName: aws.String("us-east-1")},
+	}}}
+	got, err := ProviderAwsGetEnabledRegions(client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"eu-west-1", "", "us-east-1"}; reflect.DeepEqual(got, want) == false {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestProviderAwsGetEc2Instances(t *testing.T) {
+	pages := [][]ec2types.Reservation{
+		{{OwnerId: aws.String("123456789012"), Instances: []ec2types.Instance{
+			{InstanceId: aws.String("i-1"), Tags: testEc2Tags("Name", "web1", "Backup", "yes")},
+			{InstanceId: aws.String("i-2"), Tags: testEc2Tags("Name", "web2", "Backup", "yes", "Skip", "true")},
+		}}},
+		{},
+		{{Instances: []ec2types.Instance{
+			{InstanceId: aws.String("i-3"), Tags: testEc2Tags("Name", "db1", "Backup", "yes")},
+			{InstanceId: aws.String("i-4"), Tags: testEc2Tags("Name", "db2", "Backup", "no")},
+		}}},
+	}
+	tests := []struct {
+		name     string
+		pages    [][]ec2types.Reservation
+		failPage int
+		failErr  error
+		tags     map[string]string
+		excl     map[string]string
+		want     []string
+		wantErr  string
+	}{
+		{name: "no page", want: nil},
+		{name: "all pages", pages: pages, tags: map[string]string{"Backup": "yes"}, want: []string{"i-1", "i-2", "i-3"}},
+		{name: "exclusions", pages: pages, tags: map[string]string{"Backup": "yes"}, excl: map[string]string{"Skip": "true"}, want: []string{"i-1", "i-3"}},
+		{name: "wildcards", pages: pages, tags: map[string]string{"Name": "db*"}, want: []string{"i-3", "i-4"}},
+		{name: "failure on a later page", pages: pages, failPage: 2, failErr: errors.New("throttled"), wantErr: "DescribeInstances"},
+		{
+			name:    "missing identifier",
+			pages:   [][]ec2types.Reservation{{{Instances: []ec2types.Instance{{Tags: testEc2Tags("Backup", "yes")}}}}},
+			tags:    map[string]string{"Backup": "yes"},
+			wantErr: "InstanceId",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAwsDescribeClient{instancePages: tt.pages, failPage: tt.failPage, failErr: tt.failErr}
+			instances, err := ProviderAwsGetEc2Instances(client, "", tt.tags, tt.excl)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("expected an error about %s", tt.wantErr)
+				}
+				var providerErr *ProviderError
+				if tt.failErr != nil && errors.As(err, &providerErr) == false {
+					t.Fatalf("expected a provider error, got %v", err)
+				}
+				if tt.failErr == nil {
+					checkDecodeError(t, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, instance := range instances {
+				got = append(got, instance.instanceId)
+			}
+			if reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if len(tt.pages) > 0 && client.calls != len(tt.pages) {
+				t.Fatalf("expected %d calls for %d pages, got %d", len(tt.pages), len(tt.pages), client.calls)
+			}
+		})
+	}
+}
+
+func TestProviderAwsGetEbsVolumesByIds(t *testing.T) {
+	client := &mockAwsDescribeClient{volumePages: [][]ec2types.Volume{
+		{
+			{VolumeId: aws.String("vol-1"), Attachments: []ec2types.VolumeAttachment{
+				{InstanceId: aws.String("i-1"), State: ec2types.VolumeAttachmentStateAttached},
+			}},
+			{VolumeId: aws.String("vol-2"), Attachments: []ec2types.VolumeAttachment{
+				{InstanceId: nil, State: ec2types.VolumeAttachmentStateAttached},
+				{InstanceId: aws.String("i-2"), State: ec2types.VolumeAttachmentStateDetaching},
+			}},
+		},
+		{
+			{VolumeId: aws.String("vol-3"), Tags: testEc2Tags("Skip", "true")},
+		},
+	}}
+	volumes, attachments, err := ProviderAwsGetEbsVolumesByIds(client, []string{"vol-1", "vol-2", "vol-3"}, map[string]string{"Skip": "true"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, volume := range volumes {
+		got = append(got, volume.volumeId)
+	}
+	if want := []string{"vol-1", "vol-2"}; reflect.DeepEqual(got, want) == false {
+		t.Fatalf("got volumes %v, want %v", got, want)
+	}
+	if want := map[string]string{"vol-1": "i-1"}; reflect.DeepEqual(attachments, want) == false {
+		t.Fatalf("got attachments %v, want %v", attachments, want)
+	}
+}
+
+func TestProviderAwsGetEbsSnapshots(t *testing.T) {
+	snapshot := func(id string) ec2types.Snapshot {
+		return ec2types.Snapshot{SnapshotId: aws.String(id), VolumeId: aws.String("vol-1"), StartTime: aws.Time(testAwsTime)}
+	}
+	tests := []struct {
+		name     string
+		pages    [][]ec2types.Snapshot
+		failPage int
+		failErr  error
+		want     []string
+		wantErr  string
+	}{
+		{name: "single page", pages: [][]ec2types.Snapshot{{snapshot("snap-1"), snapshot("snap-2")}}, want: []string{"snap-1", "snap-2"}},
+		{
+			name:  "several pages with an empty page",
+			pages: [][]ec2types.Snapshot{{snapshot("snap-1")}, {}, {snapshot("snap-2"), snapshot("snap-3")}},
+			want:  []string{"snap-1", "snap-2", "snap-3"},
+		},
+		{
+			name:    "snapshot without start time on a later page",
+			pages:   [][]ec2types.Snapshot{{snapshot("snap-1")}, {{SnapshotId: aws.String("snap-2")}}},
+			wantErr: "StartTime",
+		},
+		{
+			name:     "failure on a later page",
+			pages:    [][]ec2types.Snapshot{{snapshot("snap-1")}, {snapshot("snap-2")}},
+			failPage: 1,
+			failErr:  fmt.Errorf("request timeout"),
+			wantErr:  "DescribeSnapshots",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, copies := range []bool{false, true} {
+				client := &mockAwsDescribeClient{snapshotPages: tt.pages, failPage: tt.failPage, failErr: tt.failErr}
+				var snapshots []ProviderAwsEbsSnapshot
+				var err error
+				if copies == true {
+					snapshots, err = ProviderAwsGetEbsSnapshotCopies(client, "vol-1")
+				} else {
+					snapshots, err = ProviderAwsGetEbsSnapshots(client, "vol-1")
+				}
+				if tt.wantErr != "" {
+					if err == nil {
+						t.Fatalf("expected an error about %s", tt.wantErr)
+					}
+					if tt.failErr == nil {
+						checkDecodeError(t, err, tt.wantErr)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				var got []string
+				for _, snap := range snapshots {
+					got = append(got, snap.snapshotId)
+				}
+				if reflect.DeepEqual(got, tt.want) == false {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestProviderAwsGetRdsClusters(t *testing.T) {
+	pages := [][]rdstypes.DBCluster{
+		{
+			{DBClusterIdentifier: aws.String("db1"), Engine: aws.String("aurora-postgresql"), TagList: testRdsTags("Backup", "yes")},
+			{DBClusterIdentifier: aws.String("graph"), Engine: aws.String("neptune"), TagList: testRdsTags("Backup", "yes")},
+		},
+		{
+			{DBClusterIdentifier: aws.String("db2"), Engine: aws.String("aurora-mysql"), TagList: testRdsTags("Backup", "no")},
+			{DBClusterIdentifier: aws.String("db3"), Engine: nil},
+			{DBClusterIdentifier: aws.String("db4"), Engine: aws.String("aurora-mysql")},
+		},
+	}
+	tests := []struct {
+		name    string
+		pages   [][]rdstypes.DBCluster
+		engines []string
+		tags    map[string]string
+		want    []string
+		wantErr string
+	}{
+		{name: "all pages", pages: pages, engines: auroraClusterEngines, want: []string{"db1", "db2", "db4"}},
+		{name: "tags", pages: pages, engines: auroraClusterEngines, tags: map[string]string{"Backup": "yes"}, want: []string{"db1"}},
+		{name: "other engine", pages: pages, engines: []string{"neptune"}, want: []string{"graph"}},
+		{
+			name:    "missing identifier",
+			pages:   [][]rdstypes.DBCluster{{{Engine: aws.String("neptune")}}},
+			engines: []string{"neptune"},
+			wantErr: "DBClusterIdentifier",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockAwsDescribeClient{clusterPages: tt.pages}
+			clusters, err := ProviderAwsGetRdsClusters(client, "", tt.tags, tt.engines)
+			checkDecodeError(t, err, tt.wantErr)
+			if tt.wantErr != "" {
+				return
+			}
+			var got []string
+			for _, cluster := range clusters {
+				got = append(got, cluster.clusterId)
+			}
+			if reflect.DeepEqual(got, tt.want) == false {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if client.calls != len(tt.pages) {
+				t.Fatalf("expected %d calls for %d pages, got %d", len(tt.pages), len(tt.pages), client.calls)
+			}
+		})
+	}
+}
+
+func TestProviderAwsGetRdsClusterSnapshots(t *testing.T) {
+	snapshot := func(id string, createdBy string) rdstypes.DBClusterSnapshot {
+		return rdstypes.DBClusterSnapshot{DBClusterSnapshotIdentifier: aws.String(id), DBClusterIdentifier: aws.String("db1"),
+			SnapshotCreateTime: aws.Time(testAwsTime), TagList: testRdsTags("CreatedBy", createdBy)}
+	}
+	client := &mockAwsDescribeClient{clusterSnapPages: [][]rdstypes.DBClusterSnapshot{
+		{snapshot("snap-1", "molibackup"), snapshot("manual-1", "someone")},
+		{snapshot("snap-2", "molibackup"), {DBClusterSnapshotIdentifier: aws.String("untagged")}},
+	}}
+	snapshots, err := ProviderAwsGetRdsClusterSnapshots(client, "db1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, snap := range snapshots {
+		got = append(got, snap.snapshotId)
+	}
+	if want := []string{"snap-1", "snap-2"}; reflect.DeepEqual(got, want) == false {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+type ProviderAzureBlob struct {
+	name string
+	size int64
+}
+
+// Error returned when a response from the Azure API lacks an attribute which is required
+type ProviderAzureDecodeError struct {
+	resource  string
+	attribute string
+}
+
+func (e *ProviderAzureDecodeError) Error() string {
+	return fmt.Sprintf("invalid response from the Azure API: %s has no %s attribute", e.resource, e.attribute)
+}
+
+// Create a client for a storage account using either a service principal or the default credential chain
+func ProviderAzureNewBlobClient(account string, tenantId string, clientId string, clientSecret string) (*azblob.Client, error) {
+
+	var cred azcore.TokenCredential
+	var err error
+
+	if tenantId != "" && clientId != "" && clientSecret != "" {
+		cred, err = azidentity.NewClientSecretCredential(tenantId, clientId, clientSecret, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the azure credential with explicit service principal: %v", err)
+		}
+	} else {
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the azure credential without an explicit service principal: %v", err)
+		}
+	}
+
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	client, err := azblob.NewClient(serviceURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob client for storage account %s: %v", account, err)
+	}
+
+	return client, nil
+}
+
+// Return all blobs stored in a container under a particular prefix
+func ProviderAzureListBlobs(client *azblob.Client, containerName string, prefix string) ([]ProviderAzureBlob, error) {
+
+	var results []ProviderAzureBlob
+
+	pager := client.NewListBlobsFlatPager(containerName, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("ListBlobsFlat", "container", containerName, err)
+		}
+		if page.Segment == nil {
+			continue
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				return nil, &ProviderAzureDecodeError{resource: fmt.Sprintf("blob in container %s", containerName), attribute: "Name"}
+			}
+			blobdata := ProviderAzureBlob{name: *item.Name}
+			if item.Properties != nil && item.Properties.ContentLength != nil {
+				blobdata.size = *item.Properties.ContentLength
+			}
+			results = append(results, blobdata)
+		}
+	}
+
+	return results, nil
+}
+
+// Return the virtual directories found immediately under a prefix of a container
+func ProviderAzureListBlobPrefixes(client *azblob.Client, containerName string, prefix string) ([]string, error) {
+
+	var results []string
+
+	containerClient := client.ServiceClient().NewContainerClient(containerName)
+	pager := containerClient.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			return nil, newProviderError("ListBlobsHierarchy", "container", containerName, err)
+		}
+		if page.Segment == nil {
+			continue
+		}
+		for _, blobPrefix := range page.Segment.BlobPrefixes {
+			if blobPrefix.Name != nil {
+				results = append(results, *blobPrefix.Name)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Copy a blob by streaming its contents so the source and destination can use different accounts
+func ProviderAzureCopyBlob(srcClient *azblob.Client, srcContainer string, srcName string, dstClient *azblob.Client, dstContainer string, dstName string) error {
+
+	download, err := srcClient.DownloadStream(context.TODO(), srcContainer, srcName, nil)
+	if err != nil {
+		return newProviderError("DownloadStream", "blob", srcContainer+"/"+srcName, err)
+	}
+	defer download.Body.Close()
+
+	_, err = dstClient.UploadStream(context.TODO(), dstContainer, dstName, download.Body, nil)
+	if err != nil {
+		return newProviderError("UploadStream", "blob", dstContainer+"/"+dstName, err)
+	}
+
+	return nil
+}
+
+func ProviderAzureUploadBlob(client *azblob.Client, containerName string, blobName string, data []byte) error {
+
+	_, err := client.UploadBuffer(context.TODO(), containerName, blobName, data, nil)
+	if err != nil {
+		return newProviderError("UploadBuffer", "blob", containerName+"/"+blobName, err)
+	}
+
+	return nil
+}
+
+func ProviderAzureDeleteBlob(client *azblob.Client, containerName string, blobName string) error {
+
+	_, err := client.DeleteBlob(context.TODO(), containerName, blobName, nil)
+	if err != nil {
+		return newProviderError("DeleteBlob", "blob", containerName+"/"+blobName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Program and settings used to run borg commands against a repository
+type ProviderBorg struct {
+	program string
+	env     map[string]string
+}
+
+// Statistics about a new archive returned by "borg create --json"
+type ProviderBorgArchiveStats struct {
+	Files            int64 `json:"nfiles"`
+	OriginalSize     int64 `json:"original_size"`
+	CompressedSize   int64 `json:"compressed_size"`
+	DeduplicatedSize int64 `json:"deduplicated_size"`
+}
+
+// Subset of the attributes returned by "borg create --json"
+type borgCreateOutput struct {
+	Archive struct {
+		Name     string                   `json:"name"`
+		Duration float64                  `json:"duration"`
+		Stats    ProviderBorgArchiveStats `json:"stats"`
+	} `json:"archive"`
+}
+
+// Subset of the attributes returned by "borg list --json"
+type borgListOutput struct {
+	Archives []struct {
+		Name string `json:"name"`
+	} `json:"archives"`
+}
+
+func (b *ProviderBorg) run(args ...string) ([]byte, error) {
+	return runCommand(b.program, args, b.env)
+}
+
+// Return the names of the archives of the repository which start with a prefix
+func ProviderBorgListArchives(borg *ProviderBorg, prefix string) ([]string, error) {
+
+	var results []string
+	var listing borgListOutput
+
+	output, err := borg.run("list", "--json", "--glob-archives", prefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(output, &listing); err != nil {
+		return nil, fmt.Errorf("failed to decode the list of archives returned by borg: %v", err)
+	}
+
+	for _, archive := range listing.Archives {
+		results = append(results, archive.Name)
+	}
+
+	return results, nil
+}
+
+// Create an archive of directories and return the statistics reported by borg
+func ProviderBorgCreateArchive(borg *ProviderBorg, archive string, directories []string, exclude []string, compression string) (ProviderBorgArchiveStats, error) {
+
+	var created borgCreateOutput
+
+	args := []string{"create", "--json", "--compression", compression}
+	for _, pattern := range exclude {
+		args = append(args, "--exclude", pattern)
+	}
+	args = append(args, "::"+archive)
+	args = append(args, directories...)
+
+	output, err := borg.run(args...)
+	if err != nil {
+		return ProviderBorgArchiveStats{}, err
+	}
+	if err := json.Unmarshal(output, &created); err != nil {
+		return ProviderBorgArchiveStats{}, fmt.Errorf("failed to decode the statistics returned by borg: %v", err)
+	}
+
+	return created.Archive.Stats, nil
+}
+
+// Delete the archives starting with a prefix which are older than a number of days
+func ProviderBorgPrune(borg *ProviderBorg, prefix string, days int64) error {
+
+	_, err := borg.run("prune", "--glob-archives", prefix+"*", "--keep-within", fmt.Sprintf("%dd", days))
+	return err
+}
+
+// Free the space used by the data which is no longer referenced by any archive
+func ProviderBorgCompact(borg *ProviderBorg) error {
+
+	_, err := borg.run("compact")
+	return err
+}
@@ -0,0 +1,91 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Program and JMX connection settings used to run nodetool against the local Cassandra node
+type ProviderCassandra struct {
+	program      string
+	host         string
+	port         int64
+	username     string
+	passwordFile string
+}
+
+type ProviderCassandraSnapshot struct {
+	snapshotName string
+	keyspace     string
+	table        string
+}
+
+// Options which must be passed to nodetool to connect to the node
+func (c *ProviderCassandra) connectionArgs() []string {
+	var args []string
+	if c.host != "" {
+		args = append(args, "-h", c.host)
+	}
+	if c.port > 0 {
+		args = append(args, "-p", fmt.Sprintf("%d", c.port))
+	}
+	// The password is read by nodetool from a file as the command line is visible to other users
+	if c.username != "" {
+		args = append(args, "-u", c.username)
+	}
+	if c.passwordFile != "" {
+		args = append(args, "-pwf", c.passwordFile)
+	}
+	return args
+}
+
+// Return the snapshots of all tables of the node
+func ProviderCassandraListSnapshots(cassandra *ProviderCassandra) ([]ProviderCassandraSnapshot, error) {
+
+	var results []ProviderCassandraSnapshot
+
+	args := append(cassandra.connectionArgs(), "listsnapshots")
+	output, err := runCommand(cassandra.program, args, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each table of a snapshot is on a line which starts with the name of the snapshot, the keyspace and the table,
+	// and the header, the totals and the message written when there are no snapshots are ignored
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] == "Snapshot" || strings.HasPrefix(fields[0], "Total") {
+			continue
+		}
+		snapdata := ProviderCassandraSnapshot{}
+		snapdata.snapshotName = fields[0]
+		snapdata.keyspace = fields[1]
+		snapdata.table = fields[2]
+		results = append(results, snapdata)
+	}
+
+	return results, nil
+}
+
+// Create a snapshot of the keyspaces specified with a name which identifies the job
+func ProviderCassandraCreateSnapshot(cassandra *ProviderCassandra, snapshotName string, keyspaces []string) error {
+
+	args := append(cassandra.connectionArgs(), "snapshot", "-t", snapshotName)
+	args = append(args, keyspaces...)
+	_, err := runCommand(cassandra.program, args, nil)
+	return err
+}
+
+// Delete a snapshot from all keyspaces of the node
+func ProviderCassandraClearSnapshot(cassandra *ProviderCassandra, snapshotName string) error {
+
+	args := append(cassandra.connectionArgs(), "clearsnapshot", "-t", snapshotName)
+	_, err := runCommand(cassandra.program, args, nil)
+	return err
+}
@@ -0,0 +1,68 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Program and connection settings used to run clickhouse-client
+type ProviderClickhouse struct {
+	program    string
+	host       string
+	port       int64
+	configFile string
+}
+
+// Options which must be passed to clickhouse-client to connect to the server
+func (c *ProviderClickhouse) connectionArgs() []string {
+	var args []string
+	// Credentials are better stored in the configuration file as the command line is visible to other users
+	if c.configFile != "" {
+		args = append(args, fmt.Sprintf("--config-file=%s", c.configFile))
+	}
+	if c.host != "" {
+		args = append(args, fmt.Sprintf("--host=%s", c.host))
+	}
+	if c.port > 0 {
+		args = append(args, fmt.Sprintf("--port=%d", c.port))
+	}
+	return args
+}
+
+// Return a name such as "db" or "db.table" as quoted identifiers which can be used in a statement
+func clickhouseQuoteName(name string) string {
+	var parts []string
+	for _, part := range strings.SplitN(name, ".", 2) {
+		parts = append(parts, "`"+strings.ReplaceAll(strings.ReplaceAll(part, "\\", "\\\\"), "`", "\\`")+"`")
+	}
+	return strings.Join(parts, ".")
+}
+
+// Return a string as a literal which can be used in a statement
+func clickhouseQuoteString(value string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(value, "\\", "\\\\"), "'", "\\'") + "'"
+}
+
+// Back up databases and tables to an archive on a disk of the server which is configured for backups
+func ProviderClickhouseBackup(clickhouse *ProviderClickhouse, databases []string, tables []string, disk string, filename string) error {
+
+	var targets []string
+	for _, database := range databases {
+		targets = append(targets, fmt.Sprintf("DATABASE %s", clickhouseQuoteName(database)))
+	}
+	for _, table := range tables {
+		targets = append(targets, fmt.Sprintf("TABLE %s", clickhouseQuoteName(table)))
+	}
+	statement := fmt.Sprintf("BACKUP %s TO Disk(%s, %s)", strings.Join(targets, ", "),
+		clickhouseQuoteString(disk), clickhouseQuoteString(filename))
+
+	args := append(clickhouse.connectionArgs(), fmt.Sprintf("--query=%s", statement))
+	_, err := runCommand(clickhouse.program, args, nil)
+	return err
+}
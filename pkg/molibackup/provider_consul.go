@@ -0,0 +1,77 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ProviderConsul struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func ProviderConsulNewClient(address string, token string, tlsInsecure bool) *ProviderConsul {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsInsecure}
+
+	// Snapshots of large clusters can take several minutes to be produced and transferred
+	return &ProviderConsul{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		client:  &http.Client{Transport: transport, Timeout: 30 * time.Minute},
+	}
+}
+
+// Save a snapshot of the state of the servers which is a gzipped archive that can be restored with the same API,
+// and return its content to be read by the caller, who must close it, with the index of the raft log at which it
+// has been taken. Snapshots are taken by the leader unless stale snapshots are allowed, in which case any server
+// can produce it even without a leader.
+func ProviderConsulSaveSnapshot(consul *ProviderConsul, datacenter string, stale bool) (io.ReadCloser, string, error) {
+
+	params := url.Values{}
+	if datacenter != "" {
+		params.Set("dc", datacenter)
+	}
+	if stale == true {
+		params.Set("stale", "")
+	}
+	target := consul.address + "/v1/snapshot"
+	if len(params) > 0 {
+		target = target + "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to prepare snapshot request: %v", err)
+	}
+	// The token is sent in a header rather than in the query so it does not appear in the logs of proxies
+	if consul.token != "" {
+		req.Header.Set("X-Consul-Token", consul.token)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+
+	resp, err := consul.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("snapshot request has failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, "", fmt.Errorf("snapshot request has failed with status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp.Body, resp.Header.Get("X-Consul-Index"), nil
+}
@@ -0,0 +1,171 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+type ProviderGcpInstance struct {
+	instanceName string
+	disks        []ProviderGcpDisk
+}
+
+type ProviderGcpDisk struct {
+	diskName string
+	diskLink string
+}
+
+type ProviderGcpSnapshot struct {
+	snapshotName string
+	sourceDisk   string
+	snapshotTime int64
+}
+
+// Check that all labels specified in the conditions are present with the expected value
+func gcpLabelsMatch(labels map[string]string, conditions map[string]string) bool {
+	for key, val := range conditions {
+		curval, ok := labels[key]
+		if (ok == false) || (curval != val) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create a compute client using either a service account key file or the application default credentials
+func ProviderGcpNewComputeService(credentialsFile string) (*compute.Service, error) {
+
+	var opts []option.ClientOption
+
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+
+	service, err := compute.NewService(context.TODO(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the compute client: %v", err)
+	}
+
+	return service, nil
+}
+
+// Return the instances that match the conditions specified in the arguments with their persistent disks
+func ProviderGcpGetInstances(service *compute.Service, project string, zone string, instanceName string, instanceLabels map[string]string) ([]ProviderGcpInstance, error) {
+
+	var results []ProviderGcpInstance
+
+	call := service.Instances.List(project, zone)
+	if instanceName != "" {
+		call = call.Filter(fmt.Sprintf("name = \"%s\"", instanceName))
+	}
+
+	err := call.Pages(context.TODO(), func(page *compute.InstanceList) error {
+		for _, instance := range page.Items {
+			// Add instance to the results if all the labels specified in instance_labels match
+			if gcpLabelsMatch(instance.Labels, instanceLabels) == false {
+				continue
+			}
+			instdata := ProviderGcpInstance{instanceName: instance.Name}
+			for _, disk := range instance.Disks {
+				// Local SSDs are scratch disks which cannot be snapshotted
+				if disk.Type != "PERSISTENT" || disk.Source == "" {
+					continue
+				}
+				diskdata := ProviderGcpDisk{diskName: path.Base(disk.Source), diskLink: disk.Source}
+				instdata.disks = append(instdata.disks, diskdata)
+			}
+			results = append(results, instdata)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newProviderError("Instances.List", "zone", project+"/"+zone, err)
+	}
+
+	return results, nil
+}
+
+// Get basic information about snapshots created by this program for a particular disk
+func ProviderGcpGetDiskSnapshots(service *compute.Service, project string, disk ProviderGcpDisk) ([]ProviderGcpSnapshot, error) {
+
+	var results []ProviderGcpSnapshot
+
+	call := service.Snapshots.List(project).Filter("labels.created_by = \"molibackup\"")
+
+	err := call.Pages(context.TODO(), func(page *compute.SnapshotList) error {
+		for _, snapshot := range page.Items {
+			// The source disk is compared using the path as the API version in the URL may differ
+			if gcpResourcePath(snapshot.SourceDisk) != gcpResourcePath(disk.diskLink) {
+				continue
+			}
+			snapdata := ProviderGcpSnapshot{snapshotName: snapshot.Name, sourceDisk: disk.diskName}
+			creation, err := time.Parse(time.RFC3339, snapshot.CreationTimestamp)
+			if err != nil {
+				return fmt.Errorf("invalid creation timestamp \"%s\" for snapshot %s: %v", snapshot.CreationTimestamp, snapshot.Name, err)
+			}
+			snapdata.snapshotTime = creation.Unix()
+			if timestamp, err := strconv.ParseInt(snapshot.Labels["timestamp"], 10, 64); err == nil && timestamp > 0 {
+				snapdata.snapshotTime = timestamp
+			}
+			results = append(results, snapdata)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newProviderError("Snapshots.List", "project", project, err)
+	}
+
+	return results, nil
+}
+
+func ProviderGcpCreateDiskSnapshot(service *compute.Service, project string, zone string, disk ProviderGcpDisk, snapname string, snapdate string, snaptime string) error {
+
+	// Label values only accept lowercase letters, digits, underscores and dashes
+	snapshot := &compute.Snapshot{
+		Name: snapname,
+		Labels: map[string]string{
+			"created_by":  "molibackup",
+			"create_date": snapdate,
+			"timestamp":   snaptime,
+			"job_id":      jobExecutionId,
+		},
+	}
+
+	_, err := service.Disks.CreateSnapshot(project, zone, disk.diskName, snapshot).Context(context.TODO()).Do()
+	if err != nil {
+		return newProviderError("Disks.CreateSnapshot", "disk", disk.diskName, err)
+	}
+
+	return nil
+}
+
+func ProviderGcpDeleteSnapshot(service *compute.Service, project string, snapshotName string) error {
+
+	_, err := service.Snapshots.Delete(project, snapshotName).Context(context.TODO()).Do()
+	if err != nil {
+		return newProviderError("Snapshots.Delete", "snapshot", snapshotName, err)
+	}
+
+	return nil
+}
+
+// Return the part of a resource URL which starts with the project so URLs can be compared
+func gcpResourcePath(link string) string {
+	if pos := strings.Index(link, "/projects/"); pos >= 0 {
+		return link[pos:]
+	}
+	return link
+}
@@ -0,0 +1,92 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// Create a storage client using either a service account key file or the application default credentials
+func ProviderGcpNewStorageService(credentialsFile string) (*storage.Service, error) {
+
+	var opts []option.ClientOption
+
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+
+	service, err := storage.NewService(context.TODO(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the storage client: %v", err)
+	}
+
+	return service, nil
+}
+
+// Return the names of all objects stored in a bucket under a prefix
+func ProviderGcpListObjects(service *storage.Service, bucket string, prefix string) ([]string, error) {
+
+	var results []string
+
+	call := service.Objects.List(bucket).Prefix(prefix)
+
+	err := call.Pages(context.TODO(), func(page *storage.Objects) error {
+		for _, object := range page.Items {
+			results = append(results, object.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newProviderError("Objects.List", "bucket", bucket, err)
+	}
+
+	return results, nil
+}
+
+func ProviderGcpPutObject(service *storage.Service, bucket string, name string, reader io.Reader) error {
+
+	object := &storage.Object{Name: name}
+
+	// Data is uploaded in chunks with a resumable upload when it does not fit in a single chunk
+	_, err := service.Objects.Insert(bucket, object).Media(reader).Context(context.TODO()).Do()
+	if err != nil {
+		return newProviderError("Objects.Insert", "object", fmt.Sprintf("gs://%s/%s", bucket, name), err)
+	}
+
+	return nil
+}
+
+func ProviderGcpGetObject(service *storage.Service, bucket string, name string) ([]byte, error) {
+
+	response, err := service.Objects.Get(bucket, name).Context(context.TODO()).Download()
+	if err != nil {
+		return nil, newProviderError("Objects.Get", "object", fmt.Sprintf("gs://%s/%s", bucket, name), err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s in bucket %s: %v", name, bucket, err)
+	}
+
+	return data, nil
+}
+
+func ProviderGcpDeleteObject(service *storage.Service, bucket string, name string) error {
+
+	err := service.Objects.Delete(bucket, name).Context(context.TODO()).Do()
+	if err != nil {
+		return newProviderError("Objects.Delete", "object", fmt.Sprintf("gs://%s/%s", bucket, name), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Program and environment used to run git commands against the repositories to mirror
+type ProviderGit struct {
+	program string
+	env     map[string]string
+}
+
+func ProviderGitNewClient(program string, sshKeyFile string) *ProviderGit {
+
+	// Commands must fail rather than wait for credentials which nobody is going to type
+	env := map[string]string{"GIT_TERMINAL_PROMPT": "0"}
+	if sshKeyFile != "" {
+		env["GIT_SSH_COMMAND"] = fmt.Sprintf("ssh -i '%s' -o IdentitiesOnly=yes -o BatchMode=yes", sshKeyFile)
+	}
+
+	return &ProviderGit{program: program, env: env}
+}
+
+// Create a bare mirror of a repository in a directory, or fetch the changes when the mirror already
+// exists, so only new objects are transferred. References deleted upstream are also deleted locally.
+func ProviderGitUpdateMirror(git *ProviderGit, url string, directory string) error {
+
+	if _, err := os.Stat(filepath.Join(directory, "HEAD")); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(directory), 0700); err != nil {
+			return fmt.Errorf("failed to create the parent directory of the mirror: %v", err)
+		}
+		_, err := runCommand(git.program, []string{"clone", "--mirror", "--quiet", url, directory}, git.env)
+		return err
+	}
+
+	// The URL is updated so a repository which has moved is fetched from its new location
+	if _, err := runCommand(git.program, []string{"-C", directory, "remote", "set-url", "origin", url}, git.env); err != nil {
+		return err
+	}
+	_, err := runCommand(git.program, []string{"-C", directory, "fetch", "--prune", "--quiet", "origin"}, git.env)
+	return err
+}
+
+// Create a bundle with all references of a mirror and return a reader of the bundle with its size, or nil when
+// the repository is empty. The bundle is written to a temporary file which is removed when the reader is closed.
+func ProviderGitCreateBundle(git *ProviderGit, directory string) (io.ReadCloser, int64, error) {
+
+	refs, err := runCommand(git.program, []string{"-C", directory, "for-each-ref", "--count=1"}, git.env)
+	if err != nil {
+		return nil, 0, err
+	}
+	if strings.TrimSpace(string(refs)) == "" {
+		return nil, 0, nil
+	}
+
+	tmpfile, err := os.CreateTemp(filepath.Dir(directory), ".molibackup-bundle-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temporary bundle file: %v", err)
+	}
+	tmpfile.Close()
+
+	if _, err := runCommand(git.program, []string{"-C", directory, "bundle", "create", tmpfile.Name(), "--all"}, git.env); err != nil {
+		os.Remove(tmpfile.Name())
+		return nil, 0, err
+	}
+	reader, size, err := openCommandOutput(tmpfile.Name(), tmpfile.Name())
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read bundle: %v", err)
+	}
+
+	return reader, size, nil
+}
@@ -0,0 +1,51 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+)
+
+// Program and settings used to run the dump command of Gitea or Forgejo
+type ProviderGitea struct {
+	program    string
+	configFile string
+	workPath   string
+	runAs      string
+}
+
+// Produce a dump of the repositories, the database and the configuration of the instance and stream it to
+// the writer. The dump is written to the standard output so the user running Gitea does not need to have
+// write access to a directory of the user running this program.
+func ProviderGiteaDump(gitea *ProviderGitea, dumpType string, skip []string, output io.Writer) error {
+
+	program := gitea.program
+	args := []string{"dump", "--config", gitea.configFile, "--type", dumpType, "--file", "-"}
+	if gitea.workPath != "" {
+		args = append(args, "--work-path", gitea.workPath)
+	}
+	for _, item := range skip {
+		args = append(args, fmt.Sprintf("--skip-%s", item))
+	}
+
+	// Gitea refuses to run as root so the command is run as the user of the instance when requested
+	if gitea.runAs != "" {
+		args = append([]string{"-n", "-u", gitea.runAs, "--", program}, args...)
+		program = "sudo"
+	}
+
+	counter := &destinationCountingWriter{writer: output}
+	if err := runCommandStream(program, args, nil, counter); err != nil {
+		return err
+	}
+	if counter.size == 0 {
+		return fmt.Errorf("dump command has not produced any data")
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Interval between two checks of the state of an export
+const gitlabExportPollInterval = 10 * time.Second
+
+// Maximum number of attempts for requests rejected by the rate limits of GitLab
+const gitlabRateLimitAttempts = 5
+
+type ProviderGitlab struct {
+	apiUrl string
+	token  string
+	client *http.Client
+}
+
+func ProviderGitlabNewClient(gitlabUrl string, token string, tlsInsecure bool) *ProviderGitlab {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsInsecure}
+
+	// Archives of large projects can take several minutes to be downloaded
+	return &ProviderGitlab{
+		apiUrl: strings.TrimSuffix(gitlabUrl, "/") + "/api/v4",
+		token:  token,
+		client: &http.Client{Transport: transport, Timeout: 30 * time.Minute},
+	}
+}
+
+// Send a request to the GitLab API and return the status code and the body of the response
+func (g *ProviderGitlab) request(method string, path string) (int, []byte, error) {
+
+	status, body, err := g.open(method, path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read response of request %s %s: %v", method, path, err)
+	}
+
+	return status, data, nil
+}
+
+// Send a request to the GitLab API and return the status code and the body of the response to be read by the
+// caller, who must close it. Requests rejected by the rate limits are sent again after the delay requested by
+// the server.
+func (g *ProviderGitlab) open(method string, path string) (int, io.ReadCloser, error) {
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(method, g.apiUrl+path, nil)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to prepare request %s %s: %v", method, path, err)
+		}
+		if g.token != "" {
+			req.Header.Set("PRIVATE-TOKEN", g.token)
+		}
+		req.Header.Set("User-Agent", fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return 0, nil, fmt.Errorf("request %s %s has failed: %v", method, path, err)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= gitlabRateLimitAttempts {
+			return resp.StatusCode, resp.Body, nil
+		}
+		resp.Body.Close()
+		delay := time.Minute
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+		slog.Infof("Request %s %s has been rate limited by GitLab, trying again in %v ...", method, path, delay)
+		time.Sleep(delay)
+	}
+}
+
+// Path of a project or a group in the API where its full path such as "group/project" is encoded as an identifier
+func gitlabResourcePath(kind string, fullpath string) string {
+	return fmt.Sprintf("/%ss/%s", kind, url.PathEscape(fullpath))
+}
+
+// Schedule an export of a project or of a group, wait until it has been generated, and return the archive to be
+// read by the caller, who must close it. Projects report the state of their export while the archives of groups
+// can only be downloaded once ready.
+func ProviderGitlabExport(g *ProviderGitlab, kind string, fullpath string, timeout time.Duration) (io.ReadCloser, error) {
+
+	resource := gitlabResourcePath(kind, fullpath)
+
+	status, data, err := g.request(http.MethodPost, resource+"/export")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusAccepted {
+		return nil, fmt.Errorf("export of %s \"%s\" has failed with status %d: %s", kind, fullpath, status, strings.TrimSpace(string(data)))
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		time.Sleep(gitlabExportPollInterval)
+
+		ready := true
+		if kind == "project" {
+			status, data, err := g.request(http.MethodGet, resource+"/export")
+			if err != nil {
+				return nil, err
+			}
+			if status != http.StatusOK {
+				return nil, fmt.Errorf("failed to get state of the export of %s \"%s\" with status %d: %s", kind, fullpath, status, strings.TrimSpace(string(data)))
+			}
+			var state struct {
+				ExportStatus string `json:"export_status"`
+			}
+			if err := json.Unmarshal(data, &state); err != nil {
+				return nil, fmt.Errorf("invalid response from the GitLab API for the export of %s \"%s\": %v", kind, fullpath, err)
+			}
+			if state.ExportStatus == "failed" {
+				return nil, fmt.Errorf("export of %s \"%s\" has failed", kind, fullpath)
+			}
+			ready = state.ExportStatus == "finished"
+		}
+
+		if ready == true {
+			status, body, err := g.open(http.MethodGet, resource+"/export/download")
+			if err != nil {
+				return nil, err
+			}
+			if status == http.StatusOK {
+				return body, nil
+			}
+			data, _ := io.ReadAll(io.LimitReader(body, 4096))
+			body.Close()
+			// Groups return 404 until their export has been generated
+			if kind == "project" || status != http.StatusNotFound {
+				return nil, fmt.Errorf("download of the export of %s \"%s\" has failed with status %d: %s", kind, fullpath, status, strings.TrimSpace(string(data)))
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("export of %s \"%s\" has not completed after %v", kind, fullpath, timeout)
+		}
+		slog.Debugf("Waiting for the export of %s \"%s\" to complete ...", kind, fullpath)
+	}
+}
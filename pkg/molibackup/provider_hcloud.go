@@ -0,0 +1,137 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+type ProviderHcloudServer struct {
+	serverId   int64
+	serverName string
+}
+
+type ProviderHcloudSnapshot struct {
+	serverId     int64
+	imageId      int64
+	imageDesc    string
+	snapshotTime int64
+}
+
+// Convert a map of labels into a label selector such as "key1=value1,key2=value2"
+func hcloudLabelSelector(labels map[string]string) string {
+	var parts []string
+	for key, val := range labels {
+		parts = append(parts, fmt.Sprintf("%s=%s", key, val))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func ProviderHcloudNewClient(token string) *hcloud.Client {
+
+	return hcloud.NewClient(hcloud.WithToken(token), hcloud.WithApplication("molibackup", strings.TrimSpace(progversion)))
+
+}
+
+// Return all servers which have the name and all the labels specified
+func ProviderHcloudGetServers(client *hcloud.Client, serverName string, serverLabels map[string]string) ([]ProviderHcloudServer, error) {
+
+	var results []ProviderHcloudServer
+
+	opts := hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: hcloudLabelSelector(serverLabels)},
+		Name:     serverName,
+	}
+
+	servers, err := client.Server.AllWithOpts(context.TODO(), opts)
+	if err != nil {
+		return nil, newProviderError("Server.AllWithOpts", "", "", err)
+	}
+
+	for _, server := range servers {
+		results = append(results, ProviderHcloudServer{serverId: server.ID, serverName: server.Name})
+	}
+
+	return results, nil
+}
+
+// Get basic information about snapshots created by this program for a particular server
+func ProviderHcloudGetSnapshots(client *hcloud.Client, serverId int64) ([]ProviderHcloudSnapshot, error) {
+
+	var results []ProviderHcloudSnapshot
+
+	// Snapshots are still listed after the server has been deleted so they are selected using labels
+	labels := map[string]string{
+		"created_by":    "molibackup",
+		"source_server": fmt.Sprintf("%d", serverId),
+	}
+	opts := hcloud.ImageListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: hcloudLabelSelector(labels)},
+		Type:     []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+	}
+
+	images, err := client.Image.AllWithOpts(context.TODO(), opts)
+	if err != nil {
+		return nil, newProviderError("Image.AllWithOpts", "server", fmt.Sprint(serverId), err)
+	}
+
+	for _, image := range images {
+		snapdata := ProviderHcloudSnapshot{}
+		snapdata.serverId = serverId
+		snapdata.imageId = image.ID
+		snapdata.imageDesc = image.Description
+		snapdata.snapshotTime = image.Created.Unix()
+		if timestamp, err := strconv.ParseInt(image.Labels["timestamp"], 10, 64); err == nil && timestamp > 0 {
+			snapdata.snapshotTime = timestamp
+		}
+		results = append(results, snapdata)
+	}
+
+	return results, nil
+}
+
+func ProviderHcloudCreateSnapshot(client *hcloud.Client, serverId int64, snapname string, snapdate string, snaptime string) (int64, error) {
+
+	opts := &hcloud.ServerCreateImageOpts{
+		Type:        hcloud.ImageTypeSnapshot,
+		Description: hcloud.Ptr(snapname),
+		Labels: map[string]string{
+			"created_by":    "molibackup",
+			"create_date":   snapdate,
+			"timestamp":     snaptime,
+			"job_id":        jobExecutionId,
+			"source_server": fmt.Sprintf("%d", serverId),
+		},
+	}
+
+	result, _, err := client.Server.CreateImage(context.TODO(), &hcloud.Server{ID: serverId}, opts)
+	if err != nil {
+		return 0, newProviderError("Server.CreateImage", "server", fmt.Sprint(serverId), err)
+	}
+	if result.Image == nil {
+		return 0, fmt.Errorf("invalid response from the Hetzner Cloud API: new snapshot has no image attribute")
+	}
+
+	return result.Image.ID, nil
+}
+
+func ProviderHcloudDeleteSnapshot(client *hcloud.Client, imageId int64) error {
+
+	_, err := client.Image.Delete(context.TODO(), &hcloud.Image{ID: imageId})
+	if err != nil {
+		return newProviderError("Image.Delete", "snapshot", fmt.Sprint(imageId), err)
+	}
+
+	return nil
+}
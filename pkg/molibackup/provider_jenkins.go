@@ -0,0 +1,103 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Plugin installed in a Jenkins home directory
+type ProviderJenkinsPlugin struct {
+	name     string
+	version  string
+	disabled bool
+}
+
+// Return the plugins installed in a Jenkins home directory from the manifests of their archives
+func ProviderJenkinsGetPlugins(home string) ([]ProviderJenkinsPlugin, error) {
+
+	var results []ProviderJenkinsPlugin
+
+	var files []string
+	for _, pattern := range []string{"*.jpi", "*.hpi"} {
+		matches, err := filepath.Glob(filepath.Join(home, "plugins", pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list plugins: %v", err)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		manifest, err := jenkinsReadManifest(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest of plugin %s: %v", file, err)
+		}
+		plugin := ProviderJenkinsPlugin{name: manifest["Short-Name"], version: manifest["Plugin-Version"]}
+		if plugin.name == "" {
+			plugin.name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		// Plugins are disabled by creating an empty file next to their archive
+		if _, err := os.Stat(file + ".disabled"); err == nil {
+			plugin.disabled = true
+		} else if errors.Is(err, fs.ErrNotExist) == false {
+			return nil, fmt.Errorf("failed to check state of plugin %s: %v", file, err)
+		}
+		results = append(results, plugin)
+	}
+
+	return results, nil
+}
+
+// Read the main attributes of the manifest of a plugin archive
+func jenkinsReadManifest(file string) (map[string]string, error) {
+
+	reader, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	entry, err := reader.Open("META-INF/MANIFEST.MF")
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+
+	// Long values are wrapped on continuation lines which start with a space
+	results := make(map[string]string)
+	var lastkey string
+	scanner := bufio.NewScanner(entry)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, " ") && lastkey != "" {
+			results[lastkey] += line[1:]
+			continue
+		}
+		key, val, found := strings.Cut(line, ":")
+		if found == true {
+			lastkey = strings.TrimSpace(key)
+			results[lastkey] = strings.TrimSpace(val)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
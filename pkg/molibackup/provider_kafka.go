@@ -0,0 +1,138 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Program and settings used to run kcat against the brokers of a Kafka cluster
+type ProviderKafka struct {
+	program    string
+	brokers    string
+	configFile string
+}
+
+// Offsets of the messages of a partition consumed in a single pass
+type ProviderKafkaSegment struct {
+	firstOffset int64
+	lastOffset  int64
+	count       int64
+}
+
+// Return the arguments which are common to all kcat commands
+func (k *ProviderKafka) args(extra ...string) []string {
+	args := []string{"-b", k.brokers}
+	if k.configFile != "" {
+		args = append(args, "-F", k.configFile)
+	}
+	return append(args, extra...)
+}
+
+// Return the partitions of all topics of the cluster
+func ProviderKafkaListPartitions(kafka *ProviderKafka) (map[string][]int32, error) {
+
+	output, err := runCommand(kafka.program, kafka.args("-L", "-J"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := struct {
+		Topics []struct {
+			Topic      string `json:"topic"`
+			Partitions []struct {
+				Partition int32 `json:"partition"`
+			} `json:"partitions"`
+		} `json:"topics"`
+	}{}
+	if err := json.Unmarshal(output, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata returned by kcat: %v", err)
+	}
+
+	results := make(map[string][]int32)
+	for _, topic := range metadata.Topics {
+		for _, partition := range topic.Partitions {
+			results[topic.Topic] = append(results[topic.Topic], partition.Partition)
+		}
+	}
+
+	return results, nil
+}
+
+// Consume messages of a partition from an offset until the end of the partition or until the maximum number
+// of messages has been reached. Consumption starts from the earliest offset available when the requested
+// offset has already been deleted by the retention of the topic, which is reported by the offsets returned.
+// Messages are written to the writer as JSON lines produced by kcat as they are consumed.
+func ProviderKafkaConsumePartition(kafka *ProviderKafka, topic string, partition int32, offset int64, maxcount int64, output io.Writer) (ProviderKafkaSegment, error) {
+
+	segment := ProviderKafkaSegment{firstOffset: -1, lastOffset: -1}
+
+	args := kafka.args("-C", "-q", "-e", "-J", "-X", "auto.offset.reset=earliest",
+		"-t", topic, "-p", strconv.Itoa(int(partition)), "-o", strconv.FormatInt(offset, 10))
+	if maxcount > 0 {
+		args = append(args, "-c", strconv.FormatInt(maxcount, 10))
+	}
+	reader, writer := io.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		err := runCommandStream(kafka.program, args, nil, writer)
+		writer.CloseWithError(err)
+		result <- err
+	}()
+
+	err := segment.copyMessages(reader, output, topic, partition)
+	// Stop kcat if the messages could not be decoded or written before it has finished
+	reader.Close()
+	cmderr := <-result
+
+	if err != nil {
+		return segment, err
+	}
+	if cmderr != nil {
+		return segment, cmderr
+	}
+
+	return segment, nil
+}
+
+// Copy the messages produced by kcat to the writer one line at a time and record their offsets
+func (segment *ProviderKafkaSegment) copyMessages(input io.Reader, output io.Writer, topic string, partition int32) error {
+
+	// Each message is a JSON object on its own line where the payload is escaped
+	buffered := bufio.NewReader(input)
+	for {
+		line, readerr := buffered.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			message := struct {
+				Offset *int64 `json:"offset"`
+			}{}
+			if err := json.Unmarshal(line, &message); err != nil || message.Offset == nil {
+				return fmt.Errorf("failed to decode message of partition %d of topic \"%s\" returned by kcat", partition, topic)
+			}
+			if segment.firstOffset < 0 {
+				segment.firstOffset = *message.Offset
+			}
+			segment.lastOffset = *message.Offset
+			segment.count++
+			line = append(bytes.TrimSuffix(line, []byte("\n")), '\n')
+			if _, err := output.Write(line); err != nil {
+				return fmt.Errorf("failed to write messages of partition %d of topic \"%s\": %v", partition, topic, err)
+			}
+		}
+		if readerr == io.EOF {
+			return nil
+		}
+		if readerr != nil {
+			return readerr
+		}
+	}
+}
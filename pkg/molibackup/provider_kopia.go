@@ -0,0 +1,92 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Program and settings used to run kopia commands against the repository it is connected to
+type ProviderKopia struct {
+	program    string
+	configFile string
+	env        map[string]string
+}
+
+type ProviderKopiaSnapshot struct {
+	snapshotId   string
+	sourcePath   string
+	description  string
+	snapshotTime int64
+	totalSize    int64
+}
+
+// Subset of the attributes returned by "kopia snapshot list --json"
+type kopiaSnapshotManifest struct {
+	Id     string `json:"id"`
+	Source struct {
+		Path string `json:"path"`
+	} `json:"source"`
+	Description string    `json:"description"`
+	StartTime   time.Time `json:"startTime"`
+	Stats       struct {
+		TotalSize int64 `json:"totalSize"`
+	} `json:"stats"`
+}
+
+func (k *ProviderKopia) run(args ...string) ([]byte, error) {
+	if k.configFile != "" {
+		args = append([]string{"--config-file", k.configFile}, args...)
+	}
+	return runCommand(k.program, args, k.env)
+}
+
+// Return the snapshots of a directory which have a particular description
+func ProviderKopiaGetSnapshots(kopia *ProviderKopia, directory string, description string) ([]ProviderKopiaSnapshot, error) {
+
+	var results []ProviderKopiaSnapshot
+	var manifests []kopiaSnapshotManifest
+
+	output, err := kopia.run("snapshot", "list", "--json", directory)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(output, &manifests); err != nil {
+		return nil, fmt.Errorf("failed to decode the list of snapshots returned by kopia: %v", err)
+	}
+
+	for _, manifest := range manifests {
+		if manifest.Description != description {
+			continue
+		}
+		snapdata := ProviderKopiaSnapshot{}
+		snapdata.snapshotId = manifest.Id
+		snapdata.sourcePath = manifest.Source.Path
+		snapdata.description = manifest.Description
+		snapdata.snapshotTime = manifest.StartTime.Unix()
+		snapdata.totalSize = manifest.Stats.TotalSize
+		results = append(results, snapdata)
+	}
+
+	return results, nil
+}
+
+// Create a snapshot of a directory with a description which identifies the job
+func ProviderKopiaCreateSnapshot(kopia *ProviderKopia, directory string, description string) error {
+
+	_, err := kopia.run("snapshot", "create", "--description", description, directory)
+	return err
+}
+
+// Delete a snapshot from the repository
+func ProviderKopiaDeleteSnapshot(kopia *ProviderKopia, snapshotId string) error {
+
+	_, err := kopia.run("snapshot", "delete", snapshotId, "--delete")
+	return err
+}
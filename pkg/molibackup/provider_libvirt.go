@@ -0,0 +1,135 @@
+//go:build linux
+
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Names of snapshots created by the program start with this prefix so other snapshots are ignored
+const libvirtSnapshotPrefix = "molibackup-"
+
+// Program and connection used to run virsh commands
+type ProviderLibvirt struct {
+	program string
+	uri     string
+}
+
+type ProviderLibvirtSnapshot struct {
+	domainName   string
+	snapshotName string
+	snapshotDesc string
+	snapshotTime int64
+}
+
+// Subset of the attributes returned by "virsh snapshot-dumpxml"
+type libvirtSnapshotXml struct {
+	Name         string `xml:"name"`
+	Description  string `xml:"description"`
+	CreationTime int64  `xml:"creationTime"`
+}
+
+func (v *ProviderLibvirt) run(args ...string) ([]byte, error) {
+	if v.uri != "" {
+		args = append([]string{"--connect", v.uri}, args...)
+	}
+	return runCommand(v.program, args, nil)
+}
+
+// Split the output of a virsh command which returns one name per line
+func libvirtOutputLines(output []byte) []string {
+	var results []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			results = append(results, line)
+		}
+	}
+	return results
+}
+
+// Return the names of all domains, running or not, which match the pattern specified
+func ProviderLibvirtGetDomains(virsh *ProviderLibvirt, domainPattern string) ([]string, error) {
+
+	var results []string
+
+	output, err := virsh.run("list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, domainName := range libvirtOutputLines(output) {
+		matched, err := path.Match(domainPattern, domainName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain name pattern \"%s\": %v", domainPattern, err)
+		}
+		if matched == true {
+			results = append(results, domainName)
+		}
+	}
+
+	return results, nil
+}
+
+// Create a snapshot of a domain which is either internal or external (disk-only)
+func ProviderLibvirtCreateSnapshot(virsh *ProviderLibvirt, domainName string, snapshotName string, snapshotDesc string, external bool, quiesce bool) error {
+
+	args := []string{"snapshot-create-as", "--domain", domainName, "--name", snapshotName, "--description", snapshotDesc, "--atomic"}
+	if external == true {
+		args = append(args, "--disk-only")
+	}
+	if quiesce == true {
+		args = append(args, "--quiesce")
+	}
+
+	_, err := virsh.run(args...)
+	return err
+}
+
+// Return all snapshots of a domain which have been created by the program
+func ProviderLibvirtGetSnapshots(virsh *ProviderLibvirt, domainName string) ([]ProviderLibvirtSnapshot, error) {
+
+	var results []ProviderLibvirtSnapshot
+
+	output, err := virsh.run("snapshot-list", "--domain", domainName, "--name")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshotName := range libvirtOutputLines(output) {
+		if strings.HasPrefix(snapshotName, libvirtSnapshotPrefix) == false {
+			continue
+		}
+		output, err := virsh.run("snapshot-dumpxml", "--domain", domainName, "--snapshotname", snapshotName)
+		if err != nil {
+			return nil, err
+		}
+		var details libvirtSnapshotXml
+		if err := xml.Unmarshal(output, &details); err != nil {
+			return nil, fmt.Errorf("failed to decode the definition of snapshot \"%s\" of domain \"%s\": %v", snapshotName, domainName, err)
+		}
+		snapdata := ProviderLibvirtSnapshot{}
+		snapdata.domainName = domainName
+		snapdata.snapshotName = details.Name
+		snapdata.snapshotDesc = details.Description
+		snapdata.snapshotTime = details.CreationTime
+		results = append(results, snapdata)
+	}
+
+	return results, nil
+}
+
+// Delete a snapshot and merge its data back into the disks of the domain
+func ProviderLibvirtDeleteSnapshot(virsh *ProviderLibvirt, domainName string, snapshotName string) error {
+
+	_, err := virsh.run("snapshot-delete", "--domain", domainName, "--snapshotname", snapshotName)
+	return err
+}
@@ -0,0 +1,169 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/linode/linodego"
+)
+
+// Prefix of the description of all images created by this program
+const linodeImageDescPrefix = "molibackup"
+
+type ProviderLinodeInstance struct {
+	linodeId    int
+	linodeLabel string
+	diskIds     []int
+}
+
+type ProviderLinodeImage struct {
+	imageId    string
+	imageLabel string
+	linodeId   int
+	imageTime  int64
+}
+
+// Attributes stored in the description of an image such as "molibackup linode=123 timestamp=1700000000"
+func linodeImageAttributes(description string) (map[string]string, bool) {
+	fields := strings.Fields(description)
+	if len(fields) == 0 || fields[0] != linodeImageDescPrefix {
+		return nil, false
+	}
+	results := make(map[string]string)
+	for _, field := range fields[1:] {
+		key, val, found := strings.Cut(field, "=")
+		if found == true {
+			results[key] = val
+		}
+	}
+	return results, true
+}
+
+func ProviderLinodeNewClient(token string) *linodego.Client {
+
+	client := linodego.NewClient(http.DefaultClient)
+	client.SetToken(token)
+	client.SetUserAgent(fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+	return &client
+
+}
+
+// Return the Linodes which have the label and all the tags specified with their disks which are not swap
+func ProviderLinodeGetInstances(client *linodego.Client, linodeLabel string, linodeTags []string) ([]ProviderLinodeInstance, error) {
+
+	var results []ProviderLinodeInstance
+
+	opts := &linodego.ListOptions{}
+	if linodeLabel != "" {
+		filter, _ := json.Marshal(map[string]string{"label": linodeLabel})
+		opts.Filter = string(filter)
+	}
+
+	instances, err := client.ListInstances(context.TODO(), opts)
+	if err != nil {
+		return nil, newProviderError("ListInstances", "", "", err)
+	}
+
+	for _, instance := range instances {
+		// Add instance to the results if it has all the tags specified in linode_tags
+		matched := true
+		for _, tag := range linodeTags {
+			matched = matched && slices.Contains(instance.Tags, tag)
+		}
+		if matched == false {
+			continue
+		}
+
+		disks, err := client.ListInstanceDisks(context.TODO(), instance.ID, nil)
+		if err != nil {
+			return nil, newProviderError("ListInstanceDisks", "linode", fmt.Sprint(instance.ID), err)
+		}
+		instdata := ProviderLinodeInstance{linodeId: instance.ID, linodeLabel: instance.Label}
+		for _, disk := range disks {
+			if disk.Filesystem != linodego.FilesystemSwap {
+				instdata.diskIds = append(instdata.diskIds, disk.ID)
+			}
+		}
+		results = append(results, instdata)
+	}
+
+	return results, nil
+}
+
+// Get basic information about images created by this program for a particular Linode
+func ProviderLinodeGetImages(client *linodego.Client, linodeId int) ([]ProviderLinodeImage, error) {
+
+	var results []ProviderLinodeImage
+
+	images, err := client.ListImages(context.TODO(), nil)
+	if err != nil {
+		return nil, newProviderError("ListImages", "", "", err)
+	}
+
+	for _, image := range images {
+		// Images cannot be tagged so they are identified by their description
+		attributes, ok := linodeImageAttributes(image.Description)
+		if ok == false || image.Type != "manual" || attributes["linode"] != fmt.Sprintf("%d", linodeId) {
+			continue
+		}
+		imgdata := ProviderLinodeImage{imageId: image.ID, imageLabel: image.Label, linodeId: linodeId}
+		if image.Created != nil {
+			imgdata.imageTime = image.Created.Unix()
+		}
+		if timestamp, err := strconv.ParseInt(attributes["timestamp"], 10, 64); err == nil && timestamp > 0 {
+			imgdata.imageTime = timestamp
+		}
+		results = append(results, imgdata)
+	}
+
+	return results, nil
+}
+
+func ProviderLinodeCreateImage(client *linodego.Client, linodeId int, diskId int, imagename string, snaptime string) (string, error) {
+
+	opts := linodego.ImageCreateOptions{
+		DiskID:      diskId,
+		Label:       imagename,
+		Description: fmt.Sprintf("%s linode=%d disk=%d timestamp=%s job=%s", linodeImageDescPrefix, linodeId, diskId, snaptime, jobExecutionId),
+	}
+
+	image, err := client.CreateImage(context.TODO(), opts)
+	if err != nil {
+		return "", newProviderError("CreateImage", "disk", fmt.Sprintf("%d/%d", linodeId, diskId), err)
+	}
+
+	return image.ID, nil
+}
+
+// Take a manual snapshot using the backup service which replaces the previous manual snapshot
+func ProviderLinodeCreateSnapshot(client *linodego.Client, linodeId int, snapname string) (int, error) {
+
+	snapshot, err := client.CreateInstanceSnapshot(context.TODO(), linodeId, snapname)
+	if err != nil {
+		return 0, newProviderError("CreateInstanceSnapshot", "linode", fmt.Sprint(linodeId), err)
+	}
+
+	return snapshot.ID, nil
+}
+
+func ProviderLinodeDeleteImage(client *linodego.Client, imageId string) error {
+
+	err := client.DeleteImage(context.TODO(), imageId)
+	if err != nil {
+		return newProviderError("DeleteImage", "image", imageId, err)
+	}
+
+	return nil
+}
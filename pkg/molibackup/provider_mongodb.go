@@ -0,0 +1,47 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+)
+
+// Program and connection settings used to run mongodump
+type ProviderMongodb struct {
+	program    string
+	uri        string
+	configFile string
+}
+
+// Options which must be passed to mongodump to connect to the server
+func (m *ProviderMongodb) connectionArgs() []string {
+	var args []string
+	// Credentials are better stored in the configuration file as the command line is visible to other users
+	if m.configFile != "" {
+		args = append(args, fmt.Sprintf("--config=%s", m.configFile))
+	}
+	if m.uri != "" {
+		args = append(args, fmt.Sprintf("--uri=%s", m.uri))
+	}
+	return args
+}
+
+// Create a compressed archive of the databases or of the namespaces specified and stream it to the writer
+func ProviderMongodbDump(mongodb *ProviderMongodb, namespaces []string, oplog bool, output io.Writer) error {
+
+	// The archive is written to the standard output when no file name is specified
+	args := append(mongodb.connectionArgs(), "--archive", "--gzip")
+	for _, namespace := range namespaces {
+		args = append(args, fmt.Sprintf("--nsInclude=%s", namespace))
+	}
+	if oplog == true {
+		args = append(args, "--oplog")
+	}
+
+	return runCommandStream(mongodb.program, args, nil, output)
+}
@@ -0,0 +1,60 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Program and connection settings used to run sqlcmd against a SQL Server instance
+type ProviderMssql struct {
+	program  string
+	server   string
+	username string
+	env      map[string]string
+}
+
+// Options which must be passed to sqlcmd to connect to the server
+func (m *ProviderMssql) connectionArgs() []string {
+	// The password is read by sqlcmd from SQLCMDPASSWORD as the command line is visible to other users
+	args := []string{"-S", m.server, "-b"}
+	if m.username != "" {
+		args = append(args, "-U", m.username)
+	} else {
+		args = append(args, "-E")
+	}
+	return args
+}
+
+// Return the name of a database as a delimited identifier which can be used in a statement
+func mssqlQuoteName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// Return a string as a literal which can be used in a statement
+func mssqlQuoteString(value string) string {
+	return "N'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Back up a database to a file on the server, backups are either full or differential
+func ProviderMssqlBackupDatabase(mssql *ProviderMssql, database string, path string, differential bool, compression bool) error {
+
+	options := []string{"INIT", "CHECKSUM"}
+	if differential == true {
+		options = append(options, "DIFFERENTIAL")
+	}
+	if compression == true {
+		options = append(options, "COMPRESSION")
+	}
+	statement := fmt.Sprintf("BACKUP DATABASE %s TO DISK = %s WITH %s",
+		mssqlQuoteName(database), mssqlQuoteString(path), strings.Join(options, ", "))
+
+	args := append(mssql.connectionArgs(), "-Q", statement)
+	_, err := runCommand(mssql.program, args, mssql.env)
+	return err
+}
@@ -0,0 +1,142 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Programs and connection settings used to run the MySQL client tools
+type ProviderMysql struct {
+	mysqlPath       string
+	mysqldumpPath   string
+	mysqlbinlogPath string
+	defaultsFile    string
+	host            string
+}
+
+// Position of the binary log recorded in a dump created with --master-data=2
+var mysqlDumpBinlogRegexp = regexp.MustCompile(`(?:MASTER_LOG_FILE|SOURCE_LOG_FILE)='([^']+)'`)
+
+// Options which must be passed to all client tools to connect to the server
+func (m *ProviderMysql) connectionArgs() []string {
+	var args []string
+	// The defaults file must be the first option of the command line
+	if m.defaultsFile != "" {
+		args = append(args, fmt.Sprintf("--defaults-extra-file=%s", m.defaultsFile))
+	}
+	if m.host != "" {
+		args = append(args, fmt.Sprintf("--host=%s", m.host))
+	}
+	return args
+}
+
+// Return the sequence number of a binary log such as 123 for "binlog.000123"
+func mysqlBinlogSequence(name string) (int64, error) {
+	pos := strings.LastIndex(name, ".")
+	if pos < 0 {
+		return 0, fmt.Errorf("invalid name of binary log \"%s\"", name)
+	}
+	return strconv.ParseInt(name[pos+1:], 10, 64)
+}
+
+// Return the names of all binary logs of the server except the one which is currently written
+func ProviderMysqlListClosedBinlogs(mysql *ProviderMysql) ([]string, error) {
+
+	var results []string
+
+	args := append(mysql.connectionArgs(), "--batch", "--skip-column-names", "--execute=SHOW BINARY LOGS")
+	output, err := runCommand(mysql.mysqlPath, args, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			results = append(results, fields[0])
+		}
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("binary logging is not enabled on the server")
+	}
+
+	return results[:len(results)-1], nil
+}
+
+// Create a consistent dump of all databases and return a reader of the dump with the first binary log written
+// after the dump. The dump is written to a temporary directory which is removed when the reader is closed.
+func ProviderMysqlDumpAll(mysql *ProviderMysql) (io.ReadCloser, string, error) {
+
+	tmpdir, err := os.MkdirTemp("", "molibackup-mysqldump-")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	dumppath := filepath.Join(tmpdir, "dump.sql")
+
+	// Logs are flushed so the dump starts exactly at the beginning of a new binary log
+	args := append(mysql.connectionArgs(), "--all-databases", "--single-transaction", "--flush-logs",
+		"--master-data=2", "--routines", "--events", "--triggers", fmt.Sprintf("--result-file=%s", dumppath))
+	if _, err := runCommand(mysql.mysqldumpPath, args, nil); err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, "", err
+	}
+	reader, _, err := openCommandOutput(dumppath, tmpdir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read dump: %v", err)
+	}
+
+	// The position is written in a comment in the first lines of the dump
+	header := make([]byte, 65536)
+	count, err := io.ReadFull(reader, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		reader.Close()
+		return nil, "", fmt.Errorf("failed to read dump: %v", err)
+	}
+	match := mysqlDumpBinlogRegexp.FindSubmatch(header[:count])
+	if match == nil {
+		reader.Close()
+		return nil, "", fmt.Errorf("failed to find the position of the binary log in the dump")
+	}
+	if _, err := reader.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+		reader.Close()
+		return nil, "", fmt.Errorf("failed to read dump: %v", err)
+	}
+
+	return reader, string(match[1]), nil
+}
+
+// Read a binary log from the server in its original binary format and return a reader of the binary log
+// which is written to a temporary directory that is removed when the reader is closed
+func ProviderMysqlFetchBinlog(mysql *ProviderMysql, name string) (io.ReadCloser, error) {
+
+	tmpdir, err := os.MkdirTemp("", "molibackup-binlog-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+
+	// The raw format can only be written to files which are named after the binary logs
+	args := append(mysql.connectionArgs(), "--read-from-remote-server", "--raw",
+		fmt.Sprintf("--result-file=%s/", tmpdir), name)
+	if _, err := runCommand(mysql.mysqlbinlogPath, args, nil); err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, err
+	}
+
+	reader, _, err := openCommandOutput(filepath.Join(tmpdir, name), tmpdir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read binary log %s: %v", name, err)
+	}
+
+	return reader, nil
+}
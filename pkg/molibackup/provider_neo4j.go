@@ -0,0 +1,60 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Program and settings used to run neo4j-admin on a Neo4j server
+type ProviderNeo4j struct {
+	program string
+	env     map[string]string
+}
+
+// Dump a database which must be stopped with the community edition, or back it up online from a running
+// server with the enterprise edition, and return a reader of the file produced with its size. The file is
+// written to a temporary directory which is removed when the reader is closed.
+func ProviderNeo4jDumpDatabase(neo4j *ProviderNeo4j, database string, online bool, from string) (io.ReadCloser, int64, error) {
+
+	// Names of the files produced by neo4j-admin depend on the version so they are written to an empty directory
+	tmpdir, err := os.MkdirTemp("", "molibackup-neo4j-")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+
+	var args []string
+	if online == true {
+		args = []string{"database", "backup", fmt.Sprintf("--from=%s", from), fmt.Sprintf("--to-path=%s", tmpdir), database}
+	} else {
+		args = []string{"database", "dump", fmt.Sprintf("--to-path=%s", tmpdir), database}
+	}
+	if _, err := runCommand(neo4j.program, args, neo4j.env); err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, 0, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(tmpdir, "*"))
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return nil, 0, fmt.Errorf("failed to list files produced by neo4j-admin: %v", err)
+	}
+	if len(files) != 1 {
+		os.RemoveAll(tmpdir)
+		return nil, 0, fmt.Errorf("neo4j-admin has produced %d files instead of a single file for database \"%s\"", len(files), database)
+	}
+
+	reader, size, err := openCommandOutput(files[0], tmpdir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read file produced by neo4j-admin: %v", err)
+	}
+
+	return reader, size, nil
+}
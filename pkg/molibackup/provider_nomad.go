@@ -0,0 +1,106 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Body of a snapshot response which verifies the checksum sent by the servers once it has been read entirely
+type nomadSnapshotReader struct {
+	body   io.ReadCloser
+	hash   hash.Hash
+	digest string
+}
+
+func (r *nomadSnapshotReader) Read(data []byte) (int, error) {
+	count, err := r.body.Read(data)
+	r.hash.Write(data[:count])
+	if err == io.EOF && r.digest != "" && base64.StdEncoding.EncodeToString(r.hash.Sum(nil)) != r.digest {
+		return count, fmt.Errorf("snapshot does not match the digest sent by the server")
+	}
+	return count, err
+}
+
+func (r *nomadSnapshotReader) Close() error {
+	return r.body.Close()
+}
+
+type ProviderNomad struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func ProviderNomadNewClient(address string, token string, tlsInsecure bool) *ProviderNomad {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsInsecure}
+
+	// Snapshots of large clusters can take several minutes to be produced and transferred
+	return &ProviderNomad{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		client:  &http.Client{Transport: transport, Timeout: 30 * time.Minute},
+	}
+}
+
+// Save a snapshot of the raft state of the servers which is a gzipped archive that can be restored with
+// "nomad operator snapshot restore", and return its content to be read by the caller, who must close it, with the
+// index of the raft log at which it has been taken. Snapshots are taken by the leader unless stale snapshots are
+// allowed, in which case any server can produce it.
+func ProviderNomadSaveSnapshot(nomad *ProviderNomad, region string, stale bool) (io.ReadCloser, string, error) {
+
+	params := url.Values{}
+	if region != "" {
+		params.Set("region", region)
+	}
+	if stale == true {
+		params.Set("stale", "")
+	}
+	target := nomad.address + "/v1/operator/snapshot"
+	if len(params) > 0 {
+		target = target + "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to prepare snapshot request: %v", err)
+	}
+	// The token is sent in a header rather than in the query so it does not appear in the logs of proxies
+	if nomad.token != "" {
+		req.Header.Set("X-Nomad-Token", nomad.token)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+
+	resp, err := nomad.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("snapshot request has failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, "", fmt.Errorf("snapshot request has failed with status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	// Servers send the checksum of the snapshot so a truncated transfer is not stored as a valid snapshot
+	snapshot := &nomadSnapshotReader{body: resp.Body, hash: sha256.New()}
+	if digest := resp.Header.Get("Digest"); strings.HasPrefix(digest, "sha-256=") {
+		snapshot.digest = strings.TrimPrefix(digest, "sha-256=")
+	}
+
+	return snapshot, resp.Header.Get("X-Nomad-Index"), nil
+}
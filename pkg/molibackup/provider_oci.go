@@ -0,0 +1,194 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/common/auth"
+	"github.com/oracle/oci-go-sdk/v65/core"
+)
+
+type ProviderOciVolume struct {
+	volumeId      string
+	volumeName    string
+	compartmentId string
+}
+
+type ProviderOciVolumeBackup struct {
+	volumeId   string
+	backupId   string
+	backupName string
+	backupTime int64
+}
+
+// Check that all freeform tags specified in the conditions are present with the expected value
+func ociTagsMatch(tags map[string]string, conditions map[string]string) bool {
+	for key, val := range conditions {
+		curval, ok := tags[key]
+		if (ok == false) || (curval != val) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create a block storage client using either the instance principal or a profile of an OCI configuration file
+func ProviderOciNewBlockstorageClient(configFile string, profile string, instancePrincipal bool, region string) (core.BlockstorageClient, error) {
+
+	var provider common.ConfigurationProvider
+	var err error
+
+	if instancePrincipal == true {
+		provider, err = auth.InstancePrincipalConfigurationProvider()
+		if err != nil {
+			return core.BlockstorageClient{}, fmt.Errorf("failed to get the OCI instance principal: %v", err)
+		}
+	} else if configFile != "" {
+		provider, err = common.ConfigurationProviderFromFileWithProfile(configFile, profile, "")
+		if err != nil {
+			return core.BlockstorageClient{}, fmt.Errorf("failed to load the OCI configuration file %s: %v", configFile, err)
+		}
+	} else {
+		provider = common.CustomProfileConfigProvider("", profile)
+	}
+
+	client, err := core.NewBlockstorageClientWithConfigurationProvider(provider)
+	if err != nil {
+		return client, fmt.Errorf("failed to create OCI block storage client: %v", err)
+	}
+	client.UserAgent = fmt.Sprintf("molibackup/%s %s", strings.TrimSpace(progversion), client.UserAgent)
+	if region != "" {
+		client.SetRegion(region)
+	}
+
+	return client, nil
+}
+
+// Return all available block volumes of a compartment which have all the freeform tags specified
+func ProviderOciGetVolumes(client core.BlockstorageClient, compartmentId string, volumeTags map[string]string) ([]ProviderOciVolume, error) {
+
+	var results []ProviderOciVolume
+
+	request := core.ListVolumesRequest{
+		CompartmentId:  common.String(compartmentId),
+		LifecycleState: core.VolumeLifecycleStateAvailable,
+	}
+
+	for {
+		response, err := client.ListVolumes(context.TODO(), request)
+		if err != nil {
+			return nil, newProviderError("ListVolumes", "compartment", compartmentId, err)
+		}
+		for _, volume := range response.Items {
+			if volume.Id == nil || ociTagsMatch(volume.FreeformTags, volumeTags) == false {
+				continue
+			}
+			voldata := ProviderOciVolume{}
+			voldata.volumeId = *volume.Id
+			if volume.DisplayName != nil {
+				voldata.volumeName = *volume.DisplayName
+			}
+			voldata.compartmentId = compartmentId
+			results = append(results, voldata)
+		}
+		if response.OpcNextPage == nil {
+			break
+		}
+		request.Page = response.OpcNextPage
+	}
+
+	return results, nil
+}
+
+// Get basic information about volume backups created by this program for a particular volume
+func ProviderOciGetVolumeBackups(client core.BlockstorageClient, compartmentId string, volumeId string) ([]ProviderOciVolumeBackup, error) {
+
+	var results []ProviderOciVolumeBackup
+
+	request := core.ListVolumeBackupsRequest{
+		CompartmentId: common.String(compartmentId),
+		VolumeId:      common.String(volumeId),
+	}
+
+	for {
+		response, err := client.ListVolumeBackups(context.TODO(), request)
+		if err != nil {
+			return nil, newProviderError("ListVolumeBackups", "volume", volumeId, err)
+		}
+		for _, backup := range response.Items {
+			// Backups which are being deleted are still listed for some time
+			if backup.LifecycleState == core.VolumeBackupLifecycleStateTerminating || backup.LifecycleState == core.VolumeBackupLifecycleStateTerminated {
+				continue
+			}
+			if backup.FreeformTags["created_by"] != "molibackup" || backup.Id == nil || backup.TimeCreated == nil {
+				continue
+			}
+			bkpdata := ProviderOciVolumeBackup{}
+			bkpdata.volumeId = volumeId
+			bkpdata.backupId = *backup.Id
+			if backup.DisplayName != nil {
+				bkpdata.backupName = *backup.DisplayName
+			}
+			bkpdata.backupTime = backup.TimeCreated.Unix()
+			if timestamp, err := strconv.ParseInt(backup.FreeformTags["timestamp"], 10, 64); err == nil && timestamp > 0 {
+				bkpdata.backupTime = timestamp
+			}
+			results = append(results, bkpdata)
+		}
+		if response.OpcNextPage == nil {
+			break
+		}
+		request.Page = response.OpcNextPage
+	}
+
+	return results, nil
+}
+
+func ProviderOciCreateVolumeBackup(client core.BlockstorageClient, volumeId string, backupType string, bkpname string, bkpdate string, bkptime string) (string, error) {
+
+	request := core.CreateVolumeBackupRequest{
+		CreateVolumeBackupDetails: core.CreateVolumeBackupDetails{
+			VolumeId:    common.String(volumeId),
+			DisplayName: common.String(bkpname),
+			Type:        core.CreateVolumeBackupDetailsTypeEnum(strings.ToUpper(backupType)),
+			FreeformTags: map[string]string{
+				"created_by":  "molibackup",
+				"create_date": bkpdate,
+				"timestamp":   bkptime,
+				"job_id":      jobExecutionId,
+			},
+		},
+	}
+
+	response, err := client.CreateVolumeBackup(context.TODO(), request)
+	if err != nil {
+		return "", newProviderError("CreateVolumeBackup", "volume", volumeId, err)
+	}
+	if response.Id == nil {
+		return "", fmt.Errorf("invalid response from the OCI API: new volume backup has no id attribute")
+	}
+
+	return *response.Id, nil
+}
+
+func ProviderOciDeleteVolumeBackup(client core.BlockstorageClient, backupId string) error {
+
+	request := core.DeleteVolumeBackupRequest{
+		VolumeBackupId: common.String(backupId),
+	}
+	_, err := client.DeleteVolumeBackup(context.TODO(), request)
+	if err != nil {
+		return newProviderError("DeleteVolumeBackup", "backup", backupId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+)
+
+type ProviderOpenstackServer struct {
+	serverId   string
+	serverName string
+	volumeIds  []string
+}
+
+type ProviderOpenstackSnapshot struct {
+	volumeId     string
+	snapshotId   string
+	snapshotName string
+	snapshotTime int64
+}
+
+// Check that all metadata specified in the conditions are present with the expected value
+func openstackMetadataMatch(metadata map[string]string, conditions map[string]string) bool {
+	for key, val := range conditions {
+		curval, ok := metadata[key]
+		if (ok == false) || (curval != val) {
+			return false
+		}
+	}
+	return true
+}
+
+// Return a client for the compute service and a client for the block storage service using OS_* variables
+func ProviderOpenstackNewClients(region string) (*gophercloud.ServiceClient, *gophercloud.ServiceClient, error) {
+
+	authopts, err := openstack.AuthOptionsFromEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get OpenStack credentials from the environment: %v", err)
+	}
+	// Tokens can expire during long jobs so the client must be allowed to authenticate again
+	authopts.AllowReauth = true
+
+	provider, err := openstack.AuthenticatedClient(authopts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to authenticate with OpenStack: %v", err)
+	}
+	provider.UserAgent.Prepend(fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+
+	endpoint := gophercloud.EndpointOpts{Region: region}
+	compute, err := openstack.NewComputeV2(provider, endpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OpenStack compute client: %v", err)
+	}
+	// Filtering servers on tags requires the compute API microversion 2.26
+	compute.Microversion = "2.26"
+	blockstorage, err := openstack.NewBlockStorageV3(provider, endpoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OpenStack block storage client: %v", err)
+	}
+
+	return compute, blockstorage, nil
+}
+
+// Return all servers which have the name, all the metadata and all the tags specified
+func ProviderOpenstackGetServers(client *gophercloud.ServiceClient, serverName string, serverMetadata map[string]string, serverTags []string) ([]ProviderOpenstackServer, error) {
+
+	var results []ProviderOpenstackServer
+
+	// The compute API interprets the name as a regular expression
+	opts := servers.ListOpts{
+		Tags: strings.Join(serverTags, ","),
+	}
+	if serverName != "" {
+		opts.Name = fmt.Sprintf("^%s$", regexp.QuoteMeta(serverName))
+	}
+
+	pages, err := servers.List(client, opts).AllPages()
+	if err != nil {
+		return nil, newProviderError("servers.List", "", "", err)
+	}
+	allservers, err := servers.ExtractServers(pages)
+	if err != nil {
+		return nil, newProviderError("servers.ExtractServers", "", "", err)
+	}
+
+	for _, server := range allservers {
+		// The compute API does not support filtering servers on metadata
+		if openstackMetadataMatch(server.Metadata, serverMetadata) == false {
+			continue
+		}
+		srvdata := ProviderOpenstackServer{serverId: server.ID, serverName: server.Name}
+		for _, volume := range server.AttachedVolumes {
+			srvdata.volumeIds = append(srvdata.volumeIds, volume.ID)
+		}
+		results = append(results, srvdata)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].serverName < results[j].serverName
+	})
+
+	return results, nil
+}
+
+// Get basic information about snapshots created by this program for a particular volume
+func ProviderOpenstackGetSnapshots(client *gophercloud.ServiceClient, volumeId string) ([]ProviderOpenstackSnapshot, error) {
+
+	var results []ProviderOpenstackSnapshot
+
+	pages, err := snapshots.List(client, snapshots.ListOpts{VolumeID: volumeId}).AllPages()
+	if err != nil {
+		return nil, newProviderError("snapshots.List", "volume", volumeId, err)
+	}
+	allsnapshots, err := snapshots.ExtractSnapshots(pages)
+	if err != nil {
+		return nil, newProviderError("snapshots.ExtractSnapshots", "volume", volumeId, err)
+	}
+
+	for _, snapshot := range allsnapshots {
+		if snapshot.Metadata["created_by"] != "molibackup" {
+			continue
+		}
+		snapdata := ProviderOpenstackSnapshot{}
+		snapdata.volumeId = snapshot.VolumeID
+		snapdata.snapshotId = snapshot.ID
+		snapdata.snapshotName = snapshot.Name
+		snapdata.snapshotTime = snapshot.CreatedAt.Unix()
+		if timestamp, err := strconv.ParseInt(snapshot.Metadata["timestamp"], 10, 64); err == nil && timestamp > 0 {
+			snapdata.snapshotTime = timestamp
+		}
+		results = append(results, snapdata)
+	}
+
+	return results, nil
+}
+
+func ProviderOpenstackCreateSnapshot(client *gophercloud.ServiceClient, volumeId string, snapname string, snapdate string, snaptime string) (string, error) {
+
+	// Volumes attached to servers can only be snapshotted when the request is forced
+	opts := snapshots.CreateOpts{
+		VolumeID:    volumeId,
+		Force:       true,
+		Name:        snapname,
+		Description: snapname,
+		Metadata: map[string]string{
+			"created_by":  "molibackup",
+			"create_date": snapdate,
+			"timestamp":   snaptime,
+			"job_id":      jobExecutionId,
+		},
+	}
+
+	snapshot, err := snapshots.Create(client, opts).Extract()
+	if err != nil {
+		return "", newProviderError("snapshots.Create", "volume", volumeId, err)
+	}
+
+	return snapshot.ID, nil
+}
+
+func ProviderOpenstackDeleteSnapshot(client *gophercloud.ServiceClient, snapshotId string) error {
+
+	err := snapshots.Delete(client, snapshotId).ExtractErr()
+	if err != nil {
+		return newProviderError("snapshots.Delete", "snapshot", snapshotId, err)
+	}
+
+	return nil
+}
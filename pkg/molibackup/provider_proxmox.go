@@ -0,0 +1,275 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gookit/slog"
+)
+
+// Prefix of the notes of all vzdump archives created by this program
+const proxmoxNotesPrefix = "molibackup"
+
+// Interval between two checks of the state of a vzdump task
+const proxmoxTaskPollInterval = 10 * time.Second
+
+type ProviderProxmox struct {
+	apiUrl   string
+	apiToken string
+	client   *http.Client
+}
+
+type ProviderProxmoxGuest struct {
+	vmid      int
+	guestName string
+	guestType string
+	node      string
+}
+
+type ProviderProxmoxArchive struct {
+	vmid        int
+	node        string
+	volid       string
+	archiveTime int64
+	protected   bool
+}
+
+// Attributes stored in the notes of an archive such as "molibackup timestamp=1700000000 job=abc-01"
+func proxmoxNotesAttributes(notes string) (map[string]string, bool) {
+	fields := strings.Fields(notes)
+	if len(fields) == 0 || fields[0] != proxmoxNotesPrefix {
+		return nil, false
+	}
+	results := make(map[string]string)
+	for _, field := range fields[1:] {
+		key, val, found := strings.Cut(field, "=")
+		if found == true {
+			results[key] = val
+		}
+	}
+	return results, true
+}
+
+func ProviderProxmoxNewClient(apiUrl string, apiToken string, tlsInsecure bool) *ProviderProxmox {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Proxmox VE uses a self-signed certificate unless a certificate has been installed
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsInsecure}
+
+	return &ProviderProxmox{
+		apiUrl:   strings.TrimSuffix(apiUrl, "/") + "/api2/json",
+		apiToken: apiToken,
+		client:   &http.Client{Transport: transport, Timeout: 60 * time.Second},
+	}
+}
+
+// Send a request to the Proxmox API and decode the data attribute of the response
+func (p *ProviderProxmox) request(method string, path string, params url.Values, result any) error {
+
+	var body io.Reader
+	target := p.apiUrl + path
+	if method == http.MethodGet || method == http.MethodDelete {
+		if len(params) > 0 {
+			target = target + "?" + params.Encode()
+		}
+	} else {
+		body = strings.NewReader(params.Encode())
+	}
+
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return fmt.Errorf("failed to prepare request %s %s: %v", method, path, err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s", p.apiToken))
+	req.Header.Set("User-Agent", fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request %s %s has failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response of request %s %s: %v", method, path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request %s %s has failed with status %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("invalid response from the Proxmox API for request %s %s: %v", method, path, err)
+	}
+	if result != nil {
+		if err := json.Unmarshal(envelope.Data, result); err != nil {
+			return fmt.Errorf("invalid data from the Proxmox API for request %s %s: %v", method, path, err)
+		}
+	}
+
+	return nil
+}
+
+// Return all virtual machines and containers which have one of the IDs or all the tags specified
+func ProviderProxmoxGetGuests(p *ProviderProxmox, vmids []int, guestTags []string) ([]ProviderProxmoxGuest, error) {
+
+	var results []ProviderProxmoxGuest
+	var resources []struct {
+		Vmid     int    `json:"vmid"`
+		Name     string `json:"name"`
+		Node     string `json:"node"`
+		Type     string `json:"type"`
+		Tags     string `json:"tags"`
+		Template int    `json:"template"`
+	}
+
+	if err := p.request(http.MethodGet, "/cluster/resources", url.Values{"type": {"vm"}}, &resources); err != nil {
+		return nil, err
+	}
+
+	for _, resource := range resources {
+		// Templates cannot be modified so there is no need to back them up repeatedly
+		if resource.Template == 1 {
+			continue
+		}
+		if len(vmids) > 0 && slices.Contains(vmids, resource.Vmid) == false {
+			continue
+		}
+		// Tags are returned as a list separated with semicolons
+		tags := strings.FieldsFunc(resource.Tags, func(r rune) bool { return r == ';' || r == ',' || r == ' ' })
+		matched := true
+		for _, tag := range guestTags {
+			if slices.Contains(tags, tag) == false {
+				matched = false
+			}
+		}
+		if matched == false {
+			continue
+		}
+		results = append(results, ProviderProxmoxGuest{
+			vmid:      resource.Vmid,
+			guestName: resource.Name,
+			guestType: resource.Type,
+			node:      resource.Node,
+		})
+	}
+
+	return results, nil
+}
+
+// Run vzdump for a guest on its node and wait until the task has completed successfully
+func ProviderProxmoxRunVzdump(p *ProviderProxmox, guest ProviderProxmoxGuest, storage string, mode string, compress string, snaptime string, timeout time.Duration) error {
+
+	params := url.Values{
+		"vmid":           {strconv.Itoa(guest.vmid)},
+		"storage":        {storage},
+		"mode":           {mode},
+		"compress":       {compress},
+		"notes-template": {fmt.Sprintf("%s timestamp=%s job=%s", proxmoxNotesPrefix, snaptime, jobExecutionId)},
+	}
+
+	var upid string
+	path := fmt.Sprintf("/nodes/%s/vzdump", url.PathEscape(guest.node))
+	if err := p.request(http.MethodPost, path, params, &upid); err != nil {
+		return err
+	}
+
+	return ProviderProxmoxWaitTask(p, guest.node, upid, timeout)
+}
+
+// Wait until a task has stopped and return an error if it has not completed successfully
+func ProviderProxmoxWaitTask(p *ProviderProxmox, node string, upid string, timeout time.Duration) error {
+
+	deadline := time.Now().Add(timeout)
+	path := fmt.Sprintf("/nodes/%s/tasks/%s/status", url.PathEscape(node), url.PathEscape(upid))
+
+	for {
+		var status struct {
+			Status     string `json:"status"`
+			ExitStatus string `json:"exitstatus"`
+		}
+		if err := p.request(http.MethodGet, path, nil, &status); err != nil {
+			return err
+		}
+		if status.Status == "stopped" {
+			if status.ExitStatus != "OK" {
+				return fmt.Errorf("task %s has failed: %s", upid, status.ExitStatus)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("task %s has not completed after %v", upid, timeout)
+		}
+		slog.Debugf("Waiting for task %s to complete ...", upid)
+		time.Sleep(proxmoxTaskPollInterval)
+	}
+}
+
+// Get basic information about vzdump archives created by this program for a guest in a storage
+func ProviderProxmoxGetArchives(p *ProviderProxmox, guest ProviderProxmoxGuest, storage string) ([]ProviderProxmoxArchive, error) {
+
+	var results []ProviderProxmoxArchive
+	var contents []struct {
+		Volid     string `json:"volid"`
+		Vmid      int    `json:"vmid"`
+		Ctime     int64  `json:"ctime"`
+		Notes     string `json:"notes"`
+		Protected int    `json:"protected"`
+	}
+
+	params := url.Values{"content": {"backup"}, "vmid": {strconv.Itoa(guest.vmid)}}
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content", url.PathEscape(guest.node), url.PathEscape(storage))
+	if err := p.request(http.MethodGet, path, params, &contents); err != nil {
+		return nil, err
+	}
+
+	for _, content := range contents {
+		attributes, ok := proxmoxNotesAttributes(content.Notes)
+		if ok == false {
+			continue
+		}
+		archive := ProviderProxmoxArchive{
+			vmid:        content.Vmid,
+			node:        guest.node,
+			volid:       content.Volid,
+			archiveTime: content.Ctime,
+			protected:   content.Protected == 1,
+		}
+		if timestamp, err := strconv.ParseInt(attributes["timestamp"], 10, 64); err == nil && timestamp > 0 {
+			archive.archiveTime = timestamp
+		}
+		results = append(results, archive)
+	}
+
+	return results, nil
+}
+
+func ProviderProxmoxDeleteArchive(p *ProviderProxmox, node string, storage string, volid string) error {
+
+	path := fmt.Sprintf("/nodes/%s/storage/%s/content/%s", url.PathEscape(node), url.PathEscape(storage), url.PathEscape(volid))
+	if err := p.request(http.MethodDelete, path, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete archive %s: %w", volid, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Program and settings used to run rclone commands against a remote such as "remote:path"
+type ProviderRclone struct {
+	program    string
+	configFile string
+	bwlimit    string
+	env        map[string]string
+}
+
+// Subset of the attributes returned by "rclone lsjson"
+type rcloneListEntry struct {
+	Name  string `json:"Name"`
+	IsDir bool   `json:"IsDir"`
+}
+
+func (r *ProviderRclone) run(args ...string) ([]byte, error) {
+	if r.configFile != "" {
+		args = append([]string{"--config", r.configFile}, args...)
+	}
+	return runCommand(r.program, args, r.env)
+}
+
+// Return the path of an entry in a remote which is either "remote:" or "remote:path"
+func rcloneJoinPath(remote string, name string) string {
+	if strings.HasSuffix(remote, ":") || strings.HasSuffix(remote, "/") {
+		return remote + name
+	}
+	return remote + "/" + name
+}
+
+// Return the names of all directories at the top level of a remote path
+func ProviderRcloneListDirectories(rclone *ProviderRclone, remote string) ([]string, error) {
+
+	var results []string
+	var entries []rcloneListEntry
+
+	output, err := rclone.run("lsjson", "--dirs-only", remote)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode the list of directories returned by rclone: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir == true {
+			results = append(results, entry.Name)
+		}
+	}
+
+	return results, nil
+}
+
+// Copy a local file or directory into a directory of a remote
+func ProviderRcloneCopy(rclone *ProviderRclone, source string, remotedir string) error {
+
+	args := []string{"copy"}
+	if rclone.bwlimit != "" {
+		args = append(args, "--bwlimit", rclone.bwlimit)
+	}
+	args = append(args, source, remotedir)
+
+	_, err := rclone.run(args...)
+	return err
+}
+
+// Delete a directory of a remote with all its contents
+func ProviderRclonePurge(rclone *ProviderRclone, remotedir string) error {
+
+	_, err := rclone.run("purge", remotedir)
+	return err
+}
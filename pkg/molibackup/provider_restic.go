@@ -0,0 +1,112 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Program and settings used to run restic commands against a repository
+type ProviderRestic struct {
+	program  string
+	hostname string
+	env      map[string]string
+}
+
+type ProviderResticSnapshot struct {
+	snapshotId   string
+	shortId      string
+	snapshotTime int64
+	paths        []string
+}
+
+// Subset of the attributes returned by "restic snapshots --json"
+type resticSnapshotDetail struct {
+	Id      string    `json:"id"`
+	ShortId string    `json:"short_id"`
+	Time    time.Time `json:"time"`
+	Paths   []string  `json:"paths"`
+}
+
+// Subset of the attributes of the last message returned by "restic backup --json"
+type resticBackupSummary struct {
+	MessageType string `json:"message_type"`
+	SnapshotId  string `json:"snapshot_id"`
+	FilesNew    int64  `json:"files_new"`
+	DataAdded   int64  `json:"data_added"`
+}
+
+func (r *ProviderRestic) run(args ...string) ([]byte, error) {
+	return runCommand(r.program, args, r.env)
+}
+
+// Return the snapshots of the local host which have a particular tag
+func ProviderResticGetSnapshots(restic *ProviderRestic, tag string) ([]ProviderResticSnapshot, error) {
+
+	var results []ProviderResticSnapshot
+	var details []resticSnapshotDetail
+
+	output, err := restic.run("snapshots", "--json", "--host", restic.hostname, "--tag", tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(output, &details); err != nil {
+		return nil, fmt.Errorf("failed to decode the list of snapshots returned by restic: %v", err)
+	}
+
+	for _, detail := range details {
+		snapdata := ProviderResticSnapshot{}
+		snapdata.snapshotId = detail.Id
+		snapdata.shortId = detail.ShortId
+		snapdata.snapshotTime = detail.Time.Unix()
+		snapdata.paths = detail.Paths
+		results = append(results, snapdata)
+	}
+
+	return results, nil
+}
+
+// Create a snapshot of directories with a tag and return the ID of the new snapshot
+func ProviderResticBackup(restic *ProviderRestic, directories []string, exclude []string, tag string) (string, error) {
+
+	args := []string{"backup", "--json", "--host", restic.hostname, "--tag", tag}
+	for _, pattern := range exclude {
+		args = append(args, "--exclude", pattern)
+	}
+	args = append(args, directories...)
+
+	output, err := restic.run(args...)
+	if err != nil {
+		return "", err
+	}
+
+	// Progress messages are followed by a summary which is the only message with the ID of the snapshot
+	for _, line := range bytes.Split(bytes.TrimSpace(output), []byte("\n")) {
+		summary := resticBackupSummary{}
+		if json.Unmarshal(line, &summary) == nil && summary.MessageType == "summary" {
+			return summary.SnapshotId, nil
+		}
+	}
+
+	return "", fmt.Errorf("restic has not returned a summary with the ID of the new snapshot")
+}
+
+// Remove snapshots from the repository and optionally delete the data which is no longer referenced
+func ProviderResticForget(restic *ProviderRestic, snapshotIds []string, prune bool) error {
+
+	args := []string{"forget"}
+	if prune == true {
+		args = append(args, "--prune")
+	}
+	args = append(args, snapshotIds...)
+
+	_, err := restic.run(args...)
+	return err
+}
@@ -0,0 +1,145 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/gookit/slog"
+)
+
+// Exit status of rsync when some files have vanished while they were being transferred
+const rsyncExitVanishedFiles = 24
+
+// Programs and target used to mirror directories either locally or on a remote host through SSH
+type ProviderRsync struct {
+	rsyncPath string
+	sshPath   string
+	host      string // Empty when the target is a local directory
+	directory string
+}
+
+// Parse a target which is either an absolute local path or a remote path such as "user@host:/path"
+func ProviderRsyncNewTarget(rsyncPath string, sshPath string, target string) (*ProviderRsync, error) {
+	host, directory, remote := strings.Cut(target, ":")
+	if remote == false {
+		host, directory = "", target
+	}
+	if path.IsAbs(directory) == false {
+		return nil, fmt.Errorf("target \"%s\" must be an absolute path or a remote path such as \"user@host:/path\"", target)
+	}
+	return &ProviderRsync{rsyncPath: rsyncPath, sshPath: sshPath, host: host, directory: path.Clean(directory)}, nil
+}
+
+// Quote an argument so it is passed unchanged to the shell of the remote host
+func rsyncShellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", "'\\''") + "'"
+}
+
+// Run a command either locally or on the remote host of the target
+func (r *ProviderRsync) runTargetCommand(program string, args ...string) ([]byte, error) {
+	if r.host == "" {
+		return runCommand(program, args, nil)
+	}
+	command := program
+	for _, arg := range args {
+		command += " " + rsyncShellQuote(arg)
+	}
+	return runCommand(r.sshPath, []string{r.host, command}, nil)
+}
+
+func (r *ProviderRsync) String() string {
+	if r.host == "" {
+		return r.directory
+	}
+	return fmt.Sprintf("%s:%s", r.host, r.directory)
+}
+
+// Return the names of all directories in the target directory
+func ProviderRsyncListDirectories(r *ProviderRsync) ([]string, error) {
+
+	var results []string
+
+	if r.host == "" {
+		entries, err := os.ReadDir(r.directory)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list directory %s: %v", r.directory, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				results = append(results, entry.Name())
+			}
+		}
+		return results, nil
+	}
+
+	output, err := r.runTargetCommand("find", r.directory, "-mindepth", "1", "-maxdepth", "1", "-type", "d", "-printf", "%f\\n")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			results = append(results, line)
+		}
+	}
+
+	return results, nil
+}
+
+// Mirror directories into a new directory of the target where unchanged files are hard links to the previous copy
+func ProviderRsyncMirror(r *ProviderRsync, directories []string, excludes []string, name string, linkdest string) error {
+
+	if _, err := r.runTargetCommand("mkdir", "-p", r.directory); err != nil {
+		return err
+	}
+
+	// Data is copied to a temporary directory so an interrupted copy is never used as a reference
+	tmpname := name + ".tmp"
+	tmppath := path.Join(r.directory, tmpname)
+	args := []string{"--archive", "--relative", "--delete", "--numeric-ids", "--hard-links"}
+	if r.host != "" {
+		args = append(args, "--rsh", r.sshPath)
+	}
+	if linkdest != "" {
+		args = append(args, "--link-dest", path.Join(r.directory, linkdest))
+	}
+	for _, exclude := range excludes {
+		args = append(args, "--exclude", exclude)
+	}
+	args = append(args, directories...)
+	if r.host != "" {
+		args = append(args, fmt.Sprintf("%s:%s/", r.host, tmppath))
+	} else {
+		args = append(args, tmppath+"/")
+	}
+
+	_, err := runCommand(r.rsyncPath, args, nil)
+	var exiterr *exec.ExitError
+	if errors.As(err, &exiterr) && exiterr.ExitCode() == rsyncExitVanishedFiles {
+		slog.Warnf("Some files have vanished while they were being copied: %v", err)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = r.runTargetCommand("mv", "--", tmppath, path.Join(r.directory, name))
+	return err
+}
+
+// Delete a directory of the target with all its content
+func ProviderRsyncDeleteDirectory(r *ProviderRsync, name string) error {
+
+	_, err := r.runTargetCommand("rm", "-rf", "--", path.Join(r.directory, name))
+	return err
+}
@@ -0,0 +1,138 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ProviderVault struct {
+	address   string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+func ProviderVaultNewClient(address string, namespace string, tlsInsecure bool) *ProviderVault {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsInsecure}
+
+	// Snapshots of large clusters can take several minutes to be produced and transferred
+	return &ProviderVault{
+		address:   strings.TrimSuffix(address, "/"),
+		namespace: namespace,
+		client:    &http.Client{Transport: transport, Timeout: 30 * time.Minute},
+	}
+}
+
+// Send a request to the Vault API and return the body of the response
+func (v *ProviderVault) request(method string, path string, payload any) ([]byte, error) {
+
+	body, err := v.open(method, path, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response of request %s %s: %v", method, path, err)
+	}
+
+	return data, nil
+}
+
+// Send a request to the Vault API and return the body of the response to be read by the caller, who must close it
+func (v *ProviderVault) open(method string, path string, payload any) (io.ReadCloser, error) {
+
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request %s %s: %v", method, path, err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, v.address+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request %s %s: %v", method, path, err)
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request %s %s has failed: %v", method, path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("request %s %s has failed with status %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return resp.Body, nil
+}
+
+// Log in with an AppRole and use the token returned for the following requests
+func ProviderVaultLoginAppRole(vault *ProviderVault, mount string, roleId string, secretId string) error {
+
+	payload := map[string]string{"role_id": roleId, "secret_id": secretId}
+	data, err := vault.request(http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", mount), payload)
+	if err != nil {
+		return fmt.Errorf("failed to log in with AppRole: %w", err)
+	}
+
+	result := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to decode response of AppRole login: %v", err)
+	}
+	if result.Auth.ClientToken == "" {
+		return fmt.Errorf("response of AppRole login does not contain a token")
+	}
+	vault.token = result.Auth.ClientToken
+
+	return nil
+}
+
+// Use a token which has been provided directly
+func ProviderVaultSetToken(vault *ProviderVault, token string) {
+	vault.token = token
+}
+
+// Save a snapshot of the integrated Raft storage, which is taken by the active node and can be
+// restored with "vault operator raft snapshot restore" on a cluster which has the same unseal keys. The
+// snapshot is returned to be read by the caller, who must close it.
+func ProviderVaultSaveRaftSnapshot(vault *ProviderVault) (io.ReadCloser, error) {
+
+	snapshot, err := vault.open(http.MethodGet, "/v1/sys/storage/raft/snapshot", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save raft snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,109 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Program and settings used to run wal-g commands
+type ProviderWalg struct {
+	program    string
+	configFile string
+	env        map[string]string
+}
+
+type ProviderWalgBackup struct {
+	backupName  string
+	backupTime  int64
+	isFull      bool
+	isPermanent bool
+}
+
+// Subset of the attributes returned by "wal-g backup-list --json --detail"
+type walgBackupDetail struct {
+	BackupName  string    `json:"backup_name"`
+	StartTime   time.Time `json:"start_time"`
+	IsPermanent bool      `json:"is_permanent"`
+}
+
+func (w *ProviderWalg) run(args ...string) ([]byte, error) {
+	if w.configFile != "" {
+		args = append([]string{"--config", w.configFile}, args...)
+	}
+	return runCommand(w.program, args, w.env)
+}
+
+// Return all base backups stored in the storage configured for wal-g
+func ProviderWalgListBackups(walg *ProviderWalg) ([]ProviderWalgBackup, error) {
+
+	var results []ProviderWalgBackup
+	var details []walgBackupDetail
+
+	output, err := walg.run("backup-list", "--json", "--detail")
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty storage is reported with a message instead of an empty list
+	if strings.HasPrefix(strings.TrimSpace(string(output)), "[") == false {
+		return nil, nil
+	}
+	if err := json.Unmarshal(output, &details); err != nil {
+		return nil, fmt.Errorf("failed to decode the list of backups returned by wal-g: %v", err)
+	}
+
+	for _, detail := range details {
+		bkpdata := ProviderWalgBackup{}
+		bkpdata.backupName = detail.BackupName
+		bkpdata.backupTime = detail.StartTime.Unix()
+		// Names of delta backups include the name of the backup they are based on
+		bkpdata.isFull = strings.Contains(detail.BackupName, "_D_") == false
+		bkpdata.isPermanent = detail.IsPermanent
+		results = append(results, bkpdata)
+	}
+
+	return results, nil
+}
+
+func ProviderWalgBackupPush(walg *ProviderWalg, pgdata string) error {
+
+	_, err := walg.run("backup-push", pgdata)
+	return err
+}
+
+// Delete base backups older than the most recent full backups and the WAL segments they require
+func ProviderWalgDeleteRetainFull(walg *ProviderWalg, count int) error {
+
+	_, err := walg.run("delete", "retain", "FULL", fmt.Sprintf("%d", count), "--confirm")
+	return err
+}
+
+// Check that the WAL segments required to restore the most recent backup have been archived
+func ProviderWalgVerifyWal(walg *ProviderWalg) error {
+
+	var report map[string]struct {
+		Status string `json:"status"`
+	}
+
+	output, err := walg.run("wal-verify", "integrity", "--json")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return fmt.Errorf("failed to decode the report returned by wal-g: %v", err)
+	}
+
+	if status := report["integrity"].Status; status != "OK" {
+		return fmt.Errorf("the integrity of the WAL archive is not verified: status=\"%s\"", status)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+type ProviderZookeeper struct {
+	members  []string
+	user     string
+	password string
+	client   *http.Client
+}
+
+func ProviderZookeeperNewClient(members []string, user string, password string, tlsInsecure bool) *ProviderZookeeper {
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: tlsInsecure}
+
+	var addresses []string
+	for _, member := range members {
+		addresses = append(addresses, strings.TrimSuffix(member, "/"))
+	}
+
+	// Snapshots of large ensembles can take several minutes to be produced and transferred
+	return &ProviderZookeeper{
+		members:  addresses,
+		user:     user,
+		password: password,
+		client:   &http.Client{Transport: transport, Timeout: 30 * time.Minute},
+	}
+}
+
+// Stream a snapshot of the data tree from the admin server of a member of the ensemble, and return a reader
+// of the snapshot with the address of the member and the last zxid included in the snapshot. All members hold
+// the same data so members are tried in the order they have been configured until one of them accepts to
+// produce a snapshot. The reader must be closed by the caller.
+func ProviderZookeeperStreamSnapshot(zookeeper *ProviderZookeeper) (io.ReadCloser, string, string, error) {
+
+	var errmsgs []string
+
+	for _, member := range zookeeper.members {
+		reader, zxid, err := zookeeper.streamSnapshot(member)
+		if err == nil {
+			return reader, member, zxid, nil
+		}
+		slog.Warnf("Failed to stream snapshot from member \"%s\": %v", member, err)
+		errmsgs = append(errmsgs, fmt.Sprintf("%s: %v", member, err))
+	}
+
+	return nil, "", "", fmt.Errorf("failed to stream snapshot from any member of the ensemble: %s", strings.Join(errmsgs, "; "))
+}
+
+func (z *ProviderZookeeper) streamSnapshot(member string) (io.ReadCloser, string, error) {
+
+	req, err := http.NewRequest(http.MethodGet, member+"/commands/snapshot?streaming=true", nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to prepare snapshot request: %v", err)
+	}
+	// The snapshot command requires an authenticated user on recent versions which use the digest scheme
+	if z.user != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("digest %s:%s", z.user, z.password))
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("molibackup/%s", strings.TrimSpace(progversion)))
+
+	resp, err := z.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("snapshot request has failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, "", fmt.Errorf("snapshot request has failed with status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return &providerZookeeperSnapshotReader{body: resp.Body}, resp.Header.Get("last_zxid"), nil
+}
+
+// Reader of a snapshot which fails when the admin server has returned an empty snapshot
+type providerZookeeperSnapshotReader struct {
+	body io.ReadCloser
+	size int64
+}
+
+func (r *providerZookeeperSnapshotReader) Read(data []byte) (int, error) {
+	count, err := r.body.Read(data)
+	r.size += int64(count)
+	if err == io.EOF && r.size == 0 {
+		return count, fmt.Errorf("snapshot returned by the admin server is empty")
+	}
+	if err != nil && err != io.EOF {
+		return count, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	return count, err
+}
+
+func (r *providerZookeeperSnapshotReader) Close() error {
+	return r.body.Close()
+}
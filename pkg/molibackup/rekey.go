@@ -0,0 +1,147 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gookit/slog"
+)
+
+// Encrypt again the archives managed by a job with the key currently configured in the job, after the
+// previous keys have been replaced because they are compromised. Archives are decrypted with one of the
+// previous keys, so these keys must be kept until all archives have been encrypted with the new key.
+func rekeyJob(jobname string, oldkeyfiles []string) error {
+
+	var oldkeys [][]byte
+	for _, keyfile := range oldkeyfiles {
+		key, err := archiveReadKeyFile(keyfile)
+		if err != nil {
+			return fmt.Errorf("failed to load the previous encryption key: %w", err)
+		}
+		oldkeys = append(oldkeys, key)
+	}
+
+	jobconf, ok := jobmetadefs[jobname]
+	if ok == false {
+		return fmt.Errorf("job \"%s\" is not defined in the configuration", jobname)
+	}
+	moddef, ok := findModuleDefinition(jobconf.Module)
+	if ok == false {
+		return fmt.Errorf("invalid type of backup module: \"%s\"", jobconf.Module)
+	}
+	module := moddef.create()
+	rekeyer, ok := module.(BackupRekeyer)
+	if ok == false {
+		return fmt.Errorf("module \"%s\" of job \"%s\" does not write encrypted archives", jobconf.Module, jobname)
+	}
+
+	jobExecutionId = fmt.Sprintf("%s-01", runId)
+	defer func() { jobExecutionId = "" }()
+
+	if err := module.LoadConfiguration(jobname); err != nil {
+		return fmt.Errorf("failed to load the configuration of job \"%s\": %w", jobname, err)
+	}
+	if err := module.InitialiseModule(); err != nil {
+		return fmt.Errorf("failed to initialise job \"%s\": %w", jobname, err)
+	}
+	bkpitems, err := module.ListBackups()
+	if err != nil {
+		return fmt.Errorf("failed to list the backups of job \"%s\": %w", jobname, err)
+	}
+
+	slog.Infof("Encrypting again %d archives managed by job \"%s\" ...", len(bkpitems), jobname)
+	if len(bkpitems) == 0 {
+		return nil
+	}
+
+	return rekeyer.RekeyBackups(bkpitems, oldkeys)
+}
+
+// Encrypt again archives stored in a destination with a new key. Archives which are already encrypted
+// with the new key are skipped so the command can be run again after a failure.
+func rekeyArchives(destination BackupDestination, filenames []string, oldkeys [][]byte, newkey []byte, dryrun bool) error {
+
+	newkeyid := archiveKeyId(newkey)
+	slog.Infof("Archives are encrypted with key %s", newkeyid)
+
+	failed := 0
+	progress := NewProgressSummary(len(filenames))
+	for _, filename := range filenames {
+		data, err := destination.ReadFile(filename)
+		if err != nil {
+			slog.Errorf("Failed to read archive \"%s\": %v", filename, err)
+			failed++
+			continue
+		}
+		keyid, err := archiveGetKeyId(data)
+		if err != nil {
+			slog.Errorf("Failed to read archive \"%s\": %v", filename, err)
+			failed++
+			continue
+		}
+		if keyid == newkeyid {
+			progress.Itemf("skipped", "Archive \"%s\" is already encrypted with key %s", filename, newkeyid)
+			continue
+		}
+		result, err := rekeyArchive(data, keyid, oldkeys, newkey)
+		if err != nil {
+			slog.Errorf("Failed to encrypt archive \"%s\" again: %v", filename, err)
+			failed++
+			continue
+		}
+		if dryrun == true {
+			progress.Itemf("skipped", "Dryrun: Not writing archive \"%s\" encrypted with key %s", filename, newkeyid)
+			continue
+		}
+		if err := destination.WriteFile(filename, bytes.NewReader(result)); err != nil {
+			slog.Errorf("Failed to write archive \"%s\": %v", filename, err)
+			failed++
+			continue
+		}
+		progress.Itemf("rekeyed", "Archive \"%s\" in \"%s\" is now encrypted with key %s", filename, destination, newkeyid)
+	}
+	progress.Logf("archives")
+
+	if failed > 0 {
+		return fmt.Errorf("failed to encrypt %d archives out of %d with the new key", failed, len(filenames))
+	}
+
+	return nil
+}
+
+// Decrypt an archive with the key it was encrypted with and encrypt it with the new key. Archives in the
+// first format do not record their key so all keys are tried, including the new key.
+func rekeyArchive(data []byte, keyid string, oldkeys [][]byte, newkey []byte) ([]byte, error) {
+
+	var lasterr error
+	for _, key := range append(append([][]byte{}, oldkeys...), newkey) {
+		if keyid != "" && archiveKeyId(key) != keyid {
+			continue
+		}
+		compressed, err := archiveOpen(key, data)
+		if err != nil {
+			lasterr = err
+			continue
+		}
+		result, err := archiveSeal(newkey, compressed)
+		if err != nil {
+			return nil, err
+		}
+		// Make sure the archive can be decrypted before it replaces the original one
+		if _, err := archiveDecrypt(newkey, result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	if lasterr != nil {
+		return nil, lasterr
+	}
+	return nil, fmt.Errorf("archive was encrypted with key %s which is not among the previous keys", keyid)
+}
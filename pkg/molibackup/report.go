@@ -0,0 +1,287 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Report about the execution of all jobs on a host which is shared with other hosts
+type RunReport struct {
+	RunId     string         `json:"run_id"`
+	Hostname  string         `json:"hostname"`
+	Version   string         `json:"version"`
+	StartTime time.Time      `json:"start_time"`
+	EndTime   time.Time      `json:"end_time"`
+	Jobs      []RunReportJob `json:"jobs"`
+}
+
+type RunReportJob struct {
+	JobId         string             `json:"job_id,omitempty"`
+	Name          string             `json:"name"`
+	Module        string             `json:"module"`
+	Status        string             `json:"status"`
+	Error         string             `json:"error,omitempty"`
+	ErrorCode     string             `json:"error_code,omitempty"`
+	ErrorResource string             `json:"error_resource,omitempty"`
+	ErrorCause    string             `json:"error_cause,omitempty"`
+	ErrorStack    string             `json:"error_stack,omitempty"`
+	Usage         *StateJobUsage     `json:"usage,omitempty"`
+	Metadata      map[string]string  `json:"metadata,omitempty"`
+	ApiCalls      []RunReportApiCall `json:"api_calls,omitempty"`
+}
+
+func NewRunReport(version string) *RunReport {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return &RunReport{RunId: runId, Hostname: hostname, Version: version, StartTime: time.Now().UTC()}
+}
+
+// Record the result of a job, where the status is either "success", "failed", "disabled" or "snoozed"
+func (r *RunReport) AddJob(jobname string, module string, status string, err error) {
+	job := RunReportJob{JobId: jobExecutionId, Name: jobname, Module: module, Status: status}
+	if err != nil {
+		job.Error = err.Error()
+		job.ErrorCode, job.ErrorResource = errorDetails(err)
+		job.ErrorCause = errorCause(err)
+		job.ErrorStack = errorStack(err)
+	}
+	r.Jobs = append(r.Jobs, job)
+}
+
+// Attach the usage of the backups managed by a job to the last result recorded for this job
+func (r *RunReport) AddJobUsage(jobname string, usage *StateJobUsage) {
+	if usage == nil || len(r.Jobs) == 0 || r.Jobs[len(r.Jobs)-1].Name != jobname {
+		return
+	}
+	r.Jobs[len(r.Jobs)-1].Usage = usage
+}
+
+// Attach the custom metadata of the most recent backup of a job to the last result recorded for this job
+func (r *RunReport) AddJobMetadata(jobname string, metadata map[string]string) {
+	if len(metadata) == 0 || len(r.Jobs) == 0 || r.Jobs[len(r.Jobs)-1].Name != jobname {
+		return
+	}
+	r.Jobs[len(r.Jobs)-1].Metadata = metadata
+}
+
+// Attach the metrics of the calls to the AWS API made by a job to the last result recorded for this job
+func (r *RunReport) AddJobApiCalls(jobname string, calls []RunReportApiCall) {
+	if len(calls) == 0 || len(r.Jobs) == 0 || r.Jobs[len(r.Jobs)-1].Name != jobname {
+		return
+	}
+	r.Jobs[len(r.Jobs)-1].ApiCalls = calls
+}
+
+// Count the jobs of the report which have a particular status
+func (r *RunReport) CountJobs(status string) int {
+	count := 0
+	for _, job := range r.Jobs {
+		if job.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Jobs which have failed with the same cause, such as expired credentials shared by all jobs
+type RunReportFailureGroup struct {
+	Cause string   `json:"cause"`
+	Jobs  []string `json:"jobs"`
+}
+
+// Group the names of failed jobs by the cause of their error with the largest groups first
+func reportGroupFailures(jobs []RunReportJob) []RunReportFailureGroup {
+
+	var results []RunReportFailureGroup
+
+	groups := make(map[string]int)
+	for _, job := range jobs {
+		if job.Status != "failed" {
+			continue
+		}
+		// Reports uploaded by older versions do not have the cause of errors
+		cause := job.ErrorCause
+		if cause == "" {
+			cause = job.Error
+		}
+		index, found := groups[cause]
+		if found == false {
+			index = len(results)
+			groups[cause] = index
+			results = append(results, RunReportFailureGroup{Cause: cause})
+		}
+		results[index].Jobs = append(results[index].Jobs, job.Name)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return len(results[i].Jobs) > len(results[j].Jobs)
+	})
+
+	return results
+}
+
+// Log a single error for each cause which has made several jobs fail so the shared cause is fixed first
+func reportLogFailureGroups(groups []RunReportFailureGroup) {
+	for _, group := range groups {
+		if len(group.Jobs) > 1 {
+			slog.Errorf("%d jobs have failed with the same cause \"%s\" which they probably share: %s",
+				len(group.Jobs), group.Cause, strings.Join(group.Jobs, ", "))
+		}
+	}
+}
+
+// Upload the report to the shared bucket if this has been requested in the configuration
+func reportUpload(r *RunReport) error {
+
+	bucket := kconfig.String("global.report_s3_bucket")
+	if bucket == "" {
+		return nil
+	}
+	if outboundAllowed("report upload") == false {
+		return nil
+	}
+
+	r.EndTime = time.Now().UTC()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the run report: %v", err)
+	}
+
+	client, err := reportNewS3Client()
+	if err != nil {
+		return fmt.Errorf("failed to upload the run report to bucket \"%s\": %w", bucket, err)
+	}
+
+	// Each host overwrites its previous report so the bucket always contains the latest run
+	key := path.Join(kconfig.String("global.report_s3_prefix"), fmt.Sprintf("%s.json", r.Hostname))
+	slog.Debugf("Uploading run report to s3://%s/%s ...", bucket, key)
+	if err := ProviderAwsPutS3Object(client, bucket, key, data); err != nil {
+		return fmt.Errorf("failed to upload the run report to s3://%s/%s: %w", bucket, key, err)
+	}
+	slog.Infof("Have uploaded run report to s3://%s/%s", bucket, key)
+
+	return nil
+}
+
+// Merge the reports uploaded by all hosts and log a single summary for the whole fleet
+func reportDigest() error {
+
+	var reports []RunReport
+
+	bucket := kconfig.String("global.report_s3_bucket")
+	if bucket == "" {
+		return fmt.Errorf("option \"report_s3_bucket\" must be specified in the global section to produce a digest")
+	}
+	if kconfig.Bool("global.offline") == true {
+		return fmt.Errorf("a digest cannot be produced as the program runs in offline mode")
+	}
+
+	client, err := reportNewS3Client()
+	if err != nil {
+		return fmt.Errorf("failed to read the run reports from bucket \"%s\": %w", bucket, err)
+	}
+
+	prefix := kconfig.String("global.report_s3_prefix")
+	if prefix != "" && strings.HasSuffix(prefix, "/") == false {
+		prefix = prefix + "/"
+	}
+	objects, err := ProviderAwsListS3Objects(client, bucket, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list the run reports in s3://%s/%s: %w", bucket, prefix, err)
+	}
+
+	for _, object := range objects {
+		key := object.key
+		if strings.HasSuffix(key, ".json") == false {
+			continue
+		}
+		data, err := ProviderAwsGetS3Object(client, bucket, key)
+		if err != nil {
+			return fmt.Errorf("failed to read the run report s3://%s/%s: %w", bucket, key, err)
+		}
+		var report RunReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			slog.Warnf("Ignoring invalid run report s3://%s/%s: %v", bucket, key, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Hostname < reports[j].Hostname
+	})
+
+	// Reports which have not been updated recently indicate hosts where the program no longer runs
+	maxage := time.Duration(kconfig.Int64("global.report_max_age")) * time.Hour
+	hostsFailed, hostsStale, jobsSuccess, jobsFailed := 0, 0, 0, 0
+	for _, report := range reports {
+		success := report.CountJobs("success")
+		failed := report.CountJobs("failed")
+		jobsSuccess += success
+		jobsFailed += failed
+		age := time.Since(report.EndTime).Truncate(time.Minute)
+		if failed > 0 {
+			hostsFailed++
+		}
+		if age > maxage {
+			hostsStale++
+			slog.Warnf("Host \"%s\" has not reported since %v (%v ago)", report.Hostname, report.EndTime.Format(time.RFC3339), age)
+		}
+		slog.Infof("Host \"%s\": version=%s run=%s finished=%s successful=%d failed=%d",
+			report.Hostname, report.Version, report.RunId, report.EndTime.Format(time.RFC3339), success, failed)
+		for _, job := range report.Jobs {
+			if job.Status == "failed" {
+				slog.Errorf("Host \"%s\": job \"%s\" (%s) has failed in %s: %s", report.Hostname, job.Name, job.Module, job.JobId, job.Error)
+			}
+			if job.Usage != nil && job.Usage.WeeklyGrowth != nil {
+				slog.Infof("Host \"%s\": job \"%s\" manages %d backups (%s) with a weekly growth of %+d backups (%s)",
+					report.Hostname, job.Name, job.Usage.Count, formatBytes(job.Usage.Size), job.Usage.WeeklyGrowth.Count, formatSignedBytes(job.Usage.WeeklyGrowth.Size))
+			}
+		}
+	}
+
+	// Failures of jobs on different hosts often have the same cause such as an expired credential
+	var alljobs []RunReportJob
+	for _, report := range reports {
+		for _, job := range report.Jobs {
+			job.Name = fmt.Sprintf("%s:%s", report.Hostname, job.Name)
+			alljobs = append(alljobs, job)
+		}
+	}
+	reportLogFailureGroups(reportGroupFailures(alljobs))
+
+	slog.Infof("Summary of fleet: hosts %d, hosts with failures %d, stale hosts %d, successful jobs %d, failed jobs %d",
+		len(reports), hostsFailed, hostsStale, jobsSuccess, jobsFailed)
+
+	return nil
+}
+
+// Create a client for the bucket where run reports are shared
+func reportNewS3Client() (*s3.Client, error) {
+	region := kconfig.String("global.report_aws_region")
+	if region == "" {
+		return nil, fmt.Errorf("option \"report_aws_region\" must be specified when \"report_s3_bucket\" is used")
+	}
+	cfg, err := ProviderAwsLoadConfig(region, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the AWS configuration for region \"%s\": %w", region, err)
+	}
+	return ProviderAwsNewS3Client(cfg), nil
+}
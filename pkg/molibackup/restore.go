@@ -0,0 +1,293 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/slog"
+	"golang.org/x/exp/slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Tags of snapshots which describe the run which has created them and which are never copied to restored volumes
+var restoreExcludedTags = []string{"ConsistencyGroupId", "RestoredBy", "SourceSnapshotId"}
+
+// Restore the volumes of an instance from the snapshots created by a single run on a particular date,
+// and optionally launch a new instance with these volumes attached using the original device names.
+// Volumes are created with the type and performance settings specified rather than these of the
+// original volumes, as restores are a good opportunity to move to a more recent class of volumes.
+func restoreInstance(region string, instanceId string, date string, launch bool, settings ProviderAwsRestoreVolumeSettings) error {
+
+	restoredate, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("invalid date \"%s\", it must be in the YYYY-MM-DD format", date)
+	}
+	snapdate := restoredate.Format("20060102")
+
+	if err := restoreValidateVolumeSettings(settings); err != nil {
+		return fmt.Errorf("invalid settings for the restored volumes: %w", err)
+	}
+	switch settings.initialize {
+	case "", "fsr":
+	case "read":
+		if launch == false {
+			return fmt.Errorf("volumes can only be initialized by reading them when an instance is launched with the -launch option")
+		}
+	default:
+		return fmt.Errorf("invalid initialization method \"%s\", it must be fsr or read", settings.initialize)
+	}
+
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		return fmt.Errorf("the region must be specified with the -region option or the AWS_REGION environment variable")
+	}
+
+	cfg, err := ProviderAwsLoadConfig(region, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to load the AWS configuration for region %s: %w", region, err)
+	}
+
+	// Refuse to run in an account or a region which is not allowed in the global configuration
+	if err := ProviderAwsCheckGuardrails(cfg, region); err != nil {
+		return fmt.Errorf("failed to check the guardrails for region %s: %w", region, err)
+	}
+
+	client := ProviderAwsNewEc2Client(cfg)
+
+	layout, err := ProviderAwsGetInstanceLayout(client, instanceId)
+	if err != nil {
+		return fmt.Errorf("failed to read the layout of instance %s: %w", instanceId, err)
+	}
+	var devices []string
+	var volumeIds []string
+	for device, volumeId := range layout.devices {
+		devices = append(devices, device)
+		volumeIds = append(volumeIds, volumeId)
+		slog.Debugf("Found volume: device=\"%s\" volumeId=\"%s\" instanceId=\"%s\"", device, volumeId, instanceId)
+	}
+	sort.Strings(devices)
+	if len(devices) == 0 {
+		return fmt.Errorf("instance %s has no EBS volume", instanceId)
+	}
+
+	snapshots, err := ProviderAwsGetRestoreSnapshots(client, volumeIds, snapdate)
+	if err != nil {
+		return fmt.Errorf("failed to find the snapshots of the volumes of instance %s: %w", instanceId, err)
+	}
+
+	// Snapshots of all volumes must come from the same run so they represent the same point in time
+	runs := make(map[string]map[string]ProviderAwsRestoreSnapshot)
+	runtimes := make(map[string]int64)
+	for _, snapshot := range snapshots {
+		if _, ok := runs[snapshot.runId]; ok == false {
+			runs[snapshot.runId] = make(map[string]ProviderAwsRestoreSnapshot)
+		}
+		runs[snapshot.runId][snapshot.volumeId] = snapshot
+		if snapshot.createTime > runtimes[snapshot.runId] {
+			runtimes[snapshot.runId] = snapshot.createTime
+		}
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("have not found any completed snapshot created on %s for the volumes of instance %s", date, instanceId)
+	}
+
+	// The run which covers the most volumes is used, and the most recent one when several runs are equivalent
+	var bestrun string
+	for runId := range runs {
+		if _, ok := runs[bestrun]; ok == false || len(runs[runId]) > len(runs[bestrun]) ||
+			(len(runs[runId]) == len(runs[bestrun]) && runtimes[runId] > runtimes[bestrun]) {
+			bestrun = runId
+		}
+	}
+	slog.Infof("Restoring instance %s from the snapshots of run \"%s\" created at %s ...",
+		instanceId, bestrun, time.Unix(runtimes[bestrun], 0).Format(time.RFC3339))
+
+	if len(runs[bestrun]) < len(devices) && launch == true {
+		return fmt.Errorf("the snapshots of run \"%s\" only cover %d out of %d volumes so the instance cannot be launched", bestrun, len(runs[bestrun]), len(devices))
+	}
+
+	// Fast snapshot restores must be enabled before volumes are created, and they are disabled once volumes
+	// have been created as they are charged for each hour they are enabled on each snapshot
+	if settings.initialize == "fsr" {
+		var snapshotIds []string
+		for _, snapshot := range runs[bestrun] {
+			snapshotIds = append(snapshotIds, snapshot.snapshotId)
+		}
+		sort.Strings(snapshotIds)
+		slog.Infof("Enabling fast snapshot restore of snapshots %v in %s ...", snapshotIds, layout.availabilityZone)
+		if err := ProviderAwsEnableFastSnapshotRestores(client, snapshotIds, layout.availabilityZone); err != nil {
+			return fmt.Errorf("failed to enable fast snapshot restore of snapshots %v: %w", snapshotIds, err)
+		}
+		defer func() {
+			if err := ProviderAwsDisableFastSnapshotRestores(client, snapshotIds, layout.availabilityZone); err != nil {
+				slog.Warnf("Failed to disable fast snapshot restore, it must be disabled manually to stop charges: %v", err)
+				return
+			}
+			slog.Infof("Have disabled fast snapshot restore of snapshots %v in %s", snapshotIds, layout.availabilityZone)
+		}()
+		if err := ProviderAwsWaitFastSnapshotRestores(client, snapshotIds, layout.availabilityZone, settings.initTimeout); err != nil {
+			return fmt.Errorf("failed to wait for fast snapshot restore of snapshots %v: %w", snapshotIds, err)
+		}
+	}
+
+	restored := make(map[string]string)
+	for _, device := range devices {
+		volumeId := layout.devices[device]
+		snapshot, ok := runs[bestrun][volumeId]
+		if ok == false {
+			slog.Warnf("Have not found any snapshot of volume %s attached on %s in this run", volumeId, device)
+			continue
+		}
+		volname := fmt.Sprintf("restore-of-%s-%s", volumeId, snapdate)
+		tags := restoreVolumeTags(snapshot, volname, settings)
+		newVolumeId, err := ProviderAwsCreateVolumeFromSnapshot(client, snapshot.snapshotId, layout.availabilityZone, tags, settings)
+		if err != nil {
+			return fmt.Errorf("failed to restore volume %s from snapshot %s: %w", volumeId, snapshot.snapshotId, err)
+		}
+		slog.Infof("Successfully created %s volume %s for device %s from snapshot %s of volume %s", settings.volumeType, newVolumeId, device, snapshot.snapshotId, volumeId)
+		restored[device] = newVolumeId
+	}
+
+	if launch == false {
+		slog.Infof("Have restored %d volumes in %s which can now be attached to an instance", len(restored), layout.availabilityZone)
+		return nil
+	}
+
+	newInstanceId, err := ProviderAwsLaunchInstanceWithVolumes(client, layout, restored, settings.tagRestored)
+	if err != nil {
+		return fmt.Errorf("failed to launch an instance with the restored volumes of instance %s: %w", instanceId, err)
+	}
+	slog.Infof("Successfully launched instance %s with the restored volumes of instance %s", newInstanceId, instanceId)
+
+	if settings.initialize == "read" {
+		if err := restoreReadVolumes(cfg, newInstanceId, restored, settings.initTimeout); err != nil {
+			return fmt.Errorf("failed to initialize the restored volumes: %w", err)
+		}
+		slog.Infof("Successfully initialized the restored volumes of instance %s", newInstanceId)
+	}
+
+	return nil
+}
+
+// Read all blocks of the restored volumes on the instance they are attached to using SSM, as blocks of volumes
+// created from snapshots are only downloaded when they are accessed for the first time, which makes first reads
+// much slower. Volumes are read in parallel and they are found by volume identifier on Nitro instances as their
+// NVMe device names do not match the device names of the block device mapping.
+func restoreReadVolumes(cfg aws.Config, instanceId string, volumes map[string]string, timeout time.Duration) error {
+
+	ssmclient := ProviderAwsNewSsmClient(cfg)
+
+	slog.Infof("Waiting for the SSM agent of instance %s to be online ...", instanceId)
+	if err := ProviderAwsWaitSsmAgentOnline(ssmclient, instanceId, awsRestoreWaitTimeout); err != nil {
+		return fmt.Errorf("failed to wait for the SSM agent of instance %s: %w", instanceId, err)
+	}
+
+	var devices []string
+	for device := range volumes {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	commands := []string{"pids=''"}
+	for _, device := range devices {
+		nvmedev := "/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_" + strings.ReplaceAll(volumes[device], "-", "")
+		xendev := strings.Replace(device, "/dev/sd", "/dev/xvd", 1)
+		commands = append(commands, fmt.Sprintf("dev='%s'; [ -e \"$dev\" ] || dev='%s'; [ -e \"$dev\" ] || dev='%s'; dd if=\"$dev\" of=/dev/null bs=1M & pids=\"$pids $!\"",
+			nvmedev, device, xendev))
+	}
+	commands = append(commands, "rc=0; for pid in $pids; do wait $pid || rc=1; done; exit $rc")
+
+	slog.Infof("Reading all blocks of volumes %v on instance %s ...", devices, instanceId)
+	if err := ProviderAwsRunShellCommands(ssmclient, []string{instanceId}, commands, timeout); err != nil {
+		return fmt.Errorf("failed to read the volumes on instance %s: %w", instanceId, err)
+	}
+
+	return nil
+}
+
+// Return the tags of a volume restored from a snapshot. Tags of the snapshot are only copied when they have
+// been requested, and copying all tags excludes these which describe the snapshot rather than the data, such as
+// the tags used by this program to manage snapshots, as the restored volume is not a backup. Tags which have
+// been requested explicitly are always copied, so the name of the original volume can be kept for instance.
+func restoreVolumeTags(snapshot ProviderAwsRestoreSnapshot, volname string, settings ProviderAwsRestoreVolumeSettings) map[string]string {
+
+	tags := map[string]string{"Name": volname}
+
+	for _, tagkey := range settings.copyTags {
+		if tagkey != "*" {
+			continue
+		}
+		for snapkey, snapval := range snapshot.tags {
+			if slices.Contains(awsReservedTags, snapkey) == false && slices.Contains(restoreExcludedTags, snapkey) == false &&
+				strings.HasPrefix(snapkey, awsMetadataTagPrefix) == false && strings.HasPrefix(snapkey, "aws:") == false {
+				tags[snapkey] = snapval
+			}
+		}
+	}
+	for _, tagkey := range settings.copyTags {
+		if snapval, found := snapshot.tags[tagkey]; found == true {
+			tags[tagkey] = snapval
+		}
+	}
+
+	// Traceability tags are set last so tags copied from a previously restored volume cannot hide the actual source
+	delete(tags, "RestoredBy")
+	delete(tags, "SourceSnapshotId")
+	if settings.tagRestored == true {
+		tags["RestoredBy"] = "molibackup"
+		tags["SourceSnapshotId"] = snapshot.snapshotId
+	}
+
+	return tags
+}
+
+// Check the type of the restored volumes supports the performance settings which have been specified
+func restoreValidateVolumeSettings(settings ProviderAwsRestoreVolumeSettings) error {
+
+	for _, tagkey := range settings.copyTags {
+		if tagkey == "" || strings.HasPrefix(tagkey, "aws:") == true {
+			return fmt.Errorf("invalid tag key \"%s\" in the tags to copy", tagkey)
+		}
+	}
+
+	if settings.iops < 0 || settings.throughput < 0 {
+		return fmt.Errorf("the IOPS and the throughput must not be negative")
+	}
+
+	switch settings.volumeType {
+	case "gp3":
+		if settings.iops != 0 && (settings.iops < 3000 || settings.iops > 16000) {
+			return fmt.Errorf("the IOPS of gp3 volumes must be between 3000 and 16000")
+		}
+		if settings.throughput != 0 && (settings.throughput < 125 || settings.throughput > 1000) {
+			return fmt.Errorf("the throughput of gp3 volumes must be between 125 and 1000 MiB/s")
+		}
+	case "io1", "io2":
+		if settings.iops == 0 {
+			return fmt.Errorf("the IOPS must be specified with the -iops option for %s volumes", settings.volumeType)
+		}
+		if settings.throughput != 0 {
+			return fmt.Errorf("the throughput can only be specified for gp3 volumes")
+		}
+	case "gp2", "st1", "sc1", "standard":
+		if settings.iops != 0 || settings.throughput != 0 {
+			return fmt.Errorf("the IOPS and the throughput cannot be specified for %s volumes", settings.volumeType)
+		}
+	default:
+		return fmt.Errorf("invalid volume type \"%s\"", settings.volumeType)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/gookit/slog"
+)
+
+// Apply tags specified as "key=value" to all backups managed by a job
+func retagJob(jobname string, tagargs []string) error {
+
+	tags := make(map[string]string)
+	for _, tagarg := range tagargs {
+		tagkey, tagval, found := strings.Cut(tagarg, "=")
+		if found == false || tagkey == "" {
+			return fmt.Errorf("tag \"%s\" must be in the \"key=value\" format", tagarg)
+		}
+		if slices.Contains(awsReservedTags, tagkey) == true || strings.HasPrefix(tagkey, "aws:") == true {
+			return fmt.Errorf("tag \"%s\" is reserved and cannot be changed", tagkey)
+		}
+		tags[tagkey] = tagval
+	}
+
+	jobconf, ok := jobmetadefs[jobname]
+	if ok == false {
+		return fmt.Errorf("job \"%s\" is not defined in the configuration", jobname)
+	}
+	moddef, ok := findModuleDefinition(jobconf.Module)
+	if ok == false {
+		return fmt.Errorf("invalid type of backup module: \"%s\"", jobconf.Module)
+	}
+	module := moddef.create()
+	retagger, ok := module.(BackupRetagger)
+	if ok == false {
+		return fmt.Errorf("module \"%s\" of job \"%s\" does not support updating the tags of its backups", jobconf.Module, jobname)
+	}
+
+	jobExecutionId = fmt.Sprintf("%s-01", runId)
+	defer func() { jobExecutionId = "" }()
+
+	if err := module.LoadConfiguration(jobname); err != nil {
+		return fmt.Errorf("failed to load the configuration of job \"%s\": %w", jobname, err)
+	}
+	if err := module.InitialiseModule(); err != nil {
+		return fmt.Errorf("failed to initialise job \"%s\": %w", jobname, err)
+	}
+	bkpitems, err := module.ListBackups()
+	if err != nil {
+		return fmt.Errorf("failed to list the backups of job \"%s\": %w", jobname, err)
+	}
+
+	slog.Infof("Updating tags of %d backups managed by job \"%s\" ...", len(bkpitems), jobname)
+	if len(bkpitems) == 0 {
+		return nil
+	}
+
+	return retagger.RetagBackups(bkpitems, tags)
+}
@@ -0,0 +1,142 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Resource whose snapshots are managed by a snapshotRotation, such as a cluster or a volume
+type snapshotSource struct {
+	id     string // Identifier of the resource passed to the provider
+	kind   string // Type of the resource in messages such as "cluster"
+	prefix string // Beginning of the names of its snapshots which is followed by the date
+}
+
+// Operations on the snapshots of a source which are specific to the API of each provider
+type snapshotProvider interface {
+	CreateSnapshot(source snapshotSource, snapname string, snapdate string, snaptime string) (string, error)
+	ListSnapshots(source snapshotSource) ([]BackupItem, error)
+	DeleteSnapshot(item BackupItem) error
+}
+
+// Creation and rotation of the snapshots of the resources found by a module based on a retention in days.
+// Modules which only differ by the API of their provider embed it, so they only have to load their
+// configuration, find their sources and implement snapshotProvider. Other modules keep their own rotation
+// as it does more than this: ebs-snapshot and ami-image run pre-hooks, store metadata and apply rules such
+// as keep_minimum, efs-backup waits for backup jobs, linode-backup replaces its manual snapshot, proxmox-backup
+// applies keep_last and skips protected archives, and libvirt-snapshot names snapshots per domain. Modules
+// which write dumps or archives rotate files in their destination, and repository based modules such as
+// borg, restic, kopia or postgres-walg let their tool prune backups so the repository stays consistent.
+type snapshotRotation struct {
+	noun         string // Name of the backups in messages, either "snapshot" or "backup"
+	timeformat   string // Layout of the time in UTC at the end of the names of new snapshots
+	retention    int64
+	dryruncreate bool
+	dryrundelete bool
+	provider     snapshotProvider
+	sources      []snapshotSource
+}
+
+// Describe an item with its name when it is not its identifier
+func (r *snapshotRotation) itemLabel(item BackupItem) string {
+	if item.description == "" || item.description == item.identifier {
+		return fmt.Sprintf("id=\"%s\"", item.identifier)
+	}
+	return fmt.Sprintf("id=\"%s\" name=\"%s\"", item.identifier, item.description)
+}
+
+func (r *snapshotRotation) CreateBackup() error {
+
+	progress := NewProgressSummary(len(r.sources))
+
+	for _, source := range r.sources {
+		slog.Debugf("Considering %s of %s \"%s\" ...", r.noun, source.kind, source.id)
+		curtime := clockNow()
+		snapname := fmt.Sprintf("%s-%s", source.prefix, curtime.UTC().Format(r.timeformat))
+		snapdate := fmt.Sprintf("%04d%02d%02d", curtime.Year(), curtime.Month(), curtime.Day())
+		snaptime := fmt.Sprintf("%v", curtime.Unix())
+		if r.dryruncreate == false {
+			snapshotId, err := r.provider.CreateSnapshot(source, snapname, snapdate, snaptime)
+			if err != nil {
+				return fmt.Errorf("failed to create %s of %s \"%s\": %w", r.noun, source.kind, source.id, err)
+			}
+			progress.Itemf("created", "Successfully created %s \"%s\" of %s \"%s\"", r.noun, snapshotId, source.kind, source.id)
+		} else {
+			progress.Itemf("skipped", "Dryrun: Not creating %s of %s \"%s\"", r.noun, source.kind, source.id)
+		}
+	}
+
+	progress.Logf(r.noun + "s")
+
+	return nil
+}
+
+func (r *snapshotRotation) ListBackups() ([]BackupItem, error) {
+	var results []BackupItem
+
+	// Enumerate sources and their snapshots to get a list of relevant snapshots
+	for _, source := range r.sources {
+		slog.Debugf("Listing %ss of %s \"%s\" ...", r.noun, source.kind, source.id)
+
+		bkpitems, err := r.provider.ListSnapshots(source)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range bkpitems {
+			slog.Debugf("Found %s: %s created=\"%v\" %s=\"%s\"", r.noun, r.itemLabel(item),
+				time.Unix(item.timestamp, 0).Format(time.RFC3339), source.kind, source.id)
+		}
+		results = append(results, bkpitems...)
+	}
+
+	// Names start with the name of the source followed by the date so this orders snapshots by source and age
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].description != results[j].description {
+			return results[i].description < results[j].description
+		}
+		return results[i].timestamp < results[j].timestamp
+	})
+
+	return results, nil
+}
+
+func (r *snapshotRotation) DeleteOldBackups(bkpitems []BackupItem) error {
+
+	progress := NewProgressSummary(len(bkpitems))
+
+	retention := r.retention
+	curtime := clockNow().Unix()
+
+	for _, item := range bkpitems {
+		snapshotAge := (curtime - item.timestamp) / 86400
+		snapDelete := snapshotAge > retention
+		slog.Debugf("Considering deletion of %s: %s age=%v retention=%v ...", r.noun, r.itemLabel(item), snapshotAge, retention)
+		if snapDelete == true {
+			if r.dryrundelete == false {
+				err := r.provider.DeleteSnapshot(item)
+				if err != nil {
+					return fmt.Errorf("failed to delete %s \"%s\": %w", r.noun, item.identifier, err)
+				}
+				progress.Itemf("deleted", "Deleted %s: %s age=%v retention=%v", r.noun, r.itemLabel(item), snapshotAge, retention)
+			} else {
+				progress.Itemf("skipped", "Dryrun: Not deleting %s: %s age=%d retention=%v", r.noun, r.itemLabel(item), snapshotAge, retention)
+			}
+		} else {
+			progress.Itemf("kept", "Keeping %s: %s age=%d retention=%d", r.noun, r.itemLabel(item), snapshotAge, retention)
+		}
+	}
+
+	progress.Logf(r.noun + "s")
+
+	return nil
+}
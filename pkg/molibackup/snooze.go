@@ -0,0 +1,97 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Convert the "snooze_until" option which is either a date at midnight UTC or a date and a time in
+// the RFC3339 format, and which is decoded as a time by the yaml parser when it is not quoted
+func configSnoozeUntil(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v, nil
+	case string:
+		if v == "" {
+			return time.Time{}, nil
+		}
+		if until, err := time.Parse("2006-01-02", v); err == nil {
+			return until, nil
+		}
+		until, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("\"%s\" must be a date in the YYYY-MM-DD format or a date and a time in the RFC3339 format", v)
+		}
+		return until, nil
+	default:
+		return time.Time{}, fmt.Errorf("\"%v\" must be a date in the YYYY-MM-DD format or a date and a time in the RFC3339 format", value)
+	}
+}
+
+// Return the time until which a job is snoozed, either by its configuration or by the snooze command, where
+// the latest of both applies, and whether this time is still in the future. Expired snoozes are discarded
+// from the state so they do not accumulate.
+func jobSnoozedUntil(jobname string) (time.Time, bool) {
+
+	until, _ := configSnoozeUntil(jobmetadefs[jobname].SnoozeUntil)
+
+	if statedata != nil {
+		if snooze, found := statedata.Snoozes[jobname]; found == true {
+			if clockNow().Before(snooze) == false {
+				slog.Infof("Snooze of job \"%s\" has expired on %v", jobname, snooze.Format(time.RFC3339))
+				delete(statedata.Snoozes, jobname)
+			} else if snooze.After(until) == true {
+				until = snooze
+			}
+		}
+	}
+
+	return until, clockNow().Before(until)
+}
+
+// Snooze a job for the duration specified by recording the end of the snooze in the state file,
+// or remove the snooze of the job when the duration is zero
+func snoozeJob(jobname string, durationarg string) error {
+
+	if _, ok := jobmetadefs[jobname]; ok == false {
+		return fmt.Errorf("job \"%s\" is not defined in the configuration", jobname)
+	}
+	if kconfig.String("global.state_file") == "" {
+		return fmt.Errorf("global option \"state_file\" must be set as snoozes are recorded in the state file")
+	}
+
+	duration, err := time.ParseDuration(durationarg)
+	if err != nil || duration < 0 {
+		return fmt.Errorf("duration \"%s\" must be a positive duration such as \"48h\" or \"0\" to remove the snooze", durationarg)
+	}
+
+	if err := stateLoad(); err != nil {
+		return fmt.Errorf("failed to load the state file: %w", err)
+	}
+
+	if duration == 0 {
+		stateSetSnooze(jobname, time.Time{})
+		slog.Infof("Removed the snooze of job \"%s\"", jobname)
+	} else {
+		until := clockNow().Add(duration)
+		stateSetSnooze(jobname, until)
+		slog.Infof("Snoozed job \"%s\" until %v", jobname, until.Format(time.RFC3339))
+	}
+
+	// The option in the configuration still applies when the snooze recorded in the state is removed
+	if until, snoozed := jobSnoozedUntil(jobname); snoozed == true && duration == 0 {
+		slog.Warnf("Job \"%s\" remains snoozed until %v by option \"snooze_until\" of its configuration", jobname, until.Format(time.RFC3339))
+	}
+
+	return stateSave()
+}
@@ -0,0 +1,305 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gookit/slog"
+)
+
+// Samples older than this are discarded as they are not needed to calculate the weekly growth
+const stateHistoryMaxAge = 30 * 24 * time.Hour
+
+// Number of results of each job which are kept so recent runs can be shown by the documentation server
+const stateResultsMax = 10
+
+// Content of the optional state file where the program keeps the history of each job
+type StateFile struct {
+	Jobs    map[string][]StateUsageSample `json:"jobs"`
+	Snoozes map[string]time.Time          `json:"snoozes,omitempty"`
+	Results map[string][]StateJobResult   `json:"results,omitempty"`
+}
+
+// Number and total size of the backups managed by a job at the time of a run
+type StateUsageSample struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+	Size  int64     `json:"size"`
+}
+
+// Usage of a job included in run reports with the growth since the sample taken a week ago
+type StateJobUsage struct {
+	Count        int               `json:"count"`
+	Size         int64             `json:"size"`
+	WeeklyGrowth *StateUsageGrowth `json:"weekly_growth,omitempty"`
+}
+
+type StateUsageGrowth struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// Result of an execution of a job
+type StateJobResult struct {
+	Time   time.Time `json:"time"`
+	JobId  string    `json:"job_id,omitempty"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+var statedata *StateFile
+var stateUpdated map[string]bool
+
+// Jobs whose snooze has been set or removed by this process, the snoozes of the other jobs are those of the
+// state file when it is saved as they can be changed by the snooze command while a run is in progress
+var stateSnoozesChanged map[string]bool
+
+// Set when the state file exists but could not be read, so the empty state used by the run instead
+// does not overwrite the history and the snoozes which are still in the file
+var stateLoadFailed bool
+
+// Read the state file if one is configured, a missing file is considered as an empty state
+func stateLoad() error {
+
+	statedata = nil
+	stateUpdated = make(map[string]bool)
+	stateSnoozesChanged = make(map[string]bool)
+	stateLoadFailed = false
+
+	statefile := kconfig.String("global.state_file")
+	if statefile == "" {
+		return nil
+	}
+
+	var err error
+	statedata, err = stateRead(statefile)
+	if err != nil {
+		statedata = &StateFile{Jobs: make(map[string][]StateUsageSample), Snoozes: make(map[string]time.Time),
+			Results: make(map[string][]StateJobResult)}
+		stateLoadFailed = true
+		return err
+	}
+
+	return nil
+}
+
+// Read a state file without changing the state of the current run, a missing file is considered as an empty state
+func stateRead(statefile string) (*StateFile, error) {
+
+	state := &StateFile{Jobs: make(map[string][]StateUsageSample), Snoozes: make(map[string]time.Time),
+		Results: make(map[string][]StateJobResult)}
+	data, err := os.ReadFile(statefile)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Debugf("State file %s does not exist yet", statefile)
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %v", statefile, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to decode state file %s: %v", statefile, err)
+	}
+	if state.Jobs == nil {
+		state.Jobs = make(map[string][]StateUsageSample)
+	}
+	if state.Snoozes == nil {
+		state.Snoozes = make(map[string]time.Time)
+	}
+	if state.Results == nil {
+		state.Results = make(map[string][]StateJobResult)
+	}
+
+	return state, nil
+}
+
+// Write the state file atomically so an interrupted run cannot leave a truncated file
+func stateSave() error {
+
+	statefile := kconfig.String("global.state_file")
+	if statefile == "" || statedata == nil {
+		return nil
+	}
+	if _, simulated := clock.(fixedClock); simulated == true {
+		slog.Infof("Not saving the state file as this run is a simulation")
+		return nil
+	}
+	if stateLoadFailed == true {
+		return fmt.Errorf("not saving the state file %s as it could not be loaded, it must be fixed or removed", statefile)
+	}
+
+	// The state file is read again as the snooze command may have changed it since it has been loaded
+	current, err := stateRead(statefile)
+	if err != nil {
+		return fmt.Errorf("failed to read the state file %s: %w", statefile, err)
+	}
+	stateMergeSnoozes(current.Snoozes)
+
+	data, err := json.MarshalIndent(statedata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the state: %v", err)
+	}
+	tmpfile, err := os.CreateTemp(filepath.Dir(statefile), ".molibackup-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write(data); err != nil {
+		tmpfile.Close()
+		return fmt.Errorf("failed to write temporary state file: %v", err)
+	}
+	// The data must be on disk before the rename or a crash could replace the state with an empty file
+	if err := tmpfile.Sync(); err != nil {
+		tmpfile.Close()
+		return fmt.Errorf("failed to write temporary state file: %v", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		return fmt.Errorf("failed to write temporary state file: %v", err)
+	}
+	if err := os.Rename(tmpfile.Name(), statefile); err != nil {
+		return fmt.Errorf("failed to replace state file %s: %v", statefile, err)
+	}
+
+	return nil
+}
+
+// Replace the snoozes of the jobs which have not been changed by this process with those of the state file
+// which have not expired, so expired snoozes are discarded without losing a snooze set in the meantime
+func stateMergeSnoozes(snoozes map[string]time.Time) {
+	for jobname := range statedata.Snoozes {
+		if stateSnoozesChanged[jobname] == false {
+			delete(statedata.Snoozes, jobname)
+		}
+	}
+	for jobname, until := range snoozes {
+		if stateSnoozesChanged[jobname] == false && clockNow().Before(until) == true {
+			statedata.Snoozes[jobname] = until
+		}
+	}
+}
+
+// Set the end of the snooze of a job in the state, or remove its snooze when the time is zero
+func stateSetSnooze(jobname string, until time.Time) {
+	if stateSnoozesChanged == nil {
+		stateSnoozesChanged = make(map[string]bool)
+	}
+	stateSnoozesChanged[jobname] = true
+	if until.IsZero() == true {
+		delete(statedata.Snoozes, jobname)
+	} else {
+		statedata.Snoozes[jobname] = until
+	}
+}
+
+// Add a sample with the number and the size of the backups of a job to its history
+func stateRecordUsage(jobname string, bkpitems []BackupItem) {
+
+	if statedata == nil {
+		return
+	}
+
+	sample := StateUsageSample{Time: clockNow().UTC(), Count: len(bkpitems)}
+	for _, item := range bkpitems {
+		sample.Size += item.size
+	}
+	stateRecordSample(jobname, sample)
+}
+
+// Add a sample to the history of a job and discard the samples which are no longer needed
+func stateRecordSample(jobname string, sample StateUsageSample) {
+
+	if statedata == nil {
+		return
+	}
+
+	var history []StateUsageSample
+	for _, old := range statedata.Jobs[jobname] {
+		if sample.Time.Sub(old.Time) <= stateHistoryMaxAge {
+			history = append(history, old)
+		}
+	}
+	statedata.Jobs[jobname] = append(history, sample)
+	stateUpdated[jobname] = true
+}
+
+// Add the result of the execution of a job to its history and discard the oldest results
+func stateRecordResult(job RunReportJob) {
+
+	if statedata == nil {
+		return
+	}
+
+	results := append(statedata.Results[job.Name], StateJobResult{Time: clockNow().UTC(), JobId: job.JobId, Status: job.Status, Error: job.Error})
+	if len(results) > stateResultsMax {
+		results = results[len(results)-stateResultsMax:]
+	}
+	statedata.Results[job.Name] = results
+}
+
+// Return the latest usage of a job and its growth compared with the latest sample at least a week old
+func stateJobUsage(jobname string) *StateJobUsage {
+
+	// Jobs which have failed before their backups were listed have no sample for this run
+	if statedata == nil || stateUpdated[jobname] == false {
+		return nil
+	}
+	history := statedata.Jobs[jobname]
+	if len(history) == 0 {
+		return nil
+	}
+
+	latest := history[len(history)-1]
+	usage := &StateJobUsage{Count: latest.Count, Size: latest.Size}
+	for i := len(history) - 2; i >= 0; i-- {
+		if latest.Time.Sub(history[i].Time) >= 7*24*time.Hour {
+			usage.WeeklyGrowth = &StateUsageGrowth{
+				Count: latest.Count - history[i].Count,
+				Size:  latest.Size - history[i].Size,
+			}
+			break
+		}
+	}
+
+	// Modules which do not know the size of their backups only report the number of backups
+	if usage.WeeklyGrowth == nil {
+		slog.Infof("Job manages %d backups (%s), the weekly growth is not known yet", usage.Count, formatBytes(usage.Size))
+	} else if usage.Size == 0 {
+		slog.Infof("Job manages %d backups with a weekly growth of %+d backups", usage.Count, usage.WeeklyGrowth.Count)
+	} else {
+		slog.Infof("Job manages %d backups (%s) with a weekly growth of %+d backups (%s)",
+			usage.Count, formatBytes(usage.Size), usage.WeeklyGrowth.Count, formatSignedBytes(usage.WeeklyGrowth.Size))
+	}
+
+	return usage
+}
+
+// Format a number of bytes with a binary unit such as "12.5GiB"
+func formatBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+func formatSignedBytes(size int64) string {
+	if size < 0 {
+		return "-" + formatBytes(-size)
+	}
+	return "+" + formatBytes(size)
+}
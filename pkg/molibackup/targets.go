@@ -0,0 +1,137 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Jobs can read the resources they back up from a file exported by a CMDB rather than from tags, for
+// organisations where the CMDB is the source of truth. The file is read at each run so changes in the
+// CMDB are picked up without changing the configuration. Each row has the identifier of a resource and
+// an optional retention in days which overrides the retention of the job for this resource.
+
+// Resource listed in a targets file
+type TargetRow struct {
+	id        string
+	retention int64
+}
+
+// Read a targets file from a local path or from an s3:// location. Files with the .json extension contain
+// an array of objects with "id" and "retention" attributes, and other files are CSV files where the first
+// column is the identifier and the optional second column is the retention, with an optional header row.
+func readTargetsFile(location string, cfg aws.Config) ([]TargetRow, error) {
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(location, "s3://") {
+		bucket, key, err := destinationBucketAndPrefix(location, "s3")
+		if err != nil {
+			return nil, err
+		}
+		data, err = ProviderAwsGetS3Object(ProviderAwsNewS3Client(cfg), bucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read targets file \"%s\": %w", location, err)
+		}
+	} else {
+		data, err = os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read targets file \"%s\": %v", location, err)
+		}
+	}
+
+	var rows []TargetRow
+	if strings.HasSuffix(strings.ToLower(location), ".json") {
+		rows, err = parseTargetsJson(data)
+	} else {
+		rows, err = parseTargetsCsv(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid targets file \"%s\": %v", location, err)
+	}
+
+	// A resource listed twice with different retentions is most likely a mistake in the CMDB
+	seen := make(map[string]int64)
+	for _, row := range rows {
+		if retention, found := seen[row.id]; found == true && retention != row.retention {
+			return nil, fmt.Errorf("invalid targets file \"%s\": resource \"%s\" is listed with different retentions", location, row.id)
+		}
+		seen[row.id] = row.retention
+	}
+
+	return rows, nil
+}
+
+func parseTargetsJson(data []byte) ([]TargetRow, error) {
+
+	var entries []struct {
+		Id        string `json:"id"`
+		Retention int64  `json:"retention"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %v", err)
+	}
+
+	var rows []TargetRow
+	for i, entry := range entries {
+		if strings.TrimSpace(entry.Id) == "" {
+			return nil, fmt.Errorf("entry %d has no id", i+1)
+		}
+		if entry.Retention < 0 {
+			return nil, fmt.Errorf("entry %d has an invalid retention %d", i+1, entry.Retention)
+		}
+		rows = append(rows, TargetRow{id: strings.TrimSpace(entry.Id), retention: entry.Retention})
+	}
+
+	return rows, nil
+}
+
+func parseTargetsCsv(data []byte) ([]TargetRow, error) {
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	var rows []TargetRow
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode CSV: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		id := strings.TrimSpace(record[0])
+		if first == true && strings.EqualFold(id, "id") {
+			continue
+		}
+		if id == "" {
+			continue
+		}
+		row := TargetRow{id: id}
+		if len(record) > 1 && strings.TrimSpace(record[1]) != "" {
+			row.retention, err = strconv.ParseInt(strings.TrimSpace(record[1]), 10, 64)
+			if err != nil || row.retention <= 0 {
+				return nil, fmt.Errorf("row %d has an invalid retention \"%s\"", line, record[1])
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
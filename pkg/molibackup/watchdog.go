@@ -0,0 +1,129 @@
+/******************************************************************************\
+* Copyright (C) 2024-2024 The Molibackup Authors. All rights reserved.         *
+* Licensed under the Apache version 2.0 License                                *
+* Homepage: https://github.com/fdupoux/molibackup                              *
+\******************************************************************************/
+
+package molibackup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gookit/slog"
+)
+
+// The watchdog detects jobs which have stalled, when no call to a provider has completed for longer than
+// the stall timeout, so a hang is reported while the run is still in progress rather than the next day.
+// Activity is recorded when a call to the AWS API completes, when a command started by a module writes
+// output or exits and when an item is processed, which covers the calls to the APIs of the other providers.
+
+// Time of the latest activity of the job which is running
+var watchdogMutex sync.Mutex
+var watchdogLastActivity time.Time
+
+// Error returned when a job has been aborted as it has stalled
+type StallError struct {
+	timeout time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("the job has been aborted as it has stalled with no call to a provider completed for %v", e.timeout)
+}
+
+func (e *StallError) ErrorCode() string {
+	return "JobStalled"
+}
+
+func (e *StallError) ErrorResource() string {
+	return ""
+}
+
+// Record that a call to a provider has completed
+func watchdogActivity() {
+	watchdogMutex.Lock()
+	defer watchdogMutex.Unlock()
+	watchdogLastActivity = time.Now()
+}
+
+// Writer which records activity each time a command writes output, so commands which stream data or
+// report their progress are not considered as stalled while they are running
+type watchdogWriter struct {
+	writer io.Writer
+}
+
+func (w *watchdogWriter) Write(data []byte) (int, error) {
+	watchdogActivity()
+	return w.writer.Write(data)
+}
+
+// Return how long it has been since the latest activity
+func watchdogIdle() time.Duration {
+	watchdogMutex.Lock()
+	defer watchdogMutex.Unlock()
+	return time.Since(watchdogLastActivity)
+}
+
+// Add the middleware which records the completion of each call to the AWS API
+func watchdogAwsMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("Watchdog",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleFinalize(ctx, in)
+			watchdogActivity()
+			return out, metadata, err
+		}), middleware.Before)
+}
+
+// Execute a job and check its activity until it has finished. A job which has stalled is flagged with an
+// error and an event. It is aborted when abort is true, which is only safe in the subprocess of an isolated
+// job which exits right after, otherwise it is left running as a goroutine cannot be interrupted.
+func watchdogRun(jobname string, abort bool, run func() error) error {
+
+	timeout := time.Duration(kconfig.Int64("global.stall_timeout")) * time.Minute
+	if timeout <= 0 {
+		return run()
+	}
+
+	watchdogActivity()
+	done := make(chan error, 1)
+	go func() {
+		done <- run()
+	}()
+
+	interval := timeout / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stalled := false
+	for {
+		select {
+		case err := <-done:
+			if stalled == true && err == nil {
+				slog.Warnf("Job \"%s\" has completed after having stalled", jobname)
+			}
+			return err
+		case <-ticker.C:
+			idle := watchdogIdle()
+			if idle < timeout {
+				stalled = false
+				continue
+			}
+			if stalled == false {
+				stalled = true
+				slog.Errorf("Job \"%s\" has stalled as no call to a provider has completed for %v", jobname, idle.Truncate(time.Second))
+				eventEmit(Event{Type: "job_stalled", Job: jobname, Module: jobmetadefs[jobname].Module,
+					Message: fmt.Sprintf("no call to a provider has completed for %v", idle.Truncate(time.Second))}, false)
+			}
+			if abort == true {
+				return &StallError{timeout: timeout}
+			}
+		}
+	}
+}